@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// ackMaxRetries limits the retries of acknowledging a report that changed
+// in the meantime
+const ackMaxRetries = 3
+
+// AcknowledgmentDoc - a manager has read the report. LatencySeconds is the
+// time from creating the report to the acknowledgment, it is missing for
+// reports created before createdAt was recorded.
+type AcknowledgmentDoc struct {
+	By             string    `json:"by,omitempty"`
+	At             time.Time `json:"at"`
+	LatencySeconds *float64  `json:"latencySeconds,omitempty"`
+}
+
+// AckStatsDoc - acknowledgment of the reports and its latency in seconds
+type AckStatsDoc struct {
+	Reports           int     `json:"reports"`
+	Acknowledged      int     `json:"acknowledged"`
+	AvgLatencySeconds float64 `json:"avgLatencySeconds"`
+	MinLatencySeconds float64 `json:"minLatencySeconds"`
+	MaxLatencySeconds float64 `json:"maxLatencySeconds"`
+}
+
+// AckStatsQuery - query parameters of GET /stats/acknowledgments
+type AckStatsQuery struct {
+	ContactID string `url:"contactid" validate:"omitempty,uuid"`
+	From      string `url:"from" validate:"omitempty,datetime=2006-01-02"`
+	To        string `url:"to" validate:"omitempty,datetime=2006-01-02"`
+}
+
+// acknowledgedCondition returns the condition matching reports that were or
+// were not acknowledged, drafts never are.
+func acknowledgedCondition(acknowledged bool) (string, []cosmosapi.QueryParam) {
+	if acknowledged {
+		return "IS_DEFINED(c.acknowledgment)", nil
+	}
+	return "NOT IS_DEFINED(c.acknowledgment)", nil
+}
+
+func newAcknowledgment(model *VisitReportModel, by string) *AcknowledgmentDoc {
+	ack := &AcknowledgmentDoc{By: by, At: time.Now().UTC()}
+	if model.CreatedAt != nil {
+		latency := ack.At.Sub(*model.CreatedAt).Seconds()
+		ack.LatencySeconds = &latency
+	}
+	return ack
+}
+
+// acknowledgeReport records that the caller, a manager, has read the report.
+// Only the first acknowledgment is kept, acknowledging again returns it
+// unchanged. The report is not changed otherwise, no event is sent.
+func acknowledgeReport(ctx iris.Context) {
+	reqCtx := ctx.Request().Context()
+	reportid := ctx.Params().GetString("reportid")
+	var model *VisitReportModel
+	var err error
+	for i := 0; ; i++ {
+		model, err = currentRepo.Get(reqCtx, reportid)
+		if err != nil || model.Acknowledgment != nil {
+			break
+		}
+		model.Acknowledgment = newAcknowledgment(model, ctx.Values().GetString(ctxKeyCaller))
+		_, err = currentRepo.Update(reqCtx, model)
+		if err != ErrReportChanged || i == ackMaxRetries {
+			break
+		}
+		// changed in the meantime, acknowledge the current version
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	fmt.Printf("Report %s acknowledged by %s\n", reportid, model.Acknowledgment.By)
+	out := VisitReportReadDoc{}
+	copier.Copy(&out, model)
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
+
+func readStatsAcknowledgments(ctx iris.Context) {
+	params := AckStatsQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	var out AckStatsDoc
+	var err error
+	if aggregatesAvailable() {
+		out, err = queryAckStats(ctx.Request().Context(), params)
+	} else {
+		out, err = ackStatsFromRepository(ctx.Request().Context(), params)
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
+
+func queryAckStats(ctx context.Context, params AckStatsQuery) (AckStatsDoc, error) {
+	where := "c.type = 'visitreport' AND " + notDeleted
+	var qparams []cosmosapi.QueryParam
+	if params.ContactID != "" {
+		where += " AND c.contact.id = @contactid"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@contactid", Value: params.ContactID})
+	}
+	if params.From != "" {
+		where += " AND c.visitDate >= @from"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@from", Value: params.From})
+	}
+	if params.To != "" {
+		where += " AND c.visitDate < @to"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@to", Value: nextDay(params.To)})
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	// AVG, MIN and MAX skip reports without a latency
+	qry := cosmosapi.Query{
+		Query: `SELECT
+					COUNT(1) as reports,
+					SUM(IS_DEFINED(c.acknowledgment) ? 1 : 0) as acknowledged,
+					AVG(c.acknowledgment.latencySeconds) as avgLatencySeconds,
+					MIN(c.acknowledgment.latencySeconds) as minLatencySeconds,
+					MAX(c.acknowledgment.latencySeconds) as maxLatencySeconds
+				FROM c
+				WHERE ` + where + `
+				GROUP BY c.type`,
+		Params: qparams,
+	}
+	var docs []AckStatsDoc
+	if _, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops); err != nil {
+		return AckStatsDoc{}, err
+	}
+	if len(docs) == 0 {
+		return AckStatsDoc{}, nil
+	}
+	return docs[0], nil
+}
+
+// ackStatsFromRepository computes the acknowledgment stats from the reports
+// of the memory repository, the way queryAckStats aggregates them.
+func ackStatsFromRepository(ctx context.Context, params AckStatsQuery) (AckStatsDoc, error) {
+	out := AckStatsDoc{}
+	latencies := 0
+	filter := ReportFilter{ContactID: params.ContactID, From: params.From, To: params.To}
+	err := currentRepo.Query(ctx, filter, func(model *VisitReportModel) error {
+		out.Reports++
+		if model.Acknowledgment == nil {
+			return nil
+		}
+		out.Acknowledged++
+		latency := model.Acknowledgment.LatencySeconds
+		if latency == nil {
+			return nil
+		}
+		if latencies == 0 || *latency < out.MinLatencySeconds {
+			out.MinLatencySeconds = *latency
+		}
+		if latencies == 0 || *latency > out.MaxLatencySeconds {
+			out.MaxLatencySeconds = *latency
+		}
+		out.AvgLatencySeconds += *latency
+		latencies++
+		return nil
+	})
+	if latencies > 0 {
+		out.AvgLatencySeconds /= float64(latencies)
+	}
+	return out, err
+}
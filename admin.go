@@ -0,0 +1,62 @@
+package main
+
+import (
+	"expvar"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/logger"
+	"github.com/kataras/iris/v12/middleware/pprof"
+	"github.com/kataras/iris/v12/middleware/recover"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newAdminApp creates the admin/ops application served on VR_ADMINADDR. It
+// must never be exposed through the public ingress.
+func newAdminApp() *iris.Application {
+	app := iris.New()
+	app.Use(recover.New())
+	app.Validator = validator.New()
+	app.Use(logger.New())
+	app.Use(requireJSONBody)
+
+	app.Get("/healthz", func(ctx iris.Context) {
+		if currentClient != nil {
+			ctx.StatusCode(200)
+		} else {
+			ctx.StatusCode(500)
+		}
+	})
+	app.Get("/healthz/live", readLiveness)
+	app.Get("/healthz/ready", readReadiness)
+	app.Get("/status", readAdminStatus)
+	app.Get("/debug/vars", iris.FromStd(expvar.Handler()))
+	app.Get("/metrics", iris.FromStd(promhttp.HandlerFor(currentMetrics, promhttp.HandlerOpts{})))
+	app.HandleMany("GET", "/debug/pprof /debug/pprof/{action:path}", pprof.New())
+
+	app.Get("/debug/deprecations", readDeprecationReport)
+
+	capture := app.Party("/debug/capture")
+	capture.Get("/", readCapture)
+	capture.Post("/", enableCapture)
+	capture.Delete("/", disableCapture)
+
+	crmsync := app.Party("/crmsync")
+	crmsync.Get("/", listCrmSyncStatus)
+	crmsync.Get("/{reportid}", readCrmSyncStatus)
+	crmsync.Post("/{reportid}/retry", retryCrmSync)
+
+	coverage := app.Party("/coverage/targets")
+	coverage.Get("/", listCoverageTargets)
+	coverage.Put("/{company}", saveCoverageTargetHandler)
+	coverage.Delete("/{company}", deleteCoverageTarget)
+
+	app.Post("/stats/recompute", recomputeStats)
+	app.Post("/reassign", reassignReports)
+
+	deadletters := app.Party("/deadletters/contacts")
+	deadletters.Get("/", listContactDeadLetters)
+	deadletters.Post("/resubmit", resubmitContactDeadLetters)
+
+	return app
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const archiveVersion = "1"
+
+// ArchiveManifest - first entry of an export archive
+type ArchiveManifest struct {
+	Version    string    `json:"version"`
+	ExportedAt time.Time `json:"exportedAt"`
+	Source     string    `json:"source"`
+	Reports    int       `json:"reports"`
+}
+
+// exportArchive writes all reports to a tar.gz archive containing
+// manifest.json and reports.jsonl, e.g. "visitreports export -out stage.tar.gz".
+func exportArchive(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("out", "visitreports.tar.gz", "archive file to write")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var reports bytes.Buffer
+	enc := json.NewEncoder(&reports)
+	qry := cosmosapi.Query{
+		Query: "SELECT * FROM c WHERE c.type = 'visitreport'",
+	}
+	count := 0
+	charges, err := queryAllPartitions(context.Background(), currentCfg.ReportContainer, qry, 1000, func() interface{} {
+		return &[]map[string]interface{}{}
+	}, func(page interface{}) error {
+		docs := *page.(*[]map[string]interface{})
+		for _, doc := range docs {
+			if err := enc.Encode(stripSystemProperties(doc)); err != nil {
+				return err
+			}
+		}
+		count += len(docs)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logPartitionCharges(charges)
+
+	manifest, err := json.MarshalIndent(ArchiveManifest{
+		Version:    archiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Source:     currentCfg.DbURL + "/" + currentCfg.DbName,
+		Reports:    count,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{"manifest.json", manifest},
+		{"reports.jsonl", reports.Bytes()},
+	} {
+		hdr := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d reports to %s\n", count, *out)
+	return nil
+}
+
+// importArchive loads an export archive into the configured environment.
+// Report ids are preserved. Contact ids can be remapped with a JSON object
+// of old to new ids when contacts have different ids in the target, e.g.
+// "visitreports import -in stage.tar.gz -contactmap contacts.json".
+func importArchive(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	in := fs.String("in", "visitreports.tar.gz", "archive file to read")
+	contactMapFile := fs.String("contactmap", "", "JSON file mapping source contact ids to target contact ids")
+	overwrite := fs.Bool("overwrite", false, "replace reports that already exist in the target")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	contactMap := map[string]string{}
+	if *contactMapFile != "" {
+		b, err := ioutil.ReadFile(*contactMapFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &contactMap); err != nil {
+			return errors.Wrap(err, "invalid contact map")
+		}
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	var manifest *ArchiveManifest
+	imported, skipped, remapped := 0, 0, 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Name {
+		case "manifest.json":
+			manifest = &ArchiveManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return errors.Wrap(err, "invalid manifest")
+			}
+			if manifest.Version != archiveVersion {
+				return fmt.Errorf("unsupported archive version %q", manifest.Version)
+			}
+		case "reports.jsonl":
+			if manifest == nil {
+				return fmt.Errorf("archive has no manifest")
+			}
+			scanner := bufio.NewScanner(tr)
+			scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+			for scanner.Scan() {
+				var doc map[string]interface{}
+				if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+					return err
+				}
+				if contact, ok := doc["contact"].(map[string]interface{}); ok {
+					if id, ok := contact["id"].(string); ok {
+						if newID, ok := contactMap[id]; ok {
+							contact["id"] = newID
+							remapped++
+						}
+					}
+				}
+				ops := cosmosapi.CreateDocumentOptions{
+					PartitionKeyValue: "visitreport",
+					IsUpsert:          *overwrite,
+				}
+				_, _, err := currentClient.CreateDocument(context.Background(), currentCfg.DbName, currentCfg.ReportContainer, doc, ops)
+				if err == cosmosapi.ErrConflict {
+					skipped++
+					continue
+				}
+				if err != nil {
+					return errors.Wrapf(err, "importing report %v", doc["id"])
+				}
+				imported++
+			}
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("Imported %d reports, skipped %d existing, remapped %d contacts\n", imported, skipped, remapped)
+	return nil
+}
+
+var cosmosSystemProperties = map[string]bool{"_rid": true, "_self": true, "_etag": true, "_attachments": true, "_ts": true}
+
+// stripSystemProperties returns the document without the Cosmos generated
+// properties, which must not be carried over to another account.
+func stripSystemProperties(doc map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		if !cosmosSystemProperties[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
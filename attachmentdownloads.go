@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// DownloadAuditDoc - audit entry for a download URL handed out for an
+// attachment. Thumbnail is the size of the thumbnail, 0 for the file. By
+// is the authenticated caller, IP the address the request came from.
+type DownloadAuditDoc struct {
+	AttachmentId string    `json:"attachmentId"`
+	Thumbnail    int       `json:"thumbnail,omitempty"`
+	At           time.Time `json:"at"`
+	By           string    `json:"by,omitempty"`
+	IP           string    `json:"ip,omitempty"`
+}
+
+// AttachmentDownloadQuery - query parameters of POST
+// /reports/{id}/attachments/{aid}/downloads, Thumbnail is the size of the
+// thumbnail to download instead of the file
+type AttachmentDownloadQuery struct {
+	Thumbnail int `url:"thumbnail" validate:"min=0"`
+}
+
+// AttachmentDownloadLinkDoc - a short-lived URL to download an attachment
+type AttachmentDownloadLinkDoc struct {
+	DownloadURL string    `json:"downloadUrl"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// attachmentOfReport returns an attachment of a report, nil if there is
+// none.
+func attachmentOfReport(model *VisitReportModel, attachmentid string) *AttachmentDoc {
+	for i := range model.Attachments {
+		if model.Attachments[i].Id == attachmentid {
+			return &model.Attachments[i]
+		}
+	}
+	return nil
+}
+
+// createAttachmentDownload records the download in the audit of the report
+// and returns a read-only SAS for the file or a thumbnail that expires
+// after VR_ATTACHMENTDOWNLOADTTL. No URL is handed out without the audit
+// entry.
+func createAttachmentDownload(ctx iris.Context) {
+	if !attachmentsEnabled(ctx) {
+		return
+	}
+	params := AttachmentDownloadQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	reqCtx := ctx.Request().Context()
+	reportid := ctx.Params().GetString("reportid")
+	attachmentid := ctx.Params().GetString("attachmentid")
+	var blob string
+	for i := 0; ; i++ {
+		model, err := currentRepo.Get(reqCtx, reportid)
+		if err != nil {
+			stopWithError(ctx, err)
+			return
+		}
+		attachment := attachmentOfReport(model, attachmentid)
+		if attachment == nil {
+			ctx.StopWithStatus(iris.StatusNotFound)
+			return
+		}
+		if attachment.Tier == blobTierArchive {
+			ctx.StopWithProblem(iris.StatusConflict, iris.NewProblem().
+				Title("Attachment is archived").
+				Detail(fmt.Sprintf("The attachment was archived on %s after its retention period", attachment.TieredAt.Format(time.RFC3339))))
+			return
+		}
+		blob = attachmentBlobName(reportid, attachmentid)
+		if params.Thumbnail > 0 {
+			found := false
+			for _, t := range attachment.Thumbnails {
+				found = found || t.Size == params.Thumbnail
+			}
+			if !found {
+				ctx.StopWithStatus(iris.StatusNotFound)
+				return
+			}
+			blob = thumbnailBlobName(reportid, attachmentid, params.Thumbnail)
+		}
+
+		model.AttachmentDownloads = append(model.AttachmentDownloads, DownloadAuditDoc{
+			AttachmentId: attachmentid,
+			Thumbnail:    params.Thumbnail,
+			At:           time.Now().UTC(),
+			By:           consumerOf(ctx),
+			IP:           clientIP(ctx),
+		})
+		_, err = currentRepo.Update(reqCtx, model)
+		if err == nil {
+			break
+		}
+		if err != ErrReportChanged || i == attachmentMaxRetries {
+			stopWithError(ctx, err)
+			return
+		}
+	}
+
+	expires := time.Now().Add(currentCfg.AttachmentDownloadTTL).UTC()
+	u, err := blobSASURL(blob, "r", expires)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(normalize(AttachmentDownloadLinkDoc{DownloadURL: u, ExpiresAt: expires}))
+}
+
+// listAttachmentDownloads returns who downloaded an attachment when, for
+// compliance reviews.
+func listAttachmentDownloads(ctx iris.Context) {
+	reportid := ctx.Params().GetString("reportid")
+	attachmentid := ctx.Params().GetString("attachmentid")
+	// the downloads of deleted reports are reviewed as well
+	model, err := getAnyReport(ctx.Request().Context(), reportid)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	if attachmentOfReport(model, attachmentid) == nil {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	out := []DownloadAuditDoc{}
+	for _, d := range model.AttachmentDownloads {
+		if d.AttachmentId == attachmentid {
+			out = append(out, d)
+		}
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
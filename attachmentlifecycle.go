@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// access tiers of Blob Storage, see
+// https://docs.microsoft.com/en-us/azure/storage/blobs/storage-blob-storage-tiers
+const (
+	blobTierCool    = "Cool"
+	blobTierArchive = "Archive"
+)
+
+// attachmentLifecycleEnabled reports whether attachments move to cheaper
+// tiers, VR_ATTACHMENTCOOLAFTER or VR_ATTACHMENTARCHIVEAFTER is set.
+func attachmentLifecycleEnabled() bool {
+	return currentCfg.AttachmentAccount != "" && currentCfg.LifecycleInterval > 0 &&
+		(currentCfg.AttachmentCoolAfter > 0 || currentCfg.AttachmentArchiveAfter > 0)
+}
+
+// attachmentTier returns the tier an attachment belongs in by its age, ""
+// if it stays where it is. Attachments are cooled after
+// VR_ATTACHMENTCOOLAFTER and archived once the retention period
+// VR_ATTACHMENTARCHIVEAFTER expired. Archived attachments are not moved
+// back.
+func attachmentTier(a AttachmentDoc, now time.Time) string {
+	age := now.Sub(a.UploadedAt)
+	tier := ""
+	switch {
+	case a.Tier == blobTierArchive:
+		return ""
+	case currentCfg.AttachmentArchiveAfter > 0 && age >= currentCfg.AttachmentArchiveAfter:
+		tier = blobTierArchive
+	case currentCfg.AttachmentCoolAfter > 0 && age >= currentCfg.AttachmentCoolAfter:
+		tier = blobTierCool
+	}
+	if tier == a.Tier {
+		return ""
+	}
+	return tier
+}
+
+// setBlobTier moves a blob to an access tier, see
+// https://docs.microsoft.com/en-us/rest/api/storageservices/set-blob-tier
+func setBlobTier(ctx context.Context, blob, tier string) error {
+	res, err := blobRequest(ctx, http.MethodPut, blob, "w", "comp=tier", http.Header{"x-ms-access-tier": {tier}}, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("moving blob %s to tier %s: %s", blob, tier, res.Status)
+	}
+	return nil
+}
+
+// startAttachmentLifecycleWorker moves attachments to their tier every
+// VR_LIFECYCLEINTERVAL.
+func startAttachmentLifecycleWorker() {
+	go func() {
+		for {
+			n, err := applyAttachmentLifecycle(context.Background(), time.Now().UTC())
+			if err != nil {
+				err = errors.WithStack(err)
+				fmt.Println(err)
+			} else if n > 0 {
+				fmt.Printf("Moved %d attachments to a cheaper tier\n", n)
+			}
+			time.Sleep(currentCfg.LifecycleInterval)
+		}
+	}()
+}
+
+// applyAttachmentLifecycle moves the attachments of all reports, deleted
+// ones included, to their tier and records it in the attachment metadata.
+// Thumbnails stay where they are. Reports under legal hold are not
+// changed, reports changed in the meantime are picked up by the next run.
+func applyAttachmentLifecycle(ctx context.Context, now time.Time) (int, error) {
+	moved := 0
+	err := currentRepo.Query(ctx, ReportFilter{IncludeDeleted: true}, func(model *VisitReportModel) error {
+		if model.LegalHold != nil || len(model.Attachments) == 0 {
+			return nil
+		}
+		changed := 0
+		for i, a := range model.Attachments {
+			tier := attachmentTier(a, now)
+			if tier == "" {
+				continue
+			}
+			if err := setBlobTier(ctx, attachmentBlobName(model.Id, a.Id), tier); err != nil {
+				return err
+			}
+			model.Attachments[i].Tier = tier
+			model.Attachments[i].TieredAt = &now
+			changed++
+		}
+		if changed == 0 {
+			return nil
+		}
+		_, err := currentRepo.Update(ctx, model)
+		if err == ErrReportChanged {
+			return nil
+		}
+		moved += changed
+		return err
+	})
+	return moved, err
+}
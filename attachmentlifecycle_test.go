@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttachmentTier(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) {
+		cfg.AttachmentCoolAfter = 30 * 24 * time.Hour
+		cfg.AttachmentArchiveAfter = 365 * 24 * time.Hour
+	})
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		age  time.Duration
+		tier string
+		want string
+	}{
+		{"new", 24 * time.Hour, "", ""},
+		{"old", 60 * 24 * time.Hour, "", blobTierCool},
+		{"cooled", 60 * 24 * time.Hour, blobTierCool, ""},
+		{"expired", 400 * 24 * time.Hour, blobTierCool, blobTierArchive},
+		{"archived", 400 * 24 * time.Hour, blobTierArchive, ""},
+	}
+	for _, tt := range tests {
+		a := AttachmentDoc{UploadedAt: now.Add(-tt.age), Tier: tt.tier}
+		if got := attachmentTier(a, now); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
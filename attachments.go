@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+)
+
+const (
+	// blobSASVersion is the storage service version the SAS is signed for
+	blobSASVersion = "2019-12-12"
+	// attachmentMaxRetries limits the retries of adding an attachment to a
+	// report that changed in the meantime
+	attachmentMaxRetries = 3
+)
+
+// AttachmentDoc - a file attached to a report. The file is stored as blob
+// "<reportid>/<attachmentid>" in VR_ATTACHMENTCONTAINER.
+type AttachmentDoc struct {
+	Id          string    `json:"id"`
+	FileName    string    `json:"fileName"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	MD5         string    `json:"md5"`
+	UploadedBy  string    `json:"uploadedBy,omitempty"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+	// Thumbnails of images are added in the background, see thumbnails.go
+	Thumbnails []AttachmentThumbnailDoc `json:"thumbnails,omitempty"`
+	// Tier is the access tier the file was moved to, see
+	// attachmentlifecycle.go, empty while it is in the default tier
+	Tier     string     `json:"tier,omitempty"`
+	TieredAt *time.Time `json:"tieredAt,omitempty"`
+}
+
+// AttachmentUploadCreateDoc - struct for requesting an upload URL
+type AttachmentUploadCreateDoc struct {
+	FileName    string `json:"fileName" validate:"required,max=255"`
+	ContentType string `json:"contentType" validate:"required"`
+	Size        int64  `json:"size" validate:"required,min=1"`
+}
+
+// AttachmentUploadDoc - where and how the browser uploads the file. The
+// storage account needs a CORS rule for the origins of the web app. With
+// BlockSize the file is uploaded in blocks of at most that size, each with
+// PUT <uploadUrl>&comp=block&blockid=<id>, ids of the same length in
+// base64, and completed with the ids in order. Blocks are the blocks
+// uploaded so far when an upload is resumed.
+type AttachmentUploadDoc struct {
+	AttachmentId string               `json:"attachmentId"`
+	UploadURL    string               `json:"uploadUrl"`
+	Method       string               `json:"method"`
+	Headers      map[string]string    `json:"headers"`
+	BlockSize    int64                `json:"blockSize,omitempty"`
+	Blocks       []AttachmentBlockDoc `json:"blocks,omitempty"`
+	ExpiresAt    time.Time            `json:"expiresAt"`
+}
+
+// AttachmentCompleteDoc - struct for attaching an uploaded file, MD5 is the
+// base64 encoded MD5 of the file as computed by the browser. Files uploaded
+// in blocks are assembled from Blocks, the block ids in order, with
+// ContentType.
+type AttachmentCompleteDoc struct {
+	FileName    string   `json:"fileName" validate:"required,max=255"`
+	MD5         string   `json:"md5" validate:"required,base64"`
+	Blocks      []string `json:"blocks" validate:"max=50000,dive,base64"`
+	ContentType string   `json:"contentType" validate:"required_with=Blocks"`
+}
+
+func attachmentsEnabled(ctx iris.Context) bool {
+	if currentCfg.AttachmentAccount == "" {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func attachmentTypeAllowed(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, allowed := range currentCfg.AttachmentTypes {
+		if contentType == strings.ToLower(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func attachmentBlobName(reportid, attachmentid string) string {
+	return reportid + "/" + attachmentid
+}
+
+// blobSASURL returns the URL of a blob in VR_ATTACHMENTCONTAINER with a
+// service SAS for the permissions.
+func blobSASURL(blob, permissions string, expires time.Time) (string, error) {
+	return containerBlobSASURL(currentCfg.AttachmentContainer, blob, permissions, expires)
+}
+
+// containerBlobSASURL returns the URL of a blob with a service SAS for the
+// permissions, signed with VR_ATTACHMENTKEY, see
+// https://docs.microsoft.com/en-us/rest/api/storageservices/create-service-sas
+func containerBlobSASURL(container, blob, permissions string, expires time.Time) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(currentCfg.AttachmentKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid attachment storage key: %v", err)
+	}
+	se := expires.UTC().Format(time.RFC3339)
+	resource := fmt.Sprintf("/blob/%s/%s/%s", currentCfg.AttachmentAccount, container, blob)
+	stringToSign := strings.Join([]string{
+		permissions, "", se, resource, "", "", "https", blobSASVersion, "b", "",
+		"", "", "", "", "",
+	}, "\n")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	q := url.Values{}
+	q.Set("sv", blobSASVersion)
+	q.Set("sr", "b")
+	q.Set("sp", permissions)
+	q.Set("se", se)
+	q.Set("spr", "https")
+	q.Set("sig", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s?%s",
+		currentCfg.AttachmentAccount, container, blob, q.Encode()), nil
+}
+
+// blobProperties returns the response of a HEAD request on a blob, nil if
+// the blob doesn't exist.
+func blobProperties(ctx context.Context, blob string) (http.Header, error) {
+	u, err := blobSASURL(blob, "r", time.Now().Add(5*time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading properties of blob %s: %s", blob, res.Status)
+	}
+	return res.Header, nil
+}
+
+func deleteBlob(ctx context.Context, blob string) error {
+	u, err := blobSASURL(blob, "d", time.Now().Add(5*time.Minute))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("deleting blob %s: %s", blob, res.Status)
+	}
+	return nil
+}
+
+func stopInvalidAttachment(ctx iris.Context, detail string) {
+	ctx.StopWithProblem(iris.StatusUnprocessableEntity, iris.NewProblem().
+		Title("Invalid attachment").
+		Detail(detail))
+}
+
+// createAttachmentUpload issues a short-lived SAS with which the browser
+// uploads the file straight to Blob Storage, the file never passes the API.
+// Files larger than VR_ATTACHMENTBLOCKSIZE are uploaded in blocks, an
+// interrupted upload is resumed with readAttachmentUpload.
+func createAttachmentUpload(ctx iris.Context) {
+	if !attachmentsEnabled(ctx) {
+		return
+	}
+	in := AttachmentUploadCreateDoc{}
+	if err := ctx.ReadJSON(&in); err != nil {
+		handleBindError(ctx, err)
+		return
+	}
+	if !attachmentTypeAllowed(in.ContentType) {
+		stopInvalidAttachment(ctx, fmt.Sprintf("contentType must be one of %s", strings.Join(currentCfg.AttachmentTypes, ", ")))
+		return
+	}
+	if in.Size > currentCfg.AttachmentMaxSize {
+		stopInvalidAttachment(ctx, fmt.Sprintf("size must be at most %d bytes", currentCfg.AttachmentMaxSize))
+		return
+	}
+	reportid := ctx.Params().GetString("reportid")
+	model, err := currentRepo.Get(ctx.Request().Context(), reportid)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	// no upload URL for a report that can't be changed
+	if stopIfHeld(ctx, model) {
+		return
+	}
+
+	attachmentid := uuid.New().String()
+	expires := time.Now().Add(currentCfg.AttachmentUploadTTL).UTC()
+	// create and write only, the SAS can't read or overwrite other blobs
+	u, err := blobSASURL(attachmentBlobName(reportid, attachmentid), "cw", expires)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	out := AttachmentUploadDoc{
+		AttachmentId: attachmentid,
+		UploadURL:    u,
+		Method:       http.MethodPut,
+		Headers: map[string]string{
+			"x-ms-blob-type": "BlockBlob",
+			"Content-Type":   in.ContentType,
+		},
+		ExpiresAt: expires,
+	}
+	if in.Size > currentCfg.AttachmentBlockSize {
+		out.BlockSize = currentCfg.AttachmentBlockSize
+		out.Headers = map[string]string{}
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(normalize(out))
+}
+
+// completeAttachmentUpload validates an uploaded blob and attaches it to the
+// report. Blobs that fail the checks are deleted. Completing an attachment
+// twice returns the attachment again.
+func completeAttachmentUpload(ctx iris.Context) {
+	if !attachmentsEnabled(ctx) {
+		return
+	}
+	in := AttachmentCompleteDoc{}
+	if err := ctx.ReadJSON(&in); err != nil {
+		handleBindError(ctx, err)
+		return
+	}
+	reqCtx := ctx.Request().Context()
+	reportid := ctx.Params().GetString("reportid")
+	attachmentid := ctx.Params().GetString("attachmentid")
+	if _, err := uuid.Parse(attachmentid); err != nil {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	blob := attachmentBlobName(reportid, attachmentid)
+
+	model, err := currentRepo.Get(reqCtx, reportid)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	if stopIfHeld(ctx, model) {
+		return
+	}
+	for _, a := range model.Attachments {
+		if a.Id == attachmentid {
+			ctx.StatusCode(http.StatusOK)
+			ctx.JSON(normalize(a))
+			return
+		}
+	}
+
+	if len(in.Blocks) > 0 {
+		err := commitBlocks(reqCtx, blob, in.Blocks, in.ContentType, in.MD5)
+		if err == errInvalidBlocks {
+			stopInvalidAttachment(ctx, "Not all blocks have been uploaded")
+			return
+		}
+		if err != nil {
+			stopWithError(ctx, err)
+			return
+		}
+	}
+	props, err := blobProperties(reqCtx, blob)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	if props == nil {
+		stopInvalidAttachment(ctx, "The file has not been uploaded")
+		return
+	}
+	attachment := AttachmentDoc{
+		Id:          attachmentid,
+		FileName:    in.FileName,
+		ContentType: props.Get("Content-Type"),
+		MD5:         props.Get("Content-MD5"),
+		UploadedBy:  ctx.Values().GetString(ctxKeyCaller),
+		UploadedAt:  time.Now().UTC(),
+	}
+	fmt.Sscan(props.Get("Content-Length"), &attachment.Size)
+	var problem string
+	switch {
+	case attachment.Size > currentCfg.AttachmentMaxSize:
+		problem = fmt.Sprintf("The file is larger than %d bytes", currentCfg.AttachmentMaxSize)
+	case !attachmentTypeAllowed(attachment.ContentType):
+		problem = fmt.Sprintf("The content type %q is not allowed", attachment.ContentType)
+	case attachment.MD5 == "":
+		problem = "The blob has no Content-MD5, set x-ms-blob-content-md5 when uploading in blocks"
+	case attachment.MD5 != in.MD5:
+		problem = "The checksum of the file does not match md5"
+	}
+	if problem != "" {
+		if err := deleteBlob(context.Background(), blob); err != nil {
+			err = errors.WithStack(err)
+			fmt.Println(err)
+		}
+		stopInvalidAttachment(ctx, problem)
+		return
+	}
+
+	for i := 0; ; i++ {
+		model.Attachments = append(model.Attachments, attachment)
+		_, err = currentRepo.Update(reqCtx, model)
+		if err != ErrReportChanged || i == attachmentMaxRetries {
+			break
+		}
+		// changed in the meantime, attach to the current version
+		if model, err = currentRepo.Get(reqCtx, reportid); err != nil {
+			break
+		}
+		// the hold may have been placed in the meantime
+		if stopIfHeld(ctx, model) {
+			return
+		}
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	generateThumbnailsInBg(reportid, attachment)
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(normalize(attachment))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+)
+
+// AttachmentBlockDoc - a block of a chunked upload staged in Blob Storage,
+// Id is the base64 encoded block id
+type AttachmentBlockDoc struct {
+	Id   string `json:"id" xml:"Name"`
+	Size int64  `json:"size" xml:"Size"`
+}
+
+// blockList is the response of Get Block List, see
+// https://docs.microsoft.com/en-us/rest/api/storageservices/get-block-list
+type blockList struct {
+	UncommittedBlocks []AttachmentBlockDoc `xml:"UncommittedBlocks>Block"`
+}
+
+// putBlockList is the request of Put Block List, see
+// https://docs.microsoft.com/en-us/rest/api/storageservices/put-block-list
+type putBlockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+// blobRequest sends a request to a blob of VR_ATTACHMENTCONTAINER with a
+// SAS for the permissions, query is appended to the SAS.
+func blobRequest(ctx context.Context, method, blob, permissions, query string, header http.Header, body []byte) (*http.Response, error) {
+	u, err := blobSASURL(blob, permissions, time.Now().Add(5*time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	if query != "" {
+		u += "&" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// stagedBlocks returns the blocks staged for a blob and not committed yet.
+func stagedBlocks(ctx context.Context, blob string) ([]AttachmentBlockDoc, error) {
+	res, err := blobRequest(ctx, http.MethodGet, blob, "r", "comp=blocklist&blocklisttype=uncommitted", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return []AttachmentBlockDoc{}, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading the blocks of blob %s: %s", blob, res.Status)
+	}
+	return parseBlockList(res.Body)
+}
+
+func parseBlockList(r io.Reader) ([]AttachmentBlockDoc, error) {
+	list := blockList{}
+	if err := xml.NewDecoder(r).Decode(&list); err != nil {
+		return nil, err
+	}
+	if list.UncommittedBlocks == nil {
+		return []AttachmentBlockDoc{}, nil
+	}
+	return list.UncommittedBlocks, nil
+}
+
+// commitBlocks assembles the blob from the staged blocks in the order of
+// the ids. Content-MD5 is stored as given, Blob Storage only checks the
+// MD5 of each block.
+func commitBlocks(ctx context.Context, blob string, blockIds []string, contentType, md5 string) error {
+	body, err := xml.Marshal(putBlockList{Latest: blockIds})
+	if err != nil {
+		return err
+	}
+	header := http.Header{
+		"x-ms-blob-content-type": {contentType},
+		"x-ms-blob-content-md5":  {md5},
+	}
+	res, err := blobRequest(ctx, http.MethodPut, blob, "w", "comp=blocklist", header, append([]byte(xml.Header), body...))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusBadRequest {
+		return errInvalidBlocks
+	}
+	if res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("committing the blocks of blob %s: %s", blob, res.Status)
+	}
+	return nil
+}
+
+// errInvalidBlocks - the block list names blocks that were not staged
+var errInvalidBlocks = errors.New("the blocks are not staged")
+
+// discardBlob removes an upload, committed or not. Uncommitted blocks can't
+// be deleted, writing an empty blob discards them.
+func discardBlob(ctx context.Context, blob string) error {
+	res, err := blobRequest(ctx, http.MethodPut, blob, "cw", "", http.Header{"x-ms-blob-type": {"BlockBlob"}}, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("discarding blob %s: %s", blob, res.Status)
+	}
+	return deleteBlob(ctx, blob)
+}
+
+// uploadOfReport returns the report of an upload that is not attached yet
+// and the blob name. It responds itself if there is none.
+func uploadOfReport(ctx iris.Context) (*VisitReportModel, string, bool) {
+	if !attachmentsEnabled(ctx) {
+		return nil, "", false
+	}
+	reportid := ctx.Params().GetString("reportid")
+	attachmentid := ctx.Params().GetString("attachmentid")
+	if _, err := uuid.Parse(attachmentid); err != nil {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return nil, "", false
+	}
+	model, err := currentRepo.Get(ctx.Request().Context(), reportid)
+	if err != nil {
+		stopWithError(ctx, err)
+		return nil, "", false
+	}
+	for _, a := range model.Attachments {
+		if a.Id == attachmentid {
+			ctx.StopWithProblem(iris.StatusConflict, iris.NewProblem().
+				Title("Upload is complete").
+				Detail("The file is attached to the report already"))
+			return nil, "", false
+		}
+	}
+	return model, attachmentBlobName(reportid, attachmentid), true
+}
+
+// readAttachmentUpload resumes a chunked upload, e.g. after the connection
+// of a mobile client dropped. It returns the blocks staged so far and a new
+// upload URL, the client uploads the missing blocks only.
+func readAttachmentUpload(ctx iris.Context) {
+	model, blob, ok := uploadOfReport(ctx)
+	if !ok || stopIfHeld(ctx, model) {
+		return
+	}
+	blocks, err := stagedBlocks(ctx.Request().Context(), blob)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	expires := time.Now().Add(currentCfg.AttachmentUploadTTL).UTC()
+	u, err := blobSASURL(blob, "cw", expires)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(AttachmentUploadDoc{
+		AttachmentId: ctx.Params().GetString("attachmentid"),
+		UploadURL:    u,
+		Method:       http.MethodPut,
+		Headers:      map[string]string{},
+		BlockSize:    currentCfg.AttachmentBlockSize,
+		Blocks:       blocks,
+		ExpiresAt:    expires,
+	}))
+}
+
+// abortAttachmentUpload discards an upload that was not completed.
+func abortAttachmentUpload(ctx iris.Context) {
+	_, blob, ok := uploadOfReport(ctx)
+	if !ok {
+		return
+	}
+	if err := discardBlob(ctx.Request().Context(), blob); err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusNoContent)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestParseBlockList(t *testing.T) {
+	const res = `<?xml version="1.0" encoding="utf-8"?>
+<BlockList>
+  <CommittedBlocks />
+  <UncommittedBlocks>
+    <Block><Name>MDAwMDAw</Name><Size>4194304</Size></Block>
+    <Block><Name>MDAwMDAx</Name><Size>1024</Size></Block>
+  </UncommittedBlocks>
+</BlockList>`
+	blocks, err := parseBlockList(strings.NewReader(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Id != "MDAwMDAw" || blocks[1].Size != 1024 {
+		t.Errorf("got %+v, want the two uncommitted blocks", blocks)
+	}
+
+	body, err := xml.Marshal(putBlockList{Latest: []string{"MDAwMDAw", "MDAwMDAx"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<BlockList><Latest>MDAwMDAw</Latest><Latest>MDAwMDAx</Latest></BlockList>"; string(body) != want {
+		t.Errorf("got %s, want %s", body, want)
+	}
+}
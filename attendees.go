@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// AttendeeDoc - a person taking part in a visit besides the contact. A
+// report can have up to 50 attendees and 20 agenda items.
+type AttendeeDoc struct {
+	Name  string `json:"name" validate:"required,max=100"`
+	Email string `json:"email,omitempty" validate:"omitempty,email,max=254"`
+	Role  string `json:"role,omitempty" validate:"max=50"`
+}
+
+// attendeeCondition returns the condition matching reports with an attendee
+// of the given name or email, ignoring case.
+func attendeeCondition(attendee string) (string, []cosmosapi.QueryParam) {
+	return "EXISTS(SELECT VALUE a FROM a IN c.attendees WHERE LOWER(a.name) = @attendee OR LOWER(a.email) = @attendee)", []cosmosapi.QueryParam{
+		{Name: "@attendee", Value: strings.ToLower(strings.TrimSpace(attendee))},
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksRefreshInterval is how long signing keys are cached
+	jwksRefreshInterval = 24 * time.Hour
+	// jwksMinRefresh limits refreshes for tokens signed with an unknown key,
+	// Azure AD rolls keys without notice
+	jwksMinRefresh = time.Minute
+)
+
+// jwksCache holds the signing keys of VR_AUTHJWKSURL by key id
+type jwksCache struct {
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	fetches   singleflight.Group
+}
+
+var currentJWKS = &jwksCache{}
+
+// key returns the signing key of a key id, refreshing the keys when they are
+// stale or the key id is unknown. The keys are fetched without holding the
+// lock, concurrent requests wait for the same fetch.
+func (c *jwksCache) key(kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	age := time.Since(c.fetchedAt)
+	c.mu.Unlock()
+	if ok && age < jwksRefreshInterval {
+		return key, nil
+	}
+	if !ok && age < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	_, err, _ := c.fetches.Do("jwks", func() (interface{}, error) {
+		keys, err := fetchJWKS(currentCfg.AuthJWKSURL)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.keys, c.fetchedAt = keys, time.Now()
+		c.mu.Unlock()
+		return nil, nil
+	})
+	if err != nil {
+		if ok {
+			// keep using the cached key while Azure AD is unreachable
+			err = errors.WithStack(err)
+			fmt.Println(err)
+			return key, nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	key, ok = c.keys[kid]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func fetchJWKS(url string) (map[string]*rsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching signing keys from %s: %s", url, res.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func authEnabled() bool {
+	return currentCfg.AuthIssuer != ""
+}
+
+// claimStrings returns a claim that may be a string or an array of strings,
+// like aud and roles.
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// parseBearerToken validates an Azure AD access token: RS256 signature by a
+// key of VR_AUTHJWKSURL, issuer VR_AUTHISSUER, one of the audiences in
+// VR_AUTHAUDIENCES and a current exp/nbf.
+func parseBearerToken(raw string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: []string{"RS256"}}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return currentJWKS.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+	if iss, _ := claims["iss"].(string); iss != currentCfg.AuthIssuer {
+		return nil, fmt.Errorf("token issuer %q is not trusted", iss)
+	}
+	for _, aud := range claimStrings(claims, "aud") {
+		for _, allowed := range currentCfg.AuthAudiences {
+			if aud == allowed {
+				return claims, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("token audience is not accepted")
+}
+
+// callerOf returns the name of the authenticated user or, for app tokens,
+// the client id.
+func callerOf(claims jwt.MapClaims) string {
+	for _, name := range []string{"preferred_username", "upn", "azp", "appid", "oid", "sub"} {
+		if v, ok := claims[name].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// authenticate requires a valid Azure AD bearer token when VR_AUTHISSUER is
+// set. Callers already verified by a signature or client certificate are
+// passed on, their identity comes from there.
+func authenticate(ctx iris.Context) {
+	if ctx.Values().GetString(ctxKeyCaller) == "" && authenticateDevToken(ctx) {
+		ctx.Next()
+		return
+	}
+	if !authEnabled() || ctx.Values().GetString(ctxKeyCaller) != "" {
+		ctx.Next()
+		return
+	}
+	header := ctx.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		ctx.Header("WWW-Authenticate", `Bearer`)
+		stopUnauthorized(ctx, "Missing bearer token")
+		return
+	}
+	claims, err := parseBearerToken(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		stopUnauthorized(ctx, "Invalid bearer token")
+		fmt.Printf("Rejected bearer token: %v\n", err)
+		return
+	}
+	ctx.Values().Set(ctxKeyCaller, callerOf(claims))
+	ctx.Values().Set(ctxKeyRoles, claimStrings(claims, "roles"))
+	if sub, ok := claims["sub"].(string); ok {
+		ctx.Values().Set(ctxKeySubject, sub)
+	}
+	ctx.Next()
+}
+
+// callerHasRole reports whether the caller has one of the roles. Without
+// VR_AUTHISSUER every caller has all roles, except for the dev token in
+// development mode. Otherwise a caller only has the roles its
+// authentication set, a request nobody authenticated has none.
+func callerHasRole(ctx iris.Context, roles []string) bool {
+	if !authEnabled() && !currentCfg.DevMode {
+		return true
+	}
+	callerRoles, ok := ctx.Values().Get(ctxKeyRoles).([]string)
+	if !ok {
+		return !authEnabled()
+	}
+	for _, role := range callerRoles {
+		for _, allowed := range roles {
+			if role == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requireRole only lets callers with one of the roles pass.
+func requireRole(roles ...string) iris.Handler {
+	return func(ctx iris.Context) {
+		if !callerHasRole(ctx, roles) {
+			ctx.StopWithProblem(iris.StatusForbidden, iris.NewProblem().
+				Title("Forbidden").
+				Detail(fmt.Sprintf("One of the roles %s is required", strings.Join(roles, ", "))))
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// stopIfNotAdmin responds with 403 unless the caller has one of
+// VR_AUTHADMINROLES, for query flags reserved to support staff.
+func stopIfNotAdmin(ctx iris.Context, flag string) bool {
+	if callerHasRole(ctx, currentCfg.AuthAdminRoles) {
+		return false
+	}
+	ctx.StopWithProblem(iris.StatusForbidden, iris.NewProblem().
+		Title("Forbidden").
+		Detail(fmt.Sprintf("%s requires one of the roles %s", flag, strings.Join(currentCfg.AuthAdminRoles, ", "))))
+	return true
+}
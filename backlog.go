@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+)
+
+// EntityBacklogDoc - runtime properties of a Service Bus entity. A growing
+// number of active messages in the contact subscription means contact
+// changes reach the reports late.
+type EntityBacklogDoc struct {
+	Entity             string     `json:"entity"`
+	ActiveMessages     int64      `json:"activeMessages"`
+	DeadLetterMessages int64      `json:"deadLetterMessages"`
+	ScheduledMessages  int64      `json:"scheduledMessages"`
+	SizeBytes          int64      `json:"sizeBytes"`
+	AccessedAt         *time.Time `json:"accessedAt,omitempty"`
+}
+
+// AdminStatusDoc - struct for GET /status of the admin app
+type AdminStatusDoc struct {
+	Backlog         []EntityBacklogDoc `json:"backlog"`
+	PolledAt        *time.Time         `json:"polledAt,omitempty"`
+	PollError       string             `json:"pollError,omitempty"`
+	ContactListener ContactListenerDoc `json:"contactListener"`
+}
+
+// currentBacklog is the result of the last poll
+var currentBacklog = struct {
+	sync.Mutex
+	docs     []EntityBacklogDoc
+	polledAt *time.Time
+	err      error
+}{}
+
+func countOf(v *int32) int64 {
+	if v == nil {
+		return 0
+	}
+	return int64(*v)
+}
+
+func backlogOf(entity string, counts *servicebus.CountDetails, size *int64, accessedAt *time.Time) EntityBacklogDoc {
+	doc := EntityBacklogDoc{Entity: entity, AccessedAt: accessedAt}
+	if counts != nil {
+		doc.ActiveMessages = countOf(counts.ActiveMessageCount)
+		doc.DeadLetterMessages = countOf(counts.DeadLetterMessageCount)
+		doc.ScheduledMessages = countOf(counts.ScheduledMessageCount)
+	}
+	if size != nil {
+		doc.SizeBytes = *size
+	}
+	return doc
+}
+
+func subscriptionBacklog(name string, sub *servicebus.SubscriptionEntity) EntityBacklogDoc {
+	var accessedAt *time.Time
+	if sub.AccessedAt != nil {
+		t := sub.AccessedAt.UTC()
+		accessedAt = &t
+	}
+	return backlogOf(name, sub.CountDetails, nil, accessedAt)
+}
+
+// pollBacklog reads the runtime properties of the report topic, the contact
+// subscription and the self subscription. It needs connection strings with
+// manage rights.
+func pollBacklog(ctx context.Context) ([]EntityBacklogDoc, error) {
+	var docs []EntityBacklogDoc
+	if currentTopic != nil {
+		ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(currentCfg.SbConnStrVisitReport))
+		if err != nil {
+			return nil, err
+		}
+		topic, err := ns.NewTopicManager().Get(ctx, currentCfg.ReportTopic)
+		if err != nil {
+			return nil, fmt.Errorf("topic %s: %v", currentCfg.ReportTopic, err)
+		}
+		docs = append(docs, backlogOf(currentCfg.ReportTopic, topic.CountDetails, topic.SizeInBytes, nil))
+		if currentCfg.SelfSubscription != "" {
+			name := currentCfg.ReportTopic + "/" + currentCfg.SelfSubscription
+			sub, err := currentTopic.NewSubscriptionManager().Get(ctx, currentCfg.SelfSubscription)
+			if err != nil {
+				return nil, fmt.Errorf("subscription %s: %v", name, err)
+			}
+			docs = append(docs, subscriptionBacklog(name, sub))
+		}
+	}
+	if contactTopic != nil {
+		name := currentCfg.ContactTopic + "/" + currentCfg.ContactSubscription
+		sub, err := contactTopic.NewSubscriptionManager().Get(ctx, currentCfg.ContactSubscription)
+		if err != nil {
+			return nil, fmt.Errorf("subscription %s: %v", name, err)
+		}
+		docs = append(docs, subscriptionBacklog(name, sub))
+	}
+	return docs, nil
+}
+
+// startBacklogPoller polls the backlog every VR_SERVICEBUSPOLLINTERVAL and
+// exports it as metrics.
+func startBacklogPoller() {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), currentCfg.OperationTimeout)
+			docs, err := pollBacklog(ctx)
+			cancel()
+			if err != nil {
+				err = errors.WithStack(err)
+				fmt.Println(err)
+			}
+			now := time.Now().UTC()
+			currentBacklog.Lock()
+			currentBacklog.err = err
+			if err == nil {
+				currentBacklog.docs = docs
+				currentBacklog.polledAt = &now
+			}
+			currentBacklog.Unlock()
+			for _, doc := range docs {
+				serviceBusActive.WithLabelValues(doc.Entity).Set(float64(doc.ActiveMessages))
+				serviceBusDeadLettered.WithLabelValues(doc.Entity).Set(float64(doc.DeadLetterMessages))
+				serviceBusSize.WithLabelValues(doc.Entity).Set(float64(doc.SizeBytes))
+			}
+			time.Sleep(currentCfg.ServiceBusPollInterval)
+		}
+	}()
+}
+
+// readAdminStatus returns the backlog of the last poll and the state of the
+// contact listener.
+func readAdminStatus(ctx iris.Context) {
+	currentBacklog.Lock()
+	out := AdminStatusDoc{
+		Backlog:         currentBacklog.docs,
+		PolledAt:        currentBacklog.polledAt,
+		ContactListener: readContactListenerState(),
+	}
+	if currentBacklog.err != nil {
+		out.PollError = currentBacklog.err.Error()
+	}
+	currentBacklog.Unlock()
+	if out.Backlog == nil {
+		out.Backlog = []EntityBacklogDoc{}
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(out)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+)
+
+// claimCheckProperty marks messages whose event is stored in a blob, so
+// consumers can tell them apart without parsing the body
+const claimCheckProperty = "claimCheck"
+
+// ClaimCheckDoc - where the payload of a large event is stored. The URL
+// carries a read SAS valid until ExpiresAt, SHA256 is the hex encoded hash
+// of the payload.
+type ClaimCheckDoc struct {
+	URL         string    `json:"url"`
+	ContentType string    `json:"contentType"`
+	Size        int       `json:"size"`
+	SHA256      string    `json:"sha256"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// ClaimCheckEventDoc - the body sent instead of an event exceeding
+// VR_EVENTMAXSIZE. It keeps the fields consumers route by, the event itself
+// is the payload of the claim check.
+type ClaimCheckEventDoc struct {
+	EventType  string        `json:"eventType"`
+	Version    string        `json:"version"`
+	Id         string        `json:"id,omitempty"`
+	ClaimCheck ClaimCheckDoc `json:"claimCheck"`
+	Guidance   string        `json:"guidance"`
+}
+
+const claimCheckGuidance = "The event exceeds the message size limit. GET claimCheck.url before " +
+	"claimCheck.expiresAt to read it and verify its SHA-256 against claimCheck.sha256."
+
+// claimCheckEnabled reports whether large events can be stored in the
+// storage account of the attachments.
+func claimCheckEnabled() bool {
+	return currentCfg.AttachmentAccount != "" && currentCfg.EventMaxSize > 0
+}
+
+// applyClaimCheck replaces the body of a message exceeding VR_EVENTMAXSIZE
+// by a reference to the event stored in VR_CLAIMCHECKCONTAINER. The
+// blob is named after the message id, a resend overwrites it.
+func applyClaimCheck(ctx context.Context, msg *servicebus.Message) error {
+	if !claimCheckEnabled() || len(msg.Data) <= currentCfg.EventMaxSize {
+		return nil
+	}
+	var event struct {
+		EventType string `json:"eventType"`
+		Version   string `json:"version"`
+		Id        string `json:"id"`
+	}
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return fmt.Errorf("reading event %s: %v", msg.ID, err)
+	}
+	blob := event.EventType + "/" + msg.ID + ".json"
+	if err := uploadBlob(ctx, currentCfg.ClaimCheckContainer, blob, msg.ContentType, msg.Data); err != nil {
+		return err
+	}
+	expires := time.Now().Add(currentCfg.ClaimCheckTTL).UTC().Truncate(time.Second)
+	u, err := containerBlobSASURL(currentCfg.ClaimCheckContainer, blob, "r", expires)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(msg.Data)
+	ref, err := json.Marshal(ClaimCheckEventDoc{
+		EventType: event.EventType,
+		Version:   event.Version,
+		Id:        event.Id,
+		ClaimCheck: ClaimCheckDoc{
+			URL:         u,
+			ContentType: msg.ContentType,
+			Size:        len(msg.Data),
+			SHA256:      hex.EncodeToString(sum[:]),
+			ExpiresAt:   expires,
+		},
+		Guidance: claimCheckGuidance,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Event %s has %d bytes, sending claim check for blob %s\n", msg.ID, len(msg.Data), blob)
+	msg.Data = ref
+	if msg.UserProperties == nil {
+		msg.UserProperties = map[string]interface{}{}
+	}
+	msg.UserProperties[claimCheckProperty] = true
+	eventsClaimChecked.Inc()
+	return nil
+}
+
+// uploadBlob stores a block blob in a container of the attachment storage
+// account, see
+// https://docs.microsoft.com/en-us/rest/api/storageservices/put-blob
+func uploadBlob(ctx context.Context, container, blob, contentType string, data []byte) error {
+	u, err := containerBlobSASURL(container, blob, "cw", time.Now().Add(5*time.Minute))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("Content-Type", contentType)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("uploading blob %s/%s: %s", container, blob, res.Status)
+	}
+	return nil
+}
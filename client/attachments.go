@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// CreateAttachmentUpload returns a short-lived URL the file is uploaded to
+// directly, without passing the API. The upload is attached to the report
+// with CompleteAttachmentUpload.
+func (c *Client) CreateAttachmentUpload(ctx context.Context, reportID string, upload CreateAttachmentUpload) (*AttachmentUpload, error) {
+	out := &AttachmentUpload{}
+	if _, err := c.do(ctx, http.MethodPost, "/reports/"+url.PathEscape(reportID)+"/attachments/uploads", nil, upload, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ResumeAttachmentUpload returns the blocks of an upload in blocks uploaded
+// so far and a new upload URL.
+func (c *Client) ResumeAttachmentUpload(ctx context.Context, reportID, attachmentID string) (*AttachmentUpload, error) {
+	out := &AttachmentUpload{}
+	path := "/reports/" + url.PathEscape(reportID) + "/attachments/uploads/" + url.PathEscape(attachmentID)
+	if _, err := c.do(ctx, http.MethodGet, path, nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AbortAttachmentUpload discards an upload that was not completed.
+func (c *Client) AbortAttachmentUpload(ctx context.Context, reportID, attachmentID string) error {
+	path := "/reports/" + url.PathEscape(reportID) + "/attachments/uploads/" + url.PathEscape(attachmentID)
+	_, err := c.do(ctx, http.MethodDelete, path, nil, nil, nil)
+	return err
+}
+
+// CompleteAttachmentUpload validates the uploaded file and attaches it to
+// the report.
+func (c *Client) CompleteAttachmentUpload(ctx context.Context, reportID, attachmentID string, complete CompleteAttachmentUpload) (*Attachment, error) {
+	out := &Attachment{}
+	path := "/reports/" + url.PathEscape(reportID) + "/attachments/" + url.PathEscape(attachmentID) + "/complete"
+	if _, err := c.do(ctx, http.MethodPost, path, nil, complete, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateAttachmentDownload returns a short-lived URL to download an
+// attachment, or with thumbnail > 0 its thumbnail of that size. The
+// download is recorded in the audit of the report.
+func (c *Client) CreateAttachmentDownload(ctx context.Context, reportID, attachmentID string, thumbnail int) (*AttachmentDownloadLink, error) {
+	var q url.Values
+	if thumbnail > 0 {
+		q = url.Values{"thumbnail": {strconv.Itoa(thumbnail)}}
+	}
+	out := &AttachmentDownloadLink{}
+	path := "/reports/" + url.PathEscape(reportID) + "/attachments/" + url.PathEscape(attachmentID) + "/downloads"
+	if _, err := c.do(ctx, http.MethodPost, path, q, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListAttachmentDownloads returns who downloaded an attachment when, it
+// needs an admin role.
+func (c *Client) ListAttachmentDownloads(ctx context.Context, reportID, attachmentID string) ([]AttachmentDownload, error) {
+	var out []AttachmentDownload
+	path := "/reports/" + url.PathEscape(reportID) + "/attachments/" + url.PathEscape(attachmentID) + "/downloads"
+	_, err := c.do(ctx, http.MethodGet, path, nil, nil, &out)
+	return out, err
+}
@@ -0,0 +1,259 @@
+// Package client is a typed Go client for the visit reports API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource returns the bearer token sent with every request. It is
+// called once per attempt so expiring tokens can be refreshed.
+type TokenSource func(ctx context.Context) (string, error)
+
+// Client - visit reports API client
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      TokenSource
+	signer     *signer
+	maxRetries int
+	minBackoff time.Duration
+
+	// session is the X-Session-Token of the last response, sent back for
+	// read-your-writes on a multi-region account
+	mu      sync.Mutex
+	session string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the underlying http.Client.
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.httpClient = c
+	}
+}
+
+// WithTokenSource injects a bearer token into every request.
+func WithTokenSource(ts TokenSource) Option {
+	return func(cl *Client) {
+		cl.token = ts
+	}
+}
+
+// WithStaticToken injects a fixed bearer token into every request.
+func WithStaticToken(token string) Option {
+	return WithTokenSource(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// WithHMACSigning signs every request with the shared secret of an
+// internal caller instead of (or in addition to) a bearer token.
+func WithHMACSigning(caller, secret string) Option {
+	return func(cl *Client) {
+		cl.signer = &signer{caller: caller, secret: secret}
+	}
+}
+
+// WithRetries sets how often idempotent requests are retried on network
+// errors, 429 and 5xx responses, and the initial backoff between attempts.
+func WithRetries(max int, minBackoff time.Duration) Option {
+	return func(cl *Client) {
+		cl.maxRetries = max
+		cl.minBackoff = minBackoff
+	}
+}
+
+// Read preferences of WithReadPreference
+const (
+	// ReadFresh reads live from the database, bypassing caches
+	ReadFresh = "fresh"
+	// ReadCached accepts cached and projected data
+	ReadCached = "cached"
+)
+
+type readPreferenceKey struct{}
+
+// WithReadPreference returns a context whose requests send the read
+// preference in X-Read-Preference, e.g. ReadFresh for a detail page right
+// after an update and ReadCached for dashboards.
+func WithReadPreference(ctx context.Context, preference string) context.Context {
+	return context.WithValue(ctx, readPreferenceKey{}, preference)
+}
+
+// New creates a client for the API at baseURL, e.g. "https://visitreports.example.com".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 3,
+		minBackoff: 200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is returned for non-2xx responses. Problem holds the decoded
+// application/problem+json body if the API sent one.
+type Error struct {
+	StatusCode int
+	Problem    *Problem
+}
+
+// Problem - RFC 7807 problem details returned by the API
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	// Policy is the code of the violated policy of a 422 response
+	Policy string `json:"policy,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Problem != nil && e.Problem.Title != "" {
+		return fmt.Sprintf("visitreports: %d %s: %s", e.StatusCode, e.Problem.Title, e.Problem.Detail)
+	}
+	return fmt.Sprintf("visitreports: unexpected status %d", e.StatusCode)
+}
+
+func (c *Client) sessionToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+func (c *Client) setSessionToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = token
+}
+
+func retriable(method string, status int) bool {
+	if method == http.MethodPost {
+		return false
+	}
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff << uint(attempt-1)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// do sends the request and decodes a JSON response into out (if not nil).
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) (http.Header, error) {
+	return c.doWithHeader(ctx, method, path, query, nil, body, out)
+}
+
+// doWithHeader is do with additional request headers, e.g. If-Match.
+func (c *Client) doWithHeader(ctx context.Context, method, path string, query url.Values, header http.Header, body, out interface{}) (http.Header, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			t := time.NewTimer(c.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			case <-t.C:
+			}
+		}
+
+		var r io.Reader
+		if payload != nil {
+			r = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u, r)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if c.token != nil {
+			token, err := c.token(ctx)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if session := c.sessionToken(); session != "" {
+			req.Header.Set("X-Session-Token", session)
+		}
+		if preference, _ := ctx.Value(readPreferenceKey{}).(string); preference != "" {
+			req.Header.Set("X-Read-Preference", preference)
+		}
+		if c.signer != nil {
+			c.signer.sign(req, payload)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || method == http.MethodPost {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		if session := resp.Header.Get("X-Session-Token"); session != "" {
+			c.setSessionToken(session)
+		}
+
+		if resp.StatusCode >= 300 {
+			apiErr := &Error{StatusCode: resp.StatusCode}
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if len(b) > 0 {
+				p := &Problem{}
+				if json.Unmarshal(b, p) == nil {
+					apiErr.Problem = p
+				}
+			}
+			if retriable(method, resp.StatusCode) {
+				lastErr = apiErr
+				continue
+			}
+			return resp.Header, apiErr
+		}
+
+		if out != nil && resp.StatusCode != http.StatusNoContent {
+			err = json.NewDecoder(resp.Body).Decode(out)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header, err
+	}
+	return nil, lastErr
+}
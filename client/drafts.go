@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// ListDrafts returns all saved drafts, most recently saved first.
+func (c *Client) ListDrafts(ctx context.Context) ([]Draft, error) {
+	var out []Draft
+	_, err := c.do(ctx, http.MethodGet, "/reports/drafts", nil, nil, &out)
+	return out, err
+}
+
+// GetDraft returns the draft with the given id.
+func (c *Client) GetDraft(ctx context.Context, id string) (*Draft, error) {
+	out := &Draft{}
+	if _, err := c.do(ctx, http.MethodGet, "/reports/"+url.PathEscape(id)+"/draft", nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SaveDraft creates or replaces a draft. The id is generated by the caller
+// (a UUID) and becomes the id of the report once the draft is submitted.
+func (c *Client) SaveDraft(ctx context.Context, id string, draft CreateVisitReport) (*Draft, error) {
+	out := &Draft{}
+	if _, err := c.do(ctx, http.MethodPut, "/reports/"+url.PathEscape(id)+"/draft", nil, draft, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeleteDraft discards a draft.
+func (c *Client) DeleteDraft(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/reports/"+url.PathEscape(id)+"/draft", nil, nil, nil)
+	return err
+}
+
+// SubmitDraft validates the draft and turns it into a report.
+func (c *Client) SubmitDraft(ctx context.Context, id string) (*VisitReport, error) {
+	out := &VisitReport{}
+	if _, err := c.do(ctx, http.MethodPost, "/reports/"+url.PathEscape(id)+"/draft/submit", nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
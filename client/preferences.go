@@ -0,0 +1,25 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// GetPreferences returns the preferences of the user of the token, the
+// defaults if none were saved.
+func (c *Client) GetPreferences(ctx context.Context) (*Preferences, error) {
+	out := &Preferences{}
+	if _, err := c.do(ctx, http.MethodGet, "/me/preferences", nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SavePreferences replaces the preferences of the user of the token.
+func (c *Client) SavePreferences(ctx context.Context, prefs Preferences) (*Preferences, error) {
+	out := &Preferences{}
+	if _, err := c.do(ctx, http.MethodPut, "/me/preferences", nil, prefs, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
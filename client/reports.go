@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// defaultPageSize matches the page size of the server
+const defaultPageSize = 100
+
+// ListReports returns the reports matching the options.
+func (c *Client) ListReports(ctx context.Context, opts ListOptions) ([]VisitReportListItem, error) {
+	var out []VisitReportListItem
+	_, err := c.do(ctx, http.MethodGet, "/reports", listQuery(opts), nil, &out)
+	return out, err
+}
+
+// ListReportsPage returns a page of at most limit reports matching the
+// options, starting at the continuation token of the previous page. Pass an
+// empty token for the first page, a limit of zero uses the server default.
+func (c *Client) ListReportsPage(ctx context.Context, opts ListOptions, limit int, continuationToken string) (*VisitReportPage, error) {
+	if limit <= 0 {
+		// the server only pages with a limit or token, it streams otherwise
+		limit = defaultPageSize
+	}
+	q := listQuery(opts)
+	q.Set("limit", strconv.Itoa(limit))
+	if continuationToken != "" {
+		q.Set("continuationToken", continuationToken)
+	}
+	out := &VisitReportPage{}
+	if _, err := c.do(ctx, http.MethodGet, "/reports", q, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func listQuery(opts ListOptions) url.Values {
+	q := url.Values{}
+	if opts.ContactID != "" {
+		q.Set("contactid", opts.ContactID)
+	}
+	if opts.ContactName != "" {
+		q.Set("contactName", opts.ContactName)
+	}
+	if opts.CrmID != "" {
+		q.Set("crmid", opts.CrmID)
+	}
+	if opts.Attendee != "" {
+		q.Set("attendee", opts.Attendee)
+	}
+	if opts.Number != "" {
+		q.Set("number", opts.Number)
+	}
+	if opts.From != "" {
+		q.Set("from", opts.From)
+	}
+	if opts.To != "" {
+		q.Set("to", opts.To)
+	}
+	if opts.MinScore != nil {
+		q.Set("minScore", strconv.FormatFloat(*opts.MinScore, 'f', -1, 64))
+	}
+	if opts.MaxScore != nil {
+		q.Set("maxScore", strconv.FormatFloat(*opts.MaxScore, 'f', -1, 64))
+	}
+	if opts.Company != "" {
+		q.Set("company", opts.Company)
+	}
+	if opts.Sort != "" {
+		q.Set("sort", opts.Sort)
+	}
+	if opts.Acknowledged != nil {
+		q.Set("acknowledged", strconv.FormatBool(*opts.Acknowledged))
+	}
+	if opts.IncludeDrafts {
+		q.Set("includeDrafts", "true")
+	}
+	if opts.IncludeDeleted {
+		q.Set("includeDeleted", "true")
+	}
+	return q
+}
+
+// GetReport returns a single report.
+func (c *Client) GetReport(ctx context.Context, id string) (*VisitReport, error) {
+	out := &VisitReport{}
+	h, err := c.do(ctx, http.MethodGet, "/reports/"+url.PathEscape(id), nil, nil, out)
+	if err != nil {
+		return nil, err
+	}
+	out.ETag = h.Get("ETag")
+	return out, nil
+}
+
+// GetReportLineage returns the provenance of the analyzed fields of a report.
+func (c *Client) GetReportLineage(ctx context.Context, id string) (*ReportLineage, error) {
+	out := &ReportLineage{}
+	if _, err := c.do(ctx, http.MethodGet, "/reports/"+url.PathEscape(id)+"/lineage", nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateReport creates a report and returns it including its new id.
+// Creating is not idempotent and therefore never retried.
+func (c *Client) CreateReport(ctx context.Context, report CreateVisitReport) (*VisitReport, error) {
+	out := &VisitReport{}
+	h, err := c.do(ctx, http.MethodPost, "/reports", nil, report, out)
+	if err != nil {
+		return nil, err
+	}
+	out.ETag = h.Get("ETag")
+	return out, nil
+}
+
+// UpdateReport replaces a report. With report.ETag the update fails with
+// 412 if the report was changed since, without it any version is replaced.
+func (c *Client) UpdateReport(ctx context.Context, report UpdateVisitReport) (*VisitReport, error) {
+	out := &VisitReport{}
+	h, err := c.doWithHeader(ctx, http.MethodPut, "/reports/"+url.PathEscape(report.ID), nil, ifMatch(report.ETag), report, out)
+	if err != nil {
+		return nil, err
+	}
+	out.ETag = h.Get("ETag")
+	return out, nil
+}
+
+// PatchReport updates only the fields of the merge patch (RFC 7386), e.g.
+// map[string]interface{}{"result": "..."}; null removes optional fields.
+// The etag works as for UpdateReport.
+func (c *Client) PatchReport(ctx context.Context, id, etag string, patch interface{}) (*VisitReport, error) {
+	out := &VisitReport{}
+	header := ifMatch(etag)
+	header.Set("Content-Type", "application/merge-patch+json")
+	h, err := c.doWithHeader(ctx, http.MethodPatch, "/reports/"+url.PathEscape(id), nil, header, patch, out)
+	if err != nil {
+		return nil, err
+	}
+	out.ETag = h.Get("ETag")
+	return out, nil
+}
+
+// DeleteReport deletes any version of a report. Admins can bring it back
+// with RestoreReport.
+func (c *Client) DeleteReport(ctx context.Context, id string) error {
+	return c.DeleteReportIfMatch(ctx, id, "")
+}
+
+// DeleteReportIfMatch deletes a report unless it was changed since it was
+// read with the etag, which fails with 412.
+func (c *Client) DeleteReportIfMatch(ctx context.Context, id, etag string) error {
+	_, err := c.doWithHeader(ctx, http.MethodDelete, "/reports/"+url.PathEscape(id), nil, ifMatch(etag), nil, nil)
+	return err
+}
+
+// RestoreReport restores a deleted report, for admins. Restoring a report
+// that is not deleted returns it unchanged.
+func (c *Client) RestoreReport(ctx context.Context, id string) (*VisitReport, error) {
+	out := &VisitReport{}
+	h, err := c.do(ctx, http.MethodPost, "/reports/"+url.PathEscape(id)+"/restore", nil, nil, out)
+	if err != nil {
+		return nil, err
+	}
+	out.ETag = h.Get("ETag")
+	return out, nil
+}
+
+// ifMatch returns the If-Match header of an etag, "*" matches any version.
+func ifMatch(etag string) http.Header {
+	if etag == "" {
+		etag = "*"
+	}
+	return http.Header{"If-Match": []string{etag}}
+}
+
+// AcknowledgeReport records that the caller, a manager, has read a report.
+// Acknowledging again returns the first acknowledgment.
+func (c *Client) AcknowledgeReport(ctx context.Context, id string) (*VisitReport, error) {
+	out := &VisitReport{}
+	if _, err := c.do(ctx, http.MethodPost, "/reports/"+url.PathEscape(id)+"/ack", nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SampleReports draws a reproducible sample of reports for a quality review.
+func (c *Client) SampleReports(ctx context.Context, opts SampleOptions) (*Sample, error) {
+	q := url.Values{}
+	if opts.N > 0 {
+		q.Set("n", strconv.Itoa(opts.N))
+	}
+	if opts.Strategy != "" {
+		q.Set("strategy", opts.Strategy)
+	}
+	if opts.Seed != 0 {
+		q.Set("seed", strconv.FormatInt(opts.Seed, 10))
+	}
+	if opts.From != "" {
+		q.Set("from", opts.From)
+	}
+	if opts.To != "" {
+		q.Set("to", opts.To)
+	}
+	out := &Sample{}
+	if _, err := c.do(ctx, http.MethodGet, "/reports/sample", q, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SuggestReports returns up to top subjects and contacts starting with the
+// prefix, 0 returns up to 10.
+func (c *Client) SuggestReports(ctx context.Context, prefix string, top int) ([]Suggestion, error) {
+	q := url.Values{}
+	q.Set("q", prefix)
+	if top > 0 {
+		q.Set("top", strconv.Itoa(top))
+	}
+	var out []Suggestion
+	if _, err := c.do(ctx, http.MethodGet, "/reports/suggest", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SearchReports runs a full-text search and returns hits with facet counts.
+func (c *Client) SearchReports(ctx context.Context, query string, opts SearchOptions) (*SearchResult, error) {
+	q := url.Values{}
+	q.Set("q", query)
+	if opts.Top > 0 {
+		q.Set("top", strconv.Itoa(opts.Top))
+	}
+	if opts.Skip > 0 {
+		q.Set("skip", strconv.Itoa(opts.Skip))
+	}
+	out := &SearchResult{}
+	if _, err := c.do(ctx, http.MethodGet, "/reports/search", q, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
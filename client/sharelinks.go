@@ -0,0 +1,31 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// CreateShareLink creates an expiring link granting read-only access to a
+// single report. Anyone with the returned URL can read the report until
+// the link expires or is revoked.
+func (c *Client) CreateShareLink(ctx context.Context, reportID string, link CreateShareLink) (*ShareLink, error) {
+	out := &ShareLink{}
+	if _, err := c.do(ctx, http.MethodPost, "/reports/"+url.PathEscape(reportID)+"/sharelinks", nil, link, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListShareLinks returns the links of a report with their recent accesses.
+func (c *Client) ListShareLinks(ctx context.Context, reportID string) ([]ShareLink, error) {
+	var out []ShareLink
+	_, err := c.do(ctx, http.MethodGet, "/reports/"+url.PathEscape(reportID)+"/sharelinks", nil, nil, &out)
+	return out, err
+}
+
+// RevokeShareLink revokes a link before it expires.
+func (c *Client) RevokeShareLink(ctx context.Context, reportID, linkID string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/reports/"+url.PathEscape(reportID)+"/sharelinks/"+url.PathEscape(linkID), nil, nil, nil)
+	return err
+}
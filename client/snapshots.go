@@ -0,0 +1,33 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// CreateSnapshot copies the reports matching the filter into a new,
+// immutable snapshot. The returned snapshot has no reports, use GetSnapshot.
+func (c *Client) CreateSnapshot(ctx context.Context, snapshot CreateSnapshot) (*Snapshot, error) {
+	out := &Snapshot{}
+	if _, err := c.do(ctx, http.MethodPost, "/snapshots", nil, snapshot, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListSnapshots returns all snapshots without their reports, newest first.
+func (c *Client) ListSnapshots(ctx context.Context) ([]Snapshot, error) {
+	var out []Snapshot
+	_, err := c.do(ctx, http.MethodGet, "/snapshots", nil, nil, &out)
+	return out, err
+}
+
+// GetSnapshot returns the snapshot with the given id and its reports.
+func (c *Client) GetSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	out := &Snapshot{}
+	if _, err := c.do(ctx, http.MethodGet, "/snapshots/"+url.PathEscape(id), nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
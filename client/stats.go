@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// StatsOverall returns the sentiment stats over all reports.
+func (c *Client) StatsOverall(ctx context.Context) ([]StatsOverall, error) {
+	var out []StatsOverall
+	_, err := c.do(ctx, http.MethodGet, "/stats", nil, nil, &out)
+	return out, err
+}
+
+// StatsByContact returns the sentiment stats of a contact.
+func (c *Client) StatsByContact(ctx context.Context, contactID string) ([]StatsByContact, error) {
+	var out []StatsByContact
+	_, err := c.do(ctx, http.MethodGet, "/stats/"+url.PathEscape(contactID), nil, nil, &out)
+	return out, err
+}
+
+// StatsTimeline returns the number of visits per visit date. From and to
+// are optional inclusive dates in the form 2006-01-02.
+func (c *Client) StatsTimeline(ctx context.Context, from, to string) ([]StatsTimeline, error) {
+	q := url.Values{}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	var out []StatsTimeline
+	_, err := c.do(ctx, http.MethodGet, "/stats/timeline", q, nil, &out)
+	return out, err
+}
+
+// StatsTimelineSeries returns the visits per day between the optional from
+// and to dates with the given moving averages (7 and/or 30 days) and, with
+// trend, the linear trend line. Without moving averages the trend is always
+// returned, the server only returns the series for one of them.
+func (c *Client) StatsTimelineSeries(ctx context.Context, from, to string, movingAverages []int, trend bool) (*TimelineSeries, error) {
+	q := url.Values{}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	for _, window := range movingAverages {
+		q.Add("movingAverage", strconv.Itoa(window))
+	}
+	if trend || len(movingAverages) == 0 {
+		q.Set("trend", "true")
+	}
+	out := &TimelineSeries{}
+	if _, err := c.do(ctx, http.MethodGet, "/stats/timeline", q, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Outcomes returns the outcome codes configured for the deployment.
+func (c *Client) Outcomes(ctx context.Context) ([]string, error) {
+	var out []string
+	_, err := c.do(ctx, http.MethodGet, "/outcomes", nil, nil, &out)
+	return out, err
+}
+
+// StatsOutcomes returns the number of reports per outcome, optionally for a
+// single contact and an inclusive range of visit dates.
+func (c *Client) StatsOutcomes(ctx context.Context, contactID, from, to string) ([]OutcomeStats, error) {
+	q := url.Values{}
+	if contactID != "" {
+		q.Set("contactid", contactID)
+	}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	var out []OutcomeStats
+	_, err := c.do(ctx, http.MethodGet, "/stats/outcomes", q, nil, &out)
+	return out, err
+}
+
+// StatsAcknowledgments returns how many reports were acknowledged by a
+// manager and how long it took, optionally for a single contact and an
+// inclusive range of visit dates.
+func (c *Client) StatsAcknowledgments(ctx context.Context, contactID, from, to string) (*AckStats, error) {
+	q := url.Values{}
+	if contactID != "" {
+		q.Set("contactid", contactID)
+	}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	out := &AckStats{}
+	if _, err := c.do(ctx, http.MethodGet, "/stats/acknowledgments", q, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StatsOwners returns the visits, average sentiment and overdue follow-ups
+// per report owner for an inclusive range of visit dates, most visits
+// first. It needs a manager role.
+func (c *Client) StatsOwners(ctx context.Context, from, to string) ([]OwnerStats, error) {
+	q := url.Values{}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	var out []OwnerStats
+	if _, err := c.do(ctx, http.MethodGet, "/stats/owners", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StatsCoverage returns the visit coverage of the companies with a target
+// frequency, most overdue first. With overdueOnly only overdue companies are
+// returned.
+func (c *Client) StatsCoverage(ctx context.Context, overdueOnly bool) ([]CompanyCoverage, error) {
+	q := url.Values{}
+	if overdueOnly {
+		q.Set("overdue", "true")
+	}
+	var out []CompanyCoverage
+	_, err := c.do(ctx, http.MethodGet, "/stats/coverage", q, nil, &out)
+	return out, err
+}
+
+// StatsHeatmap returns the visits bucketed by weekday and hour, optionally
+// for a single contact or company.
+func (c *Client) StatsHeatmap(ctx context.Context, contactID, company string) (*Heatmap, error) {
+	q := url.Values{}
+	if contactID != "" {
+		q.Set("contactid", contactID)
+	}
+	if company != "" {
+		q.Set("company", company)
+	}
+	out := &Heatmap{}
+	if _, err := c.do(ctx, http.MethodGet, "/stats/heatmap", q, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StatsCohorts returns the cohorts of first-visit months, optionally within
+// an inclusive range of months in the form 2006-01.
+func (c *Client) StatsCohorts(ctx context.Context, from, to string) ([]Cohort, error) {
+	q := url.Values{}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	var out []Cohort
+	_, err := c.do(ctx, http.MethodGet, "/stats/cohorts", q, nil, &out)
+	return out, err
+}
+
+// Dashboard returns the pre-aggregated landing page data.
+func (c *Client) Dashboard(ctx context.Context) (*Dashboard, error) {
+	out := &Dashboard{}
+	if _, err := c.do(ctx, http.MethodGet, "/dashboard", nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Summaries returns the materialized summaries of a month (2024-05) or an
+// ISO week (2024-W21). Scope is optional and either "contact" or "company".
+func (c *Client) Summaries(ctx context.Context, period, scope string) ([]Summary, error) {
+	q := url.Values{"period": {period}}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+	var out []Summary
+	_, err := c.do(ctx, http.MethodGet, "/summaries", q, nil, &out)
+	return out, err
+}
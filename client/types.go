@@ -0,0 +1,546 @@
+package client
+
+import "time"
+
+// Contact - contact snapshot stored with a report
+type Contact struct {
+	ID             string `json:"id"`
+	Firstname      string `json:"firstname"`
+	Lastname       string `json:"lastname"`
+	AvatarLocation string `json:"avatarLocation"`
+	Company        string `json:"company"`
+}
+
+// VisitReport - full visit report as returned by GET /reports/{id}. Number
+// is the human-readable number, e.g. VR-2024-000123, if the server numbers
+// reports.
+type VisitReport struct {
+	ID                        string       `json:"id"`
+	Number                    string       `json:"number,omitempty"`
+	Subject                   string       `json:"subject"`
+	Description               string       `json:"description"`
+	VisitDate                 string       `json:"visitDate"`
+	Result                    string       `json:"result"`
+	Outcome                   *Outcome     `json:"outcome,omitempty"`
+	CrmLinks                  []CrmLink    `json:"crmLinks,omitempty"`
+	Attendees                 []Attendee   `json:"attendees,omitempty"`
+	Agenda                    []string     `json:"agenda,omitempty"`
+	VisitResultSentimentScore float64      `json:"visitResultSentimentScore"`
+	VisitResultKeyPhrases     []string     `json:"visitResultKeyPhrases"`
+	Contact                   Contact      `json:"contact"`
+	Attachments               []Attachment `json:"attachments,omitempty"`
+	// Acknowledgment is set once a manager has read the report
+	Acknowledgment *Acknowledgment `json:"acknowledgment,omitempty"`
+	// Warnings lists data quality issues found when the report was written
+	Warnings []ValidationWarning `json:"warnings,omitempty"`
+	// ETag is the version of the report, pass it on to UpdateReport
+	ETag string `json:"-"`
+}
+
+// Acknowledgment - a manager has read a report. LatencySeconds is the time
+// from creating the report to the acknowledgment, nil for older reports.
+type Acknowledgment struct {
+	By             string    `json:"by,omitempty"`
+	At             time.Time `json:"at"`
+	LatencySeconds *float64  `json:"latencySeconds,omitempty"`
+}
+
+// ValidationWarning - non-critical data quality issue of a written report
+type ValidationWarning struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Attendee - a person taking part in a visit besides the contact
+type Attendee struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+// CrmLink - link to an opportunity or deal in the CRM, the type is
+// "opportunity" or "deal"
+type CrmLink struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Outcome - structured outcome of a visit, the code is one of Outcomes()
+type Outcome struct {
+	Code string `json:"code"`
+	Note string `json:"note"`
+}
+
+// VisitReportListItem - visit report as returned by list and search
+type VisitReportListItem struct {
+	ID        string  `json:"id"`
+	Type      string  `json:"type"`
+	Draft     bool    `json:"draft,omitempty"`
+	Deleted   bool    `json:"deleted,omitempty"`
+	Number    string  `json:"number,omitempty"`
+	Subject   string  `json:"subject"`
+	VisitDate string  `json:"visitDate"`
+	Contact   Contact `json:"contact"`
+}
+
+// VisitReportPage - a page of ListReportsPage, ContinuationToken is empty on
+// the last page
+type VisitReportPage struct {
+	Items             []VisitReportListItem `json:"items"`
+	ContinuationToken string                `json:"continuationToken,omitempty"`
+}
+
+// CreateVisitReport - payload for creating a report
+type CreateVisitReport struct {
+	Subject     string     `json:"subject"`
+	Description string     `json:"description"`
+	VisitDate   string     `json:"visitDate"`
+	Contact     Contact    `json:"contact"`
+	CrmLinks    []CrmLink  `json:"crmLinks,omitempty"`
+	Attendees   []Attendee `json:"attendees,omitempty"`
+	Agenda      []string   `json:"agenda,omitempty"`
+}
+
+// UpdateVisitReport - payload for updating a report
+type UpdateVisitReport struct {
+	// ETag is the version being updated, see UpdateReport
+	ETag        string     `json:"-"`
+	ID          string     `json:"id"`
+	Subject     string     `json:"subject"`
+	Description string     `json:"description"`
+	Result      string     `json:"result"`
+	Outcome     *Outcome   `json:"outcome,omitempty"`
+	VisitDate   string     `json:"visitDate"`
+	Contact     Contact    `json:"contact"`
+	CrmLinks    []CrmLink  `json:"crmLinks,omitempty"`
+	Attendees   []Attendee `json:"attendees,omitempty"`
+	Agenda      []string   `json:"agenda,omitempty"`
+}
+
+// Draft - auto-saved, possibly incomplete report
+type Draft struct {
+	ID          string     `json:"id"`
+	Draft       bool       `json:"draft"`
+	Subject     string     `json:"subject"`
+	Description string     `json:"description"`
+	VisitDate   string     `json:"visitDate"`
+	Contact     Contact    `json:"contact"`
+	CrmLinks    []CrmLink  `json:"crmLinks,omitempty"`
+	Attendees   []Attendee `json:"attendees,omitempty"`
+	Agenda      []string   `json:"agenda,omitempty"`
+	SavedAt     time.Time  `json:"savedAt"`
+}
+
+// ListOptions - filters for ListReports
+type ListOptions struct {
+	ContactID   string
+	ContactName string
+	// CrmID only lists reports linked to this CRM opportunity or deal
+	CrmID string
+	// Attendee only lists reports with an attendee of this name or email
+	Attendee string
+	// Number only lists the report with this number, e.g. VR-2024-000123
+	Number string
+	// From and To are optional inclusive visit dates in the form 2006-01-02
+	From string
+	To   string
+	// MinScore and MaxScore limit the sentiment score, between 0 and 1
+	MinScore *float64
+	MaxScore *float64
+	Company  string
+	// Sort is "visitDate", "subject" or "visitResultSentimentScore",
+	// descending with a leading "-", e.g. "-visitDate"
+	Sort string
+	// Acknowledged only lists reports a manager has (true) or has not
+	// (false) read, nil lists both
+	Acknowledged *bool
+	// IncludeDrafts appends the matching drafts, for admins. It is not
+	// supported by ListReportsPage.
+	IncludeDrafts bool
+	// IncludeDeleted lists deleted reports as well, for admins
+	IncludeDeleted bool
+}
+
+// SampleOptions - how SampleReports draws the sample. Strategy is "random"
+// (default), "lowSentiment" or "newReps", a zero Seed draws a new sample.
+// From and To are optional inclusive visit dates in the form 2006-01-02.
+type SampleOptions struct {
+	N        int
+	Strategy string
+	Seed     int64
+	From     string
+	To       string
+}
+
+// Sample - reports drawn for a quality review, pass Seed on to draw the
+// same sample again
+type Sample struct {
+	Strategy   string                `json:"strategy"`
+	Seed       int64                 `json:"seed"`
+	Population int                   `json:"population"`
+	Items      []VisitReportListItem `json:"items"`
+}
+
+// SearchOptions - paging for SearchReports
+type SearchOptions struct {
+	Top  int
+	Skip int
+}
+
+// SearchFacet - facet bucket, either a value or a range
+type SearchFacet struct {
+	Value interface{} `json:"value,omitempty"`
+	From  *float64    `json:"from,omitempty"`
+	To    *float64    `json:"to,omitempty"`
+	Count int         `json:"count"`
+}
+
+// SearchHit - a report found by SearchReports. Highlights maps the
+// subject, description and result to fragments with the matched terms
+// enclosed in <em> tags.
+type SearchHit struct {
+	VisitReportListItem
+	Score      float64             `json:"score"`
+	Highlights map[string][]string `json:"highlights,omitempty"`
+}
+
+// SearchResult - hits and facets returned by GET /reports/search
+type SearchResult struct {
+	Count  int                      `json:"count"`
+	Hits   []SearchHit              `json:"hits"`
+	Facets map[string][]SearchFacet `json:"facets"`
+}
+
+// Suggestion - a subject or a contact returned by SuggestReports. Kind is
+// "subject" or "contact", ReportID is the most recent report with the
+// subject.
+type Suggestion struct {
+	Kind     string   `json:"kind"`
+	Text     string   `json:"text"`
+	ReportID string   `json:"reportId,omitempty"`
+	Contact  *Contact `json:"contact,omitempty"`
+}
+
+// StatsByContact - sentiment stats of a single contact
+type StatsByContact struct {
+	ID         string  `json:"id"`
+	CountScore float64 `json:"countScore"`
+	MinScore   float64 `json:"minScore"`
+	MaxScore   float64 `json:"maxScore"`
+	AvgScore   float64 `json:"avgScore"`
+}
+
+// StatsOverall - sentiment stats over all reports
+type StatsOverall struct {
+	CountScore float64 `json:"countScore"`
+	MinScore   float64 `json:"minScore"`
+	MaxScore   float64 `json:"maxScore"`
+	AvgScore   float64 `json:"avgScore"`
+}
+
+// StatsTimeline - number of visits per visit date
+type StatsTimeline struct {
+	VisitDate string `json:"visitDate"`
+	Visits    int    `json:"visits"`
+}
+
+// TopContact - one of the most visited contacts
+type TopContact struct {
+	ID        string `json:"id"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	Company   string `json:"company"`
+	Visits    int    `json:"visits"`
+}
+
+// Dashboard - everything the landing page needs in one response
+type Dashboard struct {
+	Overall       []StatsOverall        `json:"overall"`
+	Timeline      []StatsTimeline       `json:"timeline"`
+	TopContacts   []TopContact          `json:"topContacts"`
+	LatestReports []VisitReportListItem `json:"latestReports"`
+	GeneratedAt   time.Time             `json:"generatedAt"`
+	Warnings      []QueryWarning        `json:"warnings,omitempty"`
+}
+
+// QueryWarning - a dashboard query that failed, its section is empty
+type QueryWarning struct {
+	Query   string `json:"query"`
+	Message string `json:"message"`
+}
+
+// Summary - weekly or monthly summary of a contact or company
+type Summary struct {
+	ID            string    `json:"id"`
+	Period        string    `json:"period"`
+	PeriodType    string    `json:"periodType"`
+	Scope         string    `json:"scope"`
+	Contact       *Contact  `json:"contact,omitempty"`
+	Company       string    `json:"company"`
+	Visits        int       `json:"visits"`
+	AvgSentiment  float64   `json:"avgSentiment"`
+	TopKeyPhrases []string  `json:"topKeyPhrases"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// OutcomeStats - number of reports per outcome code
+type OutcomeStats struct {
+	Code  string `json:"code"`
+	Count int    `json:"count"`
+}
+
+// AckStats - acknowledged reports and the latency of the acknowledgments
+// in seconds
+type AckStats struct {
+	Reports           int     `json:"reports"`
+	Acknowledged      int     `json:"acknowledged"`
+	AvgLatencySeconds float64 `json:"avgLatencySeconds"`
+	MinLatencySeconds float64 `json:"minLatencySeconds"`
+	MaxLatencySeconds float64 `json:"maxLatencySeconds"`
+}
+
+// OwnerStats - visits of a report owner, AvgScore is over the reports with
+// a result
+type OwnerStats struct {
+	Owner            string  `json:"owner"`
+	Visits           int     `json:"visits"`
+	AvgScore         float64 `json:"avgScore"`
+	OverdueFollowUps int     `json:"overdueFollowUps"`
+}
+
+// TimelineSeries - daily visits with moving averages and trend line
+type TimelineSeries struct {
+	Days       []TimelineDay `json:"days"`
+	TrendSlope *float64      `json:"trendSlope,omitempty"`
+}
+
+// TimelineDay - visits of a calendar day, the averages and trend are only
+// set when requested
+type TimelineDay struct {
+	Date            string   `json:"date"`
+	Visits          int      `json:"visits"`
+	MovingAverage7  *float64 `json:"movingAverage7,omitempty"`
+	MovingAverage30 *float64 `json:"movingAverage30,omitempty"`
+	Trend           *float64 `json:"trend,omitempty"`
+}
+
+// Heatmap - visits per weekday and hour of the local visit time, Weekdays
+// run from Monday to Sunday
+type Heatmap struct {
+	Weekdays    []HeatmapWeekday `json:"weekdays"`
+	Visits      int              `json:"visits"`
+	WithoutTime int              `json:"withoutTime"`
+}
+
+// HeatmapWeekday - visits of a weekday, Hours[h] counts visits starting
+// between h:00 and h:59
+type HeatmapWeekday struct {
+	Weekday string  `json:"weekday"`
+	Hours   [24]int `json:"hours"`
+}
+
+// Cohort - sentiment of the contacts first visited in a month, per month
+// since the first visit
+type Cohort struct {
+	Cohort    string        `json:"cohort"`
+	Contacts  int           `json:"contacts"`
+	Months    []CohortMonth `json:"months"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+}
+
+// CohortMonth - visits of a cohort Offset months after the first visit
+type CohortMonth struct {
+	Offset         int     `json:"offset"`
+	Month          string  `json:"month"`
+	ActiveContacts int     `json:"activeContacts"`
+	Visits         int     `json:"visits"`
+	AvgSentiment   float64 `json:"avgSentiment"`
+}
+
+// CompanyCoverage - visit coverage of a company with a target frequency
+type CompanyCoverage struct {
+	Company     string `json:"company"`
+	Frequency   string `json:"frequency"`
+	LastVisit   string `json:"lastVisit,omitempty"`
+	DueDate     string `json:"dueDate"`
+	Overdue     bool   `json:"overdue"`
+	DaysOverdue int    `json:"daysOverdue"`
+}
+
+// SnapshotFilter - selects the reports of a snapshot, empty fields match all
+type SnapshotFilter struct {
+	ContactID string `json:"contactId,omitempty"`
+	Company   string `json:"company,omitempty"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	Outcome   string `json:"outcome,omitempty"`
+}
+
+// CreateSnapshot - payload for taking a snapshot
+type CreateSnapshot struct {
+	Name   string         `json:"name"`
+	Filter SnapshotFilter `json:"filter"`
+}
+
+// Snapshot - immutable point-in-time copy of the reports matching a filter
+type Snapshot struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Filter      SnapshotFilter `json:"filter"`
+	TakenAt     time.Time      `json:"takenAt"`
+	TakenBy     string         `json:"takenBy"`
+	ReportCount int            `json:"reportCount"`
+	// Reports is only set by GetSnapshot
+	Reports []VisitReport `json:"reports,omitempty"`
+}
+
+// FieldLineage - provenance of an analyzed field of a report
+type FieldLineage struct {
+	Model      string    `json:"model"`
+	Version    string    `json:"version"`
+	AnalyzedAt time.Time `json:"analyzedAt"`
+	Input      string    `json:"input"`
+	InputHash  string    `json:"inputHash"`
+}
+
+// FieldLineageEntry - lineage of one analyzed field, Stale is set when the
+// input changed after the analysis
+type FieldLineageEntry struct {
+	Field    string        `json:"field"`
+	Lineage  *FieldLineage `json:"lineage,omitempty"`
+	Recorded bool          `json:"recorded"`
+	Stale    bool          `json:"stale"`
+}
+
+// ReportLineage - lineage of the analyzed fields of a report
+type ReportLineage struct {
+	ReportID string              `json:"reportId"`
+	Fields   []FieldLineageEntry `json:"fields"`
+}
+
+// CreateShareLink - payload for sharing a report, Scope is "full" or
+// "summary" and ExpiresIn a Go duration such as "72h"
+type CreateShareLink struct {
+	Scope     string `json:"scope"`
+	ExpiresIn string `json:"expiresIn"`
+}
+
+// ShareLinkAccess - an access to a report through a share link
+type ShareLinkAccess struct {
+	At        time.Time `json:"at"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+}
+
+// ShareLink - expiring read-only link to a single report, URL is only set
+// by CreateShareLink
+type ShareLink struct {
+	ID          string            `json:"id"`
+	ReportID    string            `json:"reportId"`
+	Scope       string            `json:"scope"`
+	URL         string            `json:"url,omitempty"`
+	CreatedBy   string            `json:"createdBy"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	ExpiresAt   time.Time         `json:"expiresAt"`
+	RevokedAt   *time.Time        `json:"revokedAt,omitempty"`
+	AccessCount int               `json:"accessCount"`
+	Accesses    []ShareLinkAccess `json:"accesses"`
+}
+
+// Attachment - a file attached to a report
+type Attachment struct {
+	ID          string    `json:"id"`
+	FileName    string    `json:"fileName"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	MD5         string    `json:"md5"`
+	UploadedBy  string    `json:"uploadedBy,omitempty"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+	// Thumbnails of images, added shortly after the upload
+	Thumbnails []AttachmentThumbnail `json:"thumbnails,omitempty"`
+	// Tier is "Cool" or "Archive" once the file was moved to a cheaper
+	// access tier, archived files can't be downloaded
+	Tier     string     `json:"tier,omitempty"`
+	TieredAt *time.Time `json:"tieredAt,omitempty"`
+}
+
+// AttachmentThumbnail - a scaled down copy of an image attachment that
+// fits into Size x Size pixels
+type AttachmentThumbnail struct {
+	Size        int    `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"contentType"`
+}
+
+// AttachmentDownloadLink - a short-lived URL to download an attachment
+type AttachmentDownloadLink struct {
+	DownloadURL string    `json:"downloadUrl"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// AttachmentDownload - a download of an attachment, Thumbnail is the size
+// of the downloaded thumbnail, 0 for the file
+type AttachmentDownload struct {
+	AttachmentID string    `json:"attachmentId"`
+	Thumbnail    int       `json:"thumbnail,omitempty"`
+	At           time.Time `json:"at"`
+	By           string    `json:"by,omitempty"`
+	IP           string    `json:"ip,omitempty"`
+}
+
+// CreateAttachmentUpload - payload for requesting an upload URL
+type CreateAttachmentUpload struct {
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+// AttachmentUpload - where and how to upload the file, the request must
+// carry Headers. With BlockSize the file is uploaded in blocks of at most
+// that size with PUT <UploadURL>&comp=block&blockid=<id>, Blocks are the
+// blocks uploaded so far when the upload is resumed.
+type AttachmentUpload struct {
+	AttachmentID string            `json:"attachmentId"`
+	UploadURL    string            `json:"uploadUrl"`
+	Method       string            `json:"method"`
+	Headers      map[string]string `json:"headers"`
+	BlockSize    int64             `json:"blockSize,omitempty"`
+	Blocks       []AttachmentBlock `json:"blocks,omitempty"`
+	ExpiresAt    time.Time         `json:"expiresAt"`
+}
+
+// AttachmentBlock - a block of an upload, ID is the base64 encoded block id
+type AttachmentBlock struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+// CompleteAttachmentUpload - payload for attaching an uploaded file, MD5 is
+// the base64 encoded MD5 of the file. A file uploaded in blocks is
+// assembled from Blocks, the block ids in order, with ContentType.
+type CompleteAttachmentUpload struct {
+	FileName    string   `json:"fileName"`
+	MD5         string   `json:"md5"`
+	Blocks      []string `json:"blocks,omitempty"`
+	ContentType string   `json:"contentType,omitempty"`
+}
+
+// Preferences - settings of a user. DefaultFilters are query parameters of
+// ListReports the frontend applies when the user opens the list.
+type Preferences struct {
+	EmailDigest     bool              `json:"emailDigest"`
+	DigestFrequency string            `json:"digestFrequency,omitempty"`
+	AlertThresholds AlertThresholds   `json:"alertThresholds"`
+	DefaultFilters  map[string]string `json:"defaultFilters,omitempty"`
+	Locale          string            `json:"locale,omitempty"`
+	UpdatedAt       *time.Time        `json:"updatedAt,omitempty"`
+}
+
+// AlertThresholds - when a user wants to be alerted, MinScore is the
+// sentiment score below which a visit is worth an alert
+type AlertThresholds struct {
+	MinScore    *float64 `json:"minScore,omitempty"`
+	OverdueDays int      `json:"overdueDays,omitempty"`
+}
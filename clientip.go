@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+// ctxKeyClientIP holds the resolved client IP of a request
+const ctxKeyClientIP = "clientIP"
+
+var currentTrustedProxies []*net.IPNet
+
+// parseTrustedProxies parses VR_TRUSTEDPROXIES, a comma separated list of
+// IPs or CIDRs of the ingress / App Gateway instances in front of the API.
+func parseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.Contains(e, "/") {
+			if ip := net.ParseIP(e); ip != nil && ip.To4() != nil {
+				e += "/32"
+			} else {
+				e += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", e, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range currentTrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the client addresses from the Forwarded header, or
+// from X-Forwarded-For if there is none, ordered from client to last proxy.
+func forwardedFor(ctx iris.Context) []string {
+	var addrs []string
+	if fwd := ctx.GetHeader("Forwarded"); fwd != "" {
+		for _, element := range strings.Split(fwd, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					addrs = append(addrs, strings.Trim(kv[1], `"`))
+				}
+			}
+		}
+		return addrs
+	}
+	for _, a := range strings.Split(ctx.GetHeader("X-Forwarded-For"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// parseForwardedAddr accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port".
+func parseForwardedAddr(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
+
+// resolveClientIP determines the real client IP. Forwarding headers are only
+// honored if the direct peer is a trusted proxy, and the chain is walked from
+// the right so that a client cannot spoof its address by sending its own
+// X-Forwarded-For header.
+func resolveClientIP(ctx iris.Context) string {
+	peer := ctx.Request().RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	peerIP := net.ParseIP(peer)
+	if peerIP == nil || !isTrustedProxy(peerIP) {
+		return peer
+	}
+
+	chain := forwardedFor(ctx)
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseForwardedAddr(chain[i])
+		if ip == nil {
+			// unknown or obfuscated identifiers end the trusted chain
+			break
+		}
+		client = ip.String()
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// clientIPMiddleware stores the resolved client IP for logging and auditing.
+func clientIPMiddleware(ctx iris.Context) {
+	ctx.Values().Set(ctxKeyClientIP, resolveClientIP(ctx))
+	ctx.Next()
+}
+
+// clientIP returns the client IP resolved by clientIPMiddleware.
+func clientIP(ctx iris.Context) string {
+	if ip := ctx.Values().GetString(ctxKeyClientIP); ip != "" {
+		return ip
+	}
+	return resolveClientIP(ctx)
+}
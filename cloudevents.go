@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	// cloudEventsContentType is the content type of the structured mode of
+	// the CloudEvents JSON format
+	cloudEventsContentType = "application/cloudevents+json"
+)
+
+// cloudEventTypes are the events sent as CloudEvents, unless
+// VR_LEGACYEVENTFORMAT is set
+var cloudEventTypes = map[string]bool{
+	"VisitReportCreatedEvent": true,
+	"VisitReportUpdatedEvent": true,
+}
+
+// CloudEventDoc - a CloudEvents 1.0 envelope in the JSON format. Id is the
+// message id, so redelivered events keep their id, Subject is the id of the
+// report and Data the event in the legacy format. EventVersion is an
+// extension attribute with the version of the event.
+type CloudEventDoc struct {
+	SpecVersion     string          `json:"specversion"`
+	Id              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	EventVersion    string          `json:"eventversion,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// applyCloudEvent wraps the body of a message in a CloudEvent. It runs
+// after applyClaimCheck, the claim check of a large event is the data of
+// its CloudEvent.
+func applyCloudEvent(msg *servicebus.Message) error {
+	if currentCfg.LegacyEventFormat || msg.ContentType == cloudEventsContentType {
+		return nil
+	}
+	var event struct {
+		EventType string `json:"eventType"`
+		Version   string `json:"version"`
+		Id        string `json:"id"`
+	}
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return fmt.Errorf("reading event %s: %v", msg.ID, err)
+	}
+	if !cloudEventTypes[event.EventType] {
+		return nil
+	}
+	body, err := json.Marshal(CloudEventDoc{
+		SpecVersion:     cloudEventsSpecVersion,
+		Id:              msg.ID,
+		Source:          currentCfg.EventSource,
+		Type:            event.EventType,
+		Subject:         event.Id,
+		Time:            time.Now().UTC(),
+		DataContentType: msg.ContentType,
+		EventVersion:    event.Version,
+		Data:            msg.Data,
+	})
+	if err != nil {
+		return err
+	}
+	msg.Data = body
+	msg.ContentType = cloudEventsContentType
+	return nil
+}
+
+// eventData returns the data of a CloudEvent, other events as they are.
+func eventData(body []byte) []byte {
+	var event struct {
+		SpecVersion string          `json:"specversion"`
+		Data        json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(body, &event) != nil || event.SpecVersion == "" {
+		return body
+	}
+	return event.Data
+}
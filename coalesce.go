@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/pkg/errors"
+)
+
+// eventCoalescer holds back the VisitReportUpdatedEvent of a report for
+// VR_EVENTCOALESCEWINDOW after its first update and sends only the latest
+// event of the window, so autosaves don't flood the consumers. An event is
+// delayed by at most the window, not pushed back by every further update.
+type eventCoalescer struct {
+	mu      sync.Mutex
+	pending map[string]*servicebus.Message
+}
+
+var currentCoalescer = &eventCoalescer{pending: map[string]*servicebus.Message{}}
+
+// publishUpdatedEvent sends the updated event of a report, coalesced with
+// the other updates within VR_EVENTCOALESCEWINDOW if set.
+func publishUpdatedEvent(ctx context.Context, reportid string, msg *servicebus.Message) error {
+	if currentCfg.EventCoalesceWindow <= 0 {
+		if err := sendRequestEvent(ctx, msg); err != nil {
+			return err
+		}
+		trackEventEmission("VisitReportUpdatedEvent", "1", "topic:"+currentCfg.ReportTopic)
+		return nil
+	}
+	currentCoalescer.add(reportid, msg, currentCfg.EventCoalesceWindow)
+	return nil
+}
+
+func (c *eventCoalescer) add(reportid string, msg *servicebus.Message, window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, waiting := c.pending[reportid]
+	c.pending[reportid] = msg
+	if waiting {
+		eventsCoalesced.Inc()
+		return
+	}
+	time.AfterFunc(window, func() { c.flush(reportid) })
+}
+
+// flush sends the pending event of a report. The request is long gone, a
+// failed send goes to the outbox.
+func (c *eventCoalescer) flush(reportid string) {
+	c.mu.Lock()
+	msg, ok := c.pending[reportid]
+	delete(c.pending, reportid)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), eventSendTimeout)
+	defer cancel()
+	err := sendToTopic(ctx, msg)
+	if err == nil {
+		trackEventEmission("VisitReportUpdatedEvent", "1", "topic:"+currentCfg.ReportTopic)
+		return
+	}
+	fmt.Printf("Sending event %s failed, adding it to the outbox: %v\n", msg.ID, err)
+	if err := addToOutbox(msg, err); err != nil {
+		err = errors.WithStack(err)
+		fmt.Println(err)
+	}
+}
+
+// flushAll sends the pending events right away, on shutdown.
+func (c *eventCoalescer) flushAll() {
+	c.mu.Lock()
+	ids := make([]string, 0, len(c.pending))
+	for id := range c.pending {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+	for _, id := range ids {
+		c.flush(id)
+	}
+}
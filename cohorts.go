@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// CohortMonthDoc - visits of a cohort in the Offset-th month after the first
+// visit, offset 0 is the month of the first visit
+type CohortMonthDoc struct {
+	Offset         int     `json:"offset"`
+	Month          string  `json:"month"`
+	ActiveContacts int     `json:"activeContacts"`
+	Visits         int     `json:"visits"`
+	AvgSentiment   float64 `json:"avgSentiment"`
+}
+
+// CohortDoc - materialized sentiment of the contacts first visited in a
+// month. Cohorts are stored in the "summaries" container next to the
+// summaries, partitioned by type.
+type CohortDoc struct {
+	Id        string           `json:"id"`
+	Type      string           `json:"type"`
+	Cohort    string           `json:"cohort"`
+	Contacts  int              `json:"contacts"`
+	Months    []CohortMonthDoc `json:"months"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+// CohortQuery - query parameters of GET /stats/cohorts, an inclusive range
+// of first-visit months
+type CohortQuery struct {
+	From string `url:"from" validate:"omitempty,datetime=2006-01"`
+	To   string `url:"to" validate:"omitempty,datetime=2006-01"`
+}
+
+type cohortReportDoc struct {
+	Contact                   ContactDoc `json:"contact"`
+	VisitDate                 string     `json:"visitDate"`
+	VisitResultSentimentScore float64    `json:"visitResultSentimentScore"`
+}
+
+type cohortMonthAcc struct {
+	contacts  map[string]bool
+	visits    int
+	sentiment float64
+}
+
+func monthsBetween(from, to time.Time) int {
+	return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+}
+
+// materializeCohorts recomputes all cohorts. The first visit of a contact
+// can move when reports are added or deleted, so every cohort is rebuilt and
+// cohorts that became empty are removed.
+func materializeCohorts(ctx context.Context) (int, error) {
+	qry := cosmosapi.Query{
+		Query: "SELECT c.contact, c.visitDate, c.visitResultSentimentScore FROM c WHERE c.type = 'visitreport' AND c.result != '' AND " + notDeleted,
+	}
+	var docs []cohortReportDoc
+	_, err := queryAllPartitions(ctx, currentCfg.ReportContainer, qry, 1000, func() interface{} {
+		return &[]cohortReportDoc{}
+	}, func(page interface{}) error {
+		docs = append(docs, *page.(*[]cohortReportDoc)...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	type visit struct {
+		month     time.Time
+		sentiment float64
+	}
+	visits := map[string][]visit{}
+	first := map[string]time.Time{}
+	for _, doc := range docs {
+		t, _, ok := parseVisitDate(doc.VisitDate)
+		if !ok {
+			continue
+		}
+		month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		id := doc.Contact.Id
+		visits[id] = append(visits[id], visit{month: month, sentiment: doc.VisitResultSentimentScore})
+		if f, ok := first[id]; !ok || month.Before(f) {
+			first[id] = month
+		}
+	}
+
+	cohorts := map[string]*CohortDoc{}
+	accs := map[string]map[int]*cohortMonthAcc{}
+	for id, contactVisits := range visits {
+		start := first[id]
+		cohort := start.Format("2006-01")
+		doc, ok := cohorts[cohort]
+		if !ok {
+			doc = &CohortDoc{Id: "cohort-" + cohort, Type: "cohort", Cohort: cohort}
+			cohorts[cohort] = doc
+			accs[cohort] = map[int]*cohortMonthAcc{}
+		}
+		doc.Contacts++
+		for _, v := range contactVisits {
+			offset := monthsBetween(start, v.month)
+			acc, ok := accs[cohort][offset]
+			if !ok {
+				acc = &cohortMonthAcc{contacts: map[string]bool{}}
+				accs[cohort][offset] = acc
+			}
+			acc.contacts[id] = true
+			acc.visits++
+			acc.sentiment += v.sentiment
+		}
+	}
+
+	now := time.Now().UTC()
+	ops := cosmosapi.CreateDocumentOptions{
+		PartitionKeyValue: "cohort",
+		IsUpsert:          true,
+	}
+	for cohort, doc := range cohorts {
+		start, _ := time.Parse("2006-01", cohort)
+		doc.Months = []CohortMonthDoc{}
+		for offset, acc := range accs[cohort] {
+			doc.Months = append(doc.Months, CohortMonthDoc{
+				Offset:         offset,
+				Month:          start.AddDate(0, offset, 0).Format("2006-01"),
+				ActiveContacts: len(acc.contacts),
+				Visits:         acc.visits,
+				AvgSentiment:   acc.sentiment / float64(acc.visits),
+			})
+		}
+		sort.Slice(doc.Months, func(i, j int) bool { return doc.Months[i].Offset < doc.Months[j].Offset })
+		doc.UpdatedAt = now
+		if _, _, err := currentClient.CreateDocument(ctx, currentCfg.DbName, "summaries", doc, ops); err != nil {
+			return 0, err
+		}
+	}
+
+	existing, err := queryCohorts(ctx, CohortQuery{})
+	if err != nil {
+		return 0, err
+	}
+	for _, doc := range existing {
+		if _, ok := cohorts[doc.Cohort]; ok {
+			continue
+		}
+		_, err := currentClient.DeleteDocument(ctx, currentCfg.DbName, "summaries", doc.Id, cosmosapi.DeleteDocumentOptions{
+			PartitionKeyValue: "cohort",
+		})
+		if err != nil && err != cosmosapi.ErrNotFound {
+			return 0, err
+		}
+	}
+	return len(cohorts), nil
+}
+
+func queryCohorts(ctx context.Context, params CohortQuery) ([]CohortDoc, error) {
+	where := "c.type = 'cohort'"
+	var qparams []cosmosapi.QueryParam
+	if params.From != "" {
+		where += " AND c.cohort >= @from"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@from", Value: params.From})
+	}
+	if params.To != "" {
+		where += " AND c.cohort <= @to"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@to", Value: params.To})
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "cohort"
+	qry := cosmosapi.Query{
+		Query:  "SELECT * FROM c WHERE " + where + " ORDER BY c.cohort",
+		Params: qparams,
+	}
+	docs := []CohortDoc{}
+	_, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, "summaries", qry, &docs, qops)
+	return docs, err
+}
+
+func readStatsCohorts(ctx iris.Context) {
+	params := CohortQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	docs, err := queryCohorts(ctx.Request().Context(), params)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(docs))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+)
+
+// commands - admin commands that run instead of the HTTP server
+var commands = map[string]func(args []string) error{
+	"reindex":   reindexSearch,
+	"export":    exportArchive,
+	"import":    importArchive,
+	"summarize": summarize,
+	"selfcheck": selfcheck,
+	"reanalyze": reanalyze,
+	"migrate":   migrate,
+}
+
+func runCommand(name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd(args)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	connectorKindTeams = "teams"
+	connectorKindSlack = "slack"
+)
+
+// ConnectorRoute - outbound webhook a report event is posted to. An empty
+// Company matches every report. Routes without filters are notified of new
+// reports, routes with filters of new and updated reports matching all
+// filters.
+type ConnectorRoute struct {
+	Kind    string            `json:"kind"`
+	URL     string            `json:"url"`
+	Company string            `json:"company"`
+	Filters []ConnectorFilter `json:"filters,omitempty"`
+}
+
+// ConnectorFilter - condition on a report field, evaluated against the
+// report before and after the change. Ops are "changed", "set" (was empty,
+// now is not), "eq", "ne", "lt" and "gt", the latter four compare the new
+// value with Value.
+type ConnectorFilter struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// connectorFields are the report fields filters can refer to
+var connectorFields = map[string]func(doc *VisitReportReadDoc) interface{}{
+	"subject":     func(doc *VisitReportReadDoc) interface{} { return doc.Subject },
+	"description": func(doc *VisitReportReadDoc) interface{} { return doc.Description },
+	"visitDate":   func(doc *VisitReportReadDoc) interface{} { return doc.VisitDate },
+	"result":      func(doc *VisitReportReadDoc) interface{} { return doc.Result },
+	"outcome": func(doc *VisitReportReadDoc) interface{} {
+		if doc.Outcome == nil {
+			return ""
+		}
+		return doc.Outcome.Code
+	},
+	"visitResultSentimentScore": func(doc *VisitReportReadDoc) interface{} { return doc.VisitResultSentimentScore },
+	"contact.id":                func(doc *VisitReportReadDoc) interface{} { return doc.Contact.Id },
+	"contact.company":           func(doc *VisitReportReadDoc) interface{} { return doc.Contact.Company },
+}
+
+func (f ConnectorFilter) validate() error {
+	get, ok := connectorFields[f.Field]
+	if !ok {
+		return fmt.Errorf("connector filter on unknown field %q", f.Field)
+	}
+	_, numeric := get(&VisitReportReadDoc{}).(float64)
+	switch f.Op {
+	case "changed", "set":
+		return nil
+	case "eq", "ne":
+		if f.Value == nil {
+			return fmt.Errorf("connector filter %s %s needs a value", f.Field, f.Op)
+		}
+		return nil
+	case "lt", "gt":
+		if _, ok := f.Value.(float64); !ok || !numeric {
+			return fmt.Errorf("connector filter %s %s needs a numeric field and value", f.Field, f.Op)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported connector filter op %q", f.Op)
+}
+
+// matches evaluates the filter, before is an empty report for new reports.
+func (f ConnectorFilter) matches(before, after *VisitReportReadDoc) bool {
+	get := connectorFields[f.Field]
+	old, value := get(before), get(after)
+	switch f.Op {
+	case "changed":
+		return old != value
+	case "set":
+		return isZeroField(old) && !isZeroField(value)
+	case "eq":
+		return fmt.Sprint(value) == fmt.Sprint(f.Value)
+	case "ne":
+		return fmt.Sprint(value) != fmt.Sprint(f.Value)
+	case "lt":
+		return value.(float64) < f.Value.(float64)
+	case "gt":
+		return value.(float64) > f.Value.(float64)
+	}
+	return false
+}
+
+func isZeroField(v interface{}) bool {
+	return v == "" || v == 0.0
+}
+
+// connectorRoutes is decoded from a JSON array in VR_CONNECTORS, e.g.
+// [{"kind":"teams","url":"https://...","company":"Contoso"},
+// {"kind":"slack","url":"https://...","filters":[{"field":"result","op":"set"},
+// {"field":"visitResultSentimentScore","op":"lt","value":0.3}]}]
+type connectorRoutes []ConnectorRoute
+
+// Decode implements envconfig.Decoder
+func (r *connectorRoutes) Decode(value string) error {
+	var routes []ConnectorRoute
+	if err := json.Unmarshal([]byte(value), &routes); err != nil {
+		return err
+	}
+	for _, route := range routes {
+		if route.Kind != connectorKindTeams && route.Kind != connectorKindSlack {
+			return fmt.Errorf("unsupported connector kind %q", route.Kind)
+		}
+		if route.URL == "" {
+			return fmt.Errorf("connector route for %q has no url", route.Company)
+		}
+		for _, filter := range route.Filters {
+			if err := filter.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	*r = routes
+	return nil
+}
+
+func (r connectorRoutes) match(company string) []ConnectorRoute {
+	var matched []ConnectorRoute
+	for _, route := range r {
+		if route.Company == "" || strings.EqualFold(route.Company, company) {
+			matched = append(matched, route)
+		}
+	}
+	return matched
+}
+
+// connectorMessage - a title and facts, rendered per connector kind
+type connectorMessage struct {
+	Title string
+	Facts [][2]string
+}
+
+var connectorClient = &http.Client{Timeout: 10 * time.Second}
+
+// notifyConnectors posts the event to all matching routes in the background.
+// Before is the report prior to an update, nil for new reports.
+func notifyConnectors(event VisitReportEventDoc, before *VisitReportReadDoc) {
+	var routes []ConnectorRoute
+	for _, route := range currentCfg.Connectors.match(event.Contact.Company) {
+		if len(route.Filters) == 0 {
+			if before == nil {
+				routes = append(routes, route)
+			}
+			continue
+		}
+		prev := before
+		if prev == nil {
+			prev = &VisitReportReadDoc{}
+		}
+		matched := true
+		for _, filter := range route.Filters {
+			matched = matched && filter.matches(prev, &event.VisitReportReadDoc)
+		}
+		if matched {
+			routes = append(routes, route)
+		}
+	}
+	postToConnectorsInBg(routes, reportMessage(&event))
+}
+
+// notifyConnectorsOf posts a message about a company to all matching routes
+// in the background. Filters only apply to report events.
+func notifyConnectorsOf(company string, msg connectorMessage) {
+	postToConnectorsInBg(currentCfg.Connectors.match(company), msg)
+}
+
+func postToConnectorsInBg(routes []ConnectorRoute, msg connectorMessage) {
+	for _, route := range routes {
+		go func(route ConnectorRoute) {
+			if err := postToConnector(route, msg); err != nil {
+				err = errors.WithStack(err)
+				fmt.Println(err)
+			}
+		}(route)
+	}
+}
+
+func postToConnector(route ConnectorRoute, msg connectorMessage) error {
+	var payload interface{}
+	switch route.Kind {
+	case connectorKindTeams:
+		payload = teamsCard(msg)
+	case connectorKindSlack:
+		payload = slackMessage(msg)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, route.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := connectorClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s connector returned %s", route.Kind, resp.Status)
+	}
+	return nil
+}
+
+func connectorTitle(event *VisitReportEventDoc) string {
+	switch event.EventType {
+	case "VisitReportCreatedEvent":
+		return "New visit report"
+	case "VisitReportUpdatedEvent":
+		return "Visit report updated"
+	}
+	return event.EventType
+}
+
+func connectorContactName(event *VisitReportEventDoc) string {
+	name := strings.TrimSpace(event.Contact.Firstname + " " + event.Contact.Lastname)
+	if event.Contact.Company != "" {
+		name = fmt.Sprintf("%s (%s)", name, event.Contact.Company)
+	}
+	return name
+}
+
+func reportMessage(event *VisitReportEventDoc) connectorMessage {
+	return connectorMessage{
+		Title: connectorTitle(event),
+		Facts: [][2]string{
+			{"Subject", event.Subject},
+			{"Contact", connectorContactName(event)},
+			{"Visit date", event.VisitDate},
+		},
+	}
+}
+
+func teamsCard(msg connectorMessage) map[string]interface{} {
+	facts := make([]map[string]string, 0, len(msg.Facts))
+	for _, fact := range msg.Facts {
+		facts = append(facts, map[string]string{"title": fact[0], "value": fact[1]})
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.2",
+					"body": []map[string]interface{}{
+						{
+							"type":   "TextBlock",
+							"size":   "Medium",
+							"weight": "Bolder",
+							"text":   msg.Title,
+						},
+						{
+							"type":  "FactSet",
+							"facts": facts,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func slackMessage(msg connectorMessage) map[string]interface{} {
+	text := "*" + msg.Title + "*"
+	for i, fact := range msg.Facts {
+		// the first fact follows the title, like "*New visit report*: subject"
+		if i == 0 {
+			text += ": " + fact[1]
+			continue
+		}
+		text += "\n" + fact[0] + ": " + fact[1]
+	}
+	return map[string]interface{}{"text": text}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/jinzhu/copier"
+	"github.com/pkg/errors"
+)
+
+const (
+	// contactDeletedBy is recorded as the deleter of reports deleted with
+	// their contact
+	contactDeletedBy = "contact-service"
+	// anonymizedContactName replaces the name of a deleted contact
+	anonymizedContactName = "Deleted contact"
+)
+
+// VisitReportDeletedEventDoc - struct for the event of a deleted report, it
+// carries no contact data
+type VisitReportDeletedEventDoc struct {
+	EventType string    `json:"eventType"`
+	Version   string    `json:"version"`
+	Id        string    `json:"id"`
+	DeletedAt time.Time `json:"deletedAt"`
+	DeletedBy string    `json:"deletedBy"`
+}
+
+// anonymizeContact removes the personal data of a contact from a report, the
+// contact id and company are kept for the stats.
+func anonymizeContact(model *VisitReportModel) bool {
+	contact := model.Contact
+	contact.Firstname = anonymizedContactName
+	contact.Lastname = ""
+	contact.AvatarLocation = ""
+	if contact == model.Contact {
+		return false
+	}
+	model.Contact = contact
+	return true
+}
+
+// handleContactDeleted deletes or anonymizes the reports of a deleted
+// contact, VR_CONTACTDELETION is "delete" or "anonymize". Reports under
+// legal hold are kept unchanged. If a report fails the message is delivered
+// again, reports already handled are skipped then.
+func handleContactDeleted(c context.Context, m *servicebus.Message, doc *ContactEventDoc) {
+	var models []*VisitReportModel
+	err := currentRepo.Query(c, ReportFilter{ContactID: doc.Id}, func(model *VisitReportModel) error {
+		models = append(models, model)
+		return nil
+	})
+	if err != nil {
+		abandonContact(c, m, err)
+		return
+	}
+	deleted, anonymized, held := 0, 0, 0
+	for _, model := range models {
+		if model.LegalHold != nil {
+			held++
+			continue
+		}
+		var err error
+		switch {
+		case currentCfg.ContactDeletion == "delete":
+			err = deleteReportOfContact(c, model)
+		case anonymizeContact(model):
+			err = anonymizeReportOfContact(c, model)
+		default:
+			continue
+		}
+		if err != nil {
+			abandonContact(c, m, errors.Wrapf(err, "report %s", model.Id))
+			return
+		}
+		if currentCfg.ContactDeletion == "delete" {
+			deleted++
+		} else {
+			anonymized++
+		}
+	}
+	fmt.Printf("Contact %s deleted: %d reports deleted, %d anonymized, %d kept under legal hold\n", doc.Id, deleted, anonymized, held)
+	err = m.Complete(c)
+	serviceBusReceived.WithLabelValues(currentCfg.ContactSubscription, metricResult(err)).Inc()
+	if err != nil {
+		err = errors.WithStack(err)
+		fmt.Println(err)
+	}
+}
+
+// deleteReportOfContact soft-deletes a report of a deleted contact. The
+// contact is anonymized as well, a soft-deleted report keeps its data until
+// it is purged.
+func deleteReportOfContact(ctx context.Context, model *VisitReportModel) error {
+	anonymizeContact(model)
+	if err := softDelete(ctx, model, contactDeletedBy); err != nil {
+		return err
+	}
+	removeReportFromIndexInBg(model.Id)
+	event := VisitReportDeletedEventDoc{
+		EventType: "VisitReportDeletedEvent",
+		Version:   "1",
+		Id:        model.Id,
+		DeletedAt: *model.DeletedAt,
+		DeletedBy: contactDeletedBy,
+	}
+	return sendContactCascadeEvent(ctx, event.EventType, event.Version, eventMessageID(event.EventType, model.Id), event)
+}
+
+func anonymizeReportOfContact(ctx context.Context, model *VisitReportModel) error {
+	etag, err := currentRepo.Update(ctx, model)
+	if err != nil {
+		return err
+	}
+	indexReportInBg(*model)
+	event := VisitReportEventDoc{EventType: "VisitReportUpdatedEvent", Version: "1"}
+	copier.Copy(&event.VisitReportReadDoc, model)
+	return sendContactCascadeEvent(ctx, event.EventType, event.Version, eventMessageID(event.EventType, model.Id, etag), event)
+}
+
+// sendContactCascadeEvent sends the event of a report changed by a contact
+// event, a failed send goes to the outbox.
+func sendContactCascadeEvent(ctx context.Context, eventType, version, messageID string, event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	err = sendRequestEvent(ctx, &servicebus.Message{
+		ID:          messageID,
+		ContentType: "application/json",
+		Data:        data,
+	})
+	if err == nil {
+		trackEventEmission(eventType, version, "topic:"+currentCfg.ReportTopic)
+	}
+	return err
+}
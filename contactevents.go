@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	contactCreatedEvent = "ContactCreatedEvent"
+	contactUpdatedEvent = "ContactUpdatedEvent"
+	contactDeletedEvent = "ContactDeletedEvent"
+)
+
+// ContactEventEnvelopeDoc - the fields of a contact event it is routed by.
+// Messages of contact services predating event types have neither and are
+// updates, a missing version is "1".
+type ContactEventEnvelopeDoc struct {
+	EventType string `json:"eventType"`
+	Version   string `json:"version"`
+}
+
+// contactEventHandlers are the handlers of the contact events by event type
+// and version
+var contactEventHandlers = map[string]func(c context.Context, m *servicebus.Message, doc *ContactEventDoc){
+	contactCreatedEvent + "/1": handleContactChanged,
+	contactUpdatedEvent + "/1": handleContactChanged,
+	contactDeletedEvent + "/1": handleContactDeleted,
+}
+
+// contactEventEnvelope reads the event type and version of a message from
+// the body, or from the user properties if the body has none.
+func contactEventEnvelope(m *servicebus.Message) (ContactEventEnvelopeDoc, error) {
+	env := ContactEventEnvelopeDoc{}
+	if err := json.Unmarshal(m.Data, &env); err != nil {
+		return env, err
+	}
+	if env.EventType == "" {
+		env.EventType, _ = m.UserProperties[eventTypeProperty].(string)
+	}
+	if env.EventType == "" {
+		env.EventType = contactUpdatedEvent
+	}
+	if env.Version == "" {
+		env.Version = "1"
+	}
+	return env, nil
+}
+
+// handleContactMessage routes a contact event to its handler. Malformed
+// messages and unknown event types never succeed, they are dead-lettered
+// right away. It never returns an error, that would stop the listener.
+func handleContactMessage(c context.Context, m *servicebus.Message) error {
+	env, err := contactEventEnvelope(m)
+	if err != nil {
+		deadLetterContact(c, m, errors.Wrap(err, "malformed contact event"))
+		return nil
+	}
+	handler, ok := contactEventHandlers[env.EventType+"/"+env.Version]
+	if !ok {
+		deadLetterContact(c, m, fmt.Errorf("unknown contact event %s version %s", env.EventType, env.Version))
+		return nil
+	}
+	doc := ContactEventDoc{}
+	if err := json.Unmarshal(m.Data, &doc); err != nil {
+		deadLetterContact(c, m, errors.Wrapf(err, "malformed %s", env.EventType))
+		return nil
+	}
+	if doc.Id == "" {
+		deadLetterContact(c, m, fmt.Errorf("%s without contact id", env.EventType))
+		return nil
+	}
+	handler(c, m, &doc)
+	return nil
+}
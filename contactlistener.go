@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// contactReconnectMinBackoff and contactReconnectMaxBackoff bound the
+	// wait before reconnecting a dead contact listener, doubled per failure
+	contactReconnectMinBackoff = time.Second
+	contactReconnectMaxBackoff = time.Minute
+	// contactListenerStable is how long a listener has to run until its
+	// backoff is reset
+	contactListenerStable = 5 * time.Minute
+)
+
+const (
+	listenerConnecting   = "connecting"
+	listenerListening    = "listening"
+	listenerReconnecting = "reconnecting"
+	listenerStopped      = "stopped"
+)
+
+// ContactListenerDoc - state of the listener of the contact subscription,
+// returned with the readiness check
+type ContactListenerDoc struct {
+	State       string     `json:"state"`
+	Since       time.Time  `json:"since"`
+	Reconnects  int        `json:"reconnects"`
+	LastError   string     `json:"lastError,omitempty"`
+	NextAttempt *time.Time `json:"nextAttempt,omitempty"`
+}
+
+var contactListenerState = struct {
+	sync.Mutex
+	ContactListenerDoc
+}{ContactListenerDoc: ContactListenerDoc{State: listenerStopped}}
+
+func setContactListenerState(state string, err error, next *time.Time) {
+	contactListenerState.Lock()
+	defer contactListenerState.Unlock()
+	if state == listenerReconnecting && contactListenerState.State != listenerReconnecting {
+		contactListenerState.Reconnects++
+	}
+	contactListenerState.State = state
+	contactListenerState.Since = time.Now().UTC()
+	contactListenerState.NextAttempt = next
+	if err != nil {
+		contactListenerState.LastError = err.Error()
+	}
+}
+
+func readContactListenerState() ContactListenerDoc {
+	contactListenerState.Lock()
+	defer contactListenerState.Unlock()
+	return contactListenerState.ContactListenerDoc
+}
+
+// superviseContactListener listens to the contact subscription until the
+// server shuts down. A listener stops for good when the AMQP link can't be
+// recovered, it is replaced by a new one with exponential backoff.
+func superviseContactListener(sub *servicebus.Subscription) {
+	backoff := contactReconnectMinBackoff
+	for {
+		setContactListenerState(listenerConnecting, nil, nil)
+		started := time.Now()
+		err := listenContacts(sub)
+		if serverContext.Err() != nil {
+			setContactListenerState(listenerStopped, nil, nil)
+			return
+		}
+		if time.Since(started) >= contactListenerStable {
+			backoff = contactReconnectMinBackoff
+		}
+		next := time.Now().Add(backoff).UTC()
+		setContactListenerState(listenerReconnecting, err, &next)
+		fmt.Println(errors.Wrapf(err, "contact listener stopped, reconnecting in %v", backoff))
+		select {
+		case <-time.After(backoff):
+		case <-serverContext.Done():
+			setContactListenerState(listenerStopped, nil, nil)
+			return
+		}
+		backoff *= 2
+		if backoff > contactReconnectMaxBackoff {
+			backoff = contactReconnectMaxBackoff
+		}
+	}
+}
+
+// listenContacts runs a listener until it stops and returns why.
+func listenContacts(sub *servicebus.Subscription) error {
+	receiver, err := sub.NewReceiver(serverContext)
+	if err != nil {
+		return err
+	}
+	handle := receiver.Listen(serverContext, servicebus.HandlerFunc(handleContactMessage))
+	setContactListenerState(listenerListening, nil, nil)
+	<-handle.Done()
+	if err := handle.Err(); err != nil {
+		return err
+	}
+	return errNotConnected
+}
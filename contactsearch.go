@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// contactNameCondition matches every word of the name case-insensitively as
+// a substring of the contact's firstname, lastname or company.
+func contactNameCondition(name string) (string, []cosmosapi.QueryParam) {
+	var conditions []string
+	var params []cosmosapi.QueryParam
+	for i, word := range strings.Fields(strings.ToLower(name)) {
+		p := fmt.Sprintf("@contactname%d", i)
+		conditions = append(conditions, fmt.Sprintf(
+			"(CONTAINS(LOWER(c.contact.firstname), %[1]s) OR CONTAINS(LOWER(c.contact.lastname), %[1]s) OR CONTAINS(LOWER(c.contact.company), %[1]s))", p))
+		params = append(params, cosmosapi.QueryParam{Name: p, Value: word})
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")", params
+}
+
+// searchByContactName uses the search index for fuzzy matching, so typos
+// like "Mueler" still find "Mueller".
+func searchByContactName(ctx context.Context, name, contactid string) ([]VisitReportListDoc, error) {
+	var terms []string
+	for _, word := range strings.Fields(name) {
+		terms = append(terms, "+"+escapeLucene(word)+"~1")
+	}
+	body := map[string]interface{}{
+		"search":       strings.Join(terms, " "),
+		"queryType":    "full",
+		"searchMode":   "all",
+		"searchFields": "contact/firstname,contact/lastname,contact/company",
+		"select":       "id,number,subject,visitDate,contact",
+		"top":          1000,
+	}
+	if contactid != "" {
+		body["filter"] = fmt.Sprintf("contact/id eq '%s'", strings.ReplaceAll(contactid, "'", "''"))
+	}
+
+	var res searchResponse
+	if err := searchRequest(ctx, http.MethodPost, "/"+currentCfg.SearchIndex+"/docs/search", body, &res); err != nil {
+		return []VisitReportListDoc{}, err
+	}
+	out := make([]VisitReportListDoc, 0, len(res.Value))
+	for _, hit := range res.Value {
+		hit.Type = "visitreport"
+		out = append(out, hit.VisitReportListDoc)
+	}
+	return out, nil
+}
+
+// escapeLucene escapes the special characters of the Lucene query syntax.
+func escapeLucene(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`+-&|!(){}[]^"~*?:\/`, r) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
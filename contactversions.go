@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+)
+
+// contactUpdateMaxRetries limits the retries of updating the contact of a
+// report that changed in the meantime
+const contactUpdateMaxRetries = 3
+
+// ContactEventDoc - a contact from an event of the contact service.
+// ContactVersion increases with every change of a contact, UpdatedAt is
+// used if an event has no version. Events with neither are always applied.
+type ContactEventDoc struct {
+	ContactDoc
+	ContactVersion int64      `json:"contactVersion,omitempty"`
+	UpdatedAt      *time.Time `json:"updatedAt,omitempty"`
+}
+
+// ContactSnapshotDoc - version of the contact data stored in a report, the
+// version of the last contact event applied to it
+type ContactSnapshotDoc struct {
+	Version   int64      `json:"version,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+func (e *ContactEventDoc) snapshot() *ContactSnapshotDoc {
+	if e.ContactVersion == 0 && e.UpdatedAt == nil {
+		return nil
+	}
+	return &ContactSnapshotDoc{Version: e.ContactVersion, UpdatedAt: e.UpdatedAt}
+}
+
+// isStaleContactEvent reports whether a report already has the contact data
+// of the event or of a newer one, events may arrive out of order and more
+// than once.
+func isStaleContactEvent(event *ContactEventDoc, stored *ContactSnapshotDoc) bool {
+	if stored == nil {
+		return false
+	}
+	if event.ContactVersion != 0 && stored.Version != 0 {
+		return event.ContactVersion <= stored.Version
+	}
+	if event.UpdatedAt != nil && stored.UpdatedAt != nil {
+		return !event.UpdatedAt.After(*stored.UpdatedAt)
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+// supportedMediaTypes are the media types request bodies are accepted in,
+// structured syntax suffixes like application/problem+json are JSON as well
+var supportedMediaTypes = []string{"application/json", "application/*+json"}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" ||
+		(strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
+
+// requireJSONBody rejects request bodies that are not JSON with 415, instead
+// of letting the JSON decoder fail on them. Requests without a body, like
+// DELETE or POST /reports/{reportid}/draft/submit, are passed on.
+func requireJSONBody(ctx iris.Context) {
+	r := ctx.Request()
+	if r.ContentLength == 0 && len(r.TransferEncoding) == 0 {
+		ctx.Next()
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && isJSONMediaType(mediaType) {
+		ctx.Next()
+		return
+	}
+	ctx.StopWithProblem(iris.StatusUnsupportedMediaType, iris.NewProblem().
+		Title("Unsupported media type").
+		Detail(fmt.Sprintf("Request bodies must be sent as %s", strings.Join(supportedMediaTypes, " or "))).
+		Key("supported", supportedMediaTypes))
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// coverageFrequencies maps the target frequencies to the time allowed
+// between two visits, as years, months and days
+var coverageFrequencies = map[string][3]int{
+	"weekly":    {0, 0, 7},
+	"monthly":   {0, 1, 0},
+	"quarterly": {0, 3, 0},
+	"yearly":    {1, 0, 0},
+}
+
+// CoverageTargetDoc - target visit frequency of a company. Targets are
+// stored in the "config" container, partitioned by type.
+type CoverageTargetDoc struct {
+	Id        string    `json:"id"`
+	Type      string    `json:"type"`
+	Company   string    `json:"company"`
+	Frequency string    `json:"frequency"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// AlertedDue is the due date the last overdue event was sent for
+	AlertedDue string `json:"alertedDue,omitempty"`
+}
+
+// CoverageTargetSaveDoc - struct for setting the target of a company
+type CoverageTargetSaveDoc struct {
+	Frequency string `json:"frequency" validate:"required,oneof=weekly monthly quarterly yearly"`
+}
+
+// CompanyCoverageDoc - visit coverage of a company with a target. DueDate is
+// the date the next visit is due, counted from the last visit or, without
+// any visit, from when the target was set.
+type CompanyCoverageDoc struct {
+	Company     string `json:"company"`
+	Frequency   string `json:"frequency"`
+	LastVisit   string `json:"lastVisit,omitempty"`
+	DueDate     string `json:"dueDate"`
+	Overdue     bool   `json:"overdue"`
+	DaysOverdue int    `json:"daysOverdue"`
+}
+
+// CoverageQuery - query parameters of GET /stats/coverage
+type CoverageQuery struct {
+	Overdue bool `url:"overdue"`
+}
+
+// CompanyVisitOverdueEventDoc - sent once per due date when a company is
+// overdue for a visit
+type CompanyVisitOverdueEventDoc struct {
+	EventType   string    `json:"eventType"`
+	Version     string    `json:"version"`
+	Company     string    `json:"company"`
+	Frequency   string    `json:"frequency"`
+	LastVisit   string    `json:"lastVisit,omitempty"`
+	DueDate     string    `json:"dueDate"`
+	DaysOverdue int       `json:"daysOverdue"`
+	DetectedAt  time.Time `json:"detectedAt"`
+}
+
+type lastVisitDoc struct {
+	Company   string `json:"company"`
+	LastVisit string `json:"lastVisit"`
+}
+
+func coverageTargetId(company string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(company))))
+	return hex.EncodeToString(sum[:16])
+}
+
+func queryCoverageTargets(ctx context.Context) ([]CoverageTargetDoc, error) {
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "coveragetarget"
+	qry := cosmosapi.Query{
+		Query: "SELECT * FROM c WHERE c.type = 'coveragetarget'",
+	}
+	targets := []CoverageTargetDoc{}
+	_, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, "config", qry, &targets, qops)
+	return targets, err
+}
+
+// queryCoverage returns the coverage of every company with a target, most
+// overdue first, using the same definition of a visit as the stats.
+func queryCoverage(ctx context.Context, targets []CoverageTargetDoc) ([]CompanyCoverageDoc, error) {
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qry := cosmosapi.Query{
+		Query: "SELECT c.contact.company, MAX(c.visitDate) as lastVisit FROM c WHERE c.type = 'visitreport' AND c.result != '' AND " + notDeleted + " GROUP BY c.contact.company",
+	}
+	var visits []lastVisitDoc
+	if _, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, qry, &visits, qops); err != nil {
+		return nil, err
+	}
+	lastVisits := map[string]time.Time{}
+	for _, v := range visits {
+		t, _, ok := parseVisitDate(v.LastVisit)
+		company := strings.ToLower(v.Company)
+		if ok && t.After(lastVisits[company]) {
+			lastVisits[company] = t
+		}
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	coverage := []CompanyCoverageDoc{}
+	for _, target := range targets {
+		doc := CompanyCoverageDoc{Company: target.Company, Frequency: target.Frequency}
+		from := target.CreatedAt
+		if last, ok := lastVisits[strings.ToLower(target.Company)]; ok {
+			from = last
+			doc.LastVisit = last.Format("2006-01-02")
+		}
+		f := coverageFrequencies[target.Frequency]
+		due := from.UTC().Truncate(24*time.Hour).AddDate(f[0], f[1], f[2])
+		doc.DueDate = due.Format("2006-01-02")
+		if today.After(due) {
+			doc.Overdue = true
+			doc.DaysOverdue = int(today.Sub(due).Hours() / 24)
+		}
+		coverage = append(coverage, doc)
+	}
+	sort.SliceStable(coverage, func(i, j int) bool {
+		return coverage[i].DaysOverdue > coverage[j].DaysOverdue
+	})
+	return coverage, nil
+}
+
+func readStatsCoverage(ctx iris.Context) {
+	params := CoverageQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	reqCtx := ctx.Request().Context()
+	targets, err := queryCoverageTargets(reqCtx)
+	var coverage []CompanyCoverageDoc
+	if err == nil {
+		coverage, err = queryCoverage(reqCtx, targets)
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	if params.Overdue {
+		overdue := []CompanyCoverageDoc{}
+		for _, c := range coverage {
+			if c.Overdue {
+				overdue = append(overdue, c)
+			}
+		}
+		coverage = overdue
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(coverage))
+}
+
+// startCoverageWorker checks the coverage every VR_COVERAGEINTERVAL and
+// alerts once per due date of an overdue company.
+func startCoverageWorker() {
+	go func() {
+		for {
+			n, err := alertOverdueCompanies(context.Background())
+			if err != nil {
+				err = errors.WithStack(err)
+				fmt.Println(err)
+			} else if n > 0 {
+				fmt.Printf("Sent %d overdue visit alerts\n", n)
+			}
+			time.Sleep(currentCfg.CoverageInterval)
+		}
+	}()
+}
+
+func alertOverdueCompanies(ctx context.Context) (int, error) {
+	targets, err := queryCoverageTargets(ctx)
+	if err != nil || len(targets) == 0 {
+		return 0, err
+	}
+	coverage, err := queryCoverage(ctx, targets)
+	if err != nil {
+		return 0, err
+	}
+	byId := map[string]*CoverageTargetDoc{}
+	for i := range targets {
+		byId[targets[i].Id] = &targets[i]
+	}
+
+	sent := 0
+	for _, c := range coverage {
+		target := byId[coverageTargetId(c.Company)]
+		if !c.Overdue || target == nil || target.AlertedDue == c.DueDate {
+			continue
+		}
+		event := CompanyVisitOverdueEventDoc{
+			EventType:   "CompanyVisitOverdueEvent",
+			Version:     "1",
+			Company:     c.Company,
+			Frequency:   c.Frequency,
+			LastVisit:   c.LastVisit,
+			DueDate:     c.DueDate,
+			DaysOverdue: c.DaysOverdue,
+			DetectedAt:  time.Now().UTC(),
+		}
+		id := eventMessageID(event.EventType, c.Company, c.DueDate)
+		if err := publishEvent(event.EventType, event.Version, id, event); err != nil {
+			return sent, err
+		}
+		lastVisit := c.LastVisit
+		if lastVisit == "" {
+			lastVisit = "never"
+		}
+		notifyConnectorsOf(c.Company, connectorMessage{
+			Title: "Visit overdue",
+			Facts: [][2]string{
+				{"Company", c.Company},
+				{"Target", c.Frequency},
+				{"Last visit", lastVisit},
+				{"Days overdue", strconv.Itoa(c.DaysOverdue)},
+			},
+		})
+		target.AlertedDue = c.DueDate
+		if err := saveCoverageTarget(ctx, target); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+func saveCoverageTarget(ctx context.Context, target *CoverageTargetDoc) error {
+	ops := cosmosapi.CreateDocumentOptions{
+		PartitionKeyValue: "coveragetarget",
+		IsUpsert:          true,
+	}
+	_, _, err := currentClient.CreateDocument(ctx, currentCfg.DbName, "config", target, ops)
+	return err
+}
+
+func listCoverageTargets(ctx iris.Context) {
+	targets, err := queryCoverageTargets(ctx.Request().Context())
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Company < targets[j].Company })
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(targets))
+}
+
+// saveCoverageTargetHandler sets the target of a company, e.g.
+// PUT /coverage/targets/Contoso {"frequency":"quarterly"}.
+func saveCoverageTargetHandler(ctx iris.Context) {
+	company := strings.TrimSpace(ctx.Params().GetString("company"))
+	in := CoverageTargetSaveDoc{}
+	if err := ctx.ReadJSON(&in); err != nil {
+		handleBindError(ctx, err)
+		return
+	}
+	reqCtx := ctx.Request().Context()
+	now := time.Now().UTC()
+	target := CoverageTargetDoc{}
+	_, err := currentClient.GetDocument(reqCtx, currentCfg.DbName, "config", coverageTargetId(company), cosmosapi.GetDocumentOptions{
+		PartitionKeyValue: "coveragetarget",
+	}, &target)
+	if err == cosmosapi.ErrNotFound {
+		target = CoverageTargetDoc{Id: coverageTargetId(company), Type: "coveragetarget", CreatedAt: now}
+		err = nil
+	}
+	if err == nil {
+		target.Company = company
+		target.Frequency = in.Frequency
+		target.UpdatedAt = now
+		err = saveCoverageTarget(reqCtx, &target)
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(target))
+}
+
+func deleteCoverageTarget(ctx iris.Context) {
+	company := ctx.Params().GetString("company")
+	ops := cosmosapi.DeleteDocumentOptions{
+		PartitionKeyValue: "coveragetarget",
+	}
+	_, err := currentClient.DeleteDocument(ctx.Request().Context(), currentCfg.DbName, "config", coverageTargetId(company), ops)
+	if err == cosmosapi.ErrNotFound {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusNoContent)
+}
@@ -0,0 +1,17 @@
+package main
+
+import "github.com/vippsas/go-cosmosdb/cosmosapi"
+
+// CrmLinkDoc - link of a report to an opportunity or deal in the CRM. The id
+// has to match VR_CRMIDPATTERN, a report can have up to 20 links.
+type CrmLinkDoc struct {
+	Type string `json:"type" validate:"required,oneof=opportunity deal"`
+	Id   string `json:"id" validate:"required,crmid"`
+}
+
+// crmLinkCondition returns the condition matching reports linked to a CRM id.
+func crmLinkCondition(crmid string) (string, []cosmosapi.QueryParam) {
+	return "EXISTS(SELECT VALUE l FROM l IN c.crmLinks WHERE l.id = @crmid)", []cosmosapi.QueryParam{
+		{Name: "@crmid", Value: crmid},
+	}
+}
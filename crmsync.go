@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	crmKindDynamics   = "dynamics"
+	crmKindSalesforce = "salesforce"
+
+	crmSyncPending = "pending"
+	crmSyncSynced  = "synced"
+	crmSyncFailed  = "failed"
+
+	crmSyncAttempts   = 5
+	crmSyncMinBackoff = time.Second
+	crmSyncQueueSize  = 100
+)
+
+// crmDefaultFields map the fields of a Dynamics 365 appointment and a
+// Salesforce event to the JSON names of report fields
+var crmDefaultFields = map[string]map[string]string{
+	crmKindDynamics: {
+		"subject":        "subject",
+		"description":    "description",
+		"scheduledstart": "visitDate",
+		"scheduledend":   "visitDate",
+	},
+	crmKindSalesforce: {
+		"Subject":      "subject",
+		"Description":  "description",
+		"ActivityDate": "visitDate",
+	},
+}
+
+var crmDefaultEntities = map[string]string{
+	crmKindDynamics:   "appointments",
+	crmKindSalesforce: "Event",
+}
+
+// CrmSyncConfig - connector mirroring reports as CRM activities, decoded from
+// a JSON object in VR_CRMSYNC, e.g. {"kind":"dynamics",
+// "url":"https://contoso.crm.dynamics.com/api/data/v9.2",
+// "tokenUrl":"https://login.microsoftonline.com/<tenant>/oauth2/v2.0/token",
+// "clientId":"...","clientSecret":"...","scope":"https://contoso.crm.dynamics.com/.default"}.
+// Fields maps CRM fields to report fields, e.g. {"subject":"subject",
+// "new_company":"contact.company"}.
+type CrmSyncConfig struct {
+	Kind         string            `json:"kind"`
+	URL          string            `json:"url"`
+	Entity       string            `json:"entity"`
+	TokenURL     string            `json:"tokenUrl"`
+	ClientID     string            `json:"clientId"`
+	ClientSecret string            `json:"clientSecret"`
+	Scope        string            `json:"scope"`
+	Fields       map[string]string `json:"fields"`
+}
+
+// Decode implements envconfig.Decoder
+func (c *CrmSyncConfig) Decode(value string) error {
+	cfg := CrmSyncConfig{}
+	if err := json.Unmarshal([]byte(value), &cfg); err != nil {
+		return err
+	}
+	if _, ok := crmDefaultEntities[cfg.Kind]; !ok {
+		return fmt.Errorf("unsupported crm kind %q", cfg.Kind)
+	}
+	if cfg.URL == "" || cfg.TokenURL == "" {
+		return fmt.Errorf("crm sync needs url and tokenUrl")
+	}
+	if cfg.Entity == "" {
+		cfg.Entity = crmDefaultEntities[cfg.Kind]
+	}
+	if len(cfg.Fields) == 0 {
+		cfg.Fields = crmDefaultFields[cfg.Kind]
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	*c = cfg
+	return nil
+}
+
+func crmSyncEnabled() bool {
+	return currentCfg.CrmSync.Kind != ""
+}
+
+// CrmSyncStatusDoc - sync status of a report. Statuses are stored in the
+// "crmsync" container, partitioned by type, with the report id as id.
+type CrmSyncStatusDoc struct {
+	Id           string    `json:"id"`
+	Type         string    `json:"type"`
+	Kind         string    `json:"kind"`
+	ActivityId   string    `json:"activityId,omitempty"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	LastError    string    `json:"lastError,omitempty"`
+	LastEvent    string    `json:"lastEvent"`
+	LastSyncedAt time.Time `json:"lastSyncedAt,omitempty"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// CrmSyncStatusQuery - query parameters of GET /crmsync
+type CrmSyncStatusQuery struct {
+	Status string `url:"status" validate:"omitempty,oneof=pending synced failed"`
+}
+
+var crmHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// crmQueue is served by a single worker, so the events of a report are
+// synced in order and an activity is never created twice.
+var crmQueue = make(chan VisitReportEventDoc, crmSyncQueueSize)
+
+// startCrmSync starts the worker mirroring reports to the CRM.
+func startCrmSync() {
+	go func() {
+		for event := range crmQueue {
+			syncActivity(event)
+		}
+	}()
+}
+
+// syncActivityInBg queues the event for the CRM sync, if enabled.
+func syncActivityInBg(event VisitReportEventDoc) {
+	if !crmSyncEnabled() {
+		return
+	}
+	select {
+	case crmQueue <- event:
+	default:
+		status := CrmSyncStatusDoc{}
+		getCrmSyncStatus(context.Background(), event.Id, &status)
+		status.Status = crmSyncFailed
+		status.LastError = "sync queue full"
+		status.LastEvent = event.EventType
+		saveCrmSyncStatus(event.Id, &status)
+	}
+}
+
+func syncActivity(event VisitReportEventDoc) {
+	status := CrmSyncStatusDoc{}
+	if err := getCrmSyncStatus(context.Background(), event.Id, &status); err != nil && err != cosmosapi.ErrNotFound {
+		err = errors.WithStack(err)
+		fmt.Println(err)
+		return
+	}
+	status.Status = crmSyncPending
+	status.LastEvent = event.EventType
+	status.Attempts = 0
+	for attempt := 1; attempt <= crmSyncAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(crmSyncMinBackoff << uint(attempt-2))
+		}
+		status.Attempts = attempt
+		activityID, retry, err := pushActivity(&event, status.ActivityId)
+		if err == nil {
+			status.ActivityId = activityID
+			status.Status = crmSyncSynced
+			status.LastError = ""
+			status.LastSyncedAt = time.Now().UTC()
+			break
+		}
+		status.Status = crmSyncFailed
+		status.LastError = err.Error()
+		if !retry {
+			break
+		}
+	}
+	if status.Status == crmSyncFailed {
+		fmt.Printf("CRM sync of report %s failed after %d attempts: %s\n", event.Id, status.Attempts, status.LastError)
+	}
+	saveCrmSyncStatus(event.Id, &status)
+}
+
+// crmActivity maps the report to the fields of the CRM activity.
+func crmActivity(event *VisitReportEventDoc) (map[string]interface{}, error) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	report := map[string]interface{}{}
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, err
+	}
+	activity := map[string]interface{}{}
+	for crmField, path := range currentCfg.CrmSync.Fields {
+		var value interface{} = report
+		for _, key := range strings.Split(path, ".") {
+			obj, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value = obj[key]
+		}
+		if value != nil {
+			activity[crmField] = value
+		}
+	}
+	return activity, nil
+}
+
+// pushActivity creates the activity, or updates it if it already has an id,
+// and returns its id. retry is set for errors worth another attempt.
+func pushActivity(event *VisitReportEventDoc, activityID string) (id string, retry bool, err error) {
+	cfg := &currentCfg.CrmSync
+	activity, err := crmActivity(event)
+	if err != nil {
+		return "", false, err
+	}
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return "", false, err
+	}
+
+	method, u := http.MethodPost, cfg.URL+"/"+cfg.Entity
+	if cfg.Kind == crmKindSalesforce {
+		u = cfg.URL + "/sobjects/" + cfg.Entity + "/"
+	}
+	if activityID != "" {
+		method = http.MethodPatch
+		if cfg.Kind == crmKindSalesforce {
+			u += url.PathEscape(activityID)
+		} else {
+			u += "(" + activityID + ")"
+		}
+	}
+
+	token, err := currentCrmToken.get()
+	if err != nil {
+		return "", true, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := crmHTTPClient.Do(req)
+	if err != nil {
+		return "", true, err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusUnauthorized {
+		currentCrmToken.reset()
+	}
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return "", retry, fmt.Errorf("%s %s returned %s: %.200s", cfg.Kind, method, resp.Status, b)
+	}
+	if activityID != "" {
+		return activityID, false, nil
+	}
+
+	switch cfg.Kind {
+	case crmKindDynamics:
+		// e.g. OData-EntityId: https://.../appointments(00000000-0000-0000-0000-000000000001)
+		entity := resp.Header.Get("OData-EntityId")
+		if i, j := strings.LastIndex(entity, "("), strings.LastIndex(entity, ")"); i >= 0 && j > i {
+			return entity[i+1 : j], false, nil
+		}
+	case crmKindSalesforce:
+		created := struct {
+			Id string `json:"id"`
+		}{}
+		if json.Unmarshal(b, &created) == nil && created.Id != "" {
+			return created.Id, false, nil
+		}
+	}
+	return "", false, fmt.Errorf("%s returned no activity id", cfg.Kind)
+}
+
+// crmToken caches the OAuth 2.0 client credentials token of the CRM.
+type crmToken struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+var currentCrmToken = &crmToken{}
+
+func (t *crmToken) get() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+	cfg := &currentCfg.CrmSync
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {cfg.ClientID},
+		"client_secret": {cfg.ClientSecret},
+	}
+	if cfg.Scope != "" {
+		form.Set("scope", cfg.Scope)
+	}
+	resp, err := crmHTTPClient.PostForm(cfg.TokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("crm token endpoint returned %s", resp.Status)
+	}
+	out := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	// Salesforce sends no expires_in, its tokens live for the session timeout
+	if out.ExpiresIn == 0 {
+		out.ExpiresIn = 3600
+	}
+	t.token = out.AccessToken
+	t.expires = time.Now().Add(time.Duration(out.ExpiresIn)*time.Second - time.Minute)
+	return t.token, nil
+}
+
+func (t *crmToken) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = ""
+}
+
+func getCrmSyncStatus(ctx context.Context, reportid string, status *CrmSyncStatusDoc) error {
+	ro := cosmosapi.GetDocumentOptions{
+		PartitionKeyValue: "crmsync",
+	}
+	_, err := currentClient.GetDocument(ctx, currentCfg.DbName, "crmsync", reportid, ro, status)
+	return err
+}
+
+func saveCrmSyncStatus(reportid string, status *CrmSyncStatusDoc) {
+	status.Id = reportid
+	status.Type = "crmsync"
+	status.Kind = currentCfg.CrmSync.Kind
+	status.UpdatedAt = time.Now().UTC()
+	ops := cosmosapi.CreateDocumentOptions{
+		PartitionKeyValue: "crmsync",
+		IsUpsert:          true,
+	}
+	if _, _, err := currentClient.CreateDocument(context.Background(), currentCfg.DbName, "crmsync", status, ops); err != nil {
+		err = errors.WithStack(err)
+		fmt.Println(err)
+	}
+}
+
+// listCrmSyncStatus lists the sync statuses, e.g. GET /crmsync?status=failed.
+func listCrmSyncStatus(ctx iris.Context) {
+	params := CrmSyncStatusQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "crmsync"
+	qry := cosmosapi.Query{
+		Query: "SELECT * FROM c WHERE c.type = 'crmsync'",
+	}
+	if params.Status != "" {
+		qry.Query += " AND c.status = @status"
+		qry.Params = []cosmosapi.QueryParam{{Name: "@status", Value: params.Status}}
+	}
+	qry.Query += " ORDER BY c.updatedAt DESC"
+	docs := []CrmSyncStatusDoc{}
+	_, err := currentClient.QueryDocuments(ctx.Request().Context(), currentCfg.DbName, "crmsync", qry, &docs, qops)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(docs))
+}
+
+func readCrmSyncStatus(ctx iris.Context) {
+	status := CrmSyncStatusDoc{}
+	err := getCrmSyncStatus(ctx.Request().Context(), ctx.Params().GetString("reportid"), &status)
+	if err == cosmosapi.ErrNotFound {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(status))
+}
+
+// retryCrmSync queues the current state of a report for another sync.
+func retryCrmSync(ctx iris.Context) {
+	if !crmSyncEnabled() {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	reportid := ctx.Params().GetString("reportid")
+	model, err := currentRepo.Get(ctx.Request().Context(), reportid)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	event := VisitReportEventDoc{EventType: "VisitReportUpdatedEvent", Version: "1"}
+	copier.Copy(&event.VisitReportReadDoc, model)
+	syncActivityInBg(event)
+	ctx.StatusCode(http.StatusAccepted)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	dashboardTimelineDays = 90
+	dashboardTopContacts  = 5
+	dashboardLatestItems  = 10
+)
+
+// TopContactDoc - struct for the most visited contacts
+type TopContactDoc struct {
+	Id        string `json:"id"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	Company   string `json:"company"`
+	Visits    int    `json:"visits"`
+}
+
+// DashboardDoc - struct for the pre-aggregated dashboard
+type DashboardDoc struct {
+	Overall       []StatsOverallDoc    `json:"overall"`
+	Timeline      []StatsTimelineDoc   `json:"timeline"`
+	TopContacts   []TopContactDoc      `json:"topContacts"`
+	LatestReports []VisitReportListDoc `json:"latestReports"`
+	GeneratedAt   time.Time            `json:"generatedAt"`
+	Warnings      []QueryWarningDoc    `json:"warnings,omitempty"`
+}
+
+type dashboardCache struct {
+	mu      sync.Mutex
+	doc     *DashboardDoc
+	expires time.Time
+}
+
+var currentDashboardCache = &dashboardCache{}
+
+func (c *dashboardCache) get() *DashboardDoc {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.doc == nil || time.Now().After(c.expires) {
+		return nil
+	}
+	return c.doc
+}
+
+func (c *dashboardCache) set(doc *DashboardDoc, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.doc = doc
+	c.expires = time.Now().Add(ttl)
+}
+
+// patch changes a copy of the cached dashboard and caches it until the
+// cached one expires, false if none is cached.
+func (c *dashboardCache) patch(fn func(doc *DashboardDoc)) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.doc == nil || time.Now().After(c.expires) {
+		return false
+	}
+	doc := *c.doc
+	fn(&doc)
+	c.doc = &doc
+	return true
+}
+
+// readDashboard serves the cached dashboard, unless the request prefers
+// fresh data.
+func readDashboard(ctx iris.Context) {
+	if doc := currentDashboardCache.get(); doc != nil && !wantsFresh(ctx.Request().Context()) {
+		ctx.StatusCode(http.StatusOK)
+		ctx.JSON(normalize(doc))
+		return
+	}
+
+	doc, err := buildDashboard(ctx.Request().Context())
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	// partial dashboards are not cached, the next request retries
+	if len(doc.Warnings) == 0 {
+		currentDashboardCache.set(doc, currentCfg.DashboardCacheTTL)
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(doc))
+}
+
+// buildDashboard runs all dashboard queries in parallel. Failed queries are
+// left empty and reported in the warnings of the dashboard.
+func buildDashboard(ctx context.Context) (*DashboardDoc, error) {
+	doc := &DashboardDoc{GeneratedAt: time.Now().UTC()}
+	queries := []parallelQuery{
+		{name: "overall", run: func(ctx context.Context) (err error) {
+			doc.Overall, err = queryStatsOverall(ctx)
+			return
+		}},
+		{name: "timeline", run: func(ctx context.Context) (err error) {
+			from := time.Now().UTC().AddDate(0, 0, -dashboardTimelineDays).Format("2006-01-02")
+			doc.Timeline, err = queryStatsTimeline(ctx, from, "")
+			return
+		}},
+		{name: "topContacts", run: func(ctx context.Context) (err error) {
+			doc.TopContacts, err = queryTopContacts(ctx, dashboardTopContacts)
+			return
+		}},
+		{name: "latestReports", run: func(ctx context.Context) (err error) {
+			doc.LatestReports, err = queryLatestReports(ctx, dashboardLatestItems)
+			return
+		}},
+	}
+
+	warnings, err := runParallel(ctx, queries)
+	if err != nil {
+		return nil, err
+	}
+	if len(warnings) > 0 {
+		doc.Warnings = warnings
+	}
+	return doc, nil
+}
+
+// queryTopContacts returns the contacts with the most visits. Cosmos does not
+// support ORDER BY on GROUP BY results, so sorting happens here.
+func queryTopContacts(ctx context.Context, n int) ([]TopContactDoc, error) {
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qry := cosmosapi.Query{
+		Query: `SELECT
+				c.contact.id,
+				c.contact.firstname,
+				c.contact.lastname,
+				c.contact.company,
+				COUNT(1) as visits
+				FROM c
+				WHERE c.type = 'visitreport' AND ` + notDeleted + `
+				GROUP BY c.contact.id, c.contact.firstname, c.contact.lastname, c.contact.company`,
+	}
+	var docs []TopContactDoc
+	_, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Visits > docs[j].Visits
+	})
+	if len(docs) > n {
+		docs = docs[:n]
+	}
+	return docs, nil
+}
+
+func queryLatestReports(ctx context.Context, n int) ([]VisitReportListDoc, error) {
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qry := cosmosapi.Query{
+		Query: "SELECT TOP @n c.id, c.type, c.subject, c.visitDate, c.contact FROM c WHERE c.type = 'visitreport' AND " + notDeleted + " ORDER BY c.visitDate DESC",
+		Params: []cosmosapi.QueryParam{
+			{
+				Name:  "@n",
+				Value: n,
+			},
+		},
+	}
+	var docs []VisitReportListDoc
+	_, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops)
+	return docs, err
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+)
+
+const (
+	// deadLetterReceiveWait is how long a resubmit waits for the next
+	// dead-lettered message before it considers the queue drained
+	deadLetterReceiveWait = 5 * time.Second
+	// deadLetterDefaultMax limits the messages listed or resubmitted per call
+	deadLetterDefaultMax = 100
+)
+
+var (
+	contactTopic        *servicebus.Topic
+	contactSubscription *servicebus.Subscription
+)
+
+// DeadLetterDoc - a message in the dead-letter queue of the contact
+// subscription
+type DeadLetterDoc struct {
+	Id             string     `json:"id"`
+	SequenceNumber int64      `json:"sequenceNumber"`
+	DeliveryCount  uint32     `json:"deliveryCount"`
+	EnqueuedTime   *time.Time `json:"enqueuedTime,omitempty"`
+	Reason         string     `json:"reason,omitempty"`
+	Description    string     `json:"description,omitempty"`
+	Data           string     `json:"data"`
+}
+
+// DeadLetterQuery - query parameters of GET /deadletters/contacts
+type DeadLetterQuery struct {
+	Max int `url:"max" validate:"omitempty,min=1,max=500"`
+}
+
+// DeadLetterResubmitQuery - query parameters of POST
+// /deadletters/contacts/resubmit, without id all messages are resubmitted
+type DeadLetterResubmitQuery struct {
+	Id  string `url:"id"`
+	Max int    `url:"max" validate:"omitempty,min=1,max=500"`
+}
+
+// DeadLetterResubmitDoc - result of a resubmit
+type DeadLetterResubmitDoc struct {
+	Resubmitted []string `json:"resubmitted"`
+}
+
+// deadLetterContact moves a contact message that can't be processed to the
+// dead-letter queue. Service Bus records the error as DeadLetterReason and
+// DeadLetterErrorDescription.
+func deadLetterContact(ctx context.Context, m *servicebus.Message, cause error) {
+	fmt.Printf("Dead-lettering contact message %s after %d deliveries: %v\n", m.ID, m.DeliveryCount, cause)
+	err := m.DeadLetter(ctx, cause)
+	serviceBusReceived.WithLabelValues(currentCfg.ContactSubscription, "deadlettered").Inc()
+	if err != nil {
+		err = errors.WithStack(err)
+		fmt.Println(err)
+	}
+}
+
+func newDeadLetterDoc(m *servicebus.Message) DeadLetterDoc {
+	doc := DeadLetterDoc{
+		Id:            m.ID,
+		DeliveryCount: m.DeliveryCount,
+		Data:          string(m.Data),
+	}
+	if p := m.SystemProperties; p != nil {
+		if p.SequenceNumber != nil {
+			doc.SequenceNumber = *p.SequenceNumber
+		}
+		doc.EnqueuedTime = p.EnqueuedTime
+	}
+	doc.Reason, _ = m.UserProperties["DeadLetterReason"].(string)
+	doc.Description, _ = m.UserProperties["DeadLetterErrorDescription"].(string)
+	return doc
+}
+
+func stopIfNoContactSubscription(ctx iris.Context) bool {
+	if contactSubscription != nil {
+		return false
+	}
+	ctx.StopWithProblem(iris.StatusServiceUnavailable, iris.NewProblem().
+		Title("Subscription not ready").
+		Detail("The contact subscription has not been set up yet"))
+	return true
+}
+
+// listContactDeadLetters peeks the dead-letter queue, the messages stay
+// where they are.
+func listContactDeadLetters(ctx iris.Context) {
+	params := DeadLetterQuery{Max: deadLetterDefaultMax}
+	if !bindQuery(ctx, &params) || stopIfNoContactSubscription(ctx) {
+		return
+	}
+	dlq, err := contactTopic.NewSubscription(currentCfg.ContactSubscription + "/" + servicebus.DeadLetterQueueName)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	defer dlq.Close(context.Background())
+	it, err := dlq.Peek(ctx.Request().Context(), servicebus.PeekWithPageSize(params.Max))
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	docs := []DeadLetterDoc{}
+	for len(docs) < params.Max {
+		m, err := it.Next(ctx.Request().Context())
+		if _, ok := err.(servicebus.ErrNoMessages); ok {
+			break
+		}
+		if err != nil {
+			stopWithError(ctx, err)
+			return
+		}
+		docs = append(docs, newDeadLetterDoc(m))
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(docs))
+}
+
+// resubmitContactDeadLetters sends dead-lettered messages to the contact
+// topic again and removes them from the dead-letter queue. Messages not
+// matching the id are held until the end, so every message is looked at
+// once, and then released.
+func resubmitContactDeadLetters(ctx iris.Context) {
+	params := DeadLetterResubmitQuery{Max: deadLetterDefaultMax}
+	if !bindQuery(ctx, &params) || stopIfNoContactSubscription(ctx) {
+		return
+	}
+	reqctx := ctx.Request().Context()
+	receiver, err := contactSubscription.NewDeadLetterReceiver(reqctx)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	defer receiver.Close(context.Background())
+
+	var skipped []*servicebus.Message
+	defer func() {
+		for _, m := range skipped {
+			if err := m.Abandon(context.Background()); err != nil {
+				err = errors.WithStack(err)
+				fmt.Println(err)
+			}
+		}
+	}()
+	result := DeadLetterResubmitDoc{}
+	for len(result.Resubmitted) < params.Max {
+		rctx, cancel := context.WithTimeout(reqctx, deadLetterReceiveWait)
+		done := false
+		var handleErr error
+		err := receiver.ReceiveOne(rctx, servicebus.HandlerFunc(func(c context.Context, m *servicebus.Message) error {
+			if params.Id != "" && m.ID != params.Id {
+				skipped = append(skipped, m)
+				return nil
+			}
+			done = params.Id != ""
+			err := contactTopic.Send(c, &servicebus.Message{
+				ContentType: m.ContentType,
+				Data:        m.Data,
+			})
+			serviceBusSent.WithLabelValues(currentCfg.ContactTopic, metricResult(err)).Inc()
+			if err != nil {
+				skipped = append(skipped, m)
+				handleErr = err
+				return nil
+			}
+			result.Resubmitted = append(result.Resubmitted, m.ID)
+			handleErr = m.Complete(c)
+			return nil
+		}))
+		cancel()
+		if err != nil && rctx.Err() == context.DeadlineExceeded && reqctx.Err() == nil {
+			// nothing arrived in time, the queue is drained
+			break
+		}
+		if err == nil {
+			err = handleErr
+		}
+		if err != nil {
+			stopWithError(ctx, err)
+			return
+		}
+		if done {
+			break
+		}
+	}
+	if params.Id != "" && len(result.Resubmitted) == 0 {
+		ctx.StopWithProblem(iris.StatusNotFound, iris.NewProblem().
+			Title("Message not found").
+			Detail(fmt.Sprintf("No dead-lettered message with id %s", params.Id)))
+		return
+	}
+	fmt.Printf("Resubmitted %d dead-lettered contact messages\n", len(result.Resubmitted))
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(result))
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	captureBufferSize = 200
+	captureMaxBody    = 16 * 1024
+	captureMaxTTL     = 24 * time.Hour
+)
+
+// redactedHeaders are never captured
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", headerSignature}
+
+// CaptureRuleDoc - enables payload capturing for a route for a limited time
+type CaptureRuleDoc struct {
+	Method     string    `json:"method"`
+	Route      string    `json:"route" validate:"required"`
+	SampleRate float64   `json:"sampleRate" validate:"min=0,max=1"`
+	Duration   string    `json:"duration,omitempty"`
+	Until      time.Time `json:"until"`
+}
+
+// CapturedExchangeDoc - a sanitized request/response pair
+type CapturedExchangeDoc struct {
+	At              time.Time         `json:"at"`
+	Method          string            `json:"method"`
+	Route           string            `json:"route"`
+	URL             string            `json:"url"`
+	Status          int               `json:"status"`
+	DurationMs      int64             `json:"durationMs"`
+	RequestHeaders  map[string]string `json:"requestHeaders"`
+	RequestBody     json.RawMessage   `json:"requestBody,omitempty"`
+	ResponseHeaders map[string]string `json:"responseHeaders"`
+	ResponseBody    json.RawMessage   `json:"responseBody,omitempty"`
+}
+
+// CaptureStateDoc - active rules and captured exchanges, newest first
+type CaptureStateDoc struct {
+	Rules     []CaptureRuleDoc      `json:"rules"`
+	Exchanges []CapturedExchangeDoc `json:"exchanges"`
+}
+
+// payloadCapture holds the capture rules and a ring buffer of exchanges.
+type payloadCapture struct {
+	mu        sync.Mutex
+	rules     map[string]CaptureRuleDoc
+	exchanges []CapturedExchangeDoc
+	next      int
+}
+
+var currentCapture = &payloadCapture{rules: map[string]CaptureRuleDoc{}}
+
+func captureRuleKey(method, route string) string {
+	return strings.ToUpper(method) + " " + route
+}
+
+// shouldCapture looks for an active rule for the route, falling back to a
+// rule without method, and samples the request.
+func (c *payloadCapture) shouldCapture(method, route string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.rules) == 0 {
+		return false
+	}
+	rule, ok := c.rules[captureRuleKey(method, route)]
+	if !ok {
+		rule, ok = c.rules[captureRuleKey("", route)]
+	}
+	if !ok {
+		return false
+	}
+	if time.Now().After(rule.Until) {
+		delete(c.rules, captureRuleKey(rule.Method, rule.Route))
+		return false
+	}
+	return rand.Float64() < rule.SampleRate
+}
+
+func (c *payloadCapture) add(ex CapturedExchangeDoc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.exchanges) < captureBufferSize {
+		c.exchanges = append(c.exchanges, ex)
+		return
+	}
+	c.exchanges[c.next] = ex
+	c.next = (c.next + 1) % captureBufferSize
+}
+
+func (c *payloadCapture) state() CaptureStateDoc {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := CaptureStateDoc{Rules: []CaptureRuleDoc{}, Exchanges: []CapturedExchangeDoc{}}
+	now := time.Now()
+	for key, rule := range c.rules {
+		if now.After(rule.Until) {
+			delete(c.rules, key)
+			continue
+		}
+		out.Rules = append(out.Rules, rule)
+	}
+	for i := len(c.exchanges) - 1; i >= 0; i-- {
+		out.Exchanges = append(out.Exchanges, c.exchanges[(c.next+i)%len(c.exchanges)])
+	}
+	return out
+}
+
+// capturePayloads records a sample of the traffic of routes enabled via the
+// admin API. The response is buffered only for sampled requests.
+func capturePayloads(ctx iris.Context) {
+	route := ctx.GetCurrentRoute()
+	if route == nil || !currentCapture.shouldCapture(ctx.Method(), route.Path()) {
+		ctx.Next()
+		return
+	}
+
+	reqBody, err := ioutil.ReadAll(ctx.Request().Body)
+	if err != nil {
+		ctx.StopWithStatus(iris.StatusBadRequest)
+		return
+	}
+	ctx.Request().Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+
+	start := time.Now()
+	ctx.Record()
+	ctx.Next()
+
+	rec := ctx.Recorder()
+	currentCapture.add(CapturedExchangeDoc{
+		At:              start.UTC(),
+		Method:          ctx.Method(),
+		Route:           route.Path(),
+		URL:             ctx.Request().URL.RequestURI(),
+		Status:          ctx.GetStatusCode(),
+		DurationMs:      time.Since(start).Milliseconds(),
+		RequestHeaders:  sanitizeHeaders(ctx.Request().Header),
+		RequestBody:     sanitizeBody(reqBody),
+		ResponseHeaders: sanitizeHeaders(rec.Header()),
+		ResponseBody:    sanitizeBody(rec.Body()),
+	})
+}
+
+func sanitizeHeaders(h http.Header) map[string]string {
+	out := map[string]string{}
+	for name, values := range h {
+		out[name] = strings.Join(values, ", ")
+	}
+	for _, name := range redactedHeaders {
+		if _, ok := out[http.CanonicalHeaderKey(name)]; ok {
+			out[http.CanonicalHeaderKey(name)] = "[redacted]"
+		}
+	}
+	return out
+}
+
+// sanitizeBody redacts the fields in VR_DEBUGREDACTFIELDS from JSON bodies.
+// Other payloads are replaced by a placeholder, large ones are truncated.
+func sanitizeBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		out, _ := json.Marshal("[non-JSON body, " + http.DetectContentType(body) + "]")
+		return out
+	}
+	out, err := json.Marshal(redactFields(v))
+	if err != nil {
+		return nil
+	}
+	if len(out) > captureMaxBody {
+		out, _ = json.Marshal(string(out[:captureMaxBody]) + "...[truncated]")
+	}
+	return out
+}
+
+func redactFields(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if isRedactedField(k) {
+				t[k] = "[redacted]"
+			} else {
+				t[k] = redactFields(val)
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = redactFields(t[i])
+		}
+	}
+	return v
+}
+
+func isRedactedField(name string) bool {
+	for _, f := range currentCfg.DebugRedactFields {
+		if strings.EqualFold(f, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// readCapture returns the active rules and captured exchanges.
+func readCapture(ctx iris.Context) {
+	ctx.JSON(normalize(currentCapture.state()))
+}
+
+// enableCapture enables capturing for a route, e.g.
+// {"method":"PUT","route":"/reports/{reportid}","sampleRate":0.5,"duration":"15m"}
+func enableCapture(ctx iris.Context) {
+	rule := CaptureRuleDoc{SampleRate: 1, Duration: "10m"}
+	if err := ctx.ReadJSON(&rule); err != nil {
+		handleBindError(ctx, err)
+		return
+	}
+	d, err := time.ParseDuration(rule.Duration)
+	if err != nil || d <= 0 || d > captureMaxTTL {
+		ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+			Title("Invalid duration").
+			Detail("duration must be a positive Go duration of at most 24h"))
+		return
+	}
+	rule.Method = strings.ToUpper(rule.Method)
+	rule.Until = time.Now().Add(d).UTC()
+
+	currentCapture.mu.Lock()
+	currentCapture.rules[captureRuleKey(rule.Method, rule.Route)] = rule
+	currentCapture.mu.Unlock()
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(rule))
+}
+
+// disableCapture removes the rule for ?method=&route=, or all rules.
+func disableCapture(ctx iris.Context) {
+	route := ctx.URLParam("route")
+	currentCapture.mu.Lock()
+	if route == "" {
+		currentCapture.rules = map[string]CaptureRuleDoc{}
+	} else {
+		delete(currentCapture.rules, captureRuleKey(ctx.URLParam("method"), route))
+	}
+	currentCapture.mu.Unlock()
+	ctx.StatusCode(http.StatusNoContent)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+var (
+	// deprecatedRouteCalls counts calls per deprecated route, see /debug/vars
+	deprecatedRouteCalls = expvar.NewMap("deprecatedRouteCalls")
+	// legacyEventEmissions counts sent events per legacy "type/version"
+	legacyEventEmissions = expvar.NewMap("legacyEventEmissions")
+)
+
+// DeprecationUsageDoc - usage of a deprecated route or legacy event version by one consumer
+type DeprecationUsageDoc struct {
+	Consumer string    `json:"consumer"`
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// DeprecationItemDoc - a deprecated route or legacy event version and its consumers
+type DeprecationItemDoc struct {
+	Name      string                `json:"name"`
+	Sunset    string                `json:"sunset,omitempty"`
+	Consumers []DeprecationUsageDoc `json:"consumers"`
+}
+
+// DeprecationReportDoc - report of who still uses deprecated parts of the API
+type DeprecationReportDoc struct {
+	Routes []DeprecationItemDoc `json:"routes"`
+	Events []DeprecationItemDoc `json:"events"`
+}
+
+type deprecationUsage struct {
+	mu     sync.Mutex
+	usages map[string]map[string]*DeprecationUsageDoc
+}
+
+var (
+	currentRouteUsage = &deprecationUsage{usages: map[string]map[string]*DeprecationUsageDoc{}}
+	currentEventUsage = &deprecationUsage{usages: map[string]map[string]*DeprecationUsageDoc{}}
+)
+
+func (u *deprecationUsage) record(name, consumer string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	consumers, ok := u.usages[name]
+	if !ok {
+		consumers = map[string]*DeprecationUsageDoc{}
+		u.usages[name] = consumers
+	}
+	usage, ok := consumers[consumer]
+	if !ok {
+		usage = &DeprecationUsageDoc{Consumer: consumer}
+		consumers[consumer] = usage
+	}
+	usage.Count++
+	usage.LastSeen = time.Now().UTC()
+}
+
+func (u *deprecationUsage) report(sunsets map[string]string) []DeprecationItemDoc {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	items := []DeprecationItemDoc{}
+	for name, consumers := range u.usages {
+		item := DeprecationItemDoc{Name: name, Sunset: sunsets[name], Consumers: []DeprecationUsageDoc{}}
+		for _, usage := range consumers {
+			item.Consumers = append(item.Consumers, *usage)
+		}
+		sort.Slice(item.Consumers, func(i, j int) bool {
+			return item.Consumers[i].Count > item.Consumers[j].Count
+		})
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+// consumerOf identifies the caller of a request: the authenticated service
+// caller if there is one, the user agent otherwise.
+func consumerOf(ctx iris.Context) string {
+	if caller := ctx.Values().GetString(ctxKeyCaller); caller != "" {
+		return caller
+	}
+	if ua := ctx.GetHeader("User-Agent"); ua != "" {
+		return ua
+	}
+	return "unknown"
+}
+
+// trackDeprecation counts calls to the routes in VR_DEPRECATEDROUTES, e.g.
+// "GET /stats/{contactid}:2025-06-30", and announces the sunset date with
+// the Deprecation and Sunset headers.
+func trackDeprecation(ctx iris.Context) {
+	route := ctx.GetCurrentRoute()
+	if route == nil || len(currentCfg.DeprecatedRoutes) == 0 {
+		ctx.Next()
+		return
+	}
+	name := route.Method() + " " + route.Path()
+	sunset, ok := currentCfg.DeprecatedRoutes[name]
+	if !ok {
+		ctx.Next()
+		return
+	}
+
+	deprecatedRouteCalls.Add(name, 1)
+	currentRouteUsage.record(name, consumerOf(ctx))
+	ctx.Header("Deprecation", "true")
+	if t, err := time.Parse("2006-01-02", sunset); err == nil {
+		ctx.Header("Sunset", t.Format(http.TimeFormat))
+	}
+	ctx.Next()
+}
+
+// trackEventEmission counts sent events whose version is listed as legacy
+// in VR_LEGACYEVENTVERSIONS, e.g. "VisitReportCreatedEvent/1".
+func trackEventEmission(eventType, version, consumer string) {
+	name := eventType + "/" + version
+	for _, legacy := range currentCfg.LegacyEventVersions {
+		if legacy == name {
+			legacyEventEmissions.Add(name, 1)
+			currentEventUsage.record(name, consumer)
+			return
+		}
+	}
+}
+
+// readDeprecationReport lists the consumers that still use deprecated routes
+// and legacy event versions, to plan their removal.
+func readDeprecationReport(ctx iris.Context) {
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(DeprecationReportDoc{
+		Routes: currentRouteUsage.report(currentCfg.DeprecatedRoutes),
+		Events: currentEventUsage.report(nil),
+	}))
+}
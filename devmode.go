@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+// checkDevMode refuses VR_DEVMODE in production and warns loudly otherwise.
+// Development mode allows CORS requests with credentials from localhost
+// and accepts VR_DEVTOKEN as bearer token with VR_DEVROLES.
+func checkDevMode() {
+	if !currentCfg.DevMode {
+		return
+	}
+	if currentCfg.Env == "production" {
+		log.Fatal("VR_DEVMODE must not be set with VR_ENV=production")
+	}
+	if currentCfg.DevToken == "" {
+		log.Fatal("VR_DEVMODE requires VR_DEVTOKEN")
+	}
+	banner := strings.Repeat("!", 72)
+	fmt.Println(banner)
+	fmt.Println("!!! DEVELOPMENT MODE: CORS is open to localhost and the dev token is")
+	fmt.Printf("!!! accepted as %s with the roles %s. Never expose this instance.\n",
+		currentCfg.DevCaller, strings.Join(currentCfg.DevRoles, ", "))
+	fmt.Println(banner)
+}
+
+// isLocalOrigin reports whether an origin is a local dev server, e.g. the
+// SPA on http://localhost:8080.
+func isLocalOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authenticateDevToken accepts VR_DEVTOKEN as bearer token in development
+// mode, the caller gets VR_DEVCALLER, also as subject, and VR_DEVROLES.
+func authenticateDevToken(ctx iris.Context) bool {
+	if !currentCfg.DevMode || currentCfg.DevToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(currentCfg.DevToken)) != 1 {
+		return false
+	}
+	ctx.Values().Set(ctxKeyCaller, currentCfg.DevCaller)
+	ctx.Values().Set(ctxKeyRoles, currentCfg.DevRoles)
+	ctx.Values().Set(ctxKeySubject, currentCfg.DevCaller)
+	return true
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// VisitReportDraftDoc - struct for auto-saving an incomplete VR. Only the
+// length limits are enforced, required fields may still be missing.
+type VisitReportDraftDoc struct {
+	Subject     string        `json:"subject" validate:"subjectlen"`
+	Description string        `json:"description" validate:"descriptionlen"`
+	VisitDate   string        `json:"visitDate"`
+	Contact     ContactDoc    `json:"contact" validate:"-"`
+	CrmLinks    []CrmLinkDoc  `json:"crmLinks" validate:"-"`
+	Attendees   []AttendeeDoc `json:"attendees" validate:"-"`
+	Agenda      []string      `json:"agenda" validate:"-"`
+}
+
+// VisitReportDraftModel - struct for data access of a draft. Drafts live in
+// their own "drafts" container, so they never show up in lists, stats,
+// search or events until they are submitted.
+type VisitReportDraftModel struct {
+	cosmosapi.Document
+	Type        string        `json:"type"`
+	Draft       bool          `json:"draft"`
+	Subject     string        `json:"subject"`
+	Description string        `json:"description"`
+	VisitDate   string        `json:"visitDate"`
+	Contact     ContactDoc    `json:"contact"`
+	CrmLinks    []CrmLinkDoc  `json:"crmLinks,omitempty"`
+	Attendees   []AttendeeDoc `json:"attendees,omitempty"`
+	Agenda      []string      `json:"agenda,omitempty"`
+	SavedAt     time.Time     `json:"savedAt"`
+}
+
+// VisitReportDraftReadDoc - struct for reading a draft
+type VisitReportDraftReadDoc struct {
+	Id          string        `json:"id"`
+	Draft       bool          `json:"draft"`
+	Subject     string        `json:"subject"`
+	Description string        `json:"description"`
+	VisitDate   string        `json:"visitDate"`
+	Contact     ContactDoc    `json:"contact"`
+	CrmLinks    []CrmLinkDoc  `json:"crmLinks,omitempty"`
+	Attendees   []AttendeeDoc `json:"attendees,omitempty"`
+	Agenda      []string      `json:"agenda,omitempty"`
+	SavedAt     time.Time     `json:"savedAt"`
+}
+
+func getDraft(ctx iris.Context, reportid string) (*VisitReportDraftModel, bool) {
+	ro := cosmosapi.GetDocumentOptions{
+		PartitionKeyValue: "visitreport",
+	}
+	model := VisitReportDraftModel{}
+	_, err := currentClient.GetDocument(ctx.Request().Context(), currentCfg.DbName, "drafts", reportid, ro, &model)
+	if err == cosmosapi.ErrNotFound {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return nil, false
+	}
+	return &model, true
+}
+
+// stopIfSubmitted responds with 409 Conflict if a report with the id exists.
+func stopIfSubmitted(ctx iris.Context, reportid string) bool {
+	_, err := getAnyReport(ctx.Request().Context(), reportid)
+	if err == ErrReportNotFound {
+		return false
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return true
+	}
+	ctx.StopWithProblem(iris.StatusConflict, iris.NewProblem().
+		Title("Report already submitted").
+		Detail(fmt.Sprintf("Report %s is no longer a draft", reportid)))
+	return true
+}
+
+func listDrafts(ctx iris.Context) {
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qry := cosmosapi.Query{
+		Query: "SELECT * FROM c ORDER BY c.savedAt DESC",
+	}
+	var docs []VisitReportDraftModel
+	_, err := currentClient.QueryDocuments(ctx.Request().Context(), currentCfg.DbName, "drafts", qry, &docs, qops)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	out := []VisitReportDraftReadDoc{}
+	copier.Copy(&out, &docs)
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
+
+// listDraftPage returns a page of the drafts matching the filter, as list
+// items marked as draft.
+func listDraftPage(ctx context.Context, filter ReportFilter, token string) ([]VisitReportListDoc, string, error) {
+	where, params := filter.query()
+	qry := cosmosapi.Query{
+		Query:  "SELECT c.id, c.type, c.subject, c.visitDate, c.contact FROM c" + where,
+		Params: params,
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qops.MaxItemCount = streamPageSize
+	qops.Continuation = token
+	docs := []VisitReportListDoc{}
+	res, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, "drafts", qry, &docs, qops)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range docs {
+		docs[i].Draft = true
+	}
+	return docs, res.Continuation, nil
+}
+
+func readDraft(ctx iris.Context) {
+	model, ok := getDraft(ctx, ctx.Params().GetString("reportid"))
+	if !ok {
+		return
+	}
+	out := VisitReportDraftReadDoc{}
+	copier.Copy(&out, model)
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
+
+// saveDraft creates or replaces the draft with the client generated id.
+func saveDraft(ctx iris.Context) {
+	reportid := ctx.Params().GetString("reportid")
+	if !bindValidated(ctx, &ReportIDParam{ReportID: reportid}) {
+		return
+	}
+	in := VisitReportDraftDoc{}
+	if err := ctx.ReadJSON(&in); err != nil {
+		handleBindError(ctx, err)
+		return
+	}
+	if stopIfSubmitted(ctx, reportid) {
+		return
+	}
+
+	model := VisitReportDraftModel{}
+	copier.Copy(&model, &in)
+	model.Id = reportid
+	model.Type = "visitreport"
+	model.Draft = true
+	model.SavedAt = time.Now().UTC()
+	ops := cosmosapi.CreateDocumentOptions{
+		PartitionKeyValue: "visitreport",
+		IsUpsert:          true,
+	}
+	_, _, err := currentClient.CreateDocument(ctx.Request().Context(), currentCfg.DbName, "drafts", model, ops)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	out := VisitReportDraftReadDoc{}
+	copier.Copy(&out, &model)
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
+
+func deleteDraft(ctx iris.Context) {
+	reportid := ctx.Params().GetString("reportid")
+	ops := cosmosapi.DeleteDocumentOptions{
+		PartitionKeyValue: "visitreport",
+	}
+	_, err := currentClient.DeleteDocument(ctx.Request().Context(), currentCfg.DbName, "drafts", reportid, ops)
+	if err == cosmosapi.ErrNotFound {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusNoContent)
+}
+
+// submitDraft validates the draft like a new report and creates the report
+// under the draft's id. Only from then on it counts in stats and events.
+func submitDraft(ctx iris.Context) {
+	reportid := ctx.Params().GetString("reportid")
+	draft, ok := getDraft(ctx, reportid)
+	if !ok {
+		return
+	}
+	vr := VisitReportCreateDoc{}
+	copier.Copy(&vr, draft)
+	if !bindValidated(ctx, &vr) {
+		return
+	}
+	if stopIfSubmitted(ctx, reportid) {
+		return
+	}
+
+	model := VisitReportModel{}
+	copier.Copy(&model, &vr)
+	model.Type = "visitreport"
+	model.Id = reportid
+	if !storeNewReport(ctx, &model) {
+		return
+	}
+
+	// the report is stored, a draft left behind is reported as a warning,
+	// the client deletes it with DELETE /reports/{reportid}/draft
+	var warnings []ValidationWarningDoc
+	ops := cosmosapi.DeleteDocumentOptions{
+		PartitionKeyValue: "visitreport",
+	}
+	if _, err := currentClient.DeleteDocument(ctx.Request().Context(), currentCfg.DbName, "drafts", reportid, ops); err != nil && err != cosmosapi.ErrNotFound {
+		err = errors.WithStack(err)
+		fmt.Println(err)
+		warnings = append(warnings, ValidationWarningDoc{
+			Field:   "draft",
+			Code:    warningDraftNotDeleted,
+			Message: "The report was submitted but its draft could not be deleted",
+		})
+	}
+	respondCreated(ctx, &model, warnings...)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+// etagHeader returns an etag as a quoted HTTP entity tag. Cosmos DB etags
+// are quoted already, the memory repository's are not.
+func etagHeader(etag string) string {
+	if strings.HasPrefix(etag, `"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// etagMatches reports whether an If-Match header matches the etag of the
+// stored report, "*" matches any etag.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etagHeader(etag) {
+			return true
+		}
+	}
+	return false
+}
+
+func stopPreconditionFailed(ctx iris.Context) {
+	ctx.StopWithProblem(iris.StatusPreconditionFailed, iris.NewProblem().
+		Title("Precondition failed").
+		Detail("The report was changed since it was read"))
+}
+
+// stopIfPreconditionFails checks the If-Match header of a PUT or DELETE
+// against the etag of the stored report. Without VR_REQUIREIFMATCH a
+// missing header overwrites whatever is stored, with it the request is
+// rejected with 428 so clients can't lose concurrent updates by accident.
+func stopIfPreconditionFails(ctx iris.Context, etag string) bool {
+	ifMatch := ctx.GetHeader("If-Match")
+	if ifMatch == "" {
+		if !currentCfg.RequireIfMatch {
+			return false
+		}
+		ctx.StopWithProblem(iris.StatusPreconditionRequired, iris.NewProblem().
+			Title("Precondition required").
+			Detail("Send the ETag of the report as If-Match, or * to overwrite any version"))
+		return true
+	}
+	if !etagMatches(ifMatch, etag) {
+		stopPreconditionFailed(ctx)
+		return true
+	}
+	return false
+}
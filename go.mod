@@ -7,6 +7,7 @@ require (
 	github.com/Joker/hpp v1.0.0 // indirect
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-playground/validator/v10 v10.3.0
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/google/uuid v1.1.2
 	github.com/iris-contrib/middleware/cors v0.0.0-20200913183508-5d1bed0e6ea4
 	github.com/jinzhu/copier v0.0.0-20190924061706-b57f9002281a
@@ -20,8 +21,9 @@ require (
 	github.com/onsi/ginkgo v1.14.1 // indirect
 	github.com/onsi/gomega v1.10.2 // indirect
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.1
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/vippsas/go-cosmosdb v0.0.0-20200428065936-29dab535353d
 	github.com/yudai/pp v2.0.1+incompatible // indirect
-	golang.org/x/sys v0.0.0-20200915084602-288bc346aa39 // indirect
+	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
 )
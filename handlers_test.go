@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kelseyhightower/envconfig"
+)
+
+// useMemoryRepository runs the handlers of a test against an empty memory
+// repository, with the default configuration changed by configure.
+func useMemoryRepository(t *testing.T, configure func(cfg *config)) {
+	t.Helper()
+	for _, key := range []string{"DBURL", "DBKEY", "DBNAME", "SBCONNSTRVISITREPORT", "SBCONNSTRCONTACT"} {
+		os.Setenv("VRTEST_"+key, "unused")
+	}
+	cfg := config{}
+	if err := envconfig.Process("vrtest", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Repository = "memory"
+	if configure != nil {
+		configure(&cfg)
+	}
+	prevCfg, prevRepo := currentCfg, currentRepo
+	currentCfg = &cfg
+	currentRepo = newMemoryReportRepository()
+	t.Cleanup(func() {
+		currentCfg, currentRepo = prevCfg, prevRepo
+	})
+}
+
+func newTestApp(t *testing.T) *iris.Application {
+	t.Helper()
+	app := iris.New()
+	app.Validator = newValidator()
+	app.Use(clientIPMiddleware)
+	return app
+}
+
+// serve sends a request through the app and returns the recorded response.
+func serve(t *testing.T, app *iris.Application, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec
+}
+
+func storeTestReport(t *testing.T, id, contactid string) *VisitReportModel {
+	t.Helper()
+	model := &VisitReportModel{
+		Type:      "visitreport",
+		Subject:   "Kickoff",
+		VisitDate: "2024-05-01",
+		Contact:   ContactDoc{Id: contactid, Firstname: "Ada", Lastname: "Lovelace"},
+	}
+	model.Id = id
+	etag, err := currentRepo.Create(context.Background(), model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.Etag = etag
+	return model
+}
+
+func TestReportHoldOnMemoryRepository(t *testing.T) {
+	useMemoryRepository(t, nil)
+	app := newTestApp(t)
+	app.Put("/reports/{reportid}/hold", placeReportHold)
+	app.Delete("/reports/{reportid}/hold", liftReportHold)
+	storeTestReport(t, "r1", "c1")
+
+	rec := serve(t, app, http.MethodPut, "/reports/r1/hold", `{"reason":"audit"}`, http.Header{"User-Agent": {"tests"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("placing the hold: got %d, want 200: %s", rec.Code, rec.Body)
+	}
+	model, err := currentRepo.Get(context.Background(), "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.LegalHold == nil || model.LegalHold.Reason != "audit" {
+		t.Fatalf("got hold %+v, want the hold in the repository", model.LegalHold)
+	}
+	if len(model.LegalHoldAudit) != 1 || model.LegalHoldAudit[0].By != "tests" {
+		t.Errorf("got audit %+v, want one entry by tests", model.LegalHoldAudit)
+	}
+
+	rec = serve(t, app, http.MethodDelete, "/reports/r1/hold", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("lifting the hold: got %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if model, _ = currentRepo.Get(context.Background(), "r1"); model.LegalHold != nil {
+		t.Errorf("got hold %+v, want it lifted", model.LegalHold)
+	}
+
+	rec = serve(t, app, http.MethodPut, "/reports/missing/hold", `{"reason":"audit"}`, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing report: got %d, want 404", rec.Code)
+	}
+}
+
+func TestContactHoldOnMemoryRepository(t *testing.T) {
+	useMemoryRepository(t, nil)
+	app := newTestApp(t)
+	app.Put("/contacts/{contactid}/hold", placeContactHold)
+	const contactid = "0d4c8b5e-2f4a-4d39-9d6e-0f5a7b3c2e11"
+	storeTestReport(t, "r1", contactid)
+	deleted := storeTestReport(t, "r2", contactid)
+	if err := softDelete(context.Background(), deleted, "tests"); err != nil {
+		t.Fatal(err)
+	}
+	storeTestReport(t, "r3", "0d4c8b5e-2f4a-4d39-9d6e-0f5a7b3c2e12")
+
+	rec := serve(t, app, http.MethodPut, "/contacts/"+contactid+"/hold", `{"reason":"audit"}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var out LegalHoldResultDoc
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Reports != 2 {
+		t.Errorf("got %d reports, want 2 including the deleted one", out.Reports)
+	}
+	if model, _ := currentRepo.GetDeleted(context.Background(), "r2"); model == nil || model.LegalHold == nil {
+		t.Error("the deleted report is not held")
+	}
+	if model, _ := currentRepo.Get(context.Background(), "r3"); model == nil || model.LegalHold != nil {
+		t.Error("the report of another contact is held")
+	}
+}
+
+func TestAggregatesOnMemoryRepository(t *testing.T) {
+	useMemoryRepository(t, nil)
+	app := newTestApp(t)
+	app.Get("/stats", requireAggregates, readStatsOverall)
+	app.Get("/stats/acknowledgments", readStatsAcknowledgments)
+	storeTestReport(t, "r1", "c1")
+
+	if rec := serve(t, app, http.MethodGet, "/stats", "", nil); rec.Code != http.StatusNotImplemented {
+		t.Errorf("stats: got %d, want 501", rec.Code)
+	}
+	rec := serve(t, app, http.MethodGet, "/stats/acknowledgments", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("acknowledgments: got %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var out AckStatsDoc
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Reports != 1 || out.Acknowledged != 0 {
+		t.Errorf("got %+v, want 1 report, none acknowledged", out)
+	}
+}
+
+func TestDeleteReport(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) { cfg.RequireIfMatch = true })
+	app := newTestApp(t)
+	app.Delete("/reports/{reportid}", deleteReport)
+	model := storeTestReport(t, "r1", "c1")
+	stored := http.Header{"If-Match": {etagHeader(model.Etag)}}
+	anyVersion := http.Header{"If-Match": {"*"}}
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"missing report", "/reports/missing", nil, http.StatusNoContent},
+		{"missing report with If-Match", "/reports/missing", stored, http.StatusPreconditionFailed},
+		{"missing report with any If-Match", "/reports/missing", anyVersion, http.StatusPreconditionFailed},
+		{"without If-Match", "/reports/r1", nil, http.StatusPreconditionRequired},
+		{"stale If-Match", "/reports/r1", http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed},
+		{"current If-Match", "/reports/r1", stored, http.StatusNoContent},
+		{"deleted again", "/reports/r1", nil, http.StatusNoContent},
+		{"deleted again with If-Match", "/reports/r1", stored, http.StatusPreconditionFailed},
+		{"deleted again with any If-Match", "/reports/r1", anyVersion, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		rec := serve(t, app, http.MethodDelete, tt.path, "", tt.header)
+		if rec.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+	if _, err := currentRepo.GetDeleted(context.Background(), "r1"); err != nil {
+		t.Errorf("got %v, want the report soft-deleted", err)
+	}
+}
+
+func TestDeleteReportWithoutRequiredIfMatch(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) { cfg.RequireIfMatch = false })
+	app := newTestApp(t)
+	app.Delete("/reports/{reportid}", deleteReport)
+	storeTestReport(t, "r1", "c1")
+
+	if rec := serve(t, app, http.MethodDelete, "/reports/r1", "", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("got %d, want 204: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAttachmentsOfHeldReport(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) {
+		cfg.AttachmentAccount = "account"
+		cfg.AttachmentKey = "a2V5"
+	})
+	app := newTestApp(t)
+	app.Post("/reports/{reportid}/attachments/uploads", createAttachmentUpload)
+	app.Post("/reports/{reportid}/attachments/{attachmentid}/complete", completeAttachmentUpload)
+	model := storeTestReport(t, "r1", "c1")
+	applyLegalHold(model, legalHoldPlaced, "audit", "tests", "")
+	if _, err := currentRepo.Update(context.Background(), model); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(t, app, http.MethodPost, "/reports/r1/attachments/uploads",
+		`{"fileName":"minutes.pdf","contentType":"application/pdf","size":100}`, nil)
+	if rec.Code != http.StatusLocked {
+		t.Errorf("upload: got %d, want 423: %s", rec.Code, rec.Body)
+	}
+	rec = serve(t, app, http.MethodPost, "/reports/r1/attachments/0d4c8b5e-2f4a-4d39-9d6e-0f5a7b3c2e11/complete",
+		`{"fileName":"minutes.pdf","md5":"bWQ1"}`, nil)
+	if rec.Code != http.StatusLocked {
+		t.Errorf("complete: got %d, want 423: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSignedCallerRoles(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) {
+		cfg.AuthIssuer = "https://issuer.example"
+		cfg.HmacSecrets = map[string]string{"reader": "secret1", "unknown": "secret2"}
+		cfg.HmacRoles = map[string]string{"reader": "VisitReports.Read"}
+	})
+	app := newTestApp(t)
+	ok := func(ctx iris.Context) { ctx.StatusCode(http.StatusNoContent) }
+	app.Get("/read", verifySignature, requireRole("VisitReports.Read"), ok)
+	app.Get("/admin", verifySignature, requireRole("VisitReports.Admin"), ok)
+
+	tests := []struct {
+		caller, path string
+		want         int
+	}{
+		{"reader", "/read", http.StatusNoContent},
+		{"reader", "/admin", http.StatusForbidden},
+		{"unknown", "/read", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		header := http.Header{
+			headerSignatureCaller:    {tt.caller},
+			headerSignatureTimestamp: {ts},
+			headerSignature:          {signRequest(currentCfg.HmacSecrets[tt.caller], http.MethodGet, tt.path, ts, nil)},
+		}
+		if rec := serve(t, app, http.MethodGet, tt.path, "", header); rec.Code != tt.want {
+			t.Errorf("%s %s: got %d, want %d: %s", tt.caller, tt.path, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestReassignOwner(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) { cfg.ReassignRate = 1000 })
+	for _, id := range []string{"r1", "r2", "r3"} {
+		model := storeTestReport(t, id, "c1")
+		model.CreatedBy = "alice"
+		if id == "r3" {
+			model.CreatedBy = "bob"
+		}
+		if id == "r2" {
+			applyLegalHold(model, legalHoldPlaced, "audit", "tests", "")
+		}
+		if _, err := currentRepo.Update(context.Background(), model); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := reassignOwner(context.Background(), ReassignDoc{FromOwner: "alice", ToOwner: "carol", Reason: "left"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Reassigned != 1 || out.Held != 1 {
+		t.Errorf("got %+v, want 1 reassigned and 1 held", out)
+	}
+	for id, want := range map[string]string{"r1": "carol", "r2": "alice", "r3": "bob"} {
+		model, _ := currentRepo.Get(context.Background(), id)
+		if model.CreatedBy != want {
+			t.Errorf("report %s: got owner %s, want %s", id, model.CreatedBy, want)
+		}
+	}
+	if model, _ := currentRepo.Get(context.Background(), "r1"); len(model.OwnerAudit) != 1 || model.OwnerAudit[0].From != "alice" {
+		t.Errorf("got audit %+v, want one entry from alice", model.OwnerAudit)
+	}
+}
+
+func TestSeedSandboxIsDeterministic(t *testing.T) {
+	seeded := func() []string {
+		useMemoryRepository(t, nil)
+		if err := seedSandbox(context.Background(), 7, 5); err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		currentRepo.Query(context.Background(), ReportFilter{}, func(model *VisitReportModel) error {
+			out = append(out, model.Id+" "+model.VisitDate+" "+model.Subject)
+			return nil
+		})
+		return out
+	}
+	first, second := seeded(), seeded()
+	if len(first) != 5 || strings.Join(first, "\n") != strings.Join(second, "\n") {
+		t.Errorf("got %v and %v, want the same 5 reports", first, second)
+	}
+}
+
+func TestSandboxFaults(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) { cfg.Sandbox = true })
+	app := newTestApp(t)
+	app.Get("/reports", sandboxFaults, func(ctx iris.Context) { ctx.StatusCode(http.StatusNoContent) })
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"no faults", nil, http.StatusNoContent},
+		{"latency", http.Header{headerSandboxLatency: {"10ms"}}, http.StatusNoContent},
+		{"invalid latency", http.Header{headerSandboxLatency: {"slow"}}, http.StatusBadRequest},
+		{"always failing", http.Header{headerSandboxErrorRate: {"1"}}, http.StatusServiceUnavailable},
+		{"never failing", http.Header{headerSandboxErrorRate: {"0"}}, http.StatusNoContent},
+		{"invalid error rate", http.Header{headerSandboxErrorRate: {"2"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := serve(t, app, http.MethodGet, "/reports", "", tt.header); rec.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestAttachmentDownloads(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) {
+		cfg.AttachmentAccount = "account"
+		cfg.AttachmentKey = "a2V5"
+	})
+	app := newTestApp(t)
+	app.Post("/reports/{reportid}/attachments/{attachmentid}/downloads", createAttachmentDownload)
+	app.Get("/reports/{reportid}/attachments/{attachmentid}/downloads", listAttachmentDownloads)
+	model := storeTestReport(t, "r1", "c1")
+	model.Attachments = []AttachmentDoc{
+		{Id: "a1", Thumbnails: []AttachmentThumbnailDoc{{Size: 160}}},
+		{Id: "a2", Tier: blobTierArchive, TieredAt: &time.Time{}},
+	}
+	if _, err := currentRepo.Update(context.Background(), model); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/reports/r1/attachments/a1/downloads", http.StatusCreated},
+		{"/reports/r1/attachments/a1/downloads?thumbnail=160", http.StatusCreated},
+		{"/reports/r1/attachments/a1/downloads?thumbnail=640", http.StatusNotFound},
+		{"/reports/r1/attachments/a2/downloads", http.StatusConflict},
+		{"/reports/r1/attachments/missing/downloads", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := serve(t, app, http.MethodPost, tt.path, "", http.Header{"User-Agent": {"tests"}}); rec.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.path, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	rec := serve(t, app, http.MethodGet, "/reports/r1/attachments/a1/downloads", "", nil)
+	var out []DownloadAuditDoc
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].By != "tests" || out[1].Thumbnail != 160 {
+		t.Errorf("got %+v, want the file and the thumbnail downloaded by tests", out)
+	}
+}
+
+func TestPreferencesNeedUserToken(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) {
+		cfg.AuthIssuer = "https://issuer.example"
+		cfg.HmacSecrets = map[string]string{"service": "secret"}
+	})
+	app := newTestApp(t)
+	app.Get("/me/preferences", verifySignature, authenticate, readPreferences)
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	header := http.Header{
+		headerSignatureCaller:    {"service"},
+		headerSignatureTimestamp: {ts},
+		headerSignature:          {signRequest("secret", http.MethodGet, "/me/preferences", ts, nil)},
+	}
+	if rec := serve(t, app, http.MethodGet, "/me/preferences", "", header); rec.Code != http.StatusForbidden {
+		t.Errorf("got %d, want 403: %s", rec.Code, rec.Body)
+	}
+}
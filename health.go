@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// healthCheckTimeout bounds every dependency check of the readiness probe
+const healthCheckTimeout = 5 * time.Second
+
+const (
+	healthOK          = "ok"
+	healthUnavailable = "unavailable"
+	healthSkipped     = "skipped"
+)
+
+// HealthCheckDoc - the result of checking a dependency
+type HealthCheckDoc struct {
+	Status     string `json:"status"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+	// Details is the state of the dependency, if it has one
+	Details interface{} `json:"details,omitempty"`
+}
+
+// HealthDoc - struct for GET /healthz/ready, the service is ready when all
+// checks are ok or skipped
+type HealthDoc struct {
+	Status string                    `json:"status"`
+	Checks map[string]HealthCheckDoc `json:"checks,omitempty"`
+}
+
+// errNotConnected - a dependency was never connected or its connection ended
+var errNotConnected = errors.New("not connected")
+
+// healthChecks are the dependencies checked for readiness, a nil error
+// with skip set means the dependency is not used
+var healthChecks = map[string]func(ctx context.Context) (skip bool, err error){
+	"cosmos":              checkCosmos,
+	"topic":               checkTopicSender,
+	"contactSubscription": checkContactListener,
+}
+
+// checkCosmos runs a cheap single-partition query.
+func checkCosmos(ctx context.Context) (bool, error) {
+	if currentCfg.Repository == "memory" {
+		return true, nil
+	}
+	if currentClient == nil {
+		return false, errNotConnected
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qops.MaxItemCount = 1
+	var docs []struct {
+		Id string `json:"id"`
+	}
+	_, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, cosmosapi.Query{
+		Query: "SELECT TOP 1 c.id FROM c",
+	}, &docs, qops)
+	return false, err
+}
+
+// checkTopicSender opens and closes a sender link on the report topic.
+func checkTopicSender(ctx context.Context) (bool, error) {
+	if currentTopic == nil {
+		return false, errNotConnected
+	}
+	sender, err := currentTopic.NewSender(ctx)
+	if err != nil {
+		return false, err
+	}
+	return false, sender.Close(ctx)
+}
+
+// checkContactListener verifies the listener of the contact subscription
+// is running, it is reconnected by superviseContactListener otherwise.
+func checkContactListener(ctx context.Context) (bool, error) {
+	if state := readContactListenerState(); state.State != listenerListening {
+		if state.LastError != "" {
+			return false, fmt.Errorf("%s: %s", state.State, state.LastError)
+		}
+		return false, errors.New(state.State)
+	}
+	return false, nil
+}
+
+// healthDetails returns the state of the dependencies that have one
+var healthDetails = map[string]func() interface{}{
+	"contactSubscription": func() interface{} { return readContactListenerState() },
+}
+
+// readLiveness reports that the process serves requests, it doesn't look
+// at dependencies so an outage of one doesn't restart all instances.
+func readLiveness(ctx iris.Context) {
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(HealthDoc{Status: healthOK})
+}
+
+// readReadiness checks the dependencies concurrently and returns 503 if one
+// of them is unavailable, with the status of every dependency.
+func readReadiness(ctx iris.Context) {
+	out := HealthDoc{Status: healthOK, Checks: make(map[string]HealthCheckDoc, len(healthChecks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range healthChecks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) (bool, error)) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx.Request().Context(), healthCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			skip, err := check(cctx)
+			doc := HealthCheckDoc{Status: healthOK, DurationMs: time.Since(start).Milliseconds()}
+			switch {
+			case err != nil:
+				doc.Status = healthUnavailable
+				doc.Error = err.Error()
+			case skip:
+				doc.Status = healthSkipped
+			}
+			if details, ok := healthDetails[name]; ok {
+				doc.Details = details()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			out.Checks[name] = doc
+			if err != nil {
+				out.Status = healthUnavailable
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	if out.Status != healthOK {
+		ctx.StatusCode(http.StatusServiceUnavailable)
+	} else {
+		ctx.StatusCode(http.StatusOK)
+	}
+	ctx.JSON(out)
+}
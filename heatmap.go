@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// heatmapWeekdays is the order of the weekdays in the heatmap
+var heatmapWeekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+// HeatmapQuery - query parameters of GET /stats/heatmap
+type HeatmapQuery struct {
+	ContactID string `url:"contactid" validate:"omitempty,uuid"`
+	Company   string `url:"company" validate:"max=100"`
+}
+
+// HeatmapWeekdayDoc - visits of a weekday, Hours[h] is the number of visits
+// starting between h:00 and h:59
+type HeatmapWeekdayDoc struct {
+	Weekday string  `json:"weekday"`
+	Hours   [24]int `json:"hours"`
+}
+
+// HeatmapDoc - struct for GET /stats/heatmap. Visits are bucketed by the
+// local time of the visit date, in the offset it was written with. Visit
+// dates without a time are only counted in WithoutTime.
+type HeatmapDoc struct {
+	Weekdays    []HeatmapWeekdayDoc `json:"weekdays"`
+	Visits      int                 `json:"visits"`
+	WithoutTime int                 `json:"withoutTime"`
+}
+
+func readStatsHeatmap(ctx iris.Context) {
+	params := HeatmapQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	out, err := queryStatsHeatmap(ctx.Request().Context(), params)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
+
+func queryStatsHeatmap(ctx context.Context, params HeatmapQuery) (HeatmapDoc, error) {
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	where := "c.type = 'visitreport' AND c.result != '' AND " + notDeleted
+	var qparams []cosmosapi.QueryParam
+	if params.ContactID != "" {
+		where += " AND c.contact.id = @contactid"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@contactid", Value: params.ContactID})
+	}
+	if params.Company != "" {
+		where += " AND LOWER(c.contact.company) = LOWER(@company)"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@company", Value: params.Company})
+	}
+	// group by the visit date like the timeline, the weekday is not
+	// available in Cosmos DB SQL
+	qry := cosmosapi.Query{
+		Query:  "SELECT c.visitDate, COUNT(1) as visits FROM c WHERE " + where + " GROUP BY c.visitDate",
+		Params: qparams,
+	}
+	var docs []StatsTimelineDoc
+	if _, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops); err != nil {
+		return HeatmapDoc{}, err
+	}
+
+	out := HeatmapDoc{Weekdays: make([]HeatmapWeekdayDoc, len(heatmapWeekdays))}
+	index := map[time.Weekday]int{}
+	for i, day := range heatmapWeekdays {
+		out.Weekdays[i].Weekday = day.String()
+		index[day] = i
+	}
+	for _, doc := range docs {
+		visits := int(doc.Visits)
+		out.Visits += visits
+		t, layout, ok := parseVisitDate(doc.VisitDate)
+		if !ok || layout == "2006-01-02" {
+			out.WithoutTime += visits
+			continue
+		}
+		out.Weekdays[index[t.Weekday()]].Hours[t.Hour()] += visits
+	}
+	return out, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	legalHoldPlaced = "placed"
+	legalHoldLifted = "lifted"
+)
+
+// LegalHoldDoc - active legal hold of a report
+type LegalHoldDoc struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// LegalHoldAuditDoc - audit entry for placing or lifting a hold. By is the
+// authenticated caller, IP the address the request came from.
+type LegalHoldAuditDoc struct {
+	Action string    `json:"action"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+	By     string    `json:"by,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+}
+
+// LegalHoldCreateDoc - struct for placing a hold
+type LegalHoldCreateDoc struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// LegalHoldResultDoc - struct for the result of a hold operation on a contact
+type LegalHoldResultDoc struct {
+	ContactId string `json:"contactId"`
+	Reports   int    `json:"reports"`
+}
+
+// stopIfHeld responds with 423 Locked if the report is under legal hold.
+func stopIfHeld(ctx iris.Context, model *VisitReportModel) bool {
+	if model.LegalHold == nil {
+		return false
+	}
+	ctx.StopWithProblem(iris.StatusLocked, iris.NewProblem().
+		Title("Report is under legal hold").
+		Detail(fmt.Sprintf("The report cannot be changed while the hold placed on %s is active", model.LegalHold.Since.Format(time.RFC3339))).
+		Key("reason", model.LegalHold.Reason))
+	return true
+}
+
+func applyLegalHold(model *VisitReportModel, action, reason, by, ip string) {
+	now := time.Now().UTC()
+	if action == legalHoldPlaced {
+		model.LegalHold = &LegalHoldDoc{Reason: reason, Since: now}
+	} else {
+		model.LegalHold = nil
+	}
+	model.LegalHoldAudit = append(model.LegalHoldAudit, LegalHoldAuditDoc{
+		Action: action,
+		Reason: reason,
+		At:     now,
+		By:     by,
+		IP:     ip,
+	})
+}
+
+func replaceWithHold(ctx context.Context, model *VisitReportModel, action, reason, by, ip string) error {
+	applyLegalHold(model, action, reason, by, ip)
+	_, err := currentRepo.Update(ctx, model)
+	return err
+}
+
+func readHoldReason(ctx iris.Context) (string, bool) {
+	in := LegalHoldCreateDoc{}
+	if err := ctx.ReadJSON(&in); err != nil {
+		handleBindError(ctx, err)
+		return "", false
+	}
+	return in.Reason, true
+}
+
+func placeReportHold(ctx iris.Context) {
+	reason, ok := readHoldReason(ctx)
+	if !ok {
+		return
+	}
+	changeReportHold(ctx, legalHoldPlaced, reason)
+}
+
+func liftReportHold(ctx iris.Context) {
+	changeReportHold(ctx, legalHoldLifted, ctx.URLParamDefault("reason", ""))
+}
+
+func changeReportHold(ctx iris.Context, action, reason string) {
+	reportid := ctx.Params().GetString("reportid")
+	// soft-deleted reports can be held as well
+	model, err := getAnyReport(ctx.Request().Context(), reportid)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	if err := replaceWithHold(ctx.Request().Context(), model, action, reason, consumerOf(ctx), clientIP(ctx)); err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	fmt.Printf("Legal hold %s on report %s by %s from %s: %s\n", action, reportid, consumerOf(ctx), clientIP(ctx), reason)
+	out := VisitReportReadDoc{}
+	copier.Copy(&out, model)
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
+
+func placeContactHold(ctx iris.Context) {
+	reason, ok := readHoldReason(ctx)
+	if !ok {
+		return
+	}
+	changeContactHold(ctx, legalHoldPlaced, reason)
+}
+
+func liftContactHold(ctx iris.Context) {
+	changeContactHold(ctx, legalHoldLifted, ctx.URLParamDefault("reason", ""))
+}
+
+// changeContactHold places or lifts the hold on every report of a contact.
+func changeContactHold(ctx iris.Context, action, reason string) {
+	contactid := ctx.Params().GetString("contactid")
+	if !bindValidated(ctx, &ContactIDParam{ContactID: contactid}) {
+		return
+	}
+	changed := 0
+	filter := ReportFilter{ContactID: contactid, IncludeDeleted: true}
+	err := currentRepo.Query(ctx.Request().Context(), filter, func(model *VisitReportModel) error {
+		if (action == legalHoldPlaced) == (model.LegalHold != nil) {
+			return nil
+		}
+		if err := replaceWithHold(ctx.Request().Context(), model, action, reason, consumerOf(ctx), clientIP(ctx)); err != nil {
+			return err
+		}
+		changed++
+		return nil
+	})
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	fmt.Printf("Legal hold %s on %d reports of contact %s by %s from %s: %s\n", action, changed, contactid, consumerOf(ctx), clientIP(ctx), reason)
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(LegalHoldResultDoc{ContactId: contactid, Reports: changed}))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// analyzedFields are the fields of a report written by the text analytics
+// workers, in the order of the lineage response
+var analyzedFields = []string{"detectedLanguage", "visitResultSentimentScore", "visitResultKeyPhrases"}
+
+// FieldLineageDoc - provenance of an analyzed field. The worker that writes
+// the field stores it in the lineage of the report, keyed by the JSON name
+// of the field.
+type FieldLineageDoc struct {
+	Model      string    `json:"model"`
+	Version    string    `json:"version"`
+	AnalyzedAt time.Time `json:"analyzedAt"`
+	// Input is the JSON name of the analyzed field, e.g. "result"
+	Input string `json:"input"`
+	// InputHash is the hex SHA-256 of the analyzed text, see inputHash
+	InputHash string `json:"inputHash"`
+}
+
+// FieldLineageReadDoc - lineage of one analyzed field
+type FieldLineageReadDoc struct {
+	Field    string           `json:"field"`
+	Lineage  *FieldLineageDoc `json:"lineage,omitempty"`
+	Recorded bool             `json:"recorded"`
+	// Stale is set when the input changed after the analysis
+	Stale bool `json:"stale"`
+}
+
+// ReportLineageDoc - struct for GET /reports/{reportid}/lineage
+type ReportLineageDoc struct {
+	ReportId string                `json:"reportId"`
+	Fields   []FieldLineageReadDoc `json:"fields"`
+}
+
+// inputHash returns the hash workers record for an analyzed text.
+func inputHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// inputOf returns the current value of the input field of a lineage.
+func inputOf(model *VisitReportModel, field string) (string, bool) {
+	switch field {
+	case "subject":
+		return model.Subject, true
+	case "description":
+		return model.Description, true
+	case "result":
+		return model.Result, true
+	}
+	return "", false
+}
+
+func readReportLineage(ctx iris.Context) {
+	reportid := ctx.Params().GetString("reportid")
+	model, err := currentRepo.Get(ctx.Request().Context(), reportid)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+
+	out := ReportLineageDoc{ReportId: model.Id, Fields: []FieldLineageReadDoc{}}
+	for _, field := range analyzedFields {
+		doc := FieldLineageReadDoc{Field: field}
+		if lineage, ok := model.Lineage[field]; ok {
+			lineage := lineage
+			doc.Lineage = &lineage
+			doc.Recorded = true
+			if input, ok := inputOf(model, lineage.Input); ok {
+				doc.Stale = inputHash(input) != lineage.InputHash
+			}
+		}
+		out.Fields = append(out.Fields, doc)
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
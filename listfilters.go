@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// listSortFields maps the fields GET /reports can be sorted by to their
+// Cosmos DB paths, "-" in front of a field sorts descending
+var listSortFields = map[string]string{
+	"visitDate":                 "c.visitDate",
+	"subject":                   "c.subject",
+	"visitResultSentimentScore": "c.visitResultSentimentScore",
+}
+
+// sortField splits a sort parameter into the field and the direction.
+func sortField(sort string) (string, bool) {
+	if strings.HasPrefix(sort, "-") {
+		return sort[1:], true
+	}
+	return sort, false
+}
+
+// orderBy returns the ORDER BY clause of the sort of a filter, empty if the
+// list is not sorted.
+func (f ReportFilter) orderBy() string {
+	field, desc := sortField(f.Sort)
+	path, ok := listSortFields[field]
+	if !ok {
+		return ""
+	}
+	if desc {
+		return " ORDER BY " + path + " DESC"
+	}
+	return " ORDER BY " + path + " ASC"
+}
+
+// rangeConditions returns the conditions of the visit date, sentiment and
+// company filters.
+func (f ReportFilter) rangeConditions() ([]string, []cosmosapi.QueryParam) {
+	var conditions []string
+	var params []cosmosapi.QueryParam
+	add := func(cond, name string, value interface{}) {
+		conditions = append(conditions, cond)
+		params = append(params, cosmosapi.QueryParam{Name: name, Value: value})
+	}
+	if f.From != "" {
+		add("c.visitDate >= @from", "@from", f.From)
+	}
+	if f.To != "" {
+		// visit dates may carry a time part, so compare against the next day
+		add("c.visitDate < @to", "@to", nextDay(f.To))
+	}
+	if f.MinScore != nil {
+		add("c.visitResultSentimentScore >= @minscore", "@minscore", *f.MinScore)
+	}
+	if f.MaxScore != nil {
+		add("c.visitResultSentimentScore <= @maxscore", "@maxscore", *f.MaxScore)
+	}
+	if f.Company != "" {
+		add("LOWER(c.contact.company) = @company", "@company", strings.ToLower(strings.TrimSpace(f.Company)))
+	}
+	return conditions, params
+}
+
+// matchesRange applies the range filters the way rangeConditions does.
+func (f ReportFilter) matchesRange(model *VisitReportModel) bool {
+	switch {
+	case f.From != "" && model.VisitDate < f.From,
+		f.To != "" && model.VisitDate >= nextDay(f.To),
+		f.MinScore != nil && model.VisitResultSentimentScore < *f.MinScore,
+		f.MaxScore != nil && model.VisitResultSentimentScore > *f.MaxScore,
+		f.Company != "" && strings.ToLower(model.Contact.Company) != strings.ToLower(strings.TrimSpace(f.Company)):
+		return false
+	}
+	return true
+}
+
+// less orders two reports by the sort of a filter, ties by id.
+func (f ReportFilter) less(a, b *VisitReportModel) bool {
+	field, desc := sortField(f.Sort)
+	cmp := 0
+	switch field {
+	case "visitDate":
+		cmp = strings.Compare(a.VisitDate, b.VisitDate)
+	case "subject":
+		cmp = strings.Compare(a.Subject, b.Subject)
+	case "visitResultSentimentScore":
+		if a.VisitResultSentimentScore < b.VisitResultSentimentScore {
+			cmp = -1
+		} else if a.VisitResultSentimentScore > b.VisitResultSentimentScore {
+			cmp = 1
+		}
+	}
+	if desc {
+		cmp = -cmp
+	}
+	if cmp == 0 {
+		return a.Id < b.Id
+	}
+	return cmp < 0
+}
+
+// searchable reports whether the search index can answer a list with the
+// filter, it only has the contact of a report.
+func (f ReportFilter) searchable() bool {
+	g := f
+	g.ContactID, g.ContactName = "", ""
+	return f.ContactName != "" && g.isEmpty()
+}
+
+func (f ReportFilter) isEmpty() bool {
+	return f.ContactID == "" && f.ContactName == "" && f.CrmID == "" && f.Attendee == "" &&
+		f.Number == "" && f.Acknowledged == nil && !f.IncludeDeleted &&
+		f.From == "" && f.To == "" && f.MinScore == nil && f.MaxScore == nil && f.Company == "" && f.Sort == "" && f.Text == ""
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,12 +26,107 @@ import (
 )
 
 type config struct {
-	DbURL                string `required:"true"`
-	DbKey                string `required:"true"`
-	DbName               string `required:"true"`
-	SbConnStrVisitReport string `required:"true"`
-	SbConnStrContact     string `required:"true"`
-	Env                  string
+	DbURL                  string `required:"true"`
+	DbKey                  string `required:"true"`
+	DbName                 string `required:"true"`
+	SbConnStrVisitReport   string `required:"true"`
+	SbConnStrContact       string `required:"true"`
+	Env                    string
+	DashboardCacheTTL      time.Duration `default:"1m"`
+	Connectors             connectorRoutes
+	SearchURL              string
+	SearchKey              string
+	SearchIndex            string `default:"visitreports"`
+	HmacSecrets            map[string]string
+	HmacRoles              map[string]string
+	HmacReplayWindow       time.Duration `default:"5m"`
+	TLSCertFile            string
+	TLSKeyFile             string
+	TLSClientCAFile        string
+	TLSClientRoles         map[string]string
+	AdminAddr              string `default:":3001"`
+	TrustedProxies         []string
+	DebugRedactFields      []string `default:"firstname,lastname,avatarLocation"`
+	VisitMilestones        []int    `default:"10,50,100"`
+	WeeklyVisitTarget      int
+	SummaryInterval        time.Duration `default:"1h"`
+	Outcomes               []string      `default:"deal-advanced,no-interest,follow-up-needed"`
+	MaxSubjectLength       int           `default:"255"`
+	MaxDescriptionLength   int           `default:"500"`
+	MaxResultLength        int           `default:"500"`
+	StatsQueryTimeout      time.Duration `default:"10s"`
+	PartitionKeys          []string      `default:"visitreport"`
+	CrossPartition         bool
+	MaxQueryParallelism    int `default:"4"`
+	MaxBufferedItems       int `default:"1000"`
+	MaxReportsPerDay       int
+	MinVisitInterval       time.Duration
+	SoftValidation         bool
+	MinResultLength        int `default:"20"`
+	DeprecatedRoutes       map[string]string
+	LegacyEventVersions    []string
+	ReadRegions            []string
+	WriteRegions           []string
+	ReadConsistency        string `default:"Session"`
+	CrmIDPattern           string `default:"^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$"`
+	CrmSync                CrmSyncConfig
+	ShareLinkSecret        string
+	ShareLinkMaxTTL        time.Duration `default:"168h"`
+	CoverageInterval       time.Duration `default:"6h"`
+	Repository             string        `default:"cosmos"`
+	OutboxInterval         time.Duration `default:"1m"`
+	OperationTimeout       time.Duration `default:"10s"`
+	ContactMaxDeliveries   int           `default:"5"`
+	SelfSubscription       string
+	SelfSubscriptionEvents []string
+	AttachmentAccount      string
+	AttachmentKey          string
+	AttachmentContainer    string        `default:"attachments"`
+	AttachmentMaxSize      int64         `default:"104857600"`
+	AttachmentTypes        []string      `default:"application/pdf,image/png,image/jpeg"`
+	AttachmentUploadTTL    time.Duration `default:"15m"`
+	AttachmentBlockSize    int64         `default:"4194304"`
+	AttachmentThumbnails   []int         `default:"160,640"`
+	AttachmentDownloadTTL  time.Duration `default:"5m"`
+	AttachmentCoolAfter    time.Duration
+	AttachmentArchiveAfter time.Duration
+	LifecycleInterval      time.Duration `default:"24h"`
+	RequireIfMatch         bool          `default:"true"`
+	EventCoalesceWindow    time.Duration
+	EventMaxSize           int           `default:"196608"`
+	ClaimCheckContainer    string        `default:"events"`
+	ClaimCheckTTL          time.Duration `default:"168h"`
+	MigrateOnStartup       bool
+	AuthIssuer             string
+	AuthAudiences          []string
+	AuthJWKSURL            string
+	AuthWriterRoles        []string      `default:"SalesRep"`
+	AuthDeleterRoles       []string      `default:"SalesAdmin"`
+	AuthAdminRoles         []string      `default:"SalesAdmin"`
+	AuthReviewerRoles      []string      `default:"SalesManager"`
+	SampleNewRepPeriod     time.Duration `default:"2160h"`
+	ReportNumberPrefix     string
+	ReportListContainer    string
+	ReportListInterval     time.Duration `default:"5s"`
+	FollowUpOutcome        string        `default:"follow-up-needed"`
+	FollowUpDue            time.Duration `default:"336h"`
+	ServiceBusPollInterval time.Duration `default:"1m"`
+	ContactDeletion        string        `default:"anonymize"`
+	DevMode                bool
+	DevToken               string
+	DevCaller              string   `default:"developer"`
+	DevRoles               []string `default:"SalesRep,SalesManager,SalesAdmin"`
+	ReportTopic            string   `default:"scmvrtopic"`
+	ContactTopic           string   `default:"scmtopic"`
+	ContactSubscription    string   `default:"scmcontactvisitreport"`
+	ReportContainer        string   `default:"visitreports"`
+	EventSource            string   `default:"/visitreports"`
+	LegacyEventFormat      bool
+	SuggestCacheTTL        time.Duration `default:"5m"`
+	ReassignRate           float64       `default:"10"`
+	Sandbox                bool
+	SandboxSeed            int64 `default:"1"`
+	SandboxReports         int   `default:"50"`
 }
 
 type validationError struct {
@@ -54,27 +150,54 @@ type ContactDoc struct {
 // VisitReportModel - struct for data access
 type VisitReportModel struct {
 	cosmosapi.Document
-	Type                      string     `json:"type"`
-	DetectedLanguage          string     `json:"detectedLanguage"`
-	Subject                   string     `json:"subject"`
-	Description               string     `json:"description"`
-	VisitDate                 string     `json:"visitDate"`
-	Result                    string     `json:"result"`
-	VisitResultSentimentScore float64    `json:"visitResultSentimentScore"`
-	VisitResultKeyPhrases     []string   `json:"visitResultKeyPhrases"`
-	Contact                   ContactDoc `json:"contact"`
+	Type                      string              `json:"type"`
+	Number                    string              `json:"number,omitempty"`
+	DetectedLanguage          string              `json:"detectedLanguage"`
+	Subject                   string              `json:"subject"`
+	Description               string              `json:"description"`
+	VisitDate                 string              `json:"visitDate"`
+	Result                    string              `json:"result"`
+	Outcome                   *OutcomeDoc         `json:"outcome,omitempty"`
+	CrmLinks                  []CrmLinkDoc        `json:"crmLinks,omitempty"`
+	Attendees                 []AttendeeDoc       `json:"attendees,omitempty"`
+	Agenda                    []string            `json:"agenda,omitempty"`
+	VisitResultSentimentScore float64             `json:"visitResultSentimentScore"`
+	VisitResultKeyPhrases     []string            `json:"visitResultKeyPhrases"`
+	Contact                   ContactDoc          `json:"contact"`
+	ContactSnapshot           *ContactSnapshotDoc `json:"contactSnapshot,omitempty"`
+	LegalHold                 *LegalHoldDoc       `json:"legalHold,omitempty"`
+	LegalHoldAudit            []LegalHoldAuditDoc `json:"legalHoldAudit,omitempty"`
+	Attachments               []AttachmentDoc     `json:"attachments,omitempty"`
+	AttachmentDownloads       []DownloadAuditDoc  `json:"attachmentDownloads,omitempty"`
+	Acknowledgment            *AcknowledgmentDoc  `json:"acknowledgment,omitempty"`
+	CreatedAt                 *time.Time          `json:"createdAt,omitempty"`
+	CreatedBy                 string              `json:"createdBy,omitempty"`
+	OwnerAudit                []OwnerAuditDoc     `json:"ownerAudit,omitempty"`
+	Deleted                   bool                `json:"deleted,omitempty"`
+	DeletedAt                 *time.Time          `json:"deletedAt,omitempty"`
+	DeletedBy                 string              `json:"deletedBy,omitempty"`
+	// Lineage is the provenance of the analyzed fields, see lineage.go
+	Lineage map[string]FieldLineageDoc `json:"lineage,omitempty"`
 }
 
 // VisitReportReadDoc - struct for reading a
 type VisitReportReadDoc struct {
-	Id                        string     `json:"id"`
-	Subject                   string     `json:"subject"`
-	Description               string     `json:"description"`
-	VisitDate                 string     `json:"visitDate"`
-	Result                    string     `json:"result"`
-	VisitResultSentimentScore float64    `json:"visitResultSentimentScore"`
-	VisitResultKeyPhrases     []string   `json:"visitResultKeyPhrases"`
-	Contact                   ContactDoc `json:"contact"`
+	Id                        string             `json:"id"`
+	Number                    string             `json:"number,omitempty"`
+	Subject                   string             `json:"subject"`
+	Description               string             `json:"description"`
+	VisitDate                 string             `json:"visitDate"`
+	Result                    string             `json:"result"`
+	Outcome                   *OutcomeDoc        `json:"outcome,omitempty"`
+	CrmLinks                  []CrmLinkDoc       `json:"crmLinks,omitempty"`
+	Attendees                 []AttendeeDoc      `json:"attendees,omitempty"`
+	Agenda                    []string           `json:"agenda,omitempty"`
+	VisitResultSentimentScore float64            `json:"visitResultSentimentScore"`
+	VisitResultKeyPhrases     []string           `json:"visitResultKeyPhrases"`
+	Contact                   ContactDoc         `json:"contact"`
+	LegalHold                 *LegalHoldDoc      `json:"legalHold,omitempty"`
+	Attachments               []AttachmentDoc    `json:"attachments,omitempty"`
+	Acknowledgment            *AcknowledgmentDoc `json:"acknowledgment,omitempty"`
 }
 
 // VisitReportEventDoc - struct for sending an event
@@ -86,31 +209,48 @@ type VisitReportEventDoc struct {
 
 // VisitReportCreateDoc - struct for creating a VR
 type VisitReportCreateDoc struct {
-	Subject     string     `json:"subject" validate:"required,max=255"`
-	Description string     `json:"description" validate:"max=500"`
-	VisitDate   string     `json:"visitDate" validate:"required"`
-	Contact     ContactDoc `json:"contact"  validate:"required"`
+	Subject     string        `json:"subject" validate:"required,subjectlen"`
+	Description string        `json:"description" validate:"descriptionlen"`
+	VisitDate   string        `json:"visitDate" validate:"required"`
+	Contact     ContactDoc    `json:"contact"  validate:"required"`
+	CrmLinks    []CrmLinkDoc  `json:"crmLinks" validate:"max=20,dive"`
+	Attendees   []AttendeeDoc `json:"attendees" validate:"max=50,dive"`
+	Agenda      []string      `json:"agenda" validate:"max=20,dive,required,max=200"`
 }
 
 // VisitReportUpdateDoc - struct for updating a VR
 type VisitReportUpdateDoc struct {
-	Id          string     `json:"id" validate:"required,uuid"`
-	Subject     string     `json:"subject" validate:"required,max=255"`
-	Description string     `json:"description" validate:"max=500"`
-	Result      string     `json:"result" validate:"max=500"`
-	VisitDate   string     `json:"visitDate" validate:"required"`
-	Contact     ContactDoc `json:"contact"  validate:"required"`
+	Id          string        `json:"id" validate:"required,uuid"`
+	Subject     string        `json:"subject" validate:"required,subjectlen"`
+	Description string        `json:"description" validate:"descriptionlen"`
+	Result      string        `json:"result" validate:"resultlen"`
+	Outcome     *OutcomeDoc   `json:"outcome,omitempty"`
+	VisitDate   string        `json:"visitDate" validate:"required"`
+	Contact     ContactDoc    `json:"contact"  validate:"required"`
+	CrmLinks    []CrmLinkDoc  `json:"crmLinks" validate:"max=20,dive"`
+	Attendees   []AttendeeDoc `json:"attendees" validate:"max=50,dive"`
+	Agenda      []string      `json:"agenda" validate:"max=20,dive,required,max=200"`
 }
 
 // VisitReportListDoc - struct for list operation
 type VisitReportListDoc struct {
 	Id        string     `json:"id"`
 	Type      string     `json:"type"`
+	Draft     bool       `json:"draft,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	Number    string     `json:"number,omitempty"`
 	Subject   string     `json:"subject"`
 	VisitDate string     `json:"visitDate"`
 	Contact   ContactDoc `json:"contact"`
 }
 
+// VisitReportPageDoc - struct for a page of the list operation, an empty
+// continuation token marks the last page
+type VisitReportPageDoc struct {
+	Items             []VisitReportListDoc `json:"items"`
+	ContinuationToken string               `json:"continuationToken,omitempty"`
+}
+
 // StatsByContactDoc - struct for list operation
 type StatsByContactDoc struct {
 	Id         string  `json:"id"`
@@ -155,7 +295,7 @@ func setupTopicSender() (*servicebus.Topic, error) {
 		log.Fatal(err)
 	}
 
-	topic, err := ns.NewTopic("scmvrtopic")
+	topic, err := ns.NewTopic(currentCfg.ReportTopic)
 
 	if err != nil {
 		log.Fatal(err)
@@ -170,79 +310,136 @@ func setupSubscription() error {
 		log.Fatal(err)
 	}
 
-	topic, err := ns.NewTopic("scmtopic")
-	sub, err := topic.NewSubscription("scmcontactvisitreport")
+	topic, err := ns.NewTopic(currentCfg.ContactTopic)
+	sub, err := topic.NewSubscription(currentCfg.ContactSubscription)
 
 	if err != nil {
 		log.Fatal(err)
 	}
+	contactTopic, contactSubscription = topic, sub
 
-	receiver, err := sub.NewReceiver(context.Background())
-	lHandle := receiver.Listen(context.Background(), servicebus.HandlerFunc(func(c context.Context, m *servicebus.Message) error {
-		doc := ContactDoc{}
-		err := json.Unmarshal(m.Data, &doc)
-		if err != nil {
-			m.Abandon(context.Background())
-			fmt.Println(err)
-		}
-
-		if err != nil {
-			fmt.Println(err)
-		}
-		qops := cosmosapi.DefaultQueryDocumentOptions()
-		qops.PartitionKeyValue = "visitreport"
-		var qry cosmosapi.Query
-
-		qry = cosmosapi.Query{
-			Query: "SELECT * FROM c where c.contact.id = @contactid",
-			Params: []cosmosapi.QueryParam{
-				{
-					Name:  "@contactid",
-					Value: doc.Id,
-				},
-			},
-		}
+	go superviseContactListener(sub)
+	return nil
+}
 
-		var docs []VisitReportModel
-		_, errQuery := currentClient.QueryDocuments(context.Background(), currentCfg.DbName, "visitreports", qry, &docs, qops)
-		if errQuery != nil {
-			errQuery = errors.WithStack(errQuery)
-			fmt.Println(errQuery)
-		}
+// handleContactChanged applies a created or changed contact to its reports.
+func handleContactChanged(c context.Context, m *servicebus.Message, doc *ContactEventDoc) {
+	var docs []VisitReportModel
+	errQuery := currentRepo.Query(c, ReportFilter{ContactID: doc.Id, IncludeDeleted: true}, func(model *VisitReportModel) error {
+		docs = append(docs, *model)
+		return nil
+	})
+	if errQuery != nil {
+		abandonContact(c, m, errQuery)
+		return
+	}
 
-		var wg sync.WaitGroup
-		for _, v := range docs {
-			wg.Add(1)
-			go updateInBg(v, &doc, &wg)
+	var wg sync.WaitGroup
+	skipped := 0
+	for _, v := range docs {
+		if isStaleContactEvent(doc, v.ContactSnapshot) {
+			skipped++
+			continue
 		}
-		wg.Wait()
+		wg.Add(1)
+		go updateInBg(v, doc, &wg)
+	}
+	wg.Wait()
+	if skipped > 0 {
+		contactUpdatesSkipped.Add(float64(skipped))
+		fmt.Printf("Skipped %d reports of contact %s with the same or newer contact data\n", skipped, doc.Id)
+	}
 
-		err = m.Complete(context.Background())
-		return err
-	}))
+	err := m.Complete(c)
+	serviceBusReceived.WithLabelValues(currentCfg.ContactSubscription, metricResult(err)).Inc()
+	if err != nil {
+		// returning the error would stop the listener, the message is
+		// delivered again once its lock expires
+		err = errors.WithStack(err)
+		fmt.Println(err)
+	}
+}
 
-	if lHandle == nil {
-		fmt.Println("Not init.")
+// abandonContact hands a message back for another delivery after a
+// transient error, or dead-letters it after VR_CONTACTMAXDELIVERIES.
+func abandonContact(c context.Context, m *servicebus.Message, cause error) {
+	cause = errors.WithStack(cause)
+	fmt.Println(cause)
+	if int(m.DeliveryCount) >= currentCfg.ContactMaxDeliveries {
+		deadLetterContact(c, m, cause)
+		return
+	}
+	err := m.Abandon(c)
+	serviceBusReceived.WithLabelValues(currentCfg.ContactSubscription, "abandoned").Inc()
+	if err != nil {
+		err = errors.WithStack(err)
+		fmt.Println(err)
 	}
-	return nil
 }
 
-func updateInBg(doc VisitReportModel, contact *ContactDoc, wg *sync.WaitGroup) {
+func updateInBg(doc VisitReportModel, contact *ContactEventDoc, wg *sync.WaitGroup) {
 	defer wg.Done()
-	fmt.Printf("Processing.... Id %s \n", doc.Id)
-	ops := cosmosapi.ReplaceDocumentOptions{}
-	ops.PartitionKeyValue = "visitreport"
-	doc.Contact.Firstname = contact.Firstname
-	doc.Contact.Lastname = contact.Lastname
-	doc.Contact.AvatarLocation = contact.AvatarLocation
-	doc.Contact.Company = contact.Company
-	doc.Type = "visitreport"
-	_, res, err := currentClient.ReplaceDocument(context.Background(), currentCfg.DbName, "visitreports", doc.Id, doc, ops)
-	if err != nil {
+	for i := 0; ; i++ {
+		if doc.LegalHold != nil {
+			fmt.Printf("Skipping.... Id %s is under legal hold \n", doc.Id)
+			return
+		}
+		fmt.Printf("Processing.... Id %s \n", doc.Id)
+		// the report may be changed by a newer contact event or a user in
+		// the meantime, Update checks the etag
+		doc.Contact.Firstname = contact.Firstname
+		doc.Contact.Lastname = contact.Lastname
+		doc.Contact.AvatarLocation = contact.AvatarLocation
+		doc.Contact.Company = contact.Company
+		if snapshot := contact.snapshot(); snapshot != nil {
+			doc.ContactSnapshot = snapshot
+		}
+		doc.Type = "visitreport"
+		_, err := currentRepo.Update(context.Background(), &doc)
+		if err == nil {
+			indexReportInBg(doc)
+			return
+		}
+		if err == ErrReportChanged && i < contactUpdateMaxRetries {
+			id := doc.Id
+			var current *VisitReportModel
+			current, err = getAnyReport(context.Background(), id)
+			if err == nil {
+				doc = *current
+				if isStaleContactEvent(contact, doc.ContactSnapshot) {
+					contactUpdatesSkipped.Inc()
+					fmt.Printf("Skipping.... Id %s has newer contact data \n", id)
+					return
+				}
+				continue
+			}
+		}
 		err = errors.WithStack(err)
 		fmt.Println(err)
+		return
 	}
-	fmt.Printf("Request Units: %f\n", res.RUs)
+}
+
+// sendReportEvent sends the event of a report write with send, its message
+// id is derived from the report and the etag of the write.
+func sendReportEvent(ctx context.Context, eventDoc VisitReportEventDoc, etag string, send func(context.Context, *servicebus.Message) error) error {
+	m, err := json.Marshal(eventDoc)
+	if err != nil {
+		return err
+	}
+	return send(ctx, &servicebus.Message{
+		ID:          eventMessageID(eventDoc.EventType, eventDoc.Id, etag),
+		ContentType: "application/json",
+		Data:        m,
+	})
+}
+
+// logLostEvent logs an event that was neither sent nor added to the outbox.
+// The report is stored, the write is answered anyway, an error would make
+// the client write it again.
+func logLostEvent(eventDoc VisitReportEventDoc, err error) {
+	err = errors.WithStack(err)
+	fmt.Printf("%s of report %s is lost: %v\n", eventDoc.EventType, eventDoc.Id, err)
 }
 
 func wrapValidationErrors(errs validator.ValidationErrors) []validationError {
@@ -262,21 +459,6 @@ func wrapValidationErrors(errs validator.ValidationErrors) []validationError {
 }
 
 func main() {
-	app := iris.New()
-	app.Use(recover.New())
-	app.Validator = validator.New()
-	app.Use(logger.New())
-	app.Use(iris.Compression)
-	app.AllowMethods(iris.MethodOptions)
-	crs := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "DELETE", "PUT", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "accept", "origin", "Cache-Control", "X-Requested-With"},
-		AllowCredentials: true,
-		ExposedHeaders:   []string{"Content-Length", "Location"},
-		MaxAge:           600,
-	})
-	app.Use(crs)
 	if os.Getenv("VR_ENV") != "production" {
 		err := godotenv.Load()
 		if err != nil {
@@ -289,7 +471,13 @@ func main() {
 		MasterKey: currentCfg.DbKey,
 	}
 
-	currentClient = cosmosapi.New(currentCfg.DbURL, cosmosCfg, nil, nil)
+	// Route requests to the preferred regions of a multi-region account
+	router, err := newRegionRouter(currentCfg.DbURL, currentCfg.ReadRegions, currentCfg.WriteRegions, currentCfg.ReadConsistency)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transport := &throttleDetector{base: &operationTimeout{base: router, timeout: currentCfg.OperationTimeout}}
+	currentClient = cosmosapi.New(currentCfg.DbURL, cosmosCfg, &http.Client{Transport: transport}, nil)
 
 	// Get a database
 	db, err := currentClient.GetDatabase(context.Background(), currentCfg.DbName, nil)
@@ -300,6 +488,71 @@ func main() {
 
 	currentDb = db
 
+	// Reports are stored through the repository, VR_REPOSITORY=memory runs
+	// the report endpoints without Cosmos DB
+	currentRepo, err = newReportRepository(currentCfg.Repository)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Admin commands, e.g. "visitreports reindex"
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	checkDevMode()
+	checkSandbox()
+
+	if authEnabled() && (len(currentCfg.AuthAudiences) == 0 || currentCfg.AuthJWKSURL == "") {
+		log.Fatal("VR_AUTHISSUER requires VR_AUTHAUDIENCES and VR_AUTHJWKSURL")
+	}
+	if currentCfg.ReassignRate <= 0 {
+		log.Fatal("VR_REASSIGNRATE must be positive")
+	}
+
+	currentTrustedProxies, err = parseTrustedProxies(currentCfg.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app := iris.New()
+	app.Use(recover.New())
+	app.Use(instrumentRequests)
+	app.Validator = newValidator()
+	app.Use(clientIPMiddleware)
+	// Log the resolved client IP instead of the spoofable forwarding headers
+	logCfg := logger.DefaultConfig()
+	logCfg.IP = false
+	logCfg.MessageContextKeys = []string{ctxKeyClientIP}
+	app.Use(logger.New(logCfg))
+	app.Use(iris.Compression)
+	app.Use(capturePayloads)
+	app.Use(requireJSONBody)
+	app.AllowMethods(iris.MethodOptions)
+	corsOptions := cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "DELETE", "PUT", "PATCH", "POST", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "accept", "origin", "Cache-Control", "X-Requested-With", headerSessionToken, headerReadPreference, "If-Match", headerSandboxLatency, headerSandboxErrorRate},
+		AllowCredentials: true,
+		ExposedHeaders:   []string{"Content-Length", "Location", headerSessionToken, headerContinuationToken, "ETag"},
+		MaxAge:           600,
+	}
+	if currentCfg.DevMode {
+		// reflect local origins, browsers refuse "*" with credentials
+		corsOptions.AllowOriginFunc = isLocalOrigin
+	}
+	crs := cors.New(corsOptions)
+	app.Use(crs)
+	app.Use(verifyClientCert)
+	app.Use(verifySignature)
+	app.Use(trackDeprecation)
+	app.Use(sessionConsistency)
+	app.Use(readPreference)
+	app.Use(sandboxFaults)
+
 	currentTopic, err = setupTopicSender()
 	if err != nil {
 		err = errors.WithStack(err)
@@ -308,6 +561,53 @@ func main() {
 
 	setupSubscription()
 
+	if currentCfg.SelfSubscription != "" && currentTopic != nil {
+		if err := setupSelfSubscription(); err != nil {
+			err = errors.WithStack(err)
+			fmt.Println(err)
+		}
+	}
+
+	// the workers materialize aggregates of Cosmos DB, see requireAggregates
+	if currentCfg.SummaryInterval > 0 && aggregatesAvailable() {
+		startSummaryWorker()
+	}
+
+	if crmSyncEnabled() {
+		startCrmSync()
+	}
+
+	if currentCfg.CoverageInterval > 0 && aggregatesAvailable() {
+		startCoverageWorker()
+	}
+
+	if currentCfg.OutboxInterval > 0 {
+		startOutboxWorker()
+	}
+
+	if reportListEnabled() {
+		startReportListProjector()
+	}
+
+	if attachmentLifecycleEnabled() {
+		startAttachmentLifecycleWorker()
+	}
+
+	if currentCfg.ServiceBusPollInterval > 0 {
+		startBacklogPoller()
+	}
+
+	if currentCfg.MigrateOnStartup {
+		migrateInBg()
+	}
+
+	if searchEnabled() {
+		if err := ensureSearchIndex(context.Background()); err != nil {
+			err = errors.WithStack(err)
+			fmt.Println(err)
+		}
+	}
+
 	// Health check
 	app.Get("/", func(ctx iris.Context) {
 		if currentClient != nil {
@@ -316,20 +616,99 @@ func main() {
 			ctx.StatusCode(500)
 		}
 	})
-	reportsAPI := app.Party("/reports")
+	// only / and /shared/{token} are served without authentication, probes
+	// without a token use the admin app
+	app.Get("/healthz/live", authenticate, readLiveness)
+	app.Get("/healthz/ready", authenticate, readReadiness)
+	app.Get("/openapi.json", authenticate, readOpenAPI)
+	app.Get("/swagger", authenticate, readSwaggerUI)
+
+	reportsAPI := app.Party("/reports", authenticate)
 	{
+		canWrite := requireRole(currentCfg.AuthWriterRoles...)
+		canDelete := requireRole(currentCfg.AuthDeleterRoles...)
 		reportsAPI.Get("/", list)
+		reportsAPI.Get("/search", searchReports)
+		reportsAPI.Get("/suggest", suggestReports)
+		reportsAPI.Get("/sample", requireRole(currentCfg.AuthReviewerRoles...), readSample)
+		reportsAPI.Get("/drafts", listDrafts)
 		reportsAPI.Get("/{reportid}", read)
-		reportsAPI.Delete("/{reportid}", delete)
-		reportsAPI.Post("/", create)
-		reportsAPI.Put("/{reportid}", update)
+		reportsAPI.Get("/{reportid}/lineage", readReportLineage)
+		reportsAPI.Delete("/{reportid}", canDelete, deleteReport)
+		reportsAPI.Post("/", canWrite, create)
+		reportsAPI.Put("/{reportid}", canWrite, update)
+		reportsAPI.Patch("/{reportid}", canWrite, patchReport)
+		reportsAPI.Put("/{reportid}/hold", canDelete, placeReportHold)
+		reportsAPI.Delete("/{reportid}/hold", canDelete, liftReportHold)
+		reportsAPI.Get("/{reportid}/draft", readDraft)
+		reportsAPI.Put("/{reportid}/draft", canWrite, saveDraft)
+		reportsAPI.Delete("/{reportid}/draft", canWrite, deleteDraft)
+		reportsAPI.Post("/{reportid}/draft/submit", canWrite, submitDraft)
+		reportsAPI.Get("/{reportid}/sharelinks", listShareLinks)
+		reportsAPI.Post("/{reportid}/sharelinks", canWrite, createShareLink)
+		reportsAPI.Delete("/{reportid}/sharelinks/{linkid}", canWrite, revokeShareLink)
+		reportsAPI.Post("/{reportid}/attachments/uploads", canWrite, createAttachmentUpload)
+		reportsAPI.Get("/{reportid}/attachments/uploads/{attachmentid}", canWrite, readAttachmentUpload)
+		reportsAPI.Delete("/{reportid}/attachments/uploads/{attachmentid}", canWrite, abortAttachmentUpload)
+		reportsAPI.Post("/{reportid}/attachments/{attachmentid}/complete", canWrite, completeAttachmentUpload)
+		reportsAPI.Post("/{reportid}/attachments/{attachmentid}/downloads", createAttachmentDownload)
+		reportsAPI.Get("/{reportid}/attachments/{attachmentid}/downloads", requireRole(currentCfg.AuthAdminRoles...), listAttachmentDownloads)
+		reportsAPI.Post("/{reportid}/ack", requireRole(currentCfg.AuthReviewerRoles...), acknowledgeReport)
+		reportsAPI.Post("/{reportid}/restore", requireRole(currentCfg.AuthAdminRoles...), restoreReport)
+	}
+
+	contactsAPI := app.Party("/contacts", authenticate)
+	{
+		canDelete := requireRole(currentCfg.AuthDeleterRoles...)
+		contactsAPI.Put("/{contactid}/hold", canDelete, placeContactHold)
+		contactsAPI.Delete("/{contactid}/hold", canDelete, liftContactHold)
 	}
 
-	statsAPI := app.Party("/stats")
+	meAPI := app.Party("/me", authenticate)
 	{
-		statsAPI.Get("/", readStatsOverall)
-		statsAPI.Get("/{contactid}", readStatsByContactID)
-		statsAPI.Get("/timeline", readStatsTimeline)
+		meAPI.Get("/preferences", readPreferences)
+		meAPI.Put("/preferences", savePreferences)
+	}
+
+	// the token is the credential, the ingress may let this route through
+	// without authentication
+	app.Get("/shared/{token}", readSharedReport)
+
+	// the aggregate routes answer 501 with VR_REPOSITORY=memory
+	app.Get("/dashboard", authenticate, requireAggregates, readDashboard)
+	app.Get("/summaries", authenticate, requireAggregates, readSummaries)
+	app.Get("/outcomes", authenticate, requireAggregates, readOutcomes)
+	snapshotsAPI := app.Party("/snapshots", authenticate, requireAggregates)
+	{
+		snapshotsAPI.Get("/", listSnapshots)
+		snapshotsAPI.Post("/", requireRole(currentCfg.AuthWriterRoles...), createSnapshot)
+		snapshotsAPI.Get("/{snapshotid}", readSnapshot)
+	}
+
+	statsAPI := app.Party("/stats", authenticate)
+	{
+		statsAPI.Get("/", requireAggregates, readStatsOverall)
+		statsAPI.Get("/{contactid}", requireAggregates, readStatsByContactID)
+		statsAPI.Get("/timeline", requireAggregates, readStatsTimeline)
+		statsAPI.Get("/outcomes", requireAggregates, readStatsOutcomes)
+		statsAPI.Get("/coverage", requireAggregates, readStatsCoverage)
+		statsAPI.Get("/heatmap", requireAggregates, readStatsHeatmap)
+		statsAPI.Get("/cohorts", requireAggregates, readStatsCohorts)
+		statsAPI.Get("/acknowledgments", readStatsAcknowledgments)
+		statsAPI.Get("/owners", requireRole(currentCfg.AuthReviewerRoles...), readStatsOwners)
+	}
+
+	odataAPI := app.Party("/odata", authenticate, requireAggregates)
+	{
+		odataAPI.Get("/", odataServiceDocument)
+		odataAPI.Get("/$metadata", odataMetadataDocument)
+		odataAPI.Get("/VisitReports", odataVisitReports)
+	}
+
+	var adminApp *iris.Application
+	if currentCfg.AdminAddr != "" {
+		adminApp = newAdminApp()
+		go adminApp.Listen(currentCfg.AdminAddr, iris.WithoutInterruptHandler, iris.WithoutServerError(iris.ErrServerClosed))
 	}
 
 	idleConnsClosed := make(chan struct{})
@@ -339,78 +718,169 @@ func main() {
 		defer cancel()
 		// close all hosts.
 		app.Shutdown(ctx)
+		if adminApp != nil {
+			adminApp.Shutdown(ctx)
+		}
+		cancelServerContext()
+		currentCoalescer.flushAll()
 		close(idleConnsClosed)
 	})
 
-	// [...]
-	app.Listen(":3000", iris.WithoutInterruptHandler, iris.WithoutServerError(iris.ErrServerClosed))
+	runner, err := setupListener(":3000")
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.ConfigureHost(withServerContext)
+	app.Run(runner, iris.WithoutInterruptHandler, iris.WithoutServerError(iris.ErrServerClosed))
 	<-idleConnsClosed
 
 }
 
 func list(ctx iris.Context) {
-	contactid := ctx.URLParamDefault("contactid", "")
-	qops := cosmosapi.DefaultQueryDocumentOptions()
-	qops.PartitionKeyValue = "visitreport"
-	var qry cosmosapi.Query
-	if contactid == "" {
-		qry = cosmosapi.Query{
-			Query: "SELECT * FROM c",
-		}
-	} else {
-		qry = cosmosapi.Query{
-			Query: "SELECT * FROM c where c.contact.id = @contactid",
-			Params: []cosmosapi.QueryParam{
-				{
-					Name:  "@contactid",
-					Value: contactid,
-				},
-			},
-		}
+	params := ListQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	paged := params.Limit > 0 || params.ContinuationToken != ""
+	if params.IncludeDrafts && stopIfNotAdmin(ctx, "includeDrafts") {
+		return
+	}
+	if params.IncludeDeleted && stopIfNotAdmin(ctx, "includeDeleted") {
+		return
+	}
+	if params.IncludeDrafts && (paged || params.Sort != "") {
+		ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+			Title("Invalid query").
+			Detail("includeDrafts cannot be combined with limit, continuationToken and sort"))
+		return
+	}
+	if (params.From != "" && params.To != "" && params.From > params.To) ||
+		(params.MinScore != nil && params.MaxScore != nil && *params.MinScore > *params.MaxScore) {
+		ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+			Title("Invalid query").
+			Detail("from must not be after to and minScore must not be above maxScore"))
+		return
 	}
 
-	var docs []VisitReportModel
-	_, err := currentClient.QueryDocuments(context.Background(), currentCfg.DbName, "visitreports", qry, &docs, qops)
-	if err != nil {
-		err = errors.WithStack(err)
-		fmt.Println(err)
+	filter := ReportFilter{
+		ContactID:      params.ContactID,
+		ContactName:    strings.TrimSpace(params.ContactName),
+		CrmID:          params.CrmID,
+		Attendee:       params.Attendee,
+		Number:         params.Number,
+		IncludeDeleted: params.IncludeDeleted,
+		From:           params.From,
+		To:             params.To,
+		MinScore:       params.MinScore,
+		MaxScore:       params.MaxScore,
+		Company:        params.Company,
+		Sort:           params.Sort,
 	}
-	out := []VisitReportListDoc{}
-	copier.Copy(&out, &docs)
-	ctx.StatusCode(200)
-	ctx.JSON(out)
+	if params.Acknowledged != "" {
+		acknowledged := params.Acknowledged == "true"
+		filter.Acknowledged = &acknowledged
+	}
+	// the search index only has the contact of a report and no continuation
+	// tokens, filter and page everything else in Cosmos DB
+	if filter.searchable() && !paged && !params.IncludeDrafts && searchEnabled() {
+		out, err := searchByContactName(ctx.Request().Context(), filter.ContactName, filter.ContactID)
+		if err != nil {
+			stopWithError(ctx, err)
+			return
+		}
+		ctx.StatusCode(200)
+		ctx.JSON(normalize(out))
+		return
+	}
+	if paged {
+		pageReports(ctx, filter, params.Limit, params.ContinuationToken)
+		return
+	}
+	streamReports(ctx, filter, params.IncludeDrafts)
 }
 
+// read returns a report. With includeDrafts admins also get the draft of a
+// report that was not submitted yet, e.g. to investigate a missing report.
 func read(ctx iris.Context) {
+	params := ReadQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	if params.IncludeDrafts && stopIfNotAdmin(ctx, "includeDrafts") {
+		return
+	}
 	reportid := ctx.Params().GetString("reportid")
-	ro := cosmosapi.GetDocumentOptions{
-		PartitionKeyValue: "visitreport",
+	doc, err := currentRepo.Get(ctx.Request().Context(), reportid)
+	if err == ErrReportNotFound && params.IncludeDrafts {
+		readDraft(ctx)
+		return
 	}
-
-	var doc VisitReportModel
-	_, err := currentClient.GetDocument(context.Background(), currentCfg.DbName, "visitreports", reportid, ro, &doc)
 	if err != nil {
-		err = errors.WithStack(err)
-		fmt.Println(err)
+		stopWithError(ctx, err)
+		return
 	}
 	out := VisitReportReadDoc{}
-	copier.Copy(&out, &doc)
+	copier.Copy(&out, doc)
+	if doc.Etag != "" {
+		ctx.Header("ETag", etagHeader(doc.Etag))
+	}
 	ctx.StatusCode(200)
-	ctx.JSON(out)
+	ctx.JSON(normalize(out))
 }
 
-func delete(ctx iris.Context) {
+// deleteReport soft-deletes a report, admins can restore it. It is
+// idempotent: deleting a report that does not exist, e.g. when retrying a
+// delete, returns 204 as well. With If-Match the report is only deleted if
+// it was not changed since the client read it, an If-Match can't match a
+// report that does not exist (RFC 7232 section 3.1) and fails with 412.
+// Only * on a report deleted already returns 204.
+func deleteReport(ctx iris.Context) {
 	reportid := ctx.Params().GetString("reportid")
-	ro := cosmosapi.DeleteDocumentOptions{
-		PartitionKeyValue: "visitreport",
+	model, err := currentRepo.Get(ctx.Request().Context(), reportid)
+	if err == ErrReportNotFound {
+		deleteMissingReport(ctx, reportid)
+		return
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	if stopIfPreconditionFails(ctx, model.Etag) {
+		return
+	}
+	if stopIfHeld(ctx, model) {
+		return
 	}
 
-	_, err := currentClient.DeleteDocument(context.Background(), currentCfg.DbName, "visitreports", reportid, ro)
+	err = softDelete(ctx.Request().Context(), model, ctx.Values().GetString(ctxKeyCaller))
+	if err != nil && err != ErrReportNotFound {
+		stopWithError(ctx, err)
+		return
+	}
+	removeReportFromIndexInBg(reportid)
+	ctx.StatusCode(http.StatusNoContent)
+}
+
+func deleteMissingReport(ctx iris.Context, reportid string) {
+	ifMatch := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if ifMatch == "" {
+		ctx.StatusCode(http.StatusNoContent)
+		return
+	}
+	if ifMatch != "*" {
+		stopPreconditionFailed(ctx)
+		return
+	}
+	_, err := currentRepo.GetDeleted(ctx.Request().Context(), reportid)
+	if err == ErrReportNotFound {
+		stopPreconditionFailed(ctx)
+		return
+	}
 	if err != nil {
-		err = errors.WithStack(err)
-		fmt.Println(err)
+		stopWithError(ctx, err)
+		return
 	}
-	ctx.StatusCode(http.StatusOK)
+	ctx.StatusCode(http.StatusNoContent)
 }
 
 func create(ctx iris.Context) {
@@ -442,44 +912,60 @@ func create(ctx iris.Context) {
 	model.Type = "visitreport"
 	model.Id = uuid.New().String()
 	copier.Copy(&model, &vr)
-	ops := cosmosapi.CreateDocumentOptions{}
-	ops = cosmosapi.CreateDocumentOptions{
-		PartitionKeyValue: "visitreport",
+	if storeNewReport(ctx, &model) {
+		respondCreated(ctx, &model)
+	}
+}
+
+// storeNewReport creates the report and sends the created event, the caller
+// responds with respondCreated. It returns false if the report was not
+// stored, the response is sent then.
+func storeNewReport(ctx iris.Context, model *VisitReportModel) bool {
+	if stopIfPolicyViolated(ctx, model) {
+		return false
+	}
+	now := time.Now().UTC()
+	model.CreatedAt = &now
+	model.CreatedBy = ctx.Values().GetString(ctxKeyCaller)
+	if reportNumbersEnabled() {
+		number, err := nextReportNumber(ctx.Request().Context(), now)
+		if err != nil {
+			stopWithError(ctx, err)
+			return false
+		}
+		model.Number = number
 	}
-	_, _, err = currentClient.CreateDocument(context.Background(), currentCfg.DbName, "visitreports", model, ops)
+	etag, err := currentRepo.Create(ctx.Request().Context(), model)
 	if err != nil {
-		err = errors.WithStack(err)
-		fmt.Println(err)
-		ctx.StopWithStatus(iris.StatusInternalServerError)
-		return
+		stopWithError(ctx, err)
+		return false
 	}
+	ctx.Header("ETag", etagHeader(etag))
+	indexReportInBg(*model)
 
 	// send event
-	inctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 	eventDoc := VisitReportEventDoc{}
-	copier.Copy(&eventDoc, &model)
+	copier.Copy(&eventDoc, model)
 	eventDoc.EventType = "VisitReportCreatedEvent"
 	eventDoc.Version = "1"
-	m, err := json.Marshal(eventDoc)
-	if err != nil {
-		fmt.Printf("Error: %s", err)
-		return
+	notifyConnectors(eventDoc, nil)
+	syncActivityInBg(eventDoc)
+	err = sendReportEvent(ctx.Request().Context(), eventDoc, etag, sendRequestEvent)
+	if err == nil {
+		trackEventEmission(eventDoc.EventType, eventDoc.Version, "topic:"+currentCfg.ReportTopic)
+	} else {
+		logLostEvent(eventDoc, err)
 	}
+	return true
+}
 
-	sbMessage := servicebus.Message{
-		ContentType: "application/json",
-		Data:        m,
-	}
-	err = currentTopic.Send(inctx, &sbMessage)
-	if err != nil {
-		fmt.Printf("Error: %s", err)
-		return
-	}
-	out := VisitReportReadDoc{}
-	copier.Copy(&out, &model)
+// respondCreated responds with a new report, its soft validation warnings
+// and the given warnings.
+func respondCreated(ctx iris.Context, model *VisitReportModel, warnings ...ValidationWarningDoc) {
+	out := VisitReportWriteDoc{Warnings: append(softValidate(model), warnings...)}
+	copier.Copy(&out.VisitReportReadDoc, model)
 	ctx.StatusCode(http.StatusCreated)
-	ctx.JSON(out)
+	ctx.JSON(normalize(out))
 }
 
 func update(ctx iris.Context) {
@@ -507,82 +993,116 @@ func update(ctx iris.Context) {
 		ctx.StopWithStatus(iris.StatusInternalServerError)
 		return
 	}
-	ro := cosmosapi.GetDocumentOptions{
-		PartitionKeyValue: "visitreport",
+	stored, err := currentRepo.Get(ctx.Request().Context(), reportid)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
 	}
-	model := VisitReportModel{}
+	if stopIfPreconditionFails(ctx, stored.Etag) {
+		return
+	}
+	saveUpdate(ctx, stored, vr)
+}
 
-	_, err = currentClient.GetDocument(context.Background(), currentCfg.DbName, "visitreports", reportid, ro, &model)
-	if err != nil {
-		err = errors.WithStack(err)
-		fmt.Println(err)
+// saveUpdate replaces the stored report with the fields of vr and emits the
+// updated event, for PUT and PATCH.
+func saveUpdate(ctx iris.Context, stored *VisitReportModel, vr VisitReportUpdateDoc) {
+	model := *stored
+	if stopIfHeld(ctx, &model) {
+		return
 	}
+	completed := model.Result == "" && vr.Result != ""
+	before := VisitReportReadDoc{}
+	copier.Copy(&before, stored)
 
 	copier.Copy(&model, &vr)
-	ops := cosmosapi.ReplaceDocumentOptions{}
-	ops.PartitionKeyValue = "visitreport"
+	model.Id = stored.Id
 
-	_, _, err = currentClient.ReplaceDocument(context.Background(), currentCfg.DbName, "visitreports", reportid, model, ops)
+	// the repository only replaces the report read above, a concurrent
+	// update in between fails with ErrReportChanged
+	etag, err := currentRepo.Update(ctx.Request().Context(), &model)
 
 	if err != nil {
-		err = errors.WithStack(err)
-		fmt.Println(err)
-		ctx.StopWithStatus(iris.StatusInternalServerError)
+		stopWithError(ctx, err)
 		return
 	}
+	ctx.Header("ETag", etagHeader(etag))
+	indexReportInBg(model)
 
 	// send event
-	evctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 	eventDoc := VisitReportEventDoc{}
 	copier.Copy(&eventDoc, &model)
 	eventDoc.EventType = "VisitReportUpdatedEvent"
 	eventDoc.Version = "1"
-	m, err := json.Marshal(eventDoc)
+	notifyConnectors(eventDoc, &before)
+	syncActivityInBg(eventDoc)
+	err = sendReportEvent(ctx.Request().Context(), eventDoc, etag, func(c context.Context, msg *servicebus.Message) error {
+		return publishUpdatedEvent(c, model.Id, msg)
+	})
 	if err != nil {
-		fmt.Printf("Error: %s", err)
-		return
+		logLostEvent(eventDoc, err)
+	}
+	if completed {
+		publishMilestonesInBg(model)
 	}
+	doc := VisitReportWriteDoc{Warnings: softValidate(&model)}
+	copier.Copy(&doc.VisitReportReadDoc, &model)
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(doc))
+}
 
-	sbMessage := servicebus.Message{
-		ContentType: "application/json",
-		Data:        m,
+func readStatsByContactID(ctx iris.Context) {
+	contactid := ctx.Params().GetString("contactid")
+	if !bindValidated(ctx, &ContactIDParam{ContactID: contactid}) {
+		return
 	}
-	err = currentTopic.Send(evctx, &sbMessage)
+	docs, err := queryStatsByContact(ctx.Request().Context(), contactid)
 	if err != nil {
-		fmt.Printf("Error: %s", err)
+		stopWithError(ctx, err)
 		return
 	}
-	doc := VisitReportReadDoc{}
-	copier.Copy(&model, &doc)
 	ctx.StatusCode(http.StatusOK)
-	ctx.JSON(doc)
+	ctx.JSON(normalize(docs))
 }
 
-func readStatsByContactID(ctx iris.Context) {
-	contactid := ctx.Params().GetString("contactid")
+// queryStatsByContact returns the stats of a contact, of all contacts
+// without contactid.
+func queryStatsByContact(ctx context.Context, contactid string) ([]StatsByContactDoc, error) {
 	qops := cosmosapi.DefaultQueryDocumentOptions()
 	qops.PartitionKeyValue = "visitreport"
+	where := "c.type = 'visitreport' and c.result != '' AND " + notDeleted
+	var params []cosmosapi.QueryParam
+	if contactid != "" {
+		where += " AND c.contact.id = @contactid"
+		params = append(params, cosmosapi.QueryParam{Name: "@contactid", Value: contactid})
+	}
 	qry := cosmosapi.Query{
-		Query: "SELECT c.contact.id, COUNT(1) as countScore, AVG(c.visitResultSentimentScore) as avgScore, MAX(c.visitResultSentimentScore) as maxScore, MIN(c.visitResultSentimentScore) as minScore FROM c WHERE c.type = 'visitreport' and c.result != ''  AND c.contact.id = @contactid GROUP BY c.contact.id",
-		Params: []cosmosapi.QueryParam{
-			{
-				Name:  "@contactid",
-				Value: contactid,
-			},
-		},
+		Query:  "SELECT c.contact.id, COUNT(1) as countScore, AVG(c.visitResultSentimentScore) as avgScore, MAX(c.visitResultSentimentScore) as maxScore, MIN(c.visitResultSentimentScore) as minScore FROM c WHERE " + where + " GROUP BY c.contact.id",
+		Params: params,
 	}
 	var docs []StatsByContactDoc
-	_, err := currentClient.QueryDocuments(context.Background(), currentCfg.DbName, "visitreports", qry, &docs, qops)
+	_, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops)
+	return docs, err
+}
+
+// readStatsOverall serves the stats of the cached dashboard to requests
+// accepting cached data.
+func readStatsOverall(ctx iris.Context) {
+	if doc := currentDashboardCache.get(); doc != nil && acceptsCached(ctx.Request().Context()) {
+		ctx.StatusCode(http.StatusOK)
+		ctx.JSON(normalize(doc.Overall))
+		return
+	}
+	docs, err := queryStatsOverall(ctx.Request().Context())
 	if err != nil {
-		err = errors.WithStack(err)
-		fmt.Println(err)
+		stopWithError(ctx, err)
+		return
 	}
 	ctx.StatusCode(http.StatusOK)
-	ctx.JSON(docs)
+	ctx.JSON(normalize(docs))
 }
 
-func readStatsOverall(ctx iris.Context) {
+func queryStatsOverall(ctx context.Context) ([]StatsOverallDoc, error) {
 	qops := cosmosapi.DefaultQueryDocumentOptions()
 	qops.PartitionKeyValue = "visitreport"
 	qry := cosmosapi.Query{
@@ -592,36 +1112,66 @@ func readStatsOverall(ctx iris.Context) {
 					MAX(c.visitResultSentimentScore) as maxScore,
 					MIN(c.visitResultSentimentScore) as minScore
 				FROM c
-				WHERE c.type = 'visitreport' and c.result != ''
+				WHERE c.type = 'visitreport' and c.result != '' AND ` + notDeleted + `
 				GROUP BY c.type`,
 	}
 	var docs []StatsOverallDoc
-	_, err := currentClient.QueryDocuments(context.Background(), currentCfg.DbName, "visitreports", qry, &docs, qops)
+	_, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops)
+	return docs, err
+}
+
+func readStatsTimeline(ctx iris.Context) {
+	params := TimelineQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	docs, err := queryStatsTimeline(ctx.Request().Context(), params.From, params.To)
 	if err != nil {
-		err = errors.WithStack(err)
-		fmt.Println(err)
+		stopWithError(ctx, err)
+		return
 	}
 	ctx.StatusCode(http.StatusOK)
-	ctx.JSON(docs)
+	if len(params.MovingAverage) > 0 || params.Trend {
+		ctx.JSON(normalize(timelineSeries(docs, params)))
+		return
+	}
+	ctx.JSON(normalize(docs))
 }
 
-func readStatsTimeline(ctx iris.Context) {
+// queryStatsTimeline returns the visits per day, optionally limited to an
+// inclusive from/to range of visit dates.
+func queryStatsTimeline(ctx context.Context, from, to string) ([]StatsTimelineDoc, error) {
 	qops := cosmosapi.DefaultQueryDocumentOptions()
 	qops.PartitionKeyValue = "visitreport"
+	where := "c.type = 'visitreport' AND c.result != '' AND " + notDeleted
+	var params []cosmosapi.QueryParam
+	if from != "" {
+		where += " AND c.visitDate >= @from"
+		params = append(params, cosmosapi.QueryParam{Name: "@from", Value: from})
+	}
+	if to != "" {
+		// visit dates may carry a time part, so compare against the next day
+		where += " AND c.visitDate < @to"
+		params = append(params, cosmosapi.QueryParam{Name: "@to", Value: nextDay(to)})
+	}
 	qry := cosmosapi.Query{
 		Query: `SELECT
 				c.visitDate,
 				COUNT(1) as visits
 				FROM c
-				WHERE c.type = 'visitreport' AND c.result != ''
+				WHERE ` + where + `
 				GROUP BY c.visitDate`,
+		Params: params,
 	}
 	var docs []StatsTimelineDoc
-	_, err := currentClient.QueryDocuments(context.Background(), currentCfg.DbName, "visitreports", qry, &docs, qops)
+	_, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops)
+	return docs, err
+}
+
+func nextDay(date string) string {
+	t, err := time.Parse("2006-01-02", date)
 	if err != nil {
-		err = errors.WithStack(err)
-		fmt.Println(err)
+		return date
 	}
-	ctx.StatusCode(http.StatusOK)
-	ctx.JSON(docs)
+	return t.AddDate(0, 0, 1).Format("2006-01-02")
 }
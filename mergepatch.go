@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/jinzhu/copier"
+	"github.com/kataras/iris/v12"
+)
+
+// mergePatch applies an RFC 7386 JSON merge patch to a decoded JSON value:
+// objects are merged recursively, null removes a member and anything else,
+// arrays included, replaces the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+func stopInvalidPatch(ctx iris.Context, detail string) {
+	ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+		Title("Invalid merge patch").
+		Detail(detail))
+}
+
+// patchReport applies a merge patch (application/merge-patch+json) to the
+// updatable fields of a report. The patched report is validated like a PUT
+// body, so removing a required field fails.
+func patchReport(ctx iris.Context) {
+	body, err := ctx.GetBody()
+	if err != nil {
+		stopInvalidPatch(ctx, err.Error())
+		return
+	}
+	var patch map[string]interface{}
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		stopInvalidPatch(ctx, "The body must be a JSON object")
+		return
+	}
+	stored, err := currentRepo.Get(ctx.Request().Context(), ctx.Params().GetString("reportid"))
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	if stopIfPreconditionFails(ctx, stored.Etag) {
+		return
+	}
+
+	vr := VisitReportUpdateDoc{}
+	copier.Copy(&vr, stored)
+	b, err := json.Marshal(vr)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	var target interface{}
+	if err := json.Unmarshal(b, &target); err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	if b, err = json.Marshal(mergePatch(target, patch)); err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	vr = VisitReportUpdateDoc{}
+	if err := json.Unmarshal(b, &vr); err != nil {
+		stopInvalidPatch(ctx, err.Error())
+		return
+	}
+	// the id is the one of the path, as for PUT
+	vr.Id = stored.Id
+	if err := ctx.Application().Validate(&vr); err != nil {
+		if errs, ok := err.(validator.ValidationErrors); ok {
+			ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+				Title("Validation error").
+				Detail("One or more fields failed to be validated").
+				Key("errors", wrapValidationErrors(errs)))
+			return
+		}
+		stopInvalidPatch(ctx, err.Error())
+		return
+	}
+	saveUpdate(ctx, stored, vr)
+}
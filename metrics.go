@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/kataras/iris/v12"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vr_http_requests_total",
+		Help: "HTTP requests by route and status code.",
+	}, []string{"method", "route", "code"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vr_http_request_duration_seconds",
+		Help:    "HTTP request latency by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	cosmosRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vr_cosmos_requests_total",
+		Help: "Cosmos DB requests by operation, collection and status code.",
+	}, []string{"operation", "collection", "code"})
+	cosmosRequestUnits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vr_cosmos_request_units_total",
+		Help: "Cosmos DB request units consumed by operation and collection.",
+	}, []string{"operation", "collection"})
+	serviceBusSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vr_servicebus_messages_sent_total",
+		Help: "Service Bus messages sent by topic and result.",
+	}, []string{"topic", "result"})
+	serviceBusReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vr_servicebus_messages_received_total",
+		Help: "Service Bus messages received by subscription and result.",
+	}, []string{"subscription", "result"})
+	eventsCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "vr_events_coalesced_total",
+		Help: "Updated events merged into a later event of the same report.",
+	})
+	eventsClaimChecked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "vr_events_claim_checked_total",
+		Help: "Events exceeding the message size limit sent as a claim check.",
+	})
+	contactUpdatesSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "vr_contact_updates_skipped_total",
+		Help: "Reports not updated by a contact event because they have the same or newer contact data.",
+	})
+
+	serviceBusActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vr_servicebus_active_messages",
+		Help: "Active messages of a Service Bus entity, as of the last poll.",
+	}, []string{"entity"})
+	serviceBusDeadLettered = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vr_servicebus_deadletter_messages",
+		Help: "Dead-lettered messages of a Service Bus entity, as of the last poll.",
+	}, []string{"entity"})
+	serviceBusSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vr_servicebus_size_bytes",
+		Help: "Size of a Service Bus entity, as of the last poll.",
+	}, []string{"entity"})
+
+	// currentMetrics is the registry served on /metrics
+	currentMetrics = prometheus.NewRegistry()
+)
+
+func init() {
+	currentMetrics.MustRegister(httpRequests, httpRequestDuration, cosmosRequests, cosmosRequestUnits, serviceBusSent, serviceBusReceived, eventsCoalesced, eventsClaimChecked, contactUpdatesSkipped,
+		serviceBusActive, serviceBusDeadLettered, serviceBusSize)
+}
+
+// instrumentRequests counts the requests and their latency per route
+// template, e.g. /reports/{reportid}, to keep the number of series bounded.
+func instrumentRequests(ctx iris.Context) {
+	start := time.Now()
+	ctx.Next()
+	route := "unmatched"
+	if r := ctx.GetCurrentRoute(); r != nil {
+		route = r.Path()
+	}
+	method := ctx.Method()
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(ctx.GetStatusCode())).Inc()
+	httpRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+}
+
+// cosmosOperation returns the operation and collection of a Cosmos DB REST
+// request, e.g. "query" and "visitreports" for a POST to
+// /dbs/{db}/colls/visitreports/docs with x-ms-documentdb-isquery.
+func cosmosOperation(r *http.Request) (string, string) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	collection := ""
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] == "colls" {
+			collection = segments[i+1]
+		}
+	}
+	switch {
+	case r.Header.Get("x-ms-documentdb-isquery") == "True":
+		return "query", collection
+	case r.Method == http.MethodGet && len(segments)%2 == 1:
+		return "readfeed", collection
+	case r.Method == http.MethodGet:
+		return "read", collection
+	case r.Method == http.MethodPost && r.Header.Get("x-ms-documentdb-is-upsert") == "True":
+		return "upsert", collection
+	case r.Method == http.MethodPost:
+		return "create", collection
+	case r.Method == http.MethodPut:
+		return "replace", collection
+	case r.Method == http.MethodDelete:
+		return "delete", collection
+	}
+	return strings.ToLower(r.Method), collection
+}
+
+// observeCosmosRequest records a Cosmos DB response and the request units
+// it was charged.
+func observeCosmosRequest(r *http.Request, resp *http.Response, err error) {
+	operation, collection := cosmosOperation(r)
+	if err != nil {
+		cosmosRequests.WithLabelValues(operation, collection, "error").Inc()
+		return
+	}
+	cosmosRequests.WithLabelValues(operation, collection, strconv.Itoa(resp.StatusCode)).Inc()
+	if charge, err := strconv.ParseFloat(resp.Header.Get("x-ms-request-charge"), 64); err == nil {
+		cosmosRequestUnits.WithLabelValues(operation, collection).Add(charge)
+	}
+}
+
+func metricResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// sendToTopic sends a message to the visit report topic and counts it.
+// Events exceeding VR_EVENTMAXSIZE are sent as a claim check, the report
+// events as CloudEvents. msg keeps the raw event, a failed send goes to the
+// outbox as it is and is wrapped again when the outbox is flushed. In
+// sandbox mode nothing is sent.
+func sendToTopic(ctx context.Context, msg *servicebus.Message) error {
+	if currentCfg.Sandbox {
+		return nil
+	}
+	out, err := wrapMessage(ctx, msg)
+	if err == nil {
+		err = currentTopic.Send(ctx, out)
+	}
+	serviceBusSent.WithLabelValues(currentCfg.ReportTopic, metricResult(err)).Inc()
+	return err
+}
+
+// wrapMessage returns a copy of a message as it is sent, with the event type
+// property, the claim check and the CloudEvent applied.
+func wrapMessage(ctx context.Context, msg *servicebus.Message) (*servicebus.Message, error) {
+	out := *msg
+	out.UserProperties = map[string]interface{}{}
+	for k, v := range msg.UserProperties {
+		out.UserProperties[k] = v
+	}
+	setEventTypeProperty(&out)
+	if err := applyClaimCheck(ctx, &out); err != nil {
+		return nil, err
+	}
+	if err := applyCloudEvent(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
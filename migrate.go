@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+type undefinedPartitionKey struct{}
+
+// withUndefinedPartition addresses the partition of documents without a
+// partition key. The Cosmos DB client can only send simple key values, the
+// header for an undefined key is set by the region router.
+func withUndefinedPartition(ctx context.Context) context.Context {
+	return context.WithValue(ctx, undefinedPartitionKey{}, true)
+}
+
+func isUndefinedPartition(ctx context.Context) bool {
+	v, _ := ctx.Value(undefinedPartitionKey{}).(bool)
+	return v
+}
+
+// normalizeLegacyReport sets the fields that reports written before they
+// existed lack, so partition queries and the result filters of the stats
+// find them.
+func normalizeLegacyReport(doc map[string]interface{}) map[string]interface{} {
+	doc = stripSystemProperties(doc)
+	doc["type"] = "visitreport"
+	if _, ok := doc["result"].(string); !ok {
+		doc["result"] = ""
+	}
+	return doc
+}
+
+// migrateLegacyReports moves the reports without type out of the undefined
+// partition, in batches of batchSize. As the type is the partition key the
+// report is created in the "visitreport" partition and then deleted from the
+// undefined one, a rerun after a failure continues with the reports left.
+// Reports whose id already exists in the "visitreport" partition are skipped
+// and left for manual review.
+func migrateLegacyReports(ctx context.Context, batchSize int, dryRun bool) (migrated, skipped int, err error) {
+	legacyCtx := withUndefinedPartition(ctx)
+	var counts []int
+	countQry := cosmosapi.Query{Query: "SELECT VALUE COUNT(1) FROM c"}
+	if _, err := currentClient.QueryDocuments(legacyCtx, currentCfg.DbName, currentCfg.ReportContainer, countQry, &counts, cosmosapi.DefaultQueryDocumentOptions()); err != nil {
+		return 0, 0, err
+	}
+	total := 0
+	if len(counts) > 0 {
+		total = counts[0]
+	}
+	if total == 0 || dryRun {
+		fmt.Printf("Found %d reports without type\n", total)
+		return 0, 0, nil
+	}
+
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.MaxItemCount = batchSize
+	qry := cosmosapi.Query{Query: "SELECT * FROM c"}
+	for {
+		var docs []map[string]interface{}
+		res, err := currentClient.QueryDocuments(legacyCtx, currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops)
+		if err != nil {
+			return migrated, skipped, err
+		}
+		for _, doc := range docs {
+			id, _ := doc["id"].(string)
+			ops := cosmosapi.CreateDocumentOptions{
+				PartitionKeyValue: "visitreport",
+			}
+			_, _, err := currentClient.CreateDocument(ctx, currentCfg.DbName, currentCfg.ReportContainer, normalizeLegacyReport(doc), ops)
+			if err == cosmosapi.ErrConflict {
+				fmt.Printf("Skipped report %s, it exists with type already\n", id)
+				skipped++
+				continue
+			}
+			if err != nil {
+				return migrated, skipped, errors.Wrapf(err, "migrating report %s", id)
+			}
+			_, err = currentClient.DeleteDocument(legacyCtx, currentCfg.DbName, currentCfg.ReportContainer, id, cosmosapi.DeleteDocumentOptions{})
+			if err != nil && err != cosmosapi.ErrNotFound {
+				return migrated, skipped, errors.Wrapf(err, "removing legacy report %s", id)
+			}
+			migrated++
+		}
+		fmt.Printf("Migrated %d of %d reports without type, skipped %d\n", migrated, total, skipped)
+		if res.Continuation == "" {
+			return migrated, skipped, nil
+		}
+		qops.Continuation = res.Continuation
+	}
+}
+
+// migrate backfills the type of legacy reports, e.g. "visitreports migrate
+// -batch 100". With -dry-run it only counts them. The server runs the same
+// migration on startup with VR_MIGRATEONSTARTUP.
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	batchSize := fs.Int("batch", 100, "number of reports migrated per batch")
+	dryRun := fs.Bool("dry-run", false, "only count the reports without type")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *batchSize <= 0 {
+		return fmt.Errorf("-batch must be positive")
+	}
+	migrated, skipped, err := migrateLegacyReports(context.Background(), *batchSize, *dryRun)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		fmt.Printf("Migrated %d reports, skipped %d\n", migrated, skipped)
+	}
+	return nil
+}
+
+func migrateInBg() {
+	go func() {
+		migrated, skipped, err := migrateLegacyReports(serverContext, 100, false)
+		if err != nil {
+			err = errors.WithStack(err)
+			fmt.Println(err)
+			return
+		}
+		if migrated > 0 || skipped > 0 {
+			fmt.Printf("Migrated %d reports without type on startup, skipped %d\n", migrated, skipped)
+		}
+	}()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// ContactVisitMilestoneEventDoc - sent when a contact reaches one of VR_VISITMILESTONES visits
+type ContactVisitMilestoneEventDoc struct {
+	EventType string     `json:"eventType"`
+	Version   string     `json:"version"`
+	Contact   ContactDoc `json:"contact"`
+	Milestone int        `json:"milestone"`
+	ReportId  string     `json:"reportId"`
+	ReachedAt time.Time  `json:"reachedAt"`
+}
+
+// TeamWeeklyTargetReachedEventDoc - sent when the visits of a week reach VR_WEEKLYVISITTARGET
+type TeamWeeklyTargetReachedEventDoc struct {
+	EventType string    `json:"eventType"`
+	Version   string    `json:"version"`
+	Week      string    `json:"week"`
+	Target    int       `json:"target"`
+	Visits    int       `json:"visits"`
+	ReportId  string    `json:"reportId"`
+	ReachedAt time.Time `json:"reachedAt"`
+}
+
+// publishMilestonesInBg checks the milestones after a report has been
+// completed, i.e. got a result and thereby counts as a visit in the stats.
+func publishMilestonesInBg(model VisitReportModel) {
+	go func() {
+		if err := publishContactMilestone(model); err != nil {
+			err = errors.WithStack(err)
+			fmt.Println(err)
+		}
+		if err := publishWeeklyTarget(model); err != nil {
+			err = errors.WithStack(err)
+			fmt.Println(err)
+		}
+	}()
+}
+
+func publishContactMilestone(model VisitReportModel) error {
+	if len(currentCfg.VisitMilestones) == 0 {
+		return nil
+	}
+	visits, err := countVisits("c.contact.id = @contactid", []cosmosapi.QueryParam{
+		{Name: "@contactid", Value: model.Contact.Id},
+	}, func(m *VisitReportModel) bool { return m.Contact.Id == model.Contact.Id })
+	if err != nil {
+		return err
+	}
+	for _, milestone := range currentCfg.VisitMilestones {
+		if visits != milestone {
+			continue
+		}
+		id := eventMessageID("ContactVisitMilestoneEvent", model.Contact.Id, strconv.Itoa(milestone))
+		return publishEvent("ContactVisitMilestoneEvent", "1", id, ContactVisitMilestoneEventDoc{
+			EventType: "ContactVisitMilestoneEvent",
+			Version:   "1",
+			Contact:   model.Contact,
+			Milestone: milestone,
+			ReportId:  model.Id,
+			ReachedAt: time.Now().UTC(),
+		})
+	}
+	return nil
+}
+
+func publishWeeklyTarget(model VisitReportModel) error {
+	target := currentCfg.WeeklyVisitTarget
+	if target <= 0 || len(model.VisitDate) < 10 {
+		return nil
+	}
+	day, err := time.Parse("2006-01-02", model.VisitDate[:10])
+	if err != nil {
+		return nil
+	}
+	monday := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	from, to := monday.Format("2006-01-02"), monday.AddDate(0, 0, 7).Format("2006-01-02")
+	visits, err := countVisits("c.visitDate >= @from AND c.visitDate < @to", []cosmosapi.QueryParam{
+		{Name: "@from", Value: from},
+		{Name: "@to", Value: to},
+	}, func(m *VisitReportModel) bool { return m.VisitDate >= from && m.VisitDate < to })
+	if err != nil || visits != target {
+		return err
+	}
+	year, week := day.ISOWeek()
+	weekName := fmt.Sprintf("%d-W%02d", year, week)
+	id := eventMessageID("TeamWeeklyTargetReachedEvent", weekName, strconv.Itoa(target))
+	return publishEvent("TeamWeeklyTargetReachedEvent", "1", id, TeamWeeklyTargetReachedEventDoc{
+		EventType: "TeamWeeklyTargetReachedEvent",
+		Version:   "1",
+		Week:      weekName,
+		Target:    target,
+		Visits:    visits,
+		ReportId:  model.Id,
+		ReachedAt: time.Now().UTC(),
+	})
+}
+
+// countVisits counts the completed reports matching the condition, using the
+// same definition of a visit as the stats endpoints. match is the same
+// condition for the memory repository.
+func countVisits(condition string, params []cosmosapi.QueryParam, match func(model *VisitReportModel) bool) (int, error) {
+	if !aggregatesAvailable() {
+		return countReports(context.Background(), ReportFilter{}, func(m *VisitReportModel) bool {
+			return m.Result != "" && match(m)
+		})
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qry := cosmosapi.Query{
+		Query:  "SELECT VALUE COUNT(1) FROM c WHERE c.type = 'visitreport' AND c.result != '' AND " + notDeleted + " AND " + condition,
+		Params: params,
+	}
+	var counts []int
+	_, err := currentClient.QueryDocuments(context.Background(), currentCfg.DbName, currentCfg.ReportContainer, qry, &counts, qops)
+	if err != nil || len(counts) == 0 {
+		return 0, err
+	}
+	return counts[0], nil
+}
+
+// eventMessageID derives the Service Bus message id of an event from what
+// it is about, e.g. the report id and the ETag of the write. A resend of the
+// same event carries the same id and is dropped by the duplicate detection
+// of the topic.
+func eventMessageID(eventType string, keys ...string) string {
+	h := sha256.New()
+	h.Write([]byte(eventType))
+	for _, key := range keys {
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func publishEvent(eventType, version, messageID string, event interface{}) error {
+	m, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	evctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = sendToTopic(evctx, &servicebus.Message{
+		ID:          messageID,
+		ContentType: "application/json",
+		Data:        m,
+	})
+	if err == nil {
+		trackEventEmission(eventType, version, "topic:"+currentCfg.ReportTopic)
+	}
+	return err
+}
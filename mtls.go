@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/kataras/iris/v12"
+)
+
+// ctxKeyRoles holds the roles of the authenticated caller
+const ctxKeyRoles = "roles"
+
+// setupListener returns the iris runner for the public API. Without a
+// certificate it listens on plain HTTP. With VR_TLSCLIENTCAFILE set, clients
+// must present a certificate signed by that CA.
+func setupListener(addr string) (iris.Runner, error) {
+	if currentCfg.TLSCertFile == "" {
+		return iris.Addr(addr), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(currentCfg.TLSCertFile, currentCfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if currentCfg.TLSClientCAFile != "" {
+		pem, err := ioutil.ReadFile(currentCfg.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", currentCfg.TLSClientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return iris.Listener(tls.NewListener(ln, tlsCfg)), nil
+}
+
+// certIdentities returns the common name and all SANs of a client certificate.
+func certIdentities(cert *x509.Certificate) []string {
+	ids := []string{cert.Subject.CommonName}
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return ids
+}
+
+// verifyClientCert maps a verified client certificate to a role using the
+// CN/SAN allow-list in VR_TLSCLIENTROLES. Certificates that are not on the
+// list are rejected.
+func verifyClientCert(ctx iris.Context) {
+	state := ctx.Request().TLS
+	if state == nil || len(state.VerifiedChains) == 0 {
+		ctx.Next()
+		return
+	}
+
+	cert := state.VerifiedChains[0][0]
+	for _, id := range certIdentities(cert) {
+		if role, ok := currentCfg.TLSClientRoles[id]; ok {
+			ctx.Values().Set(ctxKeyCaller, id)
+			ctx.Values().Set(ctxKeyRoles, []string{role})
+			ctx.Next()
+			return
+		}
+	}
+	ctx.StopWithProblem(iris.StatusForbidden, iris.NewProblem().
+		Title("Forbidden").
+		Detail(fmt.Sprintf("Client certificate %q is not allowed", cert.Subject.CommonName)))
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const odataMaxPageSize = 500
+
+// odataProperties maps OData property paths to Cosmos document paths. Only
+// these properties can be used in $filter, $select and $orderby.
+var odataProperties = map[string]string{
+	"id":                        "c.id",
+	"number":                    "c.number",
+	"subject":                   "c.subject",
+	"description":               "c.description",
+	"visitDate":                 "c.visitDate",
+	"result":                    "c.result",
+	"visitResultSentimentScore": "c.visitResultSentimentScore",
+	"visitResultKeyPhrases":     "c.visitResultKeyPhrases",
+	"contact":                   "c.contact",
+	"contact/id":                "c.contact.id",
+	"contact/firstname":         "c.contact.firstname",
+	"contact/lastname":          "c.contact.lastname",
+	"contact/company":           "c.contact.company",
+	"attendees":                 "c.attendees",
+	"agenda":                    "c.agenda",
+}
+
+var odataDefaultSelect = []string{"id", "number", "subject", "description", "visitDate", "result", "visitResultSentimentScore", "visitResultKeyPhrases", "contact", "attendees", "agenda"}
+
+const odataMetadata = `<?xml version="1.0" encoding="utf-8"?>
+<edmx:Edmx Version="4.0" xmlns:edmx="http://docs.oasis-open.org/odata/ns/edmx">
+  <edmx:DataServices>
+    <Schema Namespace="VisitReports" xmlns="http://docs.oasis-open.org/odata/ns/edm">
+      <ComplexType Name="Contact">
+        <Property Name="id" Type="Edm.String"/>
+        <Property Name="firstname" Type="Edm.String"/>
+        <Property Name="lastname" Type="Edm.String"/>
+        <Property Name="avatarLocation" Type="Edm.String"/>
+        <Property Name="company" Type="Edm.String"/>
+      </ComplexType>
+      <ComplexType Name="Attendee">
+        <Property Name="name" Type="Edm.String"/>
+        <Property Name="email" Type="Edm.String"/>
+        <Property Name="role" Type="Edm.String"/>
+      </ComplexType>
+      <EntityType Name="VisitReport">
+        <Key><PropertyRef Name="id"/></Key>
+        <Property Name="id" Type="Edm.String" Nullable="false"/>
+        <Property Name="number" Type="Edm.String"/>
+        <Property Name="subject" Type="Edm.String"/>
+        <Property Name="description" Type="Edm.String"/>
+        <Property Name="visitDate" Type="Edm.String"/>
+        <Property Name="result" Type="Edm.String"/>
+        <Property Name="visitResultSentimentScore" Type="Edm.Double"/>
+        <Property Name="visitResultKeyPhrases" Type="Collection(Edm.String)"/>
+        <Property Name="contact" Type="VisitReports.Contact"/>
+        <Property Name="attendees" Type="Collection(VisitReports.Attendee)"/>
+        <Property Name="agenda" Type="Collection(Edm.String)"/>
+      </EntityType>
+      <EntityContainer Name="Container">
+        <EntitySet Name="VisitReports" EntityType="VisitReports.VisitReport"/>
+      </EntityContainer>
+    </Schema>
+  </edmx:DataServices>
+</edmx:Edmx>`
+
+func odataServiceDocument(ctx iris.Context) {
+	ctx.Header("OData-Version", "4.0")
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(iris.Map{
+		"@odata.context": odataBaseURL(ctx) + "/$metadata",
+		"value": []iris.Map{
+			{"name": "VisitReports", "kind": "EntitySet", "url": "VisitReports"},
+		},
+	}))
+}
+
+func odataMetadataDocument(ctx iris.Context) {
+	ctx.Header("OData-Version", "4.0")
+	ctx.ContentType("application/xml")
+	ctx.StatusCode(http.StatusOK)
+	ctx.WriteString(odataMetadata)
+}
+
+func odataVisitReports(ctx iris.Context) {
+	qry, countQry, err := buildODataQuery(ctx.Request().URL.Query())
+	if err != nil {
+		ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+			Title("Invalid OData query").
+			Detail(err.Error()))
+		return
+	}
+
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qops.MaxItemCount = odataMaxPageSize
+	if top, ok := odataIntOption(ctx, "$top"); ok && top < odataMaxPageSize {
+		qops.MaxItemCount = top
+	}
+	if token := ctx.URLParam("$skiptoken"); token != "" {
+		continuation, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+				Title("Invalid OData query").
+				Detail("$skiptoken is malformed"))
+			return
+		}
+		qops.Continuation = string(continuation)
+	}
+
+	docs := []map[string]interface{}{}
+	res, err := currentClient.QueryDocuments(ctx.Request().Context(), currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+
+	out := iris.Map{
+		"@odata.context": odataBaseURL(ctx) + "/$metadata#VisitReports",
+		"value":          docs,
+	}
+
+	if countQry != nil {
+		var counts []int
+		countOps := cosmosapi.DefaultQueryDocumentOptions()
+		countOps.PartitionKeyValue = "visitreport"
+		_, err := currentClient.QueryDocuments(ctx.Request().Context(), currentCfg.DbName, currentCfg.ReportContainer, *countQry, &counts, countOps)
+		if err != nil {
+			stopWithError(ctx, err)
+			return
+		}
+		total := 0
+		for _, c := range counts {
+			total += c
+		}
+		out["@odata.count"] = total
+	}
+
+	if res.Continuation != "" {
+		params := ctx.Request().URL.Query()
+		params.Set("$skiptoken", base64.RawURLEncoding.EncodeToString([]byte(res.Continuation)))
+		out["@odata.nextLink"] = odataBaseURL(ctx) + "/VisitReports?" + params.Encode()
+	}
+
+	ctx.Header("OData-Version", "4.0")
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
+
+func odataBaseURL(ctx iris.Context) string {
+	return ctx.AbsoluteURI("/odata")
+}
+
+func odataIntOption(ctx iris.Context, name string) (int, bool) {
+	v := ctx.URLParam(name)
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// buildODataQuery translates the supported OData system query options into
+// a Cosmos query. The second return value is the matching count query and
+// is only set when $count=true was requested.
+func buildODataQuery(params url.Values) (cosmosapi.Query, *cosmosapi.Query, error) {
+	qry := cosmosapi.Query{}
+
+	fields := odataDefaultSelect
+	if sel := params.Get("$select"); sel != "" {
+		fields = nil
+		for _, f := range strings.Split(sel, ",") {
+			fields = append(fields, strings.TrimSpace(f))
+		}
+	}
+	projection := make([]string, 0, len(fields))
+	for _, f := range fields {
+		path, ok := odataProperties[f]
+		if !ok || strings.Contains(f, "/") {
+			return qry, nil, fmt.Errorf("property %q cannot be selected", f)
+		}
+		projection = append(projection, path)
+	}
+
+	where := "c.type = 'visitreport' AND " + notDeleted
+	if filter := params.Get("$filter"); filter != "" {
+		p := &odataFilterParser{}
+		cond, err := p.parse(filter)
+		if err != nil {
+			return qry, nil, err
+		}
+		where += " AND (" + cond + ")"
+		qry.Params = p.params
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT " + strings.Join(projection, ", ") + " FROM c WHERE " + where)
+
+	if orderby := params.Get("$orderby"); orderby != "" {
+		var order []string
+		for _, o := range strings.Split(orderby, ",") {
+			parts := strings.Fields(o)
+			if len(parts) == 0 || len(parts) > 2 {
+				return qry, nil, fmt.Errorf("invalid $orderby %q", o)
+			}
+			path, ok := odataProperties[parts[0]]
+			if !ok {
+				return qry, nil, fmt.Errorf("property %q cannot be used in $orderby", parts[0])
+			}
+			dir := "ASC"
+			if len(parts) == 2 {
+				switch strings.ToLower(parts[1]) {
+				case "asc":
+				case "desc":
+					dir = "DESC"
+				default:
+					return qry, nil, fmt.Errorf("invalid $orderby direction %q", parts[1])
+				}
+			}
+			order = append(order, path+" "+dir)
+		}
+		sb.WriteString(" ORDER BY " + strings.Join(order, ", "))
+	}
+
+	skip, top := 0, -1
+	for name, dst := range map[string]*int{"$skip": &skip, "$top": &top} {
+		if v := params.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return qry, nil, fmt.Errorf("%s must be a non-negative integer", name)
+			}
+			*dst = n
+		}
+	}
+	if skip > 0 || top >= 0 {
+		if top < 0 {
+			// Cosmos requires LIMIT whenever OFFSET is used
+			top = 1<<31 - 1
+		}
+		sb.WriteString(fmt.Sprintf(" OFFSET %d LIMIT %d", skip, top))
+	}
+	qry.Query = sb.String()
+
+	switch params.Get("$count") {
+	case "", "false":
+		return qry, nil, nil
+	case "true":
+		countQry := cosmosapi.Query{
+			Query:  "SELECT VALUE COUNT(1) FROM c WHERE " + where,
+			Params: qry.Params,
+		}
+		return qry, &countQry, nil
+	default:
+		return qry, nil, fmt.Errorf("$count must be true or false")
+	}
+}
+
+var odataComparisons = map[string]string{
+	"eq": "=",
+	"ne": "!=",
+	"gt": ">",
+	"ge": ">=",
+	"lt": "<",
+	"le": "<=",
+}
+
+var odataFunctions = map[string]string{
+	"contains":   "CONTAINS",
+	"startswith": "STARTSWITH",
+	"endswith":   "ENDSWITH",
+}
+
+type odataToken struct {
+	kind  string // ident, string, number, punct
+	value string
+}
+
+// odataFilterParser is a small recursive descent parser for the subset of
+// $filter supported by the endpoint: comparisons, and/or/not, parentheses
+// and the contains/startswith/endswith string functions. Literals are
+// always passed as query parameters.
+type odataFilterParser struct {
+	tokens []odataToken
+	pos    int
+	params []cosmosapi.QueryParam
+}
+
+func (p *odataFilterParser) parse(filter string) (string, error) {
+	tokens, err := tokenizeODataFilter(filter)
+	if err != nil {
+		return "", err
+	}
+	p.tokens = tokens
+	cond, err := p.parseOr()
+	if err != nil {
+		return "", err
+	}
+	if p.pos < len(p.tokens) {
+		return "", fmt.Errorf("unexpected %q in $filter", p.tokens[p.pos].value)
+	}
+	return cond, nil
+}
+
+func (p *odataFilterParser) peek() *odataToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+func (p *odataFilterParser) next() (odataToken, error) {
+	t := p.peek()
+	if t == nil {
+		return odataToken{}, fmt.Errorf("unexpected end of $filter")
+	}
+	p.pos++
+	return *t, nil
+}
+
+func (p *odataFilterParser) expect(value string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t.value != value {
+		return fmt.Errorf("expected %q but found %q in $filter", value, t.value)
+	}
+	return nil
+}
+
+func (p *odataFilterParser) isKeyword(word string) bool {
+	t := p.peek()
+	return t != nil && t.kind == "ident" && strings.EqualFold(t.value, word)
+}
+
+func (p *odataFilterParser) parseOr() (string, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for p.isKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " OR " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *odataFilterParser) parseAnd() (string, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return "", err
+	}
+	for p.isKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " AND " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *odataFilterParser) parseUnary() (string, error) {
+	if p.isKeyword("not") {
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + inner + ")", nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *odataFilterParser) parsePrimary() (string, error) {
+	t := p.peek()
+	if t == nil {
+		return "", fmt.Errorf("unexpected end of $filter")
+	}
+	if t.value == "(" {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect(")"); err != nil {
+			return "", err
+		}
+		return inner, nil
+	}
+	if fn, ok := odataFunctions[strings.ToLower(t.value)]; ok && t.kind == "ident" {
+		p.pos++
+		if err := p.expect("("); err != nil {
+			return "", err
+		}
+		prop, err := p.parseProperty()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect(","); err != nil {
+			return "", err
+		}
+		lit, err := p.parseLiteral()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect(")"); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s(%s, %s)", fn, prop, lit), nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return "", err
+	}
+	opTok, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	op, ok := odataComparisons[strings.ToLower(opTok.value)]
+	if !ok {
+		return "", fmt.Errorf("unsupported operator %q in $filter", opTok.value)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return "", err
+	}
+	return left + " " + op + " " + right, nil
+}
+
+func (p *odataFilterParser) parseOperand() (string, error) {
+	t := p.peek()
+	if t == nil {
+		return "", fmt.Errorf("unexpected end of $filter")
+	}
+	if t.kind == "ident" {
+		switch strings.ToLower(t.value) {
+		case "true", "false", "null":
+		default:
+			return p.parseProperty()
+		}
+	}
+	return p.parseLiteral()
+}
+
+func (p *odataFilterParser) parseProperty() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	path, ok := odataProperties[t.value]
+	if t.kind != "ident" || !ok {
+		return "", fmt.Errorf("property %q cannot be used in $filter", t.value)
+	}
+	return path, nil
+}
+
+func (p *odataFilterParser) parseLiteral() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	var value interface{}
+	switch t.kind {
+	case "string":
+		value = t.value
+	case "number":
+		n, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid number %q in $filter", t.value)
+		}
+		value = n
+	case "ident":
+		switch strings.ToLower(t.value) {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			return "null", nil
+		default:
+			return "", fmt.Errorf("expected a literal but found %q in $filter", t.value)
+		}
+	default:
+		return "", fmt.Errorf("expected a literal but found %q in $filter", t.value)
+	}
+	name := fmt.Sprintf("@p%d", len(p.params))
+	p.params = append(p.params, cosmosapi.QueryParam{Name: name, Value: value})
+	return name, nil
+}
+
+func tokenizeODataFilter(s string) ([]odataToken, error) {
+	var tokens []odataToken
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, odataToken{kind: "punct", value: string(r)})
+			i++
+		case r == '\'':
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\'' {
+					// '' is an escaped quote inside a string literal
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						sb.WriteRune('\'')
+						i += 2
+						continue
+					}
+					closed = true
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string literal in $filter")
+			}
+			tokens = append(tokens, odataToken{kind: "string", value: sb.String()})
+		case unicode.IsDigit(r) || r == '-':
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, odataToken{kind: "number", value: string(runes[start:i])})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '/') {
+				i++
+			}
+			tokens = append(tokens, odataToken{kind: "ident", value: string(runes[start:i])})
+		default:
+			return nil, fmt.Errorf("unexpected character %q in $filter", r)
+		}
+	}
+	return tokens, nil
+}
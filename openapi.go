@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// openAPIRoute - a route of the OpenAPI document. Query, Body and Response
+// are zero values of the Go types, their schemas are generated from the json,
+// url and validate tags. Alternative is a second response type, e.g. the
+// paged list. BodyType is the media type of Body, application/json if empty.
+type openAPIRoute struct {
+	Method      string
+	Path        string
+	Summary     string
+	Query       interface{}
+	Body        interface{}
+	BodyType    string
+	Status      int
+	Response    interface{}
+	Alternative interface{}
+	Errors      []int
+}
+
+var openAPIRoutes = []openAPIRoute{
+	{Method: "get", Path: "/reports", Summary: "List reports, streamed or paged with limit and continuationToken", Query: ListQuery{}, Status: 200, Response: []VisitReportListDoc{}, Alternative: VisitReportPageDoc{}, Errors: []int{400, 403}},
+	{Method: "post", Path: "/reports", Summary: "Create a report", Body: VisitReportCreateDoc{}, Status: 201, Response: VisitReportWriteDoc{}, Errors: []int{400, 403, 422}},
+	{Method: "get", Path: "/reports/search", Summary: "Full-text search with highlighting and facets", Query: SearchQuery{}, Status: 200, Response: SearchResultDoc{}, Errors: []int{400}},
+	{Method: "get", Path: "/reports/suggest", Summary: "Subjects and contacts starting with a prefix, for autocomplete", Query: SuggestQuery{}, Status: 200, Response: []SuggestionDoc{}, Errors: []int{400}},
+	{Method: "get", Path: "/reports/sample", Summary: "Reproducible sample of reports for quality reviews", Query: SampleQuery{}, Status: 200, Response: SampleDoc{}, Errors: []int{400, 403}},
+	{Method: "get", Path: "/reports/drafts", Summary: "List drafts", Status: 200, Response: []VisitReportDraftReadDoc{}},
+	{Method: "get", Path: "/reports/{reportid}", Summary: "Read a report, or with includeDrafts its draft", Query: ReadQuery{}, Status: 200, Response: VisitReportReadDoc{}, Alternative: VisitReportDraftReadDoc{}, Errors: []int{403, 404}},
+	{Method: "put", Path: "/reports/{reportid}", Summary: "Update a report", Body: VisitReportUpdateDoc{}, Status: 200, Response: VisitReportWriteDoc{}, Errors: []int{400, 403, 404, 412, 423, 428}},
+	{Method: "patch", Path: "/reports/{reportid}", Summary: "Update some fields of a report with a JSON merge patch", Body: VisitReportUpdateDoc{}, BodyType: "application/merge-patch+json", Status: 200, Response: VisitReportWriteDoc{}, Errors: []int{400, 403, 404, 412, 423, 428}},
+	{Method: "delete", Path: "/reports/{reportid}", Summary: "Delete a report, admins can restore it", Status: 204, Errors: []int{403, 412, 423, 428}},
+	{Method: "get", Path: "/reports/{reportid}/lineage", Summary: "Provenance of the analyzed fields", Status: 200, Response: ReportLineageDoc{}, Errors: []int{404}},
+	{Method: "put", Path: "/reports/{reportid}/hold", Summary: "Place a legal hold", Body: LegalHoldCreateDoc{}, Status: 200, Response: VisitReportReadDoc{}, Errors: []int{400, 403, 404}},
+	{Method: "delete", Path: "/reports/{reportid}/hold", Summary: "Lift a legal hold", Status: 200, Response: VisitReportReadDoc{}, Errors: []int{403, 404}},
+	{Method: "get", Path: "/reports/{reportid}/draft", Summary: "Read a draft", Status: 200, Response: VisitReportDraftReadDoc{}, Errors: []int{404}},
+	{Method: "put", Path: "/reports/{reportid}/draft", Summary: "Auto-save a draft", Body: VisitReportDraftDoc{}, Status: 200, Response: VisitReportDraftReadDoc{}, Errors: []int{400, 403}},
+	{Method: "delete", Path: "/reports/{reportid}/draft", Summary: "Discard a draft", Status: 204, Errors: []int{403, 404}},
+	{Method: "post", Path: "/reports/{reportid}/draft/submit", Summary: "Submit a draft as a report", Status: 201, Response: VisitReportWriteDoc{}, Errors: []int{400, 403, 404, 422}},
+	{Method: "get", Path: "/reports/{reportid}/sharelinks", Summary: "List the share links of a report", Status: 200, Response: []ShareLinkReadDoc{}, Errors: []int{404}},
+	{Method: "post", Path: "/reports/{reportid}/sharelinks", Summary: "Create an expiring share link", Body: ShareLinkCreateDoc{}, Status: 201, Response: ShareLinkReadDoc{}, Errors: []int{400, 403, 404}},
+	{Method: "delete", Path: "/reports/{reportid}/sharelinks/{linkid}", Summary: "Revoke a share link", Status: 204, Errors: []int{403, 404}},
+	{Method: "post", Path: "/reports/{reportid}/attachments/uploads", Summary: "Get a URL to upload an attachment to", Body: AttachmentUploadCreateDoc{}, Status: 201, Response: AttachmentUploadDoc{}, Errors: []int{400, 403, 404, 422}},
+	{Method: "get", Path: "/reports/{reportid}/attachments/uploads/{attachmentid}", Summary: "Resume an upload in blocks", Status: 200, Response: AttachmentUploadDoc{}, Errors: []int{403, 404, 409, 423}},
+	{Method: "delete", Path: "/reports/{reportid}/attachments/uploads/{attachmentid}", Summary: "Abort an upload", Status: 204, Errors: []int{403, 404, 409}},
+	{Method: "post", Path: "/reports/{reportid}/attachments/{attachmentid}/complete", Summary: "Attach an uploaded file", Body: AttachmentCompleteDoc{}, Status: 201, Response: AttachmentDoc{}, Errors: []int{400, 403, 404, 422}},
+	{Method: "post", Path: "/reports/{reportid}/attachments/{attachmentid}/downloads", Summary: "Get a short-lived URL to download an attachment or its thumbnail", Query: AttachmentDownloadQuery{}, Status: 201, Response: AttachmentDownloadLinkDoc{}, Errors: []int{400, 404, 409}},
+	{Method: "get", Path: "/reports/{reportid}/attachments/{attachmentid}/downloads", Summary: "Who downloaded an attachment when", Status: 200, Response: []DownloadAuditDoc{}, Errors: []int{403, 404}},
+	{Method: "post", Path: "/reports/{reportid}/restore", Summary: "Restore a deleted report", Status: 200, Response: VisitReportReadDoc{}, Errors: []int{403, 404, 412}},
+	{Method: "post", Path: "/reports/{reportid}/ack", Summary: "Acknowledge that a manager has read a report", Status: 200, Response: VisitReportReadDoc{}, Errors: []int{403, 404}},
+	{Method: "get", Path: "/me/preferences", Summary: "Read the preferences of the user", Status: 200, Response: PreferencesDoc{}, Errors: []int{403}},
+	{Method: "put", Path: "/me/preferences", Summary: "Save the preferences of the user", Body: PreferencesSaveDoc{}, Status: 200, Response: PreferencesDoc{}, Errors: []int{400, 403}},
+	{Method: "get", Path: "/stats", Summary: "Sentiment stats over all reports", Status: 200, Response: []StatsOverallDoc{}},
+	{Method: "get", Path: "/stats/{contactid}", Summary: "Sentiment stats of a contact", Status: 200, Response: []StatsByContactDoc{}, Errors: []int{400}},
+	{Method: "get", Path: "/stats/timeline", Summary: "Visits per visit date, the daily series with movingAverage or trend", Query: TimelineQuery{}, Status: 200, Response: []StatsTimelineDoc{}, Alternative: StatsTimelineSeriesDoc{}, Errors: []int{400}},
+	{Method: "get", Path: "/stats/outcomes", Summary: "Reports per outcome", Query: OutcomeStatsQuery{}, Status: 200, Response: []OutcomeStatsDoc{}, Errors: []int{400}},
+	{Method: "get", Path: "/stats/coverage", Summary: "Visit coverage of companies with a target frequency", Query: CoverageQuery{}, Status: 200, Response: []CompanyCoverageDoc{}, Errors: []int{400}},
+	{Method: "get", Path: "/stats/heatmap", Summary: "Visits per weekday and hour", Query: HeatmapQuery{}, Status: 200, Response: HeatmapDoc{}, Errors: []int{400}},
+	{Method: "get", Path: "/stats/cohorts", Summary: "Sentiment cohorts by first-visit month", Query: CohortQuery{}, Status: 200, Response: []CohortDoc{}, Errors: []int{400}},
+	{Method: "get", Path: "/stats/acknowledgments", Summary: "Acknowledged reports and acknowledgment latency", Query: AckStatsQuery{}, Status: 200, Response: AckStatsDoc{}, Errors: []int{400}},
+	{Method: "get", Path: "/stats/owners", Summary: "Visits, sentiment and overdue follow-ups per report owner", Query: OwnerStatsQuery{}, Status: 200, Response: []OwnerStatsDoc{}, Errors: []int{400, 403}},
+}
+
+var openAPIPathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPISchemas collects the component schemas while generating a document
+type openAPISchemas map[string]iris.Map
+
+func (s openAPISchemas) ref(t reflect.Type) iris.Map {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return iris.Map{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return iris.Map{"type": "string"}
+	case reflect.Bool:
+		return iris.Map{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return iris.Map{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return iris.Map{"type": "number"}
+	case reflect.Slice:
+		return iris.Map{"type": "array", "items": s.ref(t.Elem())}
+	case reflect.Array:
+		return iris.Map{"type": "array", "items": s.ref(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return iris.Map{"type": "object", "additionalProperties": s.ref(t.Elem())}
+	case reflect.Struct:
+		if _, ok := s[t.Name()]; !ok {
+			// reserve the name first, schemas may refer to themselves
+			s[t.Name()] = iris.Map{}
+			s[t.Name()] = s.object(t)
+		}
+		return iris.Map{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	// interface{} values, e.g. facet values, can be anything
+	return iris.Map{}
+}
+
+// object returns the schema of a struct, embedded structs are inlined like
+// encoding/json does.
+func (s openAPISchemas) object(t reflect.Type) iris.Map {
+	properties := iris.Map{}
+	var required []string
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := jsonName(f)
+			if f.Anonymous && name == "" {
+				add(f.Type)
+				continue
+			}
+			if name == "-" || f.PkgPath != "" {
+				continue
+			}
+			schema := s.ref(f.Type)
+			if f.Type.Kind() == reflect.Ptr {
+				schema = iris.Map{"allOf": []iris.Map{schema}, "nullable": true}
+			}
+			if applyValidation(schema, f.Tag.Get("validate")) {
+				required = append(required, name)
+			}
+			properties[name] = schema
+		}
+	}
+	add(t)
+	schema := iris.Map{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// jsonName returns the JSON name of a field, empty for embedded structs
+// without a name.
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" && !f.Anonymous {
+		name = f.Name
+	}
+	return name
+}
+
+// applyValidation adds the limits of validate tags to a schema and reports
+// whether the field is required. Rules after "dive" apply to the items.
+func applyValidation(schema iris.Map, tag string) bool {
+	required := false
+	target, dived := schema, false
+	isArray := schema["type"] == "array"
+	for _, rule := range strings.Split(tag, ",") {
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i > 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "subjectlen":
+			name, param = "max", strconv.Itoa(currentCfg.MaxSubjectLength)
+		case "descriptionlen":
+			name, param = "max", strconv.Itoa(currentCfg.MaxDescriptionLength)
+		case "resultlen":
+			name, param = "max", strconv.Itoa(currentCfg.MaxResultLength)
+		}
+		switch name {
+		case "dive":
+			items, ok := schema["items"].(iris.Map)
+			if !ok || items["$ref"] != nil {
+				return required
+			}
+			target, dived, isArray = items, true, false
+		case "required":
+			required = required || !dived
+		case "max", "min":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			key := map[string]string{"max": "maximum", "min": "minimum"}[name]
+			if isArray {
+				key = name + "Items"
+			} else if target["type"] == "string" {
+				key = name + "Length"
+			}
+			target[key] = n
+		case "oneof":
+			values := []interface{}{}
+			for _, value := range strings.Fields(param) {
+				if n, err := strconv.Atoi(value); err == nil && target["type"] == "integer" {
+					values = append(values, n)
+				} else {
+					values = append(values, value)
+				}
+			}
+			target["enum"] = values
+		case "uuid":
+			target["format"] = "uuid"
+		case "email":
+			target["format"] = "email"
+		case "datetime":
+			target["format"] = "date"
+			if param == "2006-01" {
+				target["pattern"] = `^\d{4}-\d{2}$`
+				delete(target, "format")
+			}
+		case "crmid":
+			target["pattern"] = currentCfg.CrmIDPattern
+		case "outcome":
+			target["enum"] = currentCfg.Outcomes
+		}
+	}
+	return required
+}
+
+// buildOpenAPI generates the OpenAPI 3 document of the report and stats
+// routes. Length limits and outcome codes are those of the deployment.
+func buildOpenAPI() iris.Map {
+	schemas := openAPISchemas{}
+	paths := iris.Map{}
+	for _, route := range openAPIRoutes {
+		op := iris.Map{"summary": route.Summary}
+		var params []iris.Map
+		for _, m := range openAPIPathParam.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, iris.Map{"name": m[1], "in": "path", "required": true, "schema": iris.Map{"type": "string"}})
+		}
+		if route.Query != nil {
+			t := reflect.TypeOf(route.Query)
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				name := f.Tag.Get("url")
+				if name == "" {
+					continue
+				}
+				schema := schemas.ref(f.Type)
+				applyValidation(schema, f.Tag.Get("validate"))
+				params = append(params, iris.Map{"name": name, "in": "query", "schema": schema})
+			}
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if route.Body != nil {
+			bodyType := route.BodyType
+			if bodyType == "" {
+				bodyType = "application/json"
+			}
+			op["requestBody"] = iris.Map{
+				"required": true,
+				"content":  iris.Map{bodyType: iris.Map{"schema": schemas.ref(reflect.TypeOf(route.Body))}},
+			}
+		}
+		responses := iris.Map{}
+		ok := iris.Map{"description": http.StatusText(route.Status)}
+		if route.Response != nil {
+			schema := schemas.ref(reflect.TypeOf(route.Response))
+			if route.Alternative != nil {
+				schema = iris.Map{"oneOf": []iris.Map{schema, schemas.ref(reflect.TypeOf(route.Alternative))}}
+			}
+			ok["content"] = iris.Map{"application/json": iris.Map{"schema": schema}}
+		}
+		responses[strconv.Itoa(route.Status)] = ok
+		errs := route.Errors
+		if route.Body != nil {
+			errs = append(append([]int{}, errs...), http.StatusUnsupportedMediaType)
+		}
+		for _, status := range errs {
+			responses[strconv.Itoa(status)] = iris.Map{
+				"description": http.StatusText(status),
+				"content":     iris.Map{"application/problem+json": iris.Map{"schema": iris.Map{"$ref": "#/components/schemas/Problem"}}},
+			}
+		}
+		op["responses"] = responses
+
+		item, _ := paths[route.Path].(iris.Map)
+		if item == nil {
+			item = iris.Map{}
+			paths[route.Path] = item
+		}
+		item[route.Method] = op
+	}
+
+	components := iris.Map{}
+	for name, schema := range schemas {
+		components[name] = schema
+	}
+	components["Problem"] = iris.Map{
+		"type": "object",
+		"properties": iris.Map{
+			"type":   iris.Map{"type": "string"},
+			"title":  iris.Map{"type": "string"},
+			"status": iris.Map{"type": "integer"},
+			"detail": iris.Map{"type": "string"},
+		},
+	}
+	return iris.Map{
+		"openapi":    "3.0.3",
+		"info":       iris.Map{"title": "Visit Reports API", "version": "1"},
+		"paths":      paths,
+		"components": iris.Map{"schemas": components},
+	}
+}
+
+func readOpenAPI(ctx iris.Context) {
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(buildOpenAPI())
+}
+
+// swaggerUI loads Swagger UI from a CDN, the service only serves the document.
+const swaggerUI = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Visit Reports API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: %q, dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>`
+
+func readSwaggerUI(ctx iris.Context) {
+	ctx.ContentType("text/html")
+	ctx.StatusCode(http.StatusOK)
+	ctx.WriteString(fmt.Sprintf(swaggerUI, ctx.AbsoluteURI("/openapi.json")))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// eventSendTimeout bounds an event send within the deadline of the request
+const eventSendTimeout = 10 * time.Second
+
+// OutboxDoc - an event that could not be sent to the topic, e.g. because the
+// client went away after the report was stored. Events are stored in the
+// "outbox" container, partitioned by type, with the message id as id, and
+// sent again by the outbox worker. The topic detects duplicates by message
+// id, an event that was sent before the failure is not emitted twice. Data
+// is the raw event, the claim check and the CloudEvent are applied when it
+// is sent, so a claim check can't expire in the outbox.
+type OutboxDoc struct {
+	Id             string                 `json:"id"`
+	Type           string                 `json:"type"`
+	ContentType    string                 `json:"contentType"`
+	Data           json.RawMessage        `json:"data"`
+	UserProperties map[string]interface{} `json:"userProperties,omitempty"`
+	Attempts       int                    `json:"attempts"`
+	LastError      string                 `json:"lastError,omitempty"`
+	CreatedAt      time.Time              `json:"createdAt"`
+	Etag           string                 `json:"_etag,omitempty"`
+}
+
+// sendRequestEvent sends the event of a request with a deadline derived from
+// the request. If the send fails or the client cancels the request after the
+// report was stored, the event is added to the outbox instead of being
+// dropped.
+func sendRequestEvent(ctx context.Context, msg *servicebus.Message) error {
+	sendctx, cancel := context.WithTimeout(ctx, eventSendTimeout)
+	defer cancel()
+	err := sendToTopic(sendctx, msg)
+	if err == nil {
+		return nil
+	}
+	fmt.Printf("Sending event %s failed, adding it to the outbox: %v\n", msg.ID, err)
+	return addToOutbox(msg, err)
+}
+
+// addToOutbox stores a message, detached from the request as that may be
+// cancelled already.
+func addToOutbox(msg *servicebus.Message, sendErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), eventSendTimeout)
+	defer cancel()
+	doc := OutboxDoc{
+		Id:             msg.ID,
+		Type:           "outbox",
+		ContentType:    msg.ContentType,
+		Data:           msg.Data,
+		UserProperties: msg.UserProperties,
+		LastError:      sendErr.Error(),
+		CreatedAt:      time.Now().UTC(),
+	}
+	ops := cosmosapi.CreateDocumentOptions{
+		PartitionKeyValue: "outbox",
+		IsUpsert:          true,
+	}
+	_, _, err := currentClient.CreateDocument(ctx, currentCfg.DbName, "outbox", doc, ops)
+	return err
+}
+
+func startOutboxWorker() {
+	go func() {
+		for {
+			n, err := flushOutbox(context.Background())
+			if err != nil {
+				err = errors.WithStack(err)
+				fmt.Println(err)
+			} else if n > 0 {
+				fmt.Printf("Sent %d events from the outbox\n", n)
+			}
+			time.Sleep(currentCfg.OutboxInterval)
+		}
+	}()
+}
+
+// flushOutbox sends the events in the outbox, oldest first, and returns how
+// many were sent. Failed sends stay in the outbox for the next run.
+func flushOutbox(ctx context.Context) (int, error) {
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "outbox"
+	qry := cosmosapi.Query{
+		Query: "SELECT * FROM c WHERE c.type = 'outbox' ORDER BY c.createdAt",
+	}
+	var docs []OutboxDoc
+	if _, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, "outbox", qry, &docs, qops); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, doc := range docs {
+		sendctx, cancel := context.WithTimeout(ctx, eventSendTimeout)
+		err := sendToTopic(sendctx, &servicebus.Message{
+			ID:             doc.Id,
+			ContentType:    doc.ContentType,
+			Data:           doc.Data,
+			UserProperties: doc.UserProperties,
+		})
+		cancel()
+		if err != nil {
+			doc.Attempts++
+			doc.LastError = err.Error()
+			ops := cosmosapi.ReplaceDocumentOptions{
+				PartitionKeyValue: "outbox",
+				IfMatch:           doc.Etag,
+			}
+			_, _, err := currentClient.ReplaceDocument(ctx, currentCfg.DbName, "outbox", doc.Id, doc, ops)
+			if err != nil && err != cosmosapi.ErrPreconditionFailed && err != cosmosapi.ErrNotFound {
+				return sent, err
+			}
+			continue
+		}
+		sent++
+		_, err = currentClient.DeleteDocument(ctx, currentCfg.DbName, "outbox", doc.Id, cosmosapi.DeleteDocumentOptions{
+			PartitionKeyValue: "outbox",
+		})
+		if err != nil && err != cosmosapi.ErrNotFound {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+)
+
+func TestWrapMessageKeepsRawEvent(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) { cfg.LegacyEventFormat = false })
+	raw := []byte(`{"eventType":"VisitReportCreatedEvent","version":"1","id":"r1"}`)
+	msg := &servicebus.Message{ID: "m1", ContentType: "application/json", Data: raw}
+
+	out, err := wrapMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.ContentType != cloudEventsContentType {
+		t.Errorf("got content type %s, want a CloudEvent", out.ContentType)
+	}
+	if out.UserProperties[eventTypeProperty] != "VisitReportCreatedEvent" {
+		t.Errorf("got properties %v, want the event type", out.UserProperties)
+	}
+	var event CloudEventDoc
+	if err := json.Unmarshal(out.Data, &event); err != nil || string(event.Data) != string(raw) {
+		t.Errorf("got %s, want the raw event as data of the CloudEvent", out.Data)
+	}
+
+	// the outbox stores msg, it must still be the raw event
+	if string(msg.Data) != string(raw) || msg.ContentType != "application/json" || msg.UserProperties != nil {
+		t.Errorf("wrapMessage changed the message: %s %s %v", msg.ContentType, msg.Data, msg.UserProperties)
+	}
+}
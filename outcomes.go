@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// OutcomeDoc - structured outcome of a visit, in addition to the free-text result
+type OutcomeDoc struct {
+	Code string `json:"code" validate:"required,outcome"`
+	Note string `json:"note" validate:"resultlen"`
+}
+
+// OutcomeStatsDoc - number of reports per outcome code
+type OutcomeStatsDoc struct {
+	Code  string `json:"code"`
+	Count int    `json:"count"`
+}
+
+// OutcomeStatsQuery - query parameters of GET /stats/outcomes
+type OutcomeStatsQuery struct {
+	ContactID string `url:"contactid" validate:"omitempty,uuid"`
+	From      string `url:"from" validate:"omitempty,datetime=2006-01-02"`
+	To        string `url:"to" validate:"omitempty,datetime=2006-01-02"`
+}
+
+// isKnownOutcome checks a code against the taxonomy in VR_OUTCOMES.
+func isKnownOutcome(code string) bool {
+	for _, c := range currentCfg.Outcomes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// readOutcomes returns the outcome taxonomy, e.g. for the SPA's dropdown.
+func readOutcomes(ctx iris.Context) {
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(currentCfg.Outcomes))
+}
+
+func readStatsOutcomes(ctx iris.Context) {
+	params := OutcomeStatsQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	where := "c.type = 'visitreport' AND IS_DEFINED(c.outcome.code)"
+	var qparams []cosmosapi.QueryParam
+	if params.ContactID != "" {
+		where += " AND c.contact.id = @contactid"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@contactid", Value: params.ContactID})
+	}
+	if params.From != "" {
+		where += " AND c.visitDate >= @from"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@from", Value: params.From})
+	}
+	if params.To != "" {
+		where += " AND c.visitDate < @to"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@to", Value: nextDay(params.To)})
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qry := cosmosapi.Query{
+		Query:  "SELECT c.outcome.code, COUNT(1) as count FROM c WHERE " + where + " GROUP BY c.outcome.code",
+		Params: qparams,
+	}
+	docs := []OutcomeStatsDoc{}
+	_, err := currentClient.QueryDocuments(ctx.Request().Context(), currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(docs))
+}
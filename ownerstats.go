@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// OwnerStatsDoc - visits of a report owner, the sales rep who created the
+// reports. AvgScore is over the reports with a result.
+type OwnerStatsDoc struct {
+	Owner            string  `json:"owner"`
+	Visits           int     `json:"visits"`
+	AvgScore         float64 `json:"avgScore"`
+	OverdueFollowUps int     `json:"overdueFollowUps"`
+}
+
+// OwnerStatsQuery - query parameters of GET /stats/owners
+type OwnerStatsQuery struct {
+	From string `url:"from" validate:"omitempty,datetime=2006-01-02"`
+	To   string `url:"to" validate:"omitempty,datetime=2006-01-02"`
+}
+
+// isOverdueFollowUp reports whether a visit needing a follow-up is older
+// than VR_FOLLOWUPDUE and the contact wasn't visited again since.
+func isOverdueFollowUp(model *VisitReportModel, lastVisit string, now time.Time) bool {
+	if model.Outcome == nil || model.Outcome.Code != currentCfg.FollowUpOutcome {
+		return false
+	}
+	due := now.Add(-currentCfg.FollowUpDue).Format("2006-01-02")
+	return model.VisitDate < due && lastVisit <= model.VisitDate
+}
+
+// readStatsOwners aggregates the visits of the period per report owner,
+// most visits first. Reports created before the owner was recorded are not
+// counted.
+func readStatsOwners(ctx iris.Context) {
+	params := OwnerStatsQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	type ownerTotals struct {
+		OwnerStatsDoc
+		scored   int
+		scoreSum float64
+	}
+	owners := map[string]*ownerTotals{}
+	// later visits of a contact end its follow-ups, so visits after the
+	// period are read as well
+	lastVisits := map[string]string{}
+	var inPeriod []*VisitReportModel
+	err := currentRepo.Query(ctx.Request().Context(), ReportFilter{From: params.From}, func(model *VisitReportModel) error {
+		if model.VisitDate > lastVisits[model.Contact.Id] {
+			lastVisits[model.Contact.Id] = model.VisitDate
+		}
+		if model.CreatedBy != "" && (params.To == "" || model.VisitDate < nextDay(params.To)) {
+			inPeriod = append(inPeriod, model)
+		}
+		return nil
+	})
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	now := time.Now().UTC()
+	for _, model := range inPeriod {
+		t, ok := owners[model.CreatedBy]
+		if !ok {
+			t = &ownerTotals{OwnerStatsDoc: OwnerStatsDoc{Owner: model.CreatedBy}}
+			owners[model.CreatedBy] = t
+		}
+		t.Visits++
+		if model.Result != "" {
+			t.scored++
+			t.scoreSum += model.VisitResultSentimentScore
+		}
+		if isOverdueFollowUp(model, lastVisits[model.Contact.Id], now) {
+			t.OverdueFollowUps++
+		}
+	}
+	out := make([]OwnerStatsDoc, 0, len(owners))
+	for _, t := range owners {
+		if t.scored > 0 {
+			t.AvgScore = t.scoreSum / float64(t.scored)
+		}
+		out = append(out, t.OwnerStatsDoc)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Visits != out[j].Visits {
+			return out[i].Visits > out[j].Visits
+		}
+		return out[i].Owner < out[j].Owner
+	})
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
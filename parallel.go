@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// QueryWarningDoc - a query that failed while the others succeeded
+type QueryWarningDoc struct {
+	Query   string `json:"query"`
+	Message string `json:"message"`
+}
+
+// parallelQuery - a named query for runParallel. If a required query fails,
+// the remaining queries are cancelled and runParallel fails. Failures of
+// optional queries are reported as warnings.
+type parallelQuery struct {
+	name     string
+	required bool
+	run      func(ctx context.Context) error
+}
+
+// runParallel runs the queries concurrently, each with its own timeout
+// (VR_STATSQUERYTIMEOUT). It returns an error if a required query or all
+// of the queries failed, otherwise the warnings of the failed ones. The
+// errors wrap the error of the query, stopWithError maps them like the
+// error of a single query.
+func runParallel(ctx context.Context, queries []parallelQuery) ([]QueryWarningDoc, error) {
+	g, gctx := errgroup.WithContext(ctx)
+	var mu sync.Mutex
+	warnings := []QueryWarningDoc{}
+	var firstErr error
+	for _, q := range queries {
+		q := q
+		g.Go(func() error {
+			qctx, cancel := context.WithTimeout(gctx, currentCfg.StatsQueryTimeout)
+			defer cancel()
+			start := time.Now()
+			err := q.run(qctx)
+			if err == nil {
+				return nil
+			}
+			if qctx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("timed out after %s: %w", time.Since(start).Round(time.Millisecond), context.DeadlineExceeded)
+			}
+			if q.required {
+				return fmt.Errorf("query %s: %w", q.name, err)
+			}
+			mu.Lock()
+			warnings = append(warnings, QueryWarningDoc{Query: q.name, Message: err.Error()})
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if len(queries) > 0 && len(warnings) == len(queries) {
+		return nil, fmt.Errorf("all %d queries failed, first: %s: %w", len(queries), warnings[0].Query, firstErr)
+	}
+	return warnings, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+func TestRunParallelKeepsErrors(t *testing.T) {
+	useMemoryRepository(t, func(cfg *config) { cfg.StatsQueryTimeout = 50 * time.Millisecond })
+	failing := func(err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error { return err }
+	}
+	slow := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	tests := []struct {
+		name    string
+		queries []parallelQuery
+		want    error
+	}{
+		{"required", []parallelQuery{
+			{name: "a", required: true, run: failing(ErrReportNotFound)},
+			{name: "b", run: failing(nil)},
+		}, ErrReportNotFound},
+		{"all failed", []parallelQuery{
+			{name: "a", run: failing(cosmosapi.ErrMaxRetriesExceeded)},
+		}, cosmosapi.ErrMaxRetriesExceeded},
+		{"timed out", []parallelQuery{
+			{name: "a", required: true, run: slow},
+		}, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runParallel(context.Background(), tt.queries)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want it to wrap %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"golang.org/x/sync/errgroup"
+)
+
+// PartitionChargeDoc - request units spent per partition by a fan-out query
+type PartitionChargeDoc struct {
+	PartitionKey  string  `json:"partitionKey"`
+	Pages         int     `json:"pages"`
+	Documents     int     `json:"documents"`
+	RequestCharge float64 `json:"requestCharge"`
+}
+
+// queryAllPartitions runs a query over every partition in VR_PARTITIONKEYS,
+// at most VR_MAXQUERYPARALLELISM at a time. With VR_CROSSPARTITION the query
+// is sent once as a cross-partition query instead. Pages are handed to
+// onPage one at a time, in no particular order. At most VR_MAXBUFFEREDITEMS
+// documents are buffered while onPage is busy. newPage must return a
+// pointer to an empty slice.
+func queryAllPartitions(ctx context.Context, collection string, qry cosmosapi.Query, pageSize int, newPage func() interface{}, onPage func(page interface{}) error) ([]PartitionChargeDoc, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keys := currentCfg.PartitionKeys
+	if currentCfg.CrossPartition {
+		keys = []string{""}
+	}
+	charges := make([]PartitionChargeDoc, len(keys))
+	buffered := currentCfg.MaxBufferedItems / pageSize
+	if buffered < 1 {
+		buffered = 1
+	}
+	parallelism := currentCfg.MaxQueryParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	pages := make(chan interface{}, buffered)
+	sem := make(chan struct{}, parallelism)
+
+	g, gctx := errgroup.WithContext(ctx)
+	for i, key := range keys {
+		i, key := i, key
+		charges[i].PartitionKey = key
+		qops := cosmosapi.DefaultQueryDocumentOptions()
+		qops.MaxItemCount = pageSize
+		if key == "" {
+			charges[i].PartitionKey = "*"
+			qops.EnableCrossPartition = true
+		} else {
+			qops.PartitionKeyValue = key
+		}
+		g.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+			defer func() { <-sem }()
+			for {
+				page := newPage()
+				res, err := currentClient.QueryDocuments(gctx, currentCfg.DbName, collection, qry, page, qops)
+				if err != nil {
+					return fmt.Errorf("partition %s: %v", charges[i].PartitionKey, err)
+				}
+				charges[i].Pages++
+				charges[i].Documents += res.Count
+				charges[i].RequestCharge += res.RequestCharge
+				select {
+				case pages <- page:
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+				if res.Continuation == "" {
+					return nil
+				}
+				qops.Continuation = res.Continuation
+			}
+		})
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Wait()
+		close(pages)
+	}()
+	var pageErr error
+	for page := range pages {
+		if pageErr != nil {
+			continue
+		}
+		if err := onPage(page); err != nil {
+			pageErr = err
+			cancel()
+		}
+	}
+	if err := <-done; pageErr == nil && err != nil {
+		return charges, err
+	}
+	return charges, pageErr
+}
+
+func logPartitionCharges(charges []PartitionChargeDoc) {
+	var total float64
+	for _, c := range charges {
+		fmt.Printf("Partition %s: %d documents in %d pages, Request Units: %f\n", c.PartitionKey, c.Documents, c.Pages, c.RequestCharge)
+		total += c.RequestCharge
+	}
+	fmt.Printf("Request Units: %f\n", total)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	policyMaxReportsPerDay = "max-reports-per-contact-day"
+	policyMinVisitInterval = "min-visit-interval"
+)
+
+// visitDateLayouts are the accepted formats of visitDate
+var visitDateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+func parseVisitDate(date string) (time.Time, string, bool) {
+	for _, layout := range visitDateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, layout, true
+		}
+	}
+	return time.Time{}, "", false
+}
+
+// stopIfPolicyViolated evaluates the data hygiene policies of the deployment
+// for a new report and responds with 422 and the policy code on violation.
+func stopIfPolicyViolated(ctx iris.Context, model *VisitReportModel) bool {
+	if currentCfg.MaxReportsPerDay <= 0 && currentCfg.MinVisitInterval <= 0 {
+		return false
+	}
+	visitDate, layout, ok := parseVisitDate(model.VisitDate)
+	if !ok {
+		return false
+	}
+
+	if max := currentCfg.MaxReportsPerDay; max > 0 {
+		day := visitDate.Format("2006-01-02")
+		n, err := countContactReports(ctx.Request().Context(), model.Contact.Id, "STARTSWITH(c.visitDate, @day)",
+			func(m *VisitReportModel) bool { return strings.HasPrefix(m.VisitDate, day) },
+			cosmosapi.QueryParam{Name: "@day", Value: day})
+		if err != nil {
+			return stopWithPolicyError(ctx, err)
+		}
+		if n >= max {
+			return stopWithPolicy(ctx, policyMaxReportsPerDay,
+				fmt.Sprintf("Contact %s already has %d reports on %s, at most %d are allowed", model.Contact.Id, n, day, max))
+		}
+	}
+
+	if interval := currentCfg.MinVisitInterval; interval > 0 {
+		from, to := visitDate.Add(-interval).Format(layout), visitDate.Add(interval).Format(layout)
+		n, err := countContactReports(ctx.Request().Context(), model.Contact.Id, "c.visitDate > @from AND c.visitDate < @to",
+			func(m *VisitReportModel) bool { return m.VisitDate > from && m.VisitDate < to },
+			cosmosapi.QueryParam{Name: "@from", Value: from},
+			cosmosapi.QueryParam{Name: "@to", Value: to})
+		if err != nil {
+			return stopWithPolicyError(ctx, err)
+		}
+		if n > 0 {
+			return stopWithPolicy(ctx, policyMinVisitInterval,
+				fmt.Sprintf("Visits of contact %s must be at least %s apart", model.Contact.Id, interval))
+		}
+	}
+	return false
+}
+
+// countContactReports counts the reports of a contact matching the
+// condition, match is the same condition for the memory repository.
+func countContactReports(ctx context.Context, contactid, condition string, match func(model *VisitReportModel) bool, params ...cosmosapi.QueryParam) (int, error) {
+	if !aggregatesAvailable() {
+		return countReports(ctx, ReportFilter{ContactID: contactid}, match)
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qry := cosmosapi.Query{
+		Query:  "SELECT VALUE COUNT(1) FROM c WHERE c.type = 'visitreport' AND c.contact.id = @contactid AND " + notDeleted + " AND " + condition,
+		Params: append([]cosmosapi.QueryParam{{Name: "@contactid", Value: contactid}}, params...),
+	}
+	var counts []int
+	_, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, qry, &counts, qops)
+	if err != nil || len(counts) == 0 {
+		return 0, err
+	}
+	return counts[0], nil
+}
+
+func stopWithPolicy(ctx iris.Context, policy, detail string) bool {
+	ctx.StopWithProblem(iris.StatusUnprocessableEntity, iris.NewProblem().
+		Title("Policy violation").
+		Detail(detail).
+		Key("policy", policy))
+	return true
+}
+
+func stopWithPolicyError(ctx iris.Context, err error) bool {
+	err = errors.WithStack(err)
+	fmt.Println(err)
+	ctx.StopWithStatus(iris.StatusInternalServerError)
+	return true
+}
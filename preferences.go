@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// ctxKeySubject holds the subject of the bearer token, preferences are
+// stored per subject
+const ctxKeySubject = "subject"
+
+// AlertThresholdsDoc - when a user wants to be alerted, MinScore is the
+// sentiment score below which a visit is worth an alert
+type AlertThresholdsDoc struct {
+	MinScore    *float64 `json:"minScore,omitempty" validate:"omitempty,min=0,max=1"`
+	OverdueDays int      `json:"overdueDays,omitempty" validate:"min=0"`
+}
+
+// PreferencesSaveDoc - struct for saving the preferences of a user.
+// DefaultFilters are query parameters of GET /reports the frontend applies
+// when the user opens the list.
+type PreferencesSaveDoc struct {
+	EmailDigest     bool               `json:"emailDigest"`
+	DigestFrequency string             `json:"digestFrequency,omitempty" validate:"omitempty,oneof=daily weekly"`
+	AlertThresholds AlertThresholdsDoc `json:"alertThresholds"`
+	DefaultFilters  map[string]string  `json:"defaultFilters,omitempty" validate:"max=20,dive,keys,max=64,endkeys,max=256"`
+	Locale          string             `json:"locale,omitempty" validate:"omitempty,max=35"`
+}
+
+// PreferencesDoc - preferences of a user. Preferences are stored in the
+// "config" container, partitioned by type, with an id derived from the
+// subject of the token.
+type PreferencesDoc struct {
+	Id      string `json:"id"`
+	Type    string `json:"type"`
+	Subject string `json:"subject"`
+	PreferencesSaveDoc
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+func preferencesId(subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return hex.EncodeToString(sum[:16])
+}
+
+// subjectOf returns the subject of the caller's token, it responds with 403
+// for callers without one, e.g. services signing their requests.
+func subjectOf(ctx iris.Context) (string, bool) {
+	subject := ctx.Values().GetString(ctxKeySubject)
+	if subject == "" {
+		ctx.StopWithProblem(iris.StatusForbidden, iris.NewProblem().
+			Title("Forbidden").
+			Detail("Preferences are kept per user and need a user token"))
+		return "", false
+	}
+	return subject, true
+}
+
+// readPreferences returns the preferences of the user, the defaults if
+// none were saved.
+func readPreferences(ctx iris.Context) {
+	subject, ok := subjectOf(ctx)
+	if !ok {
+		return
+	}
+	prefs := PreferencesDoc{}
+	_, err := currentClient.GetDocument(ctx.Request().Context(), currentCfg.DbName, "config", preferencesId(subject), cosmosapi.GetDocumentOptions{
+		PartitionKeyValue: "preference",
+	}, &prefs)
+	if err == cosmosapi.ErrNotFound {
+		prefs = PreferencesDoc{Id: preferencesId(subject), Type: "preference", Subject: subject}
+		err = nil
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(prefs))
+}
+
+// savePreferences replaces the preferences of the user.
+func savePreferences(ctx iris.Context) {
+	subject, ok := subjectOf(ctx)
+	if !ok {
+		return
+	}
+	in := PreferencesSaveDoc{}
+	if err := ctx.ReadJSON(&in); err != nil {
+		handleBindError(ctx, err)
+		return
+	}
+	now := time.Now().UTC()
+	prefs := PreferencesDoc{
+		Id:                 preferencesId(subject),
+		Type:               "preference",
+		Subject:            subject,
+		PreferencesSaveDoc: in,
+		UpdatedAt:          &now,
+	}
+	ops := cosmosapi.CreateDocumentOptions{
+		PartitionKeyValue: "preference",
+		IsUpsert:          true,
+	}
+	if _, _, err := currentClient.CreateDocument(ctx.Request().Context(), currentCfg.DbName, "config", prefs, ops); err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(prefs))
+}
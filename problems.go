@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// throttledRetryAfter is the Retry-After in seconds sent when Cosmos DB is
+// unavailable without asking for a delay
+const throttledRetryAfter = 1
+
+// throttledError - Cosmos DB answered 429 Too Many Requests, RetryAfter is
+// the delay of its x-ms-retry-after-ms header
+type throttledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("throttled by Cosmos DB, retry after %s", e.RetryAfter)
+}
+
+// retryAfterSeconds rounds the delay up to whole seconds for Retry-After.
+func (e *throttledError) retryAfterSeconds() int {
+	seconds := int((e.RetryAfter + time.Second - 1) / time.Second)
+	if seconds < throttledRetryAfter {
+		return throttledRetryAfter
+	}
+	return seconds
+}
+
+// throttleDetector turns a 429 of Cosmos DB into a throttledError. cosmosapi
+// retries 429 and 503 itself and only reports ErrMaxRetriesExceeded, without
+// the delay Cosmos DB asks for.
+type throttleDetector struct {
+	base http.RoundTripper
+}
+
+// RoundTrip - implements http.RoundTripper
+func (t *throttleDetector) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		return resp, err
+	}
+	resp.Body.Close()
+	ms, _ := strconv.Atoi(resp.Header.Get("x-ms-retry-after-ms"))
+	return nil, &throttledError{RetryAfter: time.Duration(ms) * time.Millisecond}
+}
+
+// stopWithError responds to a failed repository, Cosmos DB or search call
+// with a problem: 404 for missing documents, 412 for reports changed by
+// someone else, 503 with Retry-After when the
+// provisioned throughput is exhausted, 504 when the operation timed out and
+// 500 for everything else, which is logged.
+func stopWithError(ctx iris.Context, err error) {
+	var throttled *throttledError
+	switch {
+	case errors.Is(err, ErrReportNotFound) || errors.Is(err, cosmosapi.ErrNotFound):
+		ctx.StopWithProblem(iris.StatusNotFound, iris.NewProblem().
+			Title("Not found").
+			Detail("The resource does not exist"))
+	case errors.Is(err, ErrReportChanged):
+		stopPreconditionFailed(ctx)
+	case errors.As(err, &throttled):
+		ctx.Header("Retry-After", strconv.Itoa(throttled.retryAfterSeconds()))
+		ctx.StopWithProblem(iris.StatusServiceUnavailable, iris.NewProblem().
+			Title("Service unavailable").
+			Detail("The database is busy, retry later"))
+	case errors.Is(err, cosmosapi.ErrMaxRetriesExceeded) || errors.Is(err, cosmosapi.ErrTooManyRequests):
+		// 503 of Cosmos DB, or a 429 of a client without throttleDetector
+		ctx.Header("Retry-After", strconv.Itoa(throttledRetryAfter))
+		ctx.StopWithProblem(iris.StatusServiceUnavailable, iris.NewProblem().
+			Title("Service unavailable").
+			Detail("The database is busy, retry later"))
+	case ctx.Request().Context().Err() == context.Canceled:
+		// the client went away, nobody reads the response
+		ctx.StopExecution()
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, cosmosapi.ErrTimeout):
+		ctx.StopWithProblem(iris.StatusGatewayTimeout, iris.NewProblem().
+			Title("Timeout").
+			Detail("The database did not respond in time"))
+	default:
+		err = errors.WithStack(err)
+		fmt.Println(err)
+		ctx.StopWithProblem(iris.StatusInternalServerError, iris.NewProblem().
+			Title("Internal server error").
+			Detail("The request could not be completed"))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+func TestStopWithErrorThrottled(t *testing.T) {
+	cosmos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-retry-after-ms", "2500")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer cosmos.Close()
+	client := cosmosapi.New(cosmos.URL, cosmosapi.Config{MasterKey: "a2V5"},
+		&http.Client{Transport: &throttleDetector{base: http.DefaultTransport}}, nil)
+
+	app := iris.New()
+	app.Get("/", func(ctx iris.Context) {
+		_, err := client.GetDatabase(ctx.Request().Context(), "db", nil)
+		stopWithError(ctx, err)
+	})
+	rec := serve(t, app, http.MethodGet, "/", "", nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("got Retry-After %q, want 3", got)
+	}
+}
+
+func TestStopWithErrorMaxRetries(t *testing.T) {
+	cosmos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer cosmos.Close()
+	client := cosmosapi.New(cosmos.URL, cosmosapi.Config{MasterKey: "a2V5"},
+		&http.Client{Transport: &throttleDetector{base: http.DefaultTransport}}, nil)
+
+	app := iris.New()
+	app.Get("/", func(ctx iris.Context) {
+		_, err := client.GetDatabase(ctx.Request().Context(), "db", nil)
+		stopWithError(ctx, err)
+	})
+	rec := serve(t, app, http.MethodGet, "/", "", nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("got Retry-After %q, want 1", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/kataras/iris/v12"
+)
+
+// ListQuery - query parameters of GET /reports
+type ListQuery struct {
+	ContactID   string `url:"contactid" validate:"omitempty,uuid"`
+	ContactName string `url:"contactName" validate:"max=100"`
+	CrmID       string `url:"crmid" validate:"omitempty,crmid"`
+	Attendee    string `url:"attendee" validate:"max=254"`
+	Number      string `url:"number" validate:"max=50"`
+	// From and To are inclusive visit dates
+	From     string   `url:"from" validate:"omitempty,datetime=2006-01-02"`
+	To       string   `url:"to" validate:"omitempty,datetime=2006-01-02"`
+	MinScore *float64 `url:"minScore" validate:"omitempty,min=0,max=1"`
+	MaxScore *float64 `url:"maxScore" validate:"omitempty,min=0,max=1"`
+	Company  string   `url:"company" validate:"max=100"`
+	// Sort is a field to sort by, descending with a leading "-"
+	Sort string `url:"sort" validate:"omitempty,oneof=visitDate -visitDate subject -subject visitResultSentimentScore -visitResultSentimentScore"`
+	// Acknowledged only lists reports a manager has or has not read
+	Acknowledged string `url:"acknowledged" validate:"omitempty,oneof=true false"`
+	// Limit and ContinuationToken page the list instead of streaming it
+	Limit             int    `url:"limit" validate:"min=0,max=1000"`
+	ContinuationToken string `url:"continuationToken" validate:"max=8192"`
+	// IncludeDrafts appends the matching drafts, admins only
+	IncludeDrafts bool `url:"includeDrafts"`
+	// IncludeDeleted lists soft-deleted reports as well, admins only
+	IncludeDeleted bool `url:"includeDeleted"`
+}
+
+// ReadQuery - query parameters of GET /reports/{reportid}
+type ReadQuery struct {
+	// IncludeDrafts returns the draft if the report was not submitted, admins
+	// only
+	IncludeDrafts bool `url:"includeDrafts"`
+}
+
+// SearchQuery - query parameters of GET /reports/search
+type SearchQuery struct {
+	Q    string `url:"q" validate:"max=200"`
+	Top  int    `url:"top" validate:"min=1,max=1000"`
+	Skip int    `url:"skip" validate:"min=0"`
+}
+
+// TimelineQuery - query parameters of GET /stats/timeline
+type TimelineQuery struct {
+	From string `url:"from" validate:"omitempty,datetime=2006-01-02"`
+	To   string `url:"to" validate:"omitempty,datetime=2006-01-02"`
+	// MovingAverage and Trend return the daily series, see StatsTimelineSeriesDoc
+	MovingAverage []int `url:"movingAverage" validate:"max=2,dive,oneof=7 30"`
+	Trend         bool  `url:"trend"`
+}
+
+// ContactIDParam - path parameter of the per-contact stats
+type ContactIDParam struct {
+	ContactID string `validate:"required,uuid"`
+}
+
+// ReportIDParam - path parameter of client generated report ids
+type ReportIDParam struct {
+	ReportID string `validate:"required,uuid"`
+}
+
+// newValidator returns the validator of the API with the custom tags
+// registered. The length aliases take the limits of the deployment, as
+// some tenants need longer notes than the defaults.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterAlias("subjectlen", fmt.Sprintf("max=%d", currentCfg.MaxSubjectLength))
+	v.RegisterAlias("descriptionlen", fmt.Sprintf("max=%d", currentCfg.MaxDescriptionLength))
+	v.RegisterAlias("resultlen", fmt.Sprintf("max=%d", currentCfg.MaxResultLength))
+	v.RegisterValidation("outcome", func(fl validator.FieldLevel) bool {
+		return isKnownOutcome(fl.Field().String())
+	})
+	crmID := regexp.MustCompile(currentCfg.CrmIDPattern)
+	v.RegisterValidation("crmid", func(fl validator.FieldLevel) bool {
+		return crmID.MatchString(fl.Field().String())
+	})
+	return v
+}
+
+// bindQuery decodes and validates the query string into ptr. Defaults are
+// taken from the values already set in ptr. On failure a problem response
+// is sent and false is returned.
+func bindQuery(ctx iris.Context, ptr interface{}) bool {
+	err := ctx.ReadQuery(ptr)
+	if err == nil && ctx.Request().URL.RawQuery == "" {
+		// ReadQuery skips validation when there is no query string
+		err = ctx.Application().Validate(ptr)
+	}
+	return handleBindError(ctx, err)
+}
+
+// bindValidated validates an already populated struct, e.g. from path parameters.
+func bindValidated(ctx iris.Context, ptr interface{}) bool {
+	return handleBindError(ctx, ctx.Application().Validate(ptr))
+}
+
+func handleBindError(ctx iris.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	if errs, ok := err.(validator.ValidationErrors); ok {
+		ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+			Title("Validation error").
+			Detail("One or more parameters failed to be validated").
+			Key("errors", wrapValidationErrors(errs)))
+		return false
+	}
+	ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+		Title("Invalid parameters").
+		Detail(err.Error()))
+	return false
+}
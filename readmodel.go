@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	reportStatusOpen         = "open"
+	reportStatusAcknowledged = "acknowledged"
+)
+
+// ReportListItemDoc - the compact projection of a report the list grid
+// reads, stored in VR_REPORTLISTCONTAINER with the same partition key as
+// the report. It has the fields of VisitReportListDoc and the fields the
+// list is filtered and sorted by.
+type ReportListItemDoc struct {
+	Id                        string     `json:"id"`
+	Type                      string     `json:"type"`
+	Deleted                   bool       `json:"deleted,omitempty"`
+	Number                    string     `json:"number,omitempty"`
+	Subject                   string     `json:"subject"`
+	VisitDate                 string     `json:"visitDate"`
+	Contact                   ContactDoc `json:"contact"`
+	Status                    string     `json:"status"`
+	VisitResultSentimentScore float64    `json:"visitResultSentimentScore"`
+}
+
+// ChangeFeedLeaseDoc - how far the change feed of a partition key range of
+// the reports was projected. Leases are stored in the "config" container,
+// partitioned by type.
+type ChangeFeedLeaseDoc struct {
+	cosmosapi.Document
+	Type         string `json:"type"`
+	Range        string `json:"range"`
+	Continuation string `json:"continuation"`
+}
+
+// reportListReady is set once the projection caught up with the change feed,
+// until then lists are read from the reports
+var reportListReady int32
+
+func reportListEnabled() bool {
+	return currentCfg.ReportListContainer != "" && currentCfg.Repository != "memory"
+}
+
+func reportListItemOf(model *VisitReportModel) ReportListItemDoc {
+	status := reportStatusOpen
+	if model.Acknowledgment != nil {
+		status = reportStatusAcknowledged
+	}
+	return ReportListItemDoc{
+		Id:                        model.Id,
+		Type:                      model.Type,
+		Deleted:                   model.Deleted,
+		Number:                    model.Number,
+		Subject:                   model.Subject,
+		VisitDate:                 model.VisitDate,
+		Contact:                   model.Contact,
+		Status:                    status,
+		VisitResultSentimentScore: model.VisitResultSentimentScore,
+	}
+}
+
+// listModelQuery returns the conditions of a filter for the projection,
+// false if the projection doesn't have the fields to answer it. The
+// projection lags behind the reports by up to VR_REPORTLISTINTERVAL.
+func (f ReportFilter) listModelQuery() (string, []cosmosapi.QueryParam, bool) {
+	if !reportListEnabled() || atomic.LoadInt32(&reportListReady) == 0 ||
+		f.CrmID != "" || f.Attendee != "" || f.Text != "" {
+		return "", nil, false
+	}
+	acknowledged := f.Acknowledged
+	f.Acknowledged = nil
+	where, params := f.query()
+	if acknowledged != nil {
+		status := reportStatusOpen
+		if *acknowledged {
+			status = reportStatusAcknowledged
+		}
+		if where == "" {
+			where = " where "
+		} else {
+			where += " AND "
+		}
+		where += "c.status = @status"
+		params = append(params, cosmosapi.QueryParam{Name: "@status", Value: status})
+	}
+	return where, params, true
+}
+
+// startReportListProjector keeps the projection in VR_REPORTLISTCONTAINER
+// up to date with the change feed of the reports, every
+// VR_REPORTLISTINTERVAL. The first run projects all reports. Instances
+// share the leases, projecting a change twice is harmless.
+func startReportListProjector() {
+	go func() {
+		for {
+			n, err := projectReportList(context.Background())
+			if err != nil {
+				err = errors.WithStack(err)
+				fmt.Println(err)
+			} else {
+				if atomic.CompareAndSwapInt32(&reportListReady, 0, 1) {
+					fmt.Println("Report list projection caught up, reading lists from it")
+				}
+				if n > 0 {
+					fmt.Printf("Projected %d report changes to the list\n", n)
+				}
+			}
+			time.Sleep(currentCfg.ReportListInterval)
+		}
+	}()
+}
+
+// projectReportList projects the changes of every partition key range and
+// returns how many changes were projected.
+func projectReportList(ctx context.Context) (int, error) {
+	res, err := currentClient.GetPartitionKeyRanges(ctx, currentCfg.DbName, currentCfg.ReportContainer, nil)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, r := range res.PartitionKeyRanges {
+		n, err := projectReportListRange(ctx, r.Id)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("partition key range %s: %v", r.Id, err)
+		}
+	}
+	return total, nil
+}
+
+func projectReportListRange(ctx context.Context, rangeID string) (int, error) {
+	id := "reportlist-" + rangeID
+	lease := ChangeFeedLeaseDoc{}
+	_, err := currentClient.GetDocument(ctx, currentCfg.DbName, "config", id, cosmosapi.GetDocumentOptions{
+		PartitionKeyValue: "changefeed",
+	}, &lease)
+	if err == cosmosapi.ErrNotFound {
+		lease = ChangeFeedLeaseDoc{Type: "changefeed", Range: rangeID}
+		lease.Id = id
+	} else if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		var docs []VisitReportModel
+		res, err := currentClient.ListDocuments(ctx, currentCfg.DbName, currentCfg.ReportContainer, &cosmosapi.ListDocumentsOptions{
+			MaxItemCount:        streamPageSize,
+			AIM:                 "Incremental feed",
+			PartitionKeyRangeId: rangeID,
+			IfNoneMatch:         lease.Continuation,
+		}, &docs)
+		if err != nil {
+			return n, err
+		}
+		if res.Etag == "" {
+			// 304 Not Modified, no more changes
+			break
+		}
+		for i := range docs {
+			if docs[i].Type != "visitreport" {
+				continue
+			}
+			ops := cosmosapi.CreateDocumentOptions{
+				PartitionKeyValue: "visitreport",
+				IsUpsert:          true,
+			}
+			if _, _, err := currentClient.CreateDocument(ctx, currentCfg.DbName, currentCfg.ReportListContainer, reportListItemOf(&docs[i]), ops); err != nil {
+				return n, err
+			}
+			n++
+		}
+		lease.Continuation = res.Etag
+	}
+	if n == 0 && lease.Etag != "" {
+		return 0, nil
+	}
+
+	if lease.Etag == "" {
+		_, _, err = currentClient.CreateDocument(ctx, currentCfg.DbName, "config", lease, cosmosapi.CreateDocumentOptions{
+			PartitionKeyValue: "changefeed",
+		})
+	} else {
+		_, _, err = currentClient.ReplaceDocument(ctx, currentCfg.DbName, "config", id, lease, cosmosapi.ReplaceDocumentOptions{
+			PartitionKeyValue: "changefeed",
+			IfMatch:           lease.Etag,
+		})
+	}
+	if err == cosmosapi.ErrConflict || err == cosmosapi.ErrPreconditionFailed {
+		// another instance projected the same changes
+		return n, nil
+	}
+	return n, err
+}
+
+// removeFromReportList deletes the projection of a deleted report, the
+// change feed doesn't carry deletes.
+func removeFromReportList(ctx context.Context, id string) error {
+	if !reportListEnabled() {
+		return nil
+	}
+	_, err := currentClient.DeleteDocument(ctx, currentCfg.DbName, currentCfg.ReportListContainer, id, cosmosapi.DeleteDocumentOptions{
+		PartitionKeyValue: "visitreport",
+	})
+	if err == cosmosapi.ErrNotFound {
+		return nil
+	}
+	return err
+}
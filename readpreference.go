@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	// headerReadPreference lets a client choose between fresh and cached
+	// data per request, without it every route keeps its default
+	headerReadPreference = "X-Read-Preference"
+
+	// readPreferenceFresh reads from Cosmos DB, bypassing caches and
+	// projections, in the write region with the default consistency level
+	// of the account
+	readPreferenceFresh = "fresh"
+	// readPreferenceCached accepts cached and projected data and eventually
+	// consistent reads
+	readPreferenceCached = "cached"
+)
+
+type readPreferenceKey struct{}
+
+func withReadPreference(ctx context.Context, preference string) context.Context {
+	return context.WithValue(ctx, readPreferenceKey{}, preference)
+}
+
+func readPreferenceOf(ctx context.Context) string {
+	v, _ := ctx.Value(readPreferenceKey{}).(string)
+	return v
+}
+
+// wantsFresh reports whether the request must not be served from a cache or
+// a projection.
+func wantsFresh(ctx context.Context) bool {
+	return readPreferenceOf(ctx) == readPreferenceFresh
+}
+
+// acceptsCached reports whether the request may be served from a cache it
+// would otherwise bypass.
+func acceptsCached(ctx context.Context) bool {
+	return readPreferenceOf(ctx) == readPreferenceCached
+}
+
+// readPreference puts the preference of X-Read-Preference into the request
+// context, where the repository, the caches and the region router pick it
+// up.
+func readPreference(ctx iris.Context) {
+	ctx.ResponseWriter().Header().Add("Vary", headerReadPreference)
+	preference := strings.ToLower(strings.TrimSpace(ctx.GetHeader(headerReadPreference)))
+	switch preference {
+	case "":
+		ctx.Next()
+		return
+	case readPreferenceFresh, readPreferenceCached:
+	default:
+		ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+			Title("Invalid read preference").
+			Detail(fmt.Sprintf("%s must be %s or %s", headerReadPreference, readPreferenceFresh, readPreferenceCached)))
+		return
+	}
+	r := ctx.Request()
+	ctx.ResetRequest(r.WithContext(withReadPreference(r.Context(), preference)))
+	ctx.Next()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// ReanalysisRequestedEventDoc - asks the text analytics workers to analyze a
+// report again with the given model version
+type ReanalysisRequestedEventDoc struct {
+	EventType string `json:"eventType"`
+	Version   string `json:"version"`
+	VisitReportReadDoc
+	Model        string `json:"model"`
+	ModelVersion string `json:"modelVersion"`
+}
+
+// reanalysisCheckpoint - progress of a reanalyze run, the last report id
+// done per partition key
+type reanalysisCheckpoint struct {
+	Model   string            `json:"model"`
+	Version string            `json:"version"`
+	LastIds map[string]string `json:"lastIds"`
+}
+
+// analyzedWith reports whether all analyzed fields of the report were
+// written by the model version.
+func analyzedWith(model *VisitReportModel, name, version string) bool {
+	for _, field := range analyzedFields {
+		lineage, ok := model.Lineage[field]
+		if !ok || lineage.Model != name || lineage.Version != version {
+			return false
+		}
+	}
+	return true
+}
+
+// reanalyze sends a ReanalysisRequestedEvent for every report not yet
+// analyzed by a model version, e.g. "visitreports reanalyze -model
+// textanalytics -version 2024-05-01 -rate 5". The workers write the new
+// fields and lineage and send the analyzed events. Progress is saved to the
+// checkpoint file after every page, a rerun with the same file continues
+// where the last one stopped. Summaries pick up the new values on their next
+// run, or right away with "visitreports summarize".
+func reanalyze(args []string) error {
+	fs := flag.NewFlagSet("reanalyze", flag.ContinueOnError)
+	name := fs.String("model", "", "name of the analysis model, as recorded in the lineage")
+	version := fs.String("version", "", "version of the analysis model")
+	rate := fs.Float64("rate", 10, "maximum number of reports sent for analysis per second")
+	checkpointFile := fs.String("checkpoint", "reanalyze.json", "file recording the progress")
+	contactid := fs.String("contactid", "", "only reports of this contact")
+	from := fs.String("from", "", "only reports visited on or after this date")
+	to := fs.String("to", "", "only reports visited on or before this date")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" || *version == "" {
+		return fmt.Errorf("-model and -version are required")
+	}
+	if *rate <= 0 {
+		return fmt.Errorf("-rate must be positive")
+	}
+
+	// commands run before the server sets up the topic
+	topic, err := setupTopicSender()
+	if err != nil {
+		return err
+	}
+	currentTopic = topic
+
+	checkpoint := reanalysisCheckpoint{Model: *name, Version: *version, LastIds: map[string]string{}}
+	if b, err := ioutil.ReadFile(*checkpointFile); err == nil {
+		saved := reanalysisCheckpoint{}
+		if err := json.Unmarshal(b, &saved); err != nil {
+			return fmt.Errorf("invalid checkpoint %s: %v", *checkpointFile, err)
+		}
+		if saved.Model == *name && saved.Version == *version {
+			checkpoint = saved
+			fmt.Printf("Resuming from %s\n", *checkpointFile)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	save := func() error {
+		b, err := json.MarshalIndent(checkpoint, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(*checkpointFile, b, 0644)
+	}
+
+	throttle := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer throttle.Stop()
+	sent, skipped := 0, 0
+	for _, key := range currentCfg.PartitionKeys {
+		qry := filterQuery(SnapshotFilterDoc{ContactID: *contactid, From: *from, To: *to})
+		qry.Query += " AND c.id > @after ORDER BY c.id"
+		qry.Params = append(qry.Params, cosmosapi.QueryParam{Name: "@after", Value: checkpoint.LastIds[key]})
+		qops := cosmosapi.DefaultQueryDocumentOptions()
+		qops.PartitionKeyValue = key
+		qops.MaxItemCount = 100
+		for {
+			var docs []VisitReportModel
+			res, err := currentClient.QueryDocuments(context.Background(), currentCfg.DbName, currentCfg.ReportContainer, qry, &docs, qops)
+			if err != nil {
+				return err
+			}
+			for _, model := range docs {
+				// reports under legal hold must keep their analysis
+				if analyzedWith(&model, *name, *version) || model.Result == "" || model.LegalHold != nil {
+					skipped++
+					continue
+				}
+				<-throttle.C
+				if err := requestReanalysis(model, *name, *version); err != nil {
+					return fmt.Errorf("report %s: %v", model.Id, err)
+				}
+				sent++
+			}
+			if len(docs) > 0 {
+				checkpoint.LastIds[key] = docs[len(docs)-1].Id
+				if err := save(); err != nil {
+					return err
+				}
+			}
+			if res.Continuation == "" {
+				break
+			}
+			qops.Continuation = res.Continuation
+		}
+	}
+	fmt.Printf("Sent %d reports for analysis with %s %s, skipped %d\n", sent, *name, *version, skipped)
+	return nil
+}
+
+func requestReanalysis(model VisitReportModel, name, version string) error {
+	evt := ReanalysisRequestedEventDoc{
+		EventType:    "ReanalysisRequestedEvent",
+		Version:      "1",
+		Model:        name,
+		ModelVersion: version,
+	}
+	copier.Copy(&evt.VisitReportReadDoc, &model)
+	id := eventMessageID(evt.EventType, model.Id, name, version)
+	return publishEvent(evt.EventType, evt.Version, id, evt)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+)
+
+// reassignMaxRetries limits the retries of a report changed while it is
+// reassigned
+const reassignMaxRetries = 3
+
+// OwnerAuditDoc - audit entry for moving a report to another owner
+type OwnerAuditDoc struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// ReassignDoc - body of POST /reassign of the admin app. The reports created
+// by FromOwner move to ToOwner, ContactID, From and To optionally limit them
+// to a contact and an inclusive range of visit dates.
+type ReassignDoc struct {
+	FromOwner string `json:"fromOwner" validate:"required"`
+	ToOwner   string `json:"toOwner" validate:"required,nefield=FromOwner"`
+	Reason    string `json:"reason" validate:"max=500"`
+	ContactID string `json:"contactId" validate:"omitempty,uuid"`
+	From      string `json:"from" validate:"omitempty,datetime=2006-01-02"`
+	To        string `json:"to" validate:"omitempty,datetime=2006-01-02"`
+}
+
+// ReassignResultDoc - result of POST /reassign. Held is the number of
+// reports kept with their owner because of a legal hold.
+type ReassignResultDoc struct {
+	FromOwner    string    `json:"fromOwner"`
+	ToOwner      string    `json:"toOwner"`
+	Reassigned   int       `json:"reassigned"`
+	Held         int       `json:"held"`
+	ReassignedAt time.Time `json:"reassignedAt"`
+}
+
+// ReportsReassignedEventDoc - struct for the event summarizing a
+// reassignment, the reports themselves are not sent
+type ReportsReassignedEventDoc struct {
+	EventType string `json:"eventType"`
+	Version   string `json:"version"`
+	ReassignResultDoc
+}
+
+// reassignReports moves the reports of a rep who left to a successor, at
+// most VR_REASSIGNRATE reports per second. Each report records the change
+// in its owner audit, a ReportsReassignedEvent summarizes the run. A run
+// that fails can be repeated, reports already moved are not found again.
+func reassignReports(ctx iris.Context) {
+	in := ReassignDoc{}
+	if err := ctx.ReadJSON(&in); err != nil {
+		handleBindError(ctx, err)
+		return
+	}
+	out, err := reassignOwner(ctx.Request().Context(), in)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	fmt.Printf("Reassigned %d reports from %s to %s, %d kept under legal hold\n", out.Reassigned, out.FromOwner, out.ToOwner, out.Held)
+	if out.Reassigned > 0 {
+		event := ReportsReassignedEventDoc{EventType: "ReportsReassignedEvent", Version: "1", ReassignResultDoc: out}
+		id := eventMessageID(event.EventType, out.FromOwner, out.ToOwner, out.ReassignedAt.Format(time.RFC3339Nano))
+		if err := publishEvent(event.EventType, event.Version, id, event); err != nil {
+			err = errors.WithStack(err)
+			fmt.Println(err)
+		}
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(out)
+}
+
+func reassignOwner(ctx context.Context, in ReassignDoc) (ReassignResultDoc, error) {
+	out := ReassignResultDoc{FromOwner: in.FromOwner, ToOwner: in.ToOwner}
+	var ids []string
+	filter := ReportFilter{ContactID: in.ContactID, From: in.From, To: in.To}
+	err := currentRepo.Query(ctx, filter, func(model *VisitReportModel) error {
+		if model.CreatedBy != in.FromOwner {
+			return nil
+		}
+		if model.LegalHold != nil {
+			out.Held++
+			return nil
+		}
+		ids = append(ids, model.Id)
+		return nil
+	})
+	if err != nil {
+		return out, err
+	}
+
+	throttle := time.NewTicker(time.Duration(float64(time.Second) / currentCfg.ReassignRate))
+	defer throttle.Stop()
+	for _, id := range ids {
+		select {
+		case <-throttle.C:
+		case <-ctx.Done():
+			return out, ctx.Err()
+		}
+		moved, err := reassignReport(ctx, id, in)
+		if err != nil {
+			return out, errors.Wrapf(err, "report %s", id)
+		}
+		if moved {
+			out.Reassigned++
+		}
+	}
+	out.ReassignedAt = time.Now().UTC()
+	return out, nil
+}
+
+// reassignReport moves a report to the new owner, unless it was reassigned,
+// held or deleted in the meantime.
+func reassignReport(ctx context.Context, id string, in ReassignDoc) (bool, error) {
+	for i := 0; ; i++ {
+		model, err := currentRepo.Get(ctx, id)
+		if err == ErrReportNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if model.CreatedBy != in.FromOwner || model.LegalHold != nil {
+			return false, nil
+		}
+		model.CreatedBy = in.ToOwner
+		model.OwnerAudit = append(model.OwnerAudit, OwnerAuditDoc{
+			From:   in.FromOwner,
+			To:     in.ToOwner,
+			Reason: in.Reason,
+			At:     time.Now().UTC(),
+		})
+		_, err = currentRepo.Update(ctx, model)
+		if err == ErrReportChanged && i < reassignMaxRetries {
+			continue
+		}
+		return err == nil, err
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	// headerSessionToken carries the Cosmos DB session token of an API client
+	// session, the client sends back the value of the last response
+	headerSessionToken = "X-Session-Token"
+	// regionFailback is how long requests stay in a fallback region before
+	// the preferred region is tried again
+	regionFailback = 5 * time.Minute
+)
+
+// cosmosRegion - a regional endpoint of the Cosmos DB account
+type cosmosRegion struct {
+	name string
+	host string
+}
+
+// regionalHost derives the endpoint of a region from the global endpoint,
+// e.g. "myaccount.documents.azure.com" and "West Europe" give
+// "myaccount-westeurope.documents.azure.com".
+func regionalHost(global, region string) string {
+	account := strings.SplitN(global, ".", 2)
+	if len(account) != 2 {
+		return global
+	}
+	name := strings.ToLower(strings.ReplaceAll(region, " ", ""))
+	return account[0] + "-" + name + "." + account[1]
+}
+
+func parseRegions(dbURL string, names []string) ([]cosmosRegion, error) {
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		return nil, err
+	}
+	regions := []cosmosRegion{}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			regions = append(regions, cosmosRegion{name: name, host: regionalHost(u.Host, name)})
+		}
+	}
+	return regions, nil
+}
+
+// regionList - regions in order of preference and the one currently used
+type regionList struct {
+	kind       string
+	regions    []cosmosRegion
+	current    int
+	switchedAt time.Time
+}
+
+// regionRouter sends Cosmos DB requests to the preferred region of
+// VR_READREGIONS or VR_WRITEREGIONS and switches to the next one if a region
+// fails. It also attaches the session token of the API client session to
+// every request, see sessionConsistency, and requests the consistency level
+// of VR_READCONSISTENCY for reads. Reads of requests preferring fresh data
+// go to the write region with the default level of the account, those
+// accepting cached data are eventually consistent, see readPreference.
+type regionRouter struct {
+	base        http.RoundTripper
+	mu          sync.Mutex
+	reads       *regionList
+	writes      *regionList
+	consistency cosmosapi.ConsistencyLevel
+}
+
+// readConsistencies - the levels a read can request, they can only be
+// weaker than or equal to the default level of the account
+var readConsistencies = []cosmosapi.ConsistencyLevel{
+	cosmosapi.ConsistencyLevelStrong,
+	cosmosapi.ConsistencyLevelBounded,
+	cosmosapi.ConsistencyLevelSession,
+	cosmosapi.ConsistencyLevelEventual,
+}
+
+func parseConsistency(level string) (cosmosapi.ConsistencyLevel, error) {
+	if level == "" {
+		return "", nil
+	}
+	for _, c := range readConsistencies {
+		if strings.EqualFold(string(c), level) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown consistency level %q, use one of %v", level, readConsistencies)
+}
+
+func newRegionRouter(dbURL string, readRegions, writeRegions []string, readConsistency string) (*regionRouter, error) {
+	consistency, err := parseConsistency(readConsistency)
+	if err != nil {
+		return nil, err
+	}
+	reads, err := parseRegions(dbURL, readRegions)
+	if err != nil {
+		return nil, err
+	}
+	writes, err := parseRegions(dbURL, writeRegions)
+	if err != nil {
+		return nil, err
+	}
+	return &regionRouter{
+		base:        http.DefaultTransport,
+		reads:       &regionList{kind: "read", regions: reads},
+		writes:      &regionList{kind: "write", regions: writes},
+		consistency: consistency,
+	}, nil
+}
+
+// isCosmosRead reports whether the request only reads: a GET or a query,
+// which is sent as a POST.
+func isCosmosRead(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Header.Get("x-ms-documentdb-isquery") == "True"
+}
+
+// pick returns the index of the region to use, going back to the preferred
+// region once regionFailback has passed.
+func (rr *regionRouter) pick(l *regionList) int {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if l.current != 0 && time.Since(l.switchedAt) > regionFailback {
+		fmt.Printf("Cosmos DB %s region: returning from %s to %s\n", l.kind, l.regions[l.current].name, l.regions[0].name)
+		l.current = 0
+	}
+	return l.current
+}
+
+// failover switches to the region after the failed one, unless a concurrent
+// request has already switched.
+func (rr *regionRouter) failover(l *regionList, failed int, reason string) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if l.current != failed {
+		return
+	}
+	l.current = (failed + 1) % len(l.regions)
+	l.switchedAt = time.Now()
+	fmt.Printf("Cosmos DB %s region %s failed (%s), switching to %s\n", l.kind, l.regions[failed].name, reason, l.regions[l.current].name)
+}
+
+// regionFailure returns why a response means the region is not usable, or
+// an empty string if it is.
+func regionFailure(r *http.Request, resp *http.Response, err error) string {
+	if err != nil {
+		if r.Context().Err() != nil {
+			return ""
+		}
+		return err.Error()
+	}
+	substatus := resp.Header.Get("x-ms-substatus")
+	switch {
+	case resp.StatusCode == http.StatusServiceUnavailable:
+		return resp.Status
+	// WriteForbidden and DatabaseAccountNotFound: the region does not
+	// accept requests any more
+	case resp.StatusCode == http.StatusForbidden && (substatus == "3" || substatus == "1008"):
+		return resp.Status + " substatus " + substatus
+	// ReadSessionNotAvailable: the region has not caught up with the session
+	case resp.StatusCode == http.StatusNotFound && substatus == "1002":
+		return resp.Status + " substatus " + substatus
+	}
+	return ""
+}
+
+// RoundTrip - implements http.RoundTripper
+func (rr *regionRouter) RoundTrip(r *http.Request) (*http.Response, error) {
+	session, _ := r.Context().Value(cosmosSessionKey{}).(*cosmosSession)
+	read := isCosmosRead(r)
+	l := rr.writes
+	if read && !(wantsFresh(r.Context()) && len(rr.writes.regions) > 0) {
+		l = rr.reads
+	}
+
+	attempts := len(l.regions)
+	if attempts == 0 || (r.Body != nil && r.GetBody == nil) {
+		attempts = 1
+	}
+	for attempt := 0; ; attempt++ {
+		out := r.Clone(r.Context())
+		if attempt > 0 && r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			out.Body = body
+		}
+		i := -1
+		if len(l.regions) > 0 {
+			i = rr.pick(l)
+			out.URL.Host = l.regions[i].host
+			out.Host = ""
+		}
+		if token := session.header(); token != "" && out.Header.Get("x-ms-session-token") == "" {
+			out.Header.Set("x-ms-session-token", token)
+		}
+		if isUndefinedPartition(r.Context()) && out.Header.Get("x-ms-documentdb-partitionkey") == "" {
+			out.Header.Set("x-ms-documentdb-partitionkey", "[{}]")
+		}
+		if level := rr.readConsistency(r.Context()); read && level != "" && out.Header.Get("x-ms-consistency-level") == "" {
+			out.Header.Set("x-ms-consistency-level", string(level))
+		}
+
+		resp, err := rr.base.RoundTrip(out)
+		observeCosmosRequest(out, resp, err)
+		reason := regionFailure(out, resp, err)
+		if reason == "" || attempt+1 >= attempts {
+			if err == nil {
+				session.merge(resp.Header.Get("x-ms-session-token"))
+			}
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		rr.failover(l, i, reason)
+	}
+}
+
+// readConsistency returns the consistency level to request for the reads
+// of a request, empty for the default level of the account.
+func (rr *regionRouter) readConsistency(ctx context.Context) cosmosapi.ConsistencyLevel {
+	switch readPreferenceOf(ctx) {
+	case readPreferenceFresh:
+		return ""
+	case readPreferenceCached:
+		return cosmosapi.ConsistencyLevelEventual
+	}
+	return rr.consistency
+}
+
+type cosmosSessionKey struct{}
+
+// cosmosSession - the Cosmos DB session tokens of an API client session, one
+// per partition key range
+type cosmosSession struct {
+	mu       sync.Mutex
+	tokens   map[string]string
+	onChange func(token string)
+}
+
+func newCosmosSession(header string) *cosmosSession {
+	s := &cosmosSession{tokens: map[string]string{}}
+	s.merge(header)
+	return s
+}
+
+// header returns the tokens in the format of the x-ms-session-token header,
+// e.g. "0:-1#1234,1:-1#5678".
+func (s *cosmosSession) header() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.headerLocked()
+}
+
+func (s *cosmosSession) headerLocked() string {
+	tokens := make([]string, 0, len(s.tokens))
+	for pkrange, token := range s.tokens {
+		tokens = append(tokens, pkrange+":"+token)
+	}
+	sort.Strings(tokens)
+	return strings.Join(tokens, ",")
+}
+
+// merge takes over the tokens of a response, they are newer than the ones
+// seen so far.
+func (s *cosmosSession) merge(header string) {
+	if s == nil || header == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, entry := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			continue
+		}
+		if s.tokens[parts[0]] != parts[1] {
+			s.tokens[parts[0]] = parts[1]
+			changed = true
+		}
+	}
+	if changed && s.onChange != nil {
+		s.onChange(s.headerLocked())
+	}
+}
+
+// sessionConsistency gives API clients read-your-writes across regions: the
+// session token sent in X-Session-Token is attached to the Cosmos DB requests
+// of the request context, and the token after these requests is returned in
+// the X-Session-Token response header. A report created with a token is
+// readable right away by reads sending the returned token, as long as
+// VR_READCONSISTENCY is Session or stronger.
+func sessionConsistency(ctx iris.Context) {
+	session := newCosmosSession(ctx.GetHeader(headerSessionToken))
+	session.onChange = func(token string) {
+		ctx.Header(headerSessionToken, token)
+	}
+	r := ctx.Request()
+	ctx.ResetRequest(r.WithContext(context.WithValue(r.Context(), cosmosSessionKey{}, session)))
+	ctx.Next()
+}
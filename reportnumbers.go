@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// reportNumberMaxRetries limits the retries of incrementing a counter that
+// was incremented by another instance in the meantime
+const reportNumberMaxRetries = 10
+
+// ReportCounterDoc - the last report number handed out in a year. Counters
+// are stored in the "config" container, partitioned by type.
+type ReportCounterDoc struct {
+	cosmosapi.Document
+	Type  string `json:"type"`
+	Year  int    `json:"year"`
+	Value int    `json:"value"`
+}
+
+// memoryCounters are the counters of VR_REPOSITORY=memory
+var memoryCounters = struct {
+	sync.Mutex
+	values map[int]int
+}{values: map[int]int{}}
+
+func reportNumbersEnabled() bool {
+	return currentCfg.ReportNumberPrefix != ""
+}
+
+// formatReportNumber returns the human-readable number of a report, e.g.
+// VR-2024-000123.
+func formatReportNumber(year, value int) string {
+	return fmt.Sprintf("%s-%d-%06d", currentCfg.ReportNumberPrefix, year, value)
+}
+
+// nextReportNumber hands out the next number of the year. Numbers are
+// increasing without gaps as long as creating the report succeeds.
+func nextReportNumber(ctx context.Context, at time.Time) (string, error) {
+	year := at.Year()
+	if currentCfg.Repository == "memory" {
+		memoryCounters.Lock()
+		defer memoryCounters.Unlock()
+		memoryCounters.values[year]++
+		return formatReportNumber(year, memoryCounters.values[year]), nil
+	}
+
+	id := "reportnumber-" + strconv.Itoa(year)
+	for i := 0; ; i++ {
+		counter := ReportCounterDoc{}
+		_, err := currentClient.GetDocument(ctx, currentCfg.DbName, "config", id, cosmosapi.GetDocumentOptions{
+			PartitionKeyValue: "counter",
+		}, &counter)
+		switch err {
+		case cosmosapi.ErrNotFound:
+			counter = ReportCounterDoc{Type: "counter", Year: year, Value: 1}
+			counter.Id = id
+			_, _, err = currentClient.CreateDocument(ctx, currentCfg.DbName, "config", counter, cosmosapi.CreateDocumentOptions{
+				PartitionKeyValue: "counter",
+			})
+		case nil:
+			counter.Value++
+			_, _, err = currentClient.ReplaceDocument(ctx, currentCfg.DbName, "config", id, counter, cosmosapi.ReplaceDocumentOptions{
+				PartitionKeyValue: "counter",
+				IfMatch:           counter.Etag,
+			})
+		}
+		if err == nil {
+			return formatReportNumber(year, counter.Value), nil
+		}
+		if (err != cosmosapi.ErrConflict && err != cosmosapi.ErrPreconditionFailed) || i == reportNumberMaxRetries {
+			return "", err
+		}
+		// another instance took the number, try the next one
+	}
+}
+
+// numberCondition returns the condition matching the report with a number.
+func numberCondition(number string) (string, []cosmosapi.QueryParam) {
+	return "c.number = @number", []cosmosapi.QueryParam{
+		{Name: "@number", Value: number},
+	}
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+var (
+	// ErrReportNotFound - the report does not exist
+	ErrReportNotFound = errors.New("visit report not found")
+	// ErrReportChanged - the report was changed since it was read, its etag
+	// differs from the one it was updated with
+	ErrReportChanged = errors.New("visit report was changed")
+	// ErrInvalidContinuation - the continuation token was not returned by a
+	// previous page of the same list
+	ErrInvalidContinuation = errors.New("invalid continuation token")
+)
+
+// ReportFilter - filters of the repository, empty fields match all reports
+type ReportFilter struct {
+	ContactID   string
+	ContactName string
+	CrmID       string
+	Attendee    string
+	Number      string
+	// Acknowledged matches reports that were (true) or were not (false)
+	// acknowledged by a manager
+	Acknowledged *bool
+	// IncludeDeleted matches soft-deleted reports as well
+	IncludeDeleted bool
+	// From and To are inclusive visit dates in the form 2006-01-02
+	From     string
+	To       string
+	MinScore *float64
+	MaxScore *float64
+	Company  string
+	// Sort is a field of listSortFields, with "-" in front descending. Lists
+	// are not sorted without it.
+	Sort string
+	// Text matches reports containing all of its words in the subject,
+	// description or result
+	Text string
+}
+
+// VisitReportRepository - storage of the visit reports used by the report
+// handlers, selected with VR_REPOSITORY
+type VisitReportRepository interface {
+	// Create stores a new report and returns its etag
+	Create(ctx context.Context, model *VisitReportModel) (string, error)
+	// Get returns a report, ErrReportNotFound if it does not exist or was
+	// soft-deleted
+	Get(ctx context.Context, id string) (*VisitReportModel, error)
+	// GetDeleted returns a soft-deleted report, ErrReportNotFound if it does
+	// not exist or is not deleted
+	GetDeleted(ctx context.Context, id string) (*VisitReportModel, error)
+	// List returns a page of at most limit reports starting at the token
+	// and the token of the next page, empty on the last page
+	List(ctx context.Context, filter ReportFilter, limit int, token string) ([]VisitReportListDoc, string, error)
+	// Update replaces a stored report and returns its new etag. Unless
+	// model.Etag is empty, the stored report must still have that etag,
+	// otherwise ErrReportChanged is returned.
+	Update(ctx context.Context, model *VisitReportModel) (string, error)
+	// Delete removes a report for good. Reports are soft-deleted by
+	// updating them with the deleted flag set, see softdelete.go.
+	Delete(ctx context.Context, id string) error
+	// Query calls fn for every report matching the filter until fn fails
+	Query(ctx context.Context, filter ReportFilter, fn func(model *VisitReportModel) error) error
+}
+
+var currentRepo VisitReportRepository
+
+// getAnyReport returns a report whether or not it was soft-deleted.
+func getAnyReport(ctx context.Context, id string) (*VisitReportModel, error) {
+	model, err := currentRepo.Get(ctx, id)
+	if err == ErrReportNotFound {
+		return currentRepo.GetDeleted(ctx, id)
+	}
+	return model, err
+}
+
+// countReports counts the reports of the repository matching the filter
+// and match.
+func countReports(ctx context.Context, filter ReportFilter, match func(model *VisitReportModel) bool) (int, error) {
+	n := 0
+	err := currentRepo.Query(ctx, filter, func(model *VisitReportModel) error {
+		if match(model) {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// newReportRepository returns the repository of VR_REPOSITORY, "cosmos" or
+// "memory". The memory repository keeps reports for the lifetime of the
+// process, e.g. for local development and tests.
+func newReportRepository(kind string) (VisitReportRepository, error) {
+	switch kind {
+	case "", "cosmos":
+		return &cosmosReportRepository{client: currentClient, db: currentCfg.DbName}, nil
+	case "memory":
+		return newMemoryReportRepository(), nil
+	}
+	return nil, fmt.Errorf("unknown repository %q, expected cosmos or memory", kind)
+}
+
+type cosmosReportRepository struct {
+	client *cosmosapi.Client
+	db     string
+}
+
+func (r *cosmosReportRepository) Create(ctx context.Context, model *VisitReportModel) (string, error) {
+	ops := cosmosapi.CreateDocumentOptions{
+		PartitionKeyValue: "visitreport",
+	}
+	res, _, err := r.client.CreateDocument(ctx, r.db, currentCfg.ReportContainer, model, ops)
+	if err != nil {
+		return "", err
+	}
+	return res.Etag, nil
+}
+
+func (r *cosmosReportRepository) Get(ctx context.Context, id string) (*VisitReportModel, error) {
+	model, err := r.get(ctx, id)
+	if err == nil && model.Deleted {
+		return nil, ErrReportNotFound
+	}
+	return model, err
+}
+
+func (r *cosmosReportRepository) GetDeleted(ctx context.Context, id string) (*VisitReportModel, error) {
+	model, err := r.get(ctx, id)
+	if err == nil && !model.Deleted {
+		return nil, ErrReportNotFound
+	}
+	return model, err
+}
+
+func (r *cosmosReportRepository) get(ctx context.Context, id string) (*VisitReportModel, error) {
+	ro := cosmosapi.GetDocumentOptions{
+		PartitionKeyValue: "visitreport",
+	}
+	model := &VisitReportModel{}
+	_, err := r.client.GetDocument(ctx, r.db, currentCfg.ReportContainer, id, ro, model)
+	if err == cosmosapi.ErrNotFound {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// query returns the Cosmos DB condition and parameters of a filter, it
+// applies to reports and drafts.
+func (f ReportFilter) query() (string, []cosmosapi.QueryParam) {
+	var conditions []string
+	var params []cosmosapi.QueryParam
+	if !f.IncludeDeleted {
+		conditions = append(conditions, notDeleted)
+	}
+	if f.ContactID != "" {
+		conditions = append(conditions, "c.contact.id = @contactid")
+		params = append(params, cosmosapi.QueryParam{
+			Name:  "@contactid",
+			Value: f.ContactID,
+		})
+	}
+	add := func(cond string, p []cosmosapi.QueryParam) {
+		conditions = append(conditions, cond)
+		params = append(params, p...)
+	}
+	if f.ContactName != "" {
+		add(contactNameCondition(f.ContactName))
+	}
+	if f.CrmID != "" {
+		add(crmLinkCondition(f.CrmID))
+	}
+	if f.Attendee != "" {
+		add(attendeeCondition(f.Attendee))
+	}
+	if f.Number != "" {
+		add(numberCondition(f.Number))
+	}
+	if f.Acknowledged != nil {
+		add(acknowledgedCondition(*f.Acknowledged))
+	}
+	if f.Text != "" {
+		add(textCondition(f.Text))
+	}
+	rangeConditions, rangeParams := f.rangeConditions()
+	conditions = append(conditions, rangeConditions...)
+	params = append(params, rangeParams...)
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " where " + strings.Join(conditions, " AND "), params
+}
+
+// listProjection selects the fields of VisitReportListDoc server-side, to
+// keep the payload of the list small
+const listProjection = "c.id, c.type, c.deleted, c.number, c.subject, c.visitDate, c.contact"
+
+// prefixes of the continuation tokens of lists, the container a list is read
+// from is pinned by its first page
+const (
+	continuationReports = "r:"
+	continuationList    = "l:"
+)
+
+// listFromProjection decides whether a page of a list is read from the
+// projection and returns the continuation token for Cosmos DB. The first
+// page picks the container by the filter and the read preference, the
+// following pages are read from the container their token came from, a
+// token of one container is invalid in the other.
+func listFromProjection(ctx context.Context, projectable bool, token string) (bool, string, error) {
+	switch {
+	case strings.HasPrefix(token, continuationList):
+		if !projectable {
+			return false, "", ErrInvalidContinuation
+		}
+		return true, strings.TrimPrefix(token, continuationList), nil
+	case strings.HasPrefix(token, continuationReports):
+		return false, strings.TrimPrefix(token, continuationReports), nil
+	case token != "":
+		return false, "", ErrInvalidContinuation
+	}
+	return projectable && !wantsFresh(ctx), "", nil
+}
+
+func (r *cosmosReportRepository) List(ctx context.Context, filter ReportFilter, limit int, token string) ([]VisitReportListDoc, string, error) {
+	where, params := filter.query()
+	collection, prefix := currentCfg.ReportContainer, continuationReports
+	w, p, projectable := filter.listModelQuery()
+	fromProjection, token, err := listFromProjection(ctx, projectable, token)
+	if err != nil {
+		return nil, "", err
+	}
+	if fromProjection {
+		// the compact projection of the reports is cheaper to query
+		where, params = w, p
+		collection, prefix = currentCfg.ReportListContainer, continuationList
+	}
+	qry := cosmosapi.Query{
+		Query:  "SELECT " + listProjection + " FROM c" + where + filter.orderBy(),
+		Params: params,
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qops.MaxItemCount = limit
+	qops.Continuation = token
+	docs := []VisitReportListDoc{}
+	res, err := r.client.QueryDocuments(ctx, r.db, collection, qry, &docs, qops)
+	if err == cosmosapi.ErrInvalidRequest && token != "" {
+		// tokens are opaque to clients, a malformed one is rejected by Cosmos DB
+		return nil, "", ErrInvalidContinuation
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	fmt.Printf("Listed %d reports, Request Units: %f\n", res.Count, res.RequestCharge)
+	if res.Continuation == "" {
+		return docs, "", nil
+	}
+	return docs, prefix + res.Continuation, nil
+}
+
+func (r *cosmosReportRepository) Update(ctx context.Context, model *VisitReportModel) (string, error) {
+	ops := cosmosapi.ReplaceDocumentOptions{}
+	ops.PartitionKeyValue = "visitreport"
+	ops.IfMatch = model.Etag
+	res, _, err := r.client.ReplaceDocument(ctx, r.db, currentCfg.ReportContainer, model.Id, model, ops)
+	if err == cosmosapi.ErrNotFound {
+		return "", ErrReportNotFound
+	}
+	if err == cosmosapi.ErrPreconditionFailed {
+		return "", ErrReportChanged
+	}
+	if err != nil {
+		return "", err
+	}
+	return res.Etag, nil
+}
+
+func (r *cosmosReportRepository) Delete(ctx context.Context, id string) error {
+	ro := cosmosapi.DeleteDocumentOptions{
+		PartitionKeyValue: "visitreport",
+	}
+	_, err := r.client.DeleteDocument(ctx, r.db, currentCfg.ReportContainer, id, ro)
+	if err == cosmosapi.ErrNotFound {
+		return ErrReportNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return removeFromReportList(ctx, id)
+}
+
+func (r *cosmosReportRepository) Query(ctx context.Context, filter ReportFilter, fn func(model *VisitReportModel) error) error {
+	where, params := filter.query()
+	qry := cosmosapi.Query{
+		Query:  "SELECT * FROM c" + where,
+		Params: params,
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	qops.MaxItemCount = streamPageSize
+	for {
+		var docs []VisitReportModel
+		res, err := r.client.QueryDocuments(ctx, r.db, currentCfg.ReportContainer, qry, &docs, qops)
+		if err != nil {
+			return err
+		}
+		for i := range docs {
+			if err := fn(&docs[i]); err != nil {
+				return err
+			}
+		}
+		if res.Continuation == "" {
+			return nil
+		}
+		qops.Continuation = res.Continuation
+	}
+}
+
+// memoryReportRepository keeps the reports as JSON, so callers never share
+// slices or maps with the stored reports. Lists are ordered by id, the
+// continuation token is the last id of the previous page, so deleting that
+// report does not break the next page. The token of sorted lists is the
+// offset of the next page.
+type memoryReportRepository struct {
+	mu      sync.RWMutex
+	reports map[string][]byte
+	etags   map[string]string
+	version int
+}
+
+func newMemoryReportRepository() *memoryReportRepository {
+	return &memoryReportRepository{reports: map[string][]byte{}, etags: map[string]string{}}
+}
+
+// matches applies a filter the way the Cosmos DB conditions do.
+func (f ReportFilter) matches(model *VisitReportModel) bool {
+	if model.Deleted && !f.IncludeDeleted {
+		return false
+	}
+	if f.ContactID != "" && model.Contact.Id != f.ContactID {
+		return false
+	}
+	for _, word := range strings.Fields(strings.ToLower(f.ContactName)) {
+		if !strings.Contains(strings.ToLower(model.Contact.Firstname), word) &&
+			!strings.Contains(strings.ToLower(model.Contact.Lastname), word) &&
+			!strings.Contains(strings.ToLower(model.Contact.Company), word) {
+			return false
+		}
+	}
+	if f.CrmID != "" {
+		found := false
+		for _, link := range model.CrmLinks {
+			found = found || link.Id == f.CrmID
+		}
+		if !found {
+			return false
+		}
+	}
+	if attendee := strings.ToLower(strings.TrimSpace(f.Attendee)); attendee != "" {
+		found := false
+		for _, a := range model.Attendees {
+			found = found || strings.ToLower(a.Name) == attendee || strings.ToLower(a.Email) == attendee
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Number != "" && model.Number != f.Number {
+		return false
+	}
+	if f.Acknowledged != nil && *f.Acknowledged != (model.Acknowledgment != nil) {
+		return false
+	}
+	if !matchesText(f.Text, model) {
+		return false
+	}
+	return f.matchesRange(model)
+}
+
+func (r *memoryReportRepository) store(model *VisitReportModel) (string, error) {
+	b, err := json.Marshal(model)
+	if err != nil {
+		return "", err
+	}
+	r.version++
+	etag := strconv.Itoa(r.version)
+	r.reports[model.Id] = b
+	r.etags[model.Id] = etag
+	return etag, nil
+}
+
+func (r *memoryReportRepository) Create(ctx context.Context, model *VisitReportModel) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.reports[model.Id]; ok {
+		return "", cosmosapi.ErrConflict
+	}
+	return r.store(model)
+}
+
+func (r *memoryReportRepository) get(id string) (*VisitReportModel, error) {
+	b, ok := r.reports[id]
+	if !ok {
+		return nil, ErrReportNotFound
+	}
+	model := &VisitReportModel{}
+	if err := json.Unmarshal(b, model); err != nil {
+		return nil, err
+	}
+	model.Etag = r.etags[id]
+	return model, nil
+}
+
+func (r *memoryReportRepository) Get(ctx context.Context, id string) (*VisitReportModel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	model, err := r.get(id)
+	if err == nil && model.Deleted {
+		return nil, ErrReportNotFound
+	}
+	return model, err
+}
+
+func (r *memoryReportRepository) GetDeleted(ctx context.Context, id string) (*VisitReportModel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	model, err := r.get(id)
+	if err == nil && !model.Deleted {
+		return nil, ErrReportNotFound
+	}
+	return model, err
+}
+
+// sortedIds returns the ids of the reports after the given id in order.
+func (r *memoryReportRepository) sortedIds(after string) []string {
+	ids := make([]string, 0, len(r.reports))
+	for id := range r.reports {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func listDocOf(model *VisitReportModel) VisitReportListDoc {
+	return VisitReportListDoc{
+		Id:        model.Id,
+		Type:      model.Type,
+		Deleted:   model.Deleted,
+		Number:    model.Number,
+		Subject:   model.Subject,
+		VisitDate: model.VisitDate,
+		Contact:   model.Contact,
+	}
+}
+
+func (r *memoryReportRepository) List(ctx context.Context, filter ReportFilter, limit int, token string) ([]VisitReportListDoc, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if filter.Sort != "" {
+		return r.listSorted(filter, limit, token)
+	}
+	docs := []VisitReportListDoc{}
+	for _, id := range r.sortedIds(token) {
+		model, err := r.get(id)
+		if err != nil {
+			return nil, "", err
+		}
+		if !filter.matches(model) {
+			continue
+		}
+		if len(docs) == limit {
+			return docs, docs[len(docs)-1].Id, nil
+		}
+		docs = append(docs, listDocOf(model))
+	}
+	return docs, "", nil
+}
+
+func (r *memoryReportRepository) listSorted(filter ReportFilter, limit int, token string) ([]VisitReportListDoc, string, error) {
+	offset := 0
+	if token != "" {
+		var err error
+		if offset, err = strconv.Atoi(token); err != nil || offset < 0 {
+			return nil, "", ErrInvalidContinuation
+		}
+	}
+	var models []*VisitReportModel
+	for _, id := range r.sortedIds("") {
+		model, err := r.get(id)
+		if err != nil {
+			return nil, "", err
+		}
+		if filter.matches(model) {
+			models = append(models, model)
+		}
+	}
+	sort.SliceStable(models, func(i, j int) bool {
+		return filter.less(models[i], models[j])
+	})
+	docs := []VisitReportListDoc{}
+	for i := offset; i < len(models); i++ {
+		if len(docs) == limit {
+			return docs, strconv.Itoa(i), nil
+		}
+		docs = append(docs, listDocOf(models[i]))
+	}
+	return docs, "", nil
+}
+
+func (r *memoryReportRepository) Update(ctx context.Context, model *VisitReportModel) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.reports[model.Id]; !ok {
+		return "", ErrReportNotFound
+	}
+	if model.Etag != "" && model.Etag != r.etags[model.Id] {
+		return "", ErrReportChanged
+	}
+	return r.store(model)
+}
+
+func (r *memoryReportRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.reports[id]; !ok {
+		return ErrReportNotFound
+	}
+	delete(r.reports, id)
+	delete(r.etags, id)
+	return nil
+}
+
+func (r *memoryReportRepository) Query(ctx context.Context, filter ReportFilter, fn func(model *VisitReportModel) error) error {
+	// collect first, fn may call back into the repository
+	r.mu.RLock()
+	var models []*VisitReportModel
+	for _, id := range r.sortedIds("") {
+		model, err := r.get(id)
+		if err != nil {
+			r.mu.RUnlock()
+			return err
+		}
+		if filter.matches(model) {
+			models = append(models, model)
+		}
+	}
+	r.mu.RUnlock()
+	for _, model := range models {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
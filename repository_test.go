@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListProjection(t *testing.T) {
+	projected := map[string]bool{}
+	for _, field := range strings.Split(listProjection, ",") {
+		projected[strings.TrimPrefix(strings.TrimSpace(field), "c.")] = true
+	}
+
+	// the projection selects exactly the fields read into the list doc,
+	// draft is only set on drafts streamed with includeDrafts
+	projected["draft"] = true
+	typ := reflect.TypeOf(VisitReportListDoc{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if !projected[field] {
+			t.Errorf("%s is part of VisitReportListDoc but not projected", field)
+		}
+		delete(projected, field)
+	}
+	for field := range projected {
+		t.Errorf("%s is projected but not part of VisitReportListDoc", field)
+	}
+}
+
+// testListPage returns a page of reports the way SELECT * returns them and
+// the way the projection does. Only the payload is compared, the request
+// charge of the queries needs a Cosmos DB account or the emulator.
+func testListPage(n int) (full, projected []byte) {
+	models := make([]VisitReportModel, n)
+	docs := make([]VisitReportListDoc, n)
+	for i := range models {
+		model := &models[i]
+		model.Id = fmt.Sprintf("report-%03d", i)
+		model.Type = "visitreport"
+		model.Subject = "Quarterly review"
+		model.VisitDate = "2024-05-01"
+		model.Description = strings.Repeat("Discussed the roadmap and the open issues. ", 40)
+		model.Result = strings.Repeat("Agreed on the next steps and a follow-up. ", 20)
+		model.VisitResultKeyPhrases = []string{"roadmap", "open issues", "next steps", "follow-up"}
+		model.Contact = ContactDoc{Id: "c1", Firstname: "Ada", Lastname: "Lovelace", Company: "Analytical Engines"}
+		docs[i] = listDocOf(model)
+	}
+	full, _ = json.Marshal(models)
+	projected, _ = json.Marshal(docs)
+	return full, projected
+}
+
+func TestListPayloadSize(t *testing.T) {
+	full, projected := testListPage(100)
+	if len(projected)*4 > len(full) {
+		t.Errorf("got %d bytes projected and %d bytes with SELECT *, want a quarter at most", len(projected), len(full))
+	}
+}
+
+func BenchmarkListPage(b *testing.B) {
+	full, projected := testListPage(100)
+	for _, bm := range []struct {
+		name string
+		page []byte
+	}{
+		{"select *", full},
+		{"projected", projected},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(bm.page)))
+			for i := 0; i < b.N; i++ {
+				var docs []VisitReportListDoc
+				if err := json.Unmarshal(bm.page, &docs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestListContinuationPinsContainer(t *testing.T) {
+	fresh := context.WithValue(context.Background(), readPreferenceKey{}, readPreferenceFresh)
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		projectable bool
+		token       string
+		want        bool
+		wantToken   string
+		wantErr     error
+	}{
+		{"first page from projection", context.Background(), true, "", true, "", nil},
+		{"first page fresh", fresh, true, "", false, "", nil},
+		{"first page not projectable", context.Background(), false, "", false, "", nil},
+		{"projection token read fresh", fresh, true, continuationList + "abc", true, "abc", nil},
+		{"reports token read from projection", context.Background(), true, continuationReports + "abc", false, "abc", nil},
+		{"projection token not projectable", context.Background(), false, continuationList + "abc", false, "", ErrInvalidContinuation},
+		{"token without container", context.Background(), true, "abc", false, "", ErrInvalidContinuation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, token, err := listFromProjection(tt.ctx, tt.projectable, tt.token)
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want || token != tt.wantToken {
+				t.Errorf("got %v %q, want %v %q", got, token, tt.want, tt.wantToken)
+			}
+		})
+	}
+}
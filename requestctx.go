@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kataras/iris/v12/core/host"
+)
+
+// serverContext is the base context of all requests, it is cancelled when
+// the graceful shutdown has timed out, so requests still running stop their
+// Cosmos DB and Service Bus calls.
+var serverContext, cancelServerContext = context.WithCancel(context.Background())
+
+// withServerContext makes serverContext the base context of a host.
+func withServerContext(su *host.Supervisor) {
+	su.Server.BaseContext = func(net.Listener) context.Context {
+		return serverContext
+	}
+}
+
+// operationTimeout bounds every Cosmos DB request by VR_OPERATIONTIMEOUT on
+// top of the context of the caller, usually the request context.
+type operationTimeout struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+// cancelOnClose cancels the context of a request when its response body is
+// closed, the body is read after RoundTrip returns.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// RoundTrip - implements http.RoundTripper
+func (t *operationTimeout) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.base.RoundTrip(r)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(r.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
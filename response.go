@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// normalize returns a copy of a response in which nil slices and maps are
+// empty, so they serialize as [] and {} instead of null. Clients can rely on
+// array and object fields never being null. Nil pointers stay null, values
+// with their own MarshalJSON are left as they are. The response is copied,
+// not changed, as it may be shared, e.g. the cached dashboard.
+func normalize(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return normalizeValue(reflect.ValueOf(v)).Interface()
+}
+
+func normalizeValue(v reflect.Value) reflect.Value {
+	t := v.Type()
+	if t.Implements(jsonMarshalerType) {
+		return v
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(t.Elem())
+		out.Elem().Set(normalizeValue(v.Elem()))
+		return out
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(t).Elem()
+		out.Set(normalizeValue(v.Elem()))
+		return out
+	case reflect.Struct:
+		out := reflect.New(t).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := out.Field(i); f.CanSet() {
+				f.Set(normalizeValue(v.Field(i)))
+			}
+		}
+		return out
+	case reflect.Slice:
+		if v.IsNil() {
+			return reflect.MakeSlice(t, 0, 0)
+		}
+		out := reflect.MakeSlice(t, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(normalizeValue(v.Index(i)))
+		}
+		return out
+	case reflect.Array:
+		out := reflect.New(t).Elem()
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(normalizeValue(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return reflect.MakeMap(t)
+		}
+		out := reflect.MakeMapWithSize(t, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), normalizeValue(iter.Value()))
+		}
+		return out
+	}
+	return v
+}
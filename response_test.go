@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{"nil", nil, `null`},
+		{"nil slice", []VisitReportListDoc(nil), `[]`},
+		{"nil map", map[string]int(nil), `{}`},
+		{"nil pointer", (*LegalHoldDoc)(nil), `null`},
+		{"slice of nil slices", [][]string{nil, {"a"}}, `[[],["a"]]`},
+		{"map of nil slices", map[string][]string{"a": nil}, `{"a":[]}`},
+		{
+			"nested dto",
+			SearchResultDoc{},
+			`{"count":0,"hits":[],"facets":{}}`,
+		},
+		{
+			"nested dto in slice",
+			[]SearchHitDoc{{VisitReportListDoc: VisitReportListDoc{Id: "1", Type: "visitreport"}}},
+			`[{"id":"1","type":"visitreport","subject":"","visitDate":"","contact":{"id":"","firstname":"","lastname":"","avatarLocation":"","company":""},"score":0}]`,
+		},
+		{
+			"pointer to dto",
+			&StatsRecomputeDoc{Scope: "overall", RecomputedAt: at},
+			`{"scope":"overall","reports":0,"discrepancies":[],"repaired":0,"recomputedAt":"2024-05-01T12:00:00Z"}`,
+		},
+		{
+			"marshaler is left as is",
+			LegalHoldDoc{Reason: "audit", Since: at},
+			`{"reason":"audit","since":"2024-05-01T12:00:00Z"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(normalize(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeReadDoc(t *testing.T) {
+	doc := VisitReportReadDoc{Id: "1", Subject: "Kickoff"}
+	b, err := json.Marshal(normalize(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		field string
+		want  string
+		// omitted fields with omitempty must stay omitted, not become []
+		omitted bool
+	}{
+		{field: "visitResultKeyPhrases", want: `[]`},
+		{field: "contact", want: `{"id":"","firstname":"","lastname":"","avatarLocation":"","company":""}`},
+		{field: "crmLinks", omitted: true},
+		{field: "attendees", omitted: true},
+		{field: "attachments", omitted: true},
+		{field: "legalHold", omitted: true},
+	}
+	for _, tt := range tests {
+		got, ok := fields[tt.field]
+		switch {
+		case tt.omitted && ok:
+			t.Errorf("%s: got %s, want it omitted", tt.field, got)
+		case !tt.omitted && string(got) != tt.want:
+			t.Errorf("%s: got %s, want %s", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeCopies(t *testing.T) {
+	doc := &DashboardDoc{}
+	normalize(doc)
+	if doc.Overall != nil || doc.Timeline != nil {
+		t.Error("normalize changed the shared response")
+	}
+}
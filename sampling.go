@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	sampleRandom       = "random"
+	sampleLowSentiment = "lowSentiment"
+	sampleNewReps      = "newReps"
+)
+
+// SampleQuery - query parameters of GET /reports/sample. The same seed
+// returns the same sample as long as the reports don't change, without a
+// seed (or 0) one is generated and returned.
+type SampleQuery struct {
+	N        int    `url:"n" validate:"min=1,max=500"`
+	Strategy string `url:"strategy" validate:"oneof=random lowSentiment newReps"`
+	Seed     int64  `url:"seed"`
+	From     string `url:"from" validate:"omitempty,datetime=2006-01-02"`
+	To       string `url:"to" validate:"omitempty,datetime=2006-01-02"`
+}
+
+// SampleDoc - struct for GET /reports/sample, Population is the number of
+// reports the sample was drawn from
+type SampleDoc struct {
+	Strategy   string               `json:"strategy"`
+	Seed       int64                `json:"seed"`
+	Population int                  `json:"population"`
+	Items      []VisitReportListDoc `json:"items"`
+}
+
+// sampleCandidate - a report that can be drawn with its weight
+type sampleCandidate struct {
+	doc       VisitReportListDoc
+	weight    float64
+	createdBy string
+}
+
+// sampleWeight returns the weight of a report for a strategy, 0 excludes it.
+// Low sentiment reports are drawn more often, the sentiment score is
+// between 0 (negative) and 1 (positive).
+func sampleWeight(strategy string, model *VisitReportModel) float64 {
+	switch strategy {
+	case sampleLowSentiment:
+		if model.Result == "" {
+			return 0
+		}
+		return math.Max(1-model.VisitResultSentimentScore, 0.01)
+	case sampleNewReps:
+		if model.CreatedBy == "" || model.CreatedAt == nil {
+			return 0
+		}
+	}
+	return 1
+}
+
+// drawSample draws n candidates without replacement, with a probability
+// proportional to their weight (Efraimidis-Spirakis): every candidate gets
+// the key u^(1/weight) and the n largest keys win. The candidates are drawn
+// in id order, so a seed always draws the same reports.
+func drawSample(candidates []sampleCandidate, n int, seed int64) []VisitReportListDoc {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].doc.Id < candidates[j].doc.Id
+	})
+	rnd := rand.New(rand.NewSource(seed))
+	keys := make([]float64, len(candidates))
+	order := make([]int, len(candidates))
+	for i, c := range candidates {
+		keys[i] = math.Pow(rnd.Float64(), 1/c.weight)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return keys[order[i]] > keys[order[j]]
+	})
+	if len(order) > n {
+		order = order[:n]
+	}
+	out := make([]VisitReportListDoc, 0, len(order))
+	for _, i := range order {
+		out = append(out, candidates[i].doc)
+	}
+	return out
+}
+
+// sampleCandidates returns the reports of the visit date range a strategy
+// can draw. For newReps these are the reports of authors whose first report
+// was created within VR_SAMPLENEWREPPERIOD.
+func sampleCandidates(ctx context.Context, params SampleQuery) ([]sampleCandidate, error) {
+	var candidates []sampleCandidate
+	firstReports := map[string]time.Time{}
+	err := currentRepo.Query(ctx, ReportFilter{}, func(model *VisitReportModel) error {
+		if model.CreatedBy != "" && model.CreatedAt != nil {
+			if first, ok := firstReports[model.CreatedBy]; !ok || model.CreatedAt.Before(first) {
+				firstReports[model.CreatedBy] = *model.CreatedAt
+			}
+		}
+		if params.From != "" && model.VisitDate < params.From {
+			return nil
+		}
+		if params.To != "" && model.VisitDate >= nextDay(params.To) {
+			return nil
+		}
+		weight := sampleWeight(params.Strategy, model)
+		if weight <= 0 {
+			return nil
+		}
+		candidates = append(candidates, sampleCandidate{
+			doc: VisitReportListDoc{
+				Id:        model.Id,
+				Type:      model.Type,
+				Subject:   model.Subject,
+				VisitDate: model.VisitDate,
+				Contact:   model.Contact,
+			},
+			weight:    weight,
+			createdBy: model.CreatedBy,
+		})
+		return nil
+	})
+	if err != nil || params.Strategy != sampleNewReps {
+		return candidates, err
+	}
+	since := time.Now().Add(-currentCfg.SampleNewRepPeriod)
+	newReps := candidates[:0]
+	for _, c := range candidates {
+		if firstReports[c.createdBy].After(since) {
+			newReps = append(newReps, c)
+		}
+	}
+	return newReps, nil
+}
+
+// readSample returns a reproducible sample of reports for quality reviews,
+// drawn uniformly, weighted towards low sentiment or from new sales reps.
+func readSample(ctx iris.Context) {
+	params := SampleQuery{N: 50, Strategy: sampleRandom}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	if params.Seed == 0 {
+		// keep generated seeds within the integers a JavaScript number holds
+		params.Seed = time.Now().UnixNano()%(1<<53) + 1
+	}
+	candidates, err := sampleCandidates(ctx.Request().Context(), params)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	out := SampleDoc{
+		Strategy:   params.Strategy,
+		Seed:       params.Seed,
+		Population: len(candidates),
+		Items:      drawSample(candidates, params.N, params.Seed),
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
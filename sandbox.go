@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	headerSandboxLatency   = "X-Sandbox-Latency"
+	headerSandboxErrorRate = "X-Sandbox-Error-Rate"
+	// sandboxMaxLatency limits the simulated latency of a request
+	sandboxMaxLatency = 30 * time.Second
+)
+
+// sandboxContacts are the contacts of the seeded reports
+var sandboxContacts = []ContactDoc{
+	{Id: "5b0f3f8e-1d2c-4b7a-9e6f-000000000001", Firstname: "Ada", Lastname: "Lovelace", Company: "Analytical Engines"},
+	{Id: "5b0f3f8e-1d2c-4b7a-9e6f-000000000002", Firstname: "Grace", Lastname: "Hopper", Company: "Compilers Inc"},
+	{Id: "5b0f3f8e-1d2c-4b7a-9e6f-000000000003", Firstname: "Alan", Lastname: "Turing", Company: "Bletchley Systems"},
+	{Id: "5b0f3f8e-1d2c-4b7a-9e6f-000000000004", Firstname: "Edsger", Lastname: "Dijkstra", Company: "Shortest Path"},
+	{Id: "5b0f3f8e-1d2c-4b7a-9e6f-000000000005", Firstname: "Barbara", Lastname: "Liskov", Company: "Substitution Ltd"},
+}
+
+var sandboxSubjects = []string{"Kickoff", "Quarterly review", "Product demo", "Contract renewal", "Escalation"}
+
+var sandboxResults = []string{
+	"",
+	"The customer liked the demo and wants an offer for the premium plan.",
+	"No budget this year, the customer asked to be contacted again in spring.",
+	"Open questions on the integration, a follow-up with the architects is needed.",
+}
+
+// checkSandbox refuses VR_SANDBOX in production and with anything but the
+// memory repository, and seeds the reports. A sandbox instance lets frontend
+// developers build against realistic data without touching real data: the
+// reports are generated from VR_SANDBOXSEED, so every start has the same
+// ones, and events are not sent.
+func checkSandbox() {
+	if !currentCfg.Sandbox {
+		return
+	}
+	if currentCfg.Env == "production" {
+		log.Fatal("VR_SANDBOX must not be set with VR_ENV=production")
+	}
+	if currentCfg.Repository != "memory" {
+		log.Fatal("VR_SANDBOX requires VR_REPOSITORY=memory")
+	}
+	if currentCfg.SearchURL != "" {
+		log.Fatal("VR_SANDBOX must not be set with VR_SEARCHURL, the sandbox reports would be indexed")
+	}
+	if err := seedSandbox(context.Background(), currentCfg.SandboxSeed, currentCfg.SandboxReports); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Sandbox mode: seeded %d reports from seed %d, events are not sent\n", currentCfg.SandboxReports, currentCfg.SandboxSeed)
+}
+
+// seedSandbox creates n reports derived from the seed only, the ids, dates
+// and scores are the same for the same seed.
+func seedSandbox(ctx context.Context, seed int64, n int) error {
+	rng := rand.New(rand.NewSource(seed))
+	base := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		id, err := uuid.NewRandomFromReader(rng)
+		if err != nil {
+			return err
+		}
+		createdAt := base.AddDate(0, 0, rng.Intn(365))
+		model := &VisitReportModel{
+			Type:      "visitreport",
+			Subject:   sandboxSubjects[rng.Intn(len(sandboxSubjects))],
+			VisitDate: createdAt.Format("2006-01-02"),
+			Result:    sandboxResults[rng.Intn(len(sandboxResults))],
+			Contact:   sandboxContacts[rng.Intn(len(sandboxContacts))],
+			CreatedAt: &createdAt,
+			CreatedBy: "sandbox-rep-" + strconv.Itoa(1+rng.Intn(3)),
+		}
+		model.Id = id.String()
+		model.Description = "Visit of " + model.Contact.Company
+		if model.Result != "" {
+			model.VisitResultSentimentScore = float64(rng.Intn(101)) / 100
+		}
+		if _, err := currentRepo.Create(ctx, model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sandboxFaults simulates a slow or failing backend in sandbox mode.
+// X-Sandbox-Latency delays the request by a duration, e.g. "300ms", and
+// X-Sandbox-Error-Rate answers the given share of requests, 0 to 1, with
+// 503 Service Unavailable.
+func sandboxFaults(ctx iris.Context) {
+	if !currentCfg.Sandbox {
+		ctx.Next()
+		return
+	}
+	if v := ctx.GetHeader(headerSandboxLatency); v != "" {
+		latency, err := time.ParseDuration(v)
+		if err != nil || latency < 0 || latency > sandboxMaxLatency {
+			ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+				Title("Invalid sandbox latency").
+				Detail(fmt.Sprintf("%s must be a duration up to %s, e.g. 300ms", headerSandboxLatency, sandboxMaxLatency)))
+			return
+		}
+		select {
+		case <-time.After(latency):
+		case <-ctx.Request().Context().Done():
+			return
+		}
+	}
+	if v := ctx.GetHeader(headerSandboxErrorRate); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+				Title("Invalid sandbox error rate").
+				Detail(fmt.Sprintf("%s must be a number from 0 to 1", headerSandboxErrorRate)))
+			return
+		}
+		if rand.Float64() < rate {
+			ctx.StopWithProblem(iris.StatusServiceUnavailable, iris.NewProblem().
+				Title("Simulated error").
+				Detail(fmt.Sprintf("The request failed as requested by %s", headerSandboxErrorRate)))
+			return
+		}
+	}
+	ctx.Next()
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	searchAPIVersion = "2020-06-30"
+	searchBatchSize  = 1000
+)
+
+// SearchIndexDoc - struct for a report in the Azure Cognitive Search index
+type SearchIndexDoc struct {
+	Action                    string      `json:"@search.action"`
+	Id                        string      `json:"id"`
+	Number                    string      `json:"number,omitempty"`
+	Subject                   string      `json:"subject,omitempty"`
+	Description               string      `json:"description,omitempty"`
+	VisitDate                 string      `json:"visitDate,omitempty"`
+	VisitMonth                string      `json:"visitMonth,omitempty"`
+	Result                    string      `json:"result,omitempty"`
+	VisitResultSentimentScore float64     `json:"visitResultSentimentScore,omitempty"`
+	VisitResultKeyPhrases     []string    `json:"visitResultKeyPhrases,omitempty"`
+	Contact                   *ContactDoc `json:"contact,omitempty"`
+}
+
+type searchField struct {
+	Name       string        `json:"name"`
+	Type       string        `json:"type"`
+	Key        bool          `json:"key,omitempty"`
+	Searchable *bool         `json:"searchable,omitempty"`
+	Filterable *bool         `json:"filterable,omitempty"`
+	Sortable   *bool         `json:"sortable,omitempty"`
+	Facetable  *bool         `json:"facetable,omitempty"`
+	Fields     []searchField `json:"fields,omitempty"`
+}
+
+func searchFlag(b bool) *bool {
+	return &b
+}
+
+// searchIndexSchema describes the index the reports are pushed to.
+func searchIndexSchema() map[string]interface{} {
+	t, f := searchFlag(true), searchFlag(false)
+	return map[string]interface{}{
+		"name": currentCfg.SearchIndex,
+		"fields": []searchField{
+			{Name: "id", Type: "Edm.String", Key: true, Searchable: f, Filterable: t},
+			{Name: "number", Type: "Edm.String", Searchable: t, Filterable: t, Sortable: t},
+			{Name: "subject", Type: "Edm.String", Searchable: t, Sortable: t},
+			{Name: "description", Type: "Edm.String", Searchable: t},
+			{Name: "result", Type: "Edm.String", Searchable: t},
+			{Name: "visitDate", Type: "Edm.String", Searchable: f, Filterable: t, Sortable: t, Facetable: t},
+			{Name: "visitMonth", Type: "Edm.String", Searchable: f, Filterable: t, Facetable: t},
+			{Name: "visitResultSentimentScore", Type: "Edm.Double", Filterable: t, Sortable: t, Facetable: t},
+			{Name: "visitResultKeyPhrases", Type: "Collection(Edm.String)", Searchable: t, Filterable: t, Facetable: t},
+			{Name: "contact", Type: "Edm.ComplexType", Fields: []searchField{
+				{Name: "id", Type: "Edm.String", Searchable: f, Filterable: t},
+				{Name: "firstname", Type: "Edm.String", Searchable: t},
+				{Name: "lastname", Type: "Edm.String", Searchable: t},
+				{Name: "avatarLocation", Type: "Edm.String", Searchable: f},
+				{Name: "company", Type: "Edm.String", Searchable: t, Filterable: t, Facetable: t},
+			}},
+		},
+	}
+}
+
+var searchClient = &http.Client{Timeout: 30 * time.Second}
+
+func searchEnabled() bool {
+	return currentCfg.SearchURL != ""
+}
+
+func searchRequest(ctx context.Context, method, path string, body, out interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/indexes%s?api-version=%s", strings.TrimRight(currentCfg.SearchURL, "/"), path, searchAPIVersion)
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("api-key", currentCfg.SearchKey)
+	resp, err := searchClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("search request %s %s failed with %s: %s", method, path, resp.Status, msg)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// ensureSearchIndex creates the index or updates its schema.
+func ensureSearchIndex(ctx context.Context) error {
+	return searchRequest(ctx, http.MethodPut, "/"+currentCfg.SearchIndex, searchIndexSchema(), nil)
+}
+
+func pushToSearch(ctx context.Context, docs []SearchIndexDoc) error {
+	return searchRequest(ctx, http.MethodPost, "/"+currentCfg.SearchIndex+"/docs/index", map[string]interface{}{
+		"value": docs,
+	}, nil)
+}
+
+func searchDocFromModel(model *VisitReportModel) SearchIndexDoc {
+	contact := model.Contact
+	month := ""
+	if len(model.VisitDate) >= 7 {
+		month = model.VisitDate[:7]
+	}
+	return SearchIndexDoc{
+		Action:                    "mergeOrUpload",
+		Id:                        model.Id,
+		Number:                    model.Number,
+		Subject:                   model.Subject,
+		Description:               model.Description,
+		VisitDate:                 model.VisitDate,
+		VisitMonth:                month,
+		Result:                    model.Result,
+		VisitResultSentimentScore: model.VisitResultSentimentScore,
+		VisitResultKeyPhrases:     model.VisitResultKeyPhrases,
+		Contact:                   &contact,
+	}
+}
+
+// indexReportInBg pushes a created or changed report to the search index.
+func indexReportInBg(model VisitReportModel) {
+	if !searchEnabled() || model.Deleted {
+		// deleted reports were removed from the index
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := pushToSearch(ctx, []SearchIndexDoc{searchDocFromModel(&model)}); err != nil {
+			err = errors.WithStack(err)
+			fmt.Println(err)
+		}
+	}()
+}
+
+// removeReportFromIndexInBg deletes a report from the search index.
+func removeReportFromIndexInBg(id string) {
+	if !searchEnabled() {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := pushToSearch(ctx, []SearchIndexDoc{{Action: "delete", Id: id}}); err != nil {
+			err = errors.WithStack(err)
+			fmt.Println(err)
+		}
+	}()
+}
+
+// reindexSearch creates or updates the index schema and pushes all reports in batches.
+func reindexSearch(args []string) error {
+	if !searchEnabled() {
+		return fmt.Errorf("search is not configured, set VR_SEARCHURL and VR_SEARCHKEY")
+	}
+	ctx := context.Background()
+	if err := ensureSearchIndex(ctx); err != nil {
+		return err
+	}
+
+	qry := cosmosapi.Query{
+		Query: "SELECT * FROM c WHERE c.type = 'visitreport' AND " + notDeleted,
+	}
+	total := 0
+	charges, err := queryAllPartitions(ctx, currentCfg.ReportContainer, qry, searchBatchSize, func() interface{} {
+		return &[]VisitReportModel{}
+	}, func(page interface{}) error {
+		docs := *page.(*[]VisitReportModel)
+		batch := make([]SearchIndexDoc, 0, len(docs))
+		for i := range docs {
+			batch = append(batch, searchDocFromModel(&docs[i]))
+		}
+		if len(batch) > 0 {
+			if err := pushToSearch(ctx, batch); err != nil {
+				return err
+			}
+		}
+		total += len(batch)
+		fmt.Printf("Indexed %d reports\n", total)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logPartitionCharges(charges)
+	return nil
+}
+
+// searchFacets maps the facet names returned to clients to Azure Cognitive Search facet expressions.
+var searchFacets = map[string]string{
+	"company":    "contact/company,count:20",
+	"month":      "visitMonth,count:24,sort:-value",
+	"sentiment":  "visitResultSentimentScore,values:0.25|0.5|0.75",
+	"keyPhrases": "visitResultKeyPhrases,count:20",
+}
+
+// SearchFacetDoc - struct for a facet bucket, either a value or a range
+type SearchFacetDoc struct {
+	Value interface{} `json:"value,omitempty"`
+	From  *float64    `json:"from,omitempty"`
+	To    *float64    `json:"to,omitempty"`
+	Count int         `json:"count"`
+}
+
+// SearchHitDoc - struct for a search hit, ranked by score. Highlights has
+// fragments of the subject, description and result with the matched terms
+// enclosed in <em> tags.
+type SearchHitDoc struct {
+	VisitReportListDoc
+	Score      float64             `json:"score"`
+	Highlights map[string][]string `json:"highlights,omitempty"`
+}
+
+// SearchResultDoc - struct for search results
+type SearchResultDoc struct {
+	Count  int                         `json:"count"`
+	Hits   []SearchHitDoc              `json:"hits"`
+	Facets map[string][]SearchFacetDoc `json:"facets"`
+}
+
+type searchHit struct {
+	VisitReportListDoc
+	Score      float64             `json:"@search.score"`
+	Highlights map[string][]string `json:"@search.highlights"`
+}
+
+type searchResponse struct {
+	Count  int                         `json:"@odata.count"`
+	Facets map[string][]SearchFacetDoc `json:"@search.facets"`
+	Value  []searchHit                 `json:"value"`
+}
+
+// searchReports searches the subject, description and result of the
+// reports. Without a search index it falls back to a case-insensitive
+// search for the words of the query, without facets.
+func searchReports(ctx iris.Context) {
+	params := SearchQuery{Q: "*", Top: 50}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	if !searchEnabled() {
+		out, err := searchReportsByText(ctx.Request().Context(), params)
+		if err != nil {
+			stopWithError(ctx, err)
+			return
+		}
+		ctx.StatusCode(http.StatusOK)
+		ctx.JSON(normalize(out))
+		return
+	}
+
+	facetNames := make(map[string]string, len(searchFacets))
+	facets := make([]string, 0, len(searchFacets))
+	for name, expr := range searchFacets {
+		facetNames[strings.SplitN(expr, ",", 2)[0]] = name
+		facets = append(facets, expr)
+	}
+	body := map[string]interface{}{
+		"search": params.Q,
+		"count":  true,
+		"top":    params.Top,
+		"skip":   params.Skip,
+		"select": "id,number,subject,visitDate,contact",
+		"facets": facets,
+	}
+	if len(searchTerms(params.Q)) > 0 {
+		body["highlight"] = "subject,description,result"
+		body["highlightPreTag"] = highlightPreTag
+		body["highlightPostTag"] = highlightPostTag
+	}
+
+	var res searchResponse
+	if err := searchRequest(ctx.Request().Context(), http.MethodPost, "/"+currentCfg.SearchIndex+"/docs/search", body, &res); err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+
+	out := SearchResultDoc{
+		Count:  res.Count,
+		Hits:   make([]SearchHitDoc, 0, len(res.Value)),
+		Facets: make(map[string][]SearchFacetDoc, len(res.Facets)),
+	}
+	for _, hit := range res.Value {
+		hit.Type = "visitreport"
+		out.Hits = append(out.Hits, SearchHitDoc{
+			VisitReportListDoc: hit.VisitReportListDoc,
+			Score:              hit.Score,
+			Highlights:         hit.Highlights,
+		})
+	}
+	for field, buckets := range res.Facets {
+		out.Facets[facetNames[field]] = buckets
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
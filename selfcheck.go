@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/cdennig/visitreports/client"
+	"github.com/google/uuid"
+)
+
+type selfcheckStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// selfcheck runs an end-to-end smoke test against a deployed API, e.g.
+// "visitreports selfcheck -url https://visitreports.example.com", and
+// fails if any step fails. The synthetic report is always deleted. The
+// loopback step needs a Service Bus connection string with manage rights,
+// as it creates a temporary subscription on the report topic.
+func selfcheck(args []string) error {
+	fs := flag.NewFlagSet("selfcheck", flag.ContinueOnError)
+	baseURL := fs.String("url", "http://localhost:3000", "base URL of the API to check")
+	token := fs.String("token", "", "bearer token sent with every request")
+	caller := fs.String("caller", "", "HMAC caller name, see VR_HMACSECRETS")
+	secret := fs.String("secret", "", "HMAC secret of the caller")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout per step")
+	skipEvents := fs.Bool("skip-events", false, "skip the loopback event check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var opts []client.Option
+	if *token != "" {
+		opts = append(opts, client.WithStaticToken(*token))
+	}
+	if *caller != "" {
+		opts = append(opts, client.WithHMACSigning(*caller, *secret))
+	}
+	api := client.New(*baseURL, opts...)
+
+	contact := client.Contact{
+		ID:        uuid.New().String(),
+		Firstname: "Self",
+		Lastname:  "Check",
+		Company:   "selfcheck",
+	}
+	var report *client.VisitReport
+	var events *selfcheckSubscription
+	if !*skipEvents {
+		var err error
+		events, err = newSelfcheckSubscription(context.Background())
+		if err != nil {
+			return fmt.Errorf("setting up loopback subscription: %v", err)
+		}
+		defer events.close()
+	}
+
+	steps := []selfcheckStep{
+		{"create", func(ctx context.Context) (err error) {
+			report, err = api.CreateReport(ctx, client.CreateVisitReport{
+				Subject:     "selfcheck " + time.Now().UTC().Format(time.RFC3339),
+				Description: "Synthetic report of the post-deploy self check",
+				VisitDate:   time.Now().UTC().Format("2006-01-02"),
+				Contact:     contact,
+			})
+			return
+		}},
+		{"read", func(ctx context.Context) error {
+			got, err := api.GetReport(ctx, report.ID)
+			if err == nil && got.Subject != report.Subject {
+				err = fmt.Errorf("read subject %q, want %q", got.Subject, report.Subject)
+			}
+			return err
+		}},
+		{"update", func(ctx context.Context) error {
+			_, err := api.UpdateReport(ctx, client.UpdateVisitReport{
+				ID:          report.ID,
+				Subject:     report.Subject,
+				Description: report.Description,
+				Result:      "Self check completed successfully",
+				VisitDate:   report.VisitDate,
+				Contact:     contact,
+			})
+			return err
+		}},
+		{"stats", func(ctx context.Context) error {
+			stats, err := api.StatsByContact(ctx, contact.ID)
+			if err == nil && (len(stats) != 1 || stats[0].CountScore != 1) {
+				err = fmt.Errorf("expected 1 visit in the contact's stats, got %+v", stats)
+			}
+			return err
+		}},
+	}
+	if events != nil {
+		steps = append(steps, selfcheckStep{"event", func(ctx context.Context) error {
+			return events.await(ctx, report.ID)
+		}})
+	}
+
+	failed := 0
+	for _, step := range steps {
+		if report == nil && step.name != "create" {
+			fmt.Printf("SKIP %s\n", step.name)
+			failed++
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		start := time.Now()
+		err := step.run(ctx)
+		cancel()
+		if err != nil {
+			fmt.Printf("FAIL %s (%s): %v\n", step.name, time.Since(start).Round(time.Millisecond), err)
+			failed++
+			continue
+		}
+		fmt.Printf("PASS %s (%s)\n", step.name, time.Since(start).Round(time.Millisecond))
+	}
+
+	if report != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		start := time.Now()
+		if err := api.DeleteReport(ctx, report.ID); err != nil {
+			fmt.Printf("FAIL delete (%s): %v\n", time.Since(start).Round(time.Millisecond), err)
+			failed++
+		} else {
+			fmt.Printf("PASS delete (%s)\n", time.Since(start).Round(time.Millisecond))
+		}
+		cancel()
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("selfcheck failed: %d of %d steps did not pass", failed, len(steps)+1)
+	}
+	fmt.Println("selfcheck passed")
+	return nil
+}
+
+// selfcheckSubscription is a temporary subscription on the report topic that
+// receives the events of the synthetic report.
+type selfcheckSubscription struct {
+	manager *servicebus.SubscriptionManager
+	sub     *servicebus.Subscription
+	name    string
+}
+
+func newSelfcheckSubscription(ctx context.Context) (*selfcheckSubscription, error) {
+	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(currentCfg.SbConnStrVisitReport))
+	if err != nil {
+		return nil, err
+	}
+	topic, err := ns.NewTopic(currentCfg.ReportTopic)
+	if err != nil {
+		return nil, err
+	}
+	name := "selfcheck-" + uuid.New().String()[:8]
+	manager := topic.NewSubscriptionManager()
+	// removed by Service Bus even if the check crashes before close
+	idle := 5 * time.Minute
+	if _, err := manager.Put(ctx, name, servicebus.SubscriptionWithAutoDeleteOnIdle(&idle)); err != nil {
+		return nil, err
+	}
+	sub, err := topic.NewSubscription(name)
+	if err != nil {
+		manager.Delete(ctx, name)
+		return nil, err
+	}
+	return &selfcheckSubscription{manager: manager, sub: sub, name: name}, nil
+}
+
+// await waits for an event of the report.
+func (s *selfcheckSubscription) await(ctx context.Context, reportid string) error {
+	for {
+		found := false
+		err := s.sub.ReceiveOne(ctx, servicebus.HandlerFunc(func(c context.Context, m *servicebus.Message) error {
+			doc := VisitReportEventDoc{}
+			if json.Unmarshal(eventData(m.Data), &doc) == nil && doc.Id == reportid {
+				found = true
+			}
+			return m.Complete(c)
+		}))
+		if err != nil {
+			return err
+		}
+		if found {
+			return nil
+		}
+	}
+}
+
+func (s *selfcheckSubscription) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s.sub.Close(ctx)
+	if err := s.manager.Delete(ctx, s.name); err != nil {
+		fmt.Printf("Could not delete subscription %s: %v\n", s.name, err)
+	}
+}
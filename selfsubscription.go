@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+)
+
+// eventTypeProperty is the user property of published events that
+// subscriptions filter on
+const eventTypeProperty = "eventType"
+
+// selfSubscriptionRule prefixes the rules of VR_SELFSUBSCRIPTION managed by
+// the service, other rules of the subscription are left alone
+const selfSubscriptionRule = "visitreports-events"
+
+// defaultRule is the rule Service Bus adds to new subscriptions, it lets
+// every message through
+const defaultRule = "$Default"
+
+// setEventTypeProperty copies the eventType of an event into the user
+// properties, SQL filters can't look into the body. Messages replayed from
+// the outbox only have their body, so it's done right before sending, from
+// the data of those sent as CloudEvents.
+func setEventTypeProperty(msg *servicebus.Message) {
+	if _, ok := msg.UserProperties[eventTypeProperty]; ok {
+		return
+	}
+	var event struct {
+		EventType string `json:"eventType"`
+	}
+	if json.Unmarshal(eventData(msg.Data), &event) != nil || event.EventType == "" {
+		return
+	}
+	if msg.UserProperties == nil {
+		msg.UserProperties = map[string]interface{}{}
+	}
+	msg.UserProperties[eventTypeProperty] = event.EventType
+}
+
+// selfSubscriptionFilter returns the SQL filter of the event types, all
+// events without event types.
+func selfSubscriptionFilter(eventTypes []string) string {
+	if len(eventTypes) == 0 {
+		return "1=1"
+	}
+	quoted := make([]string, len(eventTypes))
+	for i, t := range eventTypes {
+		quoted[i] = "'" + strings.ReplaceAll(t, "'", "''") + "'"
+	}
+	return fmt.Sprintf("%s IN (%s)", eventTypeProperty, strings.Join(quoted, ", "))
+}
+
+// ensureSelfSubscription creates the subscription of VR_SELFSUBSCRIPTION
+// with a rule for VR_SELFSUBSCRIPTIONEVENTS, or brings the rules of an
+// existing one up to date. A rule can't be changed in place, so the rule
+// name is derived from the filter and the new rule is added before the old
+// ones are removed, no event is missed while the event types change. Only
+// the rules of the service and $Default, which would let every event
+// through, are removed, rules added by operators stay.
+func ensureSelfSubscription(ctx context.Context, topic *servicebus.Topic, name string, eventTypes []string) error {
+	filter := servicebus.SQLFilter{Expression: selfSubscriptionFilter(eventTypes)}
+	h := sha256.Sum256([]byte(filter.Expression))
+	rule := selfSubscriptionRule + "-" + hex.EncodeToString(h[:4])
+
+	manager := topic.NewSubscriptionManager()
+	_, err := manager.Get(ctx, name)
+	if servicebus.IsErrNotFound(err) {
+		if _, err := manager.Put(ctx, name, servicebus.SubscriptionWithDefaultRuleDescription(filter, rule)); err != nil {
+			return err
+		}
+		fmt.Printf("Created subscription %s with filter %s\n", name, filter.Expression)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rules, err := manager.ListRules(ctx, name)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, r := range rules {
+		found = found || (r.Entity != nil && r.Name == rule)
+	}
+	if !found {
+		if _, err := manager.PutRule(ctx, name, rule, filter); err != nil {
+			return err
+		}
+		fmt.Printf("Updated subscription %s to filter %s\n", name, filter.Expression)
+	}
+	for _, r := range rules {
+		if r.Entity == nil || r.Name == rule {
+			continue
+		}
+		if r.Name != defaultRule && !strings.HasPrefix(r.Name, selfSubscriptionRule) {
+			continue
+		}
+		if err := manager.DeleteRule(ctx, name, r.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setupSelfSubscription() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return ensureSelfSubscription(ctx, currentTopic, currentCfg.SelfSubscription, currentCfg.SelfSubscriptionEvents)
+}
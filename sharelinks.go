@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/copier"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	// shareScopeFull grants the whole report, shareScopeSummary only what is
+	// needed to see that a visit took place
+	shareScopeFull    = "full"
+	shareScopeSummary = "summary"
+
+	// shareMaxAccesses is the number of accesses kept in the audit of a link
+	shareMaxAccesses = 100
+)
+
+// ShareLinkCreateDoc - struct for creating a share link
+type ShareLinkCreateDoc struct {
+	Scope     string `json:"scope" validate:"required,oneof=full summary"`
+	ExpiresIn string `json:"expiresIn" validate:"required"`
+}
+
+// ShareLinkAccessDoc - an access to a report through a share link
+type ShareLinkAccessDoc struct {
+	At        time.Time `json:"at"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+}
+
+// ShareLinkDoc - expiring read-only link to a single report. Links are stored
+// in the "sharelinks" container, partitioned by type.
+type ShareLinkDoc struct {
+	cosmosapi.Document
+	Type        string               `json:"type"`
+	ReportId    string               `json:"reportId"`
+	Scope       string               `json:"scope"`
+	CreatedBy   string               `json:"createdBy"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	ExpiresAt   time.Time            `json:"expiresAt"`
+	RevokedAt   *time.Time           `json:"revokedAt,omitempty"`
+	AccessCount int                  `json:"accessCount"`
+	Accesses    []ShareLinkAccessDoc `json:"accesses"`
+}
+
+// ShareLinkReadDoc - struct for reading a share link, URL is only set when
+// the link is created
+type ShareLinkReadDoc struct {
+	Id          string               `json:"id"`
+	ReportId    string               `json:"reportId"`
+	Scope       string               `json:"scope"`
+	URL         string               `json:"url,omitempty"`
+	CreatedBy   string               `json:"createdBy"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	ExpiresAt   time.Time            `json:"expiresAt"`
+	RevokedAt   *time.Time           `json:"revokedAt,omitempty"`
+	AccessCount int                  `json:"accessCount"`
+	Accesses    []ShareLinkAccessDoc `json:"accesses"`
+}
+
+// SharedReportSummaryDoc - the report as seen through a summary link
+type SharedReportSummaryDoc struct {
+	Id        string      `json:"id"`
+	Subject   string      `json:"subject"`
+	VisitDate string      `json:"visitDate"`
+	Outcome   *OutcomeDoc `json:"outcome,omitempty"`
+	Company   string      `json:"company"`
+}
+
+// shareToken returns the token of a link: "<linkid>.<expires>.<signature>",
+// signed with VR_SHARELINKSECRET so forged or extended links are rejected
+// before any lookup.
+func shareToken(linkid string, expires time.Time) string {
+	payload := linkid + "." + strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(currentCfg.ShareLinkSecret))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseShareToken returns the link id of a valid, unexpired token.
+func parseShareToken(token string) (string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", false
+	}
+	expected := shareToken(parts[0], time.Unix(expires, 0))
+	if !hmac.Equal([]byte(token), []byte(expected)) {
+		return "", false
+	}
+	return parts[0], true
+}
+
+func shareLinksEnabled(ctx iris.Context) bool {
+	if currentCfg.ShareLinkSecret == "" {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func createShareLink(ctx iris.Context) {
+	if !shareLinksEnabled(ctx) {
+		return
+	}
+	reportid := ctx.Params().GetString("reportid")
+	in := ShareLinkCreateDoc{}
+	if err := ctx.ReadJSON(&in); err != nil {
+		handleBindError(ctx, err)
+		return
+	}
+	ttl, err := time.ParseDuration(in.ExpiresIn)
+	if err != nil || ttl <= 0 || ttl > currentCfg.ShareLinkMaxTTL {
+		ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+			Title("Invalid expiry").
+			Detail(fmt.Sprintf("expiresIn must be a positive Go duration of at most %s", currentCfg.ShareLinkMaxTTL)))
+		return
+	}
+
+	reqCtx := ctx.Request().Context()
+	if _, err := currentRepo.Get(reqCtx, reportid); err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	link := ShareLinkDoc{
+		Type:      "sharelink",
+		ReportId:  reportid,
+		Scope:     in.Scope,
+		CreatedBy: consumerOf(ctx),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl).Truncate(time.Second),
+		Accesses:  []ShareLinkAccessDoc{},
+	}
+	link.Id = uuid.New().String()
+	ops := cosmosapi.CreateDocumentOptions{
+		PartitionKeyValue: "sharelink",
+	}
+	if _, _, err := currentClient.CreateDocument(reqCtx, currentCfg.DbName, "sharelinks", link, ops); err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	fmt.Printf("Share link %s for report %s (%s, until %s) created by %s\n", link.Id, reportid, link.Scope, link.ExpiresAt.Format(time.RFC3339), link.CreatedBy)
+
+	out := ShareLinkReadDoc{}
+	copier.Copy(&out, &link)
+	out.URL = ctx.AbsoluteURI("/shared/" + shareToken(link.Id, link.ExpiresAt))
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(normalize(out))
+}
+
+func queryShareLinks(ctx iris.Context, reportid string) ([]ShareLinkDoc, bool) {
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "sharelink"
+	qry := cosmosapi.Query{
+		Query: "SELECT * FROM c WHERE c.type = 'sharelink' AND c.reportId = @reportid ORDER BY c.createdAt DESC",
+		Params: []cosmosapi.QueryParam{
+			{Name: "@reportid", Value: reportid},
+		},
+	}
+	var links []ShareLinkDoc
+	_, err := currentClient.QueryDocuments(ctx.Request().Context(), currentCfg.DbName, "sharelinks", qry, &links, qops)
+	if err != nil {
+		stopWithError(ctx, err)
+		return nil, false
+	}
+	return links, true
+}
+
+// listShareLinks lists the links of a report including their access audit.
+func listShareLinks(ctx iris.Context) {
+	if !shareLinksEnabled(ctx) {
+		return
+	}
+	links, ok := queryShareLinks(ctx, ctx.Params().GetString("reportid"))
+	if !ok {
+		return
+	}
+	out := []ShareLinkReadDoc{}
+	copier.Copy(&out, &links)
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
+
+func revokeShareLink(ctx iris.Context) {
+	if !shareLinksEnabled(ctx) {
+		return
+	}
+	reportid := ctx.Params().GetString("reportid")
+	linkid := ctx.Params().GetString("linkid")
+	reqCtx := ctx.Request().Context()
+	ro := cosmosapi.GetDocumentOptions{
+		PartitionKeyValue: "sharelink",
+	}
+	link := ShareLinkDoc{}
+	_, err := currentClient.GetDocument(reqCtx, currentCfg.DbName, "sharelinks", linkid, ro, &link)
+	if err == cosmosapi.ErrNotFound || (err == nil && link.ReportId != reportid) {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	if err == nil && link.RevokedAt == nil {
+		now := time.Now().UTC()
+		link.RevokedAt = &now
+		ops := cosmosapi.ReplaceDocumentOptions{
+			PartitionKeyValue: "sharelink",
+			IfMatch:           link.Etag,
+		}
+		_, _, err = currentClient.ReplaceDocument(reqCtx, currentCfg.DbName, "sharelinks", linkid, link, ops)
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	fmt.Printf("Share link %s for report %s revoked by %s\n", linkid, reportid, consumerOf(ctx))
+	ctx.StatusCode(http.StatusNoContent)
+}
+
+// readSharedReport serves GET /shared/{token}. The token is the only
+// credential, so every access is recorded on the link.
+func readSharedReport(ctx iris.Context) {
+	if !shareLinksEnabled(ctx) {
+		return
+	}
+	linkid, ok := parseShareToken(ctx.Params().GetString("token"))
+	if !ok {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	reqCtx := ctx.Request().Context()
+	link := ShareLinkDoc{}
+	_, err := currentClient.GetDocument(reqCtx, currentCfg.DbName, "sharelinks", linkid, cosmosapi.GetDocumentOptions{
+		PartitionKeyValue: "sharelink",
+	}, &link)
+	if err == cosmosapi.ErrNotFound || (err == nil && link.RevokedAt != nil) {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	var report *VisitReportModel
+	if err == nil {
+		report, err = currentRepo.Get(reqCtx, link.ReportId)
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+
+	link.AccessCount++
+	link.Accesses = append(link.Accesses, ShareLinkAccessDoc{
+		At:        time.Now().UTC(),
+		IP:        clientIP(ctx),
+		UserAgent: ctx.GetHeader("User-Agent"),
+	})
+	if len(link.Accesses) > shareMaxAccesses {
+		link.Accesses = link.Accesses[len(link.Accesses)-shareMaxAccesses:]
+	}
+	ops := cosmosapi.ReplaceDocumentOptions{
+		PartitionKeyValue: "sharelink",
+		IfMatch:           link.Etag,
+	}
+	if _, _, err := currentClient.ReplaceDocument(reqCtx, currentCfg.DbName, "sharelinks", linkid, link, ops); err != nil {
+		// concurrent accesses may lose an audit entry, the log keeps it
+		err = errors.WithStack(err)
+		fmt.Println(err)
+	}
+	fmt.Printf("Share link %s for report %s accessed by %s\n", linkid, link.ReportId, clientIP(ctx))
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.StatusCode(http.StatusOK)
+	if link.Scope == shareScopeSummary {
+		ctx.JSON(normalize(SharedReportSummaryDoc{
+			Id:        report.Id,
+			Subject:   report.Subject,
+			VisitDate: report.VisitDate,
+			Outcome:   report.Outcome,
+			Company:   report.Contact.Company,
+		}))
+		return
+	}
+	out := VisitReportReadDoc{}
+	copier.Copy(&out, report)
+	ctx.JSON(normalize(out))
+}
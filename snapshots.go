@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/copier"
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	snapshotKindHeader = "header"
+	snapshotKindPage   = "page"
+
+	// snapshotPageSize is the number of reports per page document, it keeps
+	// the documents well below the item size limit of Cosmos DB
+	snapshotPageSize = 100
+)
+
+// SnapshotFilterDoc - selects the reports of a snapshot
+type SnapshotFilterDoc struct {
+	ContactID string `json:"contactId,omitempty" validate:"omitempty,uuid"`
+	Company   string `json:"company,omitempty" validate:"max=100"`
+	From      string `json:"from,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	To        string `json:"to,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	Outcome   string `json:"outcome,omitempty" validate:"omitempty,outcome"`
+}
+
+// SnapshotCreateDoc - struct for creating a snapshot
+type SnapshotCreateDoc struct {
+	Name   string            `json:"name" validate:"required,max=100"`
+	Filter SnapshotFilterDoc `json:"filter"`
+}
+
+// SnapshotDoc - immutable point-in-time copy of the reports matching a filter.
+// Snapshots are stored in the "snapshots" container, partitioned by type: a
+// header document and the reports in page documents. The header is written
+// last, so only complete snapshots are visible.
+type SnapshotDoc struct {
+	Id          string            `json:"id"`
+	Type        string            `json:"type"`
+	Kind        string            `json:"kind"`
+	Name        string            `json:"name"`
+	Filter      SnapshotFilterDoc `json:"filter"`
+	TakenAt     time.Time         `json:"takenAt"`
+	TakenBy     string            `json:"takenBy"`
+	ReportCount int               `json:"reportCount"`
+	Pages       int               `json:"pages"`
+}
+
+// SnapshotPageDoc - a page of the reports of a snapshot
+type SnapshotPageDoc struct {
+	Id         string               `json:"id"`
+	Type       string               `json:"type"`
+	Kind       string               `json:"kind"`
+	SnapshotId string               `json:"snapshotId"`
+	Page       int                  `json:"page"`
+	Reports    []VisitReportReadDoc `json:"reports"`
+}
+
+// SnapshotReadDoc - a snapshot with its reports
+type SnapshotReadDoc struct {
+	SnapshotDoc
+	Reports []VisitReportReadDoc `json:"reports"`
+}
+
+// SnapshotIDParam - path parameter of the snapshot endpoints
+type SnapshotIDParam struct {
+	SnapshotID string `validate:"required,uuid"`
+}
+
+func filterQuery(filter SnapshotFilterDoc) cosmosapi.Query {
+	conditions := []string{"c.type = 'visitreport'", notDeleted}
+	params := []cosmosapi.QueryParam{}
+	if filter.ContactID != "" {
+		conditions = append(conditions, "c.contact.id = @contactid")
+		params = append(params, cosmosapi.QueryParam{Name: "@contactid", Value: filter.ContactID})
+	}
+	if filter.Company != "" {
+		conditions = append(conditions, "c.contact.company = @company")
+		params = append(params, cosmosapi.QueryParam{Name: "@company", Value: filter.Company})
+	}
+	if filter.From != "" {
+		conditions = append(conditions, "c.visitDate >= @from")
+		params = append(params, cosmosapi.QueryParam{Name: "@from", Value: filter.From})
+	}
+	if filter.To != "" {
+		// visit dates may carry a time part, so compare against the next day
+		conditions = append(conditions, "c.visitDate < @to")
+		params = append(params, cosmosapi.QueryParam{Name: "@to", Value: nextDay(filter.To)})
+	}
+	if filter.Outcome != "" {
+		conditions = append(conditions, "c.outcome.code = @outcome")
+		params = append(params, cosmosapi.QueryParam{Name: "@outcome", Value: filter.Outcome})
+	}
+	return cosmosapi.Query{
+		Query:  "SELECT * FROM c WHERE " + strings.Join(conditions, " AND "),
+		Params: params,
+	}
+}
+
+func createSnapshot(ctx iris.Context) {
+	in := SnapshotCreateDoc{}
+	if err := ctx.ReadJSON(&in); err != nil {
+		handleBindError(ctx, err)
+		return
+	}
+
+	snapshot := SnapshotDoc{
+		Id:      uuid.New().String(),
+		Type:    "snapshot",
+		Kind:    snapshotKindHeader,
+		Name:    in.Name,
+		Filter:  in.Filter,
+		TakenAt: time.Now().UTC(),
+		TakenBy: consumerOf(ctx),
+	}
+	ops := cosmosapi.CreateDocumentOptions{
+		PartitionKeyValue: "snapshot",
+	}
+	reqCtx := ctx.Request().Context()
+
+	// pages are filled from the partition pages, which arrive in no
+	// particular order and size
+	page := SnapshotPageDoc{Type: "snapshot", Kind: snapshotKindPage, SnapshotId: snapshot.Id}
+	flush := func() error {
+		if len(page.Reports) == 0 {
+			return nil
+		}
+		page.Id = fmt.Sprintf("%s-%d", snapshot.Id, snapshot.Pages)
+		page.Page = snapshot.Pages
+		if _, _, err := currentClient.CreateDocument(reqCtx, currentCfg.DbName, "snapshots", page, ops); err != nil {
+			return err
+		}
+		snapshot.Pages++
+		page.Reports = nil
+		return nil
+	}
+	charges, err := queryAllPartitions(reqCtx, currentCfg.ReportContainer, filterQuery(in.Filter), 1000, func() interface{} {
+		return &[]VisitReportModel{}
+	}, func(res interface{}) error {
+		for _, model := range *res.(*[]VisitReportModel) {
+			doc := VisitReportReadDoc{}
+			copier.Copy(&doc, &model)
+			page.Reports = append(page.Reports, doc)
+			snapshot.ReportCount++
+			if len(page.Reports) == snapshotPageSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		_, _, err = currentClient.CreateDocument(reqCtx, currentCfg.DbName, "snapshots", snapshot, ops)
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	logPartitionCharges(charges)
+	fmt.Printf("Snapshot %s %q of %d reports taken by %s\n", snapshot.Id, snapshot.Name, snapshot.ReportCount, snapshot.TakenBy)
+
+	ctx.Header("Location", "/snapshots/"+snapshot.Id)
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(normalize(snapshot))
+}
+
+func listSnapshots(ctx iris.Context) {
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "snapshot"
+	qry := cosmosapi.Query{
+		Query: "SELECT * FROM c WHERE c.type = 'snapshot' AND c.kind = @kind ORDER BY c.takenAt DESC",
+		Params: []cosmosapi.QueryParam{
+			{Name: "@kind", Value: snapshotKindHeader},
+		},
+	}
+	docs := []SnapshotDoc{}
+	_, err := currentClient.QueryDocuments(ctx.Request().Context(), currentCfg.DbName, "snapshots", qry, &docs, qops)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(docs))
+}
+
+func readSnapshot(ctx iris.Context) {
+	snapshotid := ctx.Params().GetString("snapshotid")
+	if !bindValidated(ctx, &SnapshotIDParam{SnapshotID: snapshotid}) {
+		return
+	}
+	reqCtx := ctx.Request().Context()
+	ro := cosmosapi.GetDocumentOptions{
+		PartitionKeyValue: "snapshot",
+	}
+	out := SnapshotReadDoc{Reports: []VisitReportReadDoc{}}
+	_, err := currentClient.GetDocument(reqCtx, currentCfg.DbName, "snapshots", snapshotid, ro, &out.SnapshotDoc)
+	if err == cosmosapi.ErrNotFound {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	if err == nil && out.Kind != snapshotKindHeader {
+		ctx.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "snapshot"
+	qry := cosmosapi.Query{
+		Query: "SELECT * FROM c WHERE c.type = 'snapshot' AND c.kind = @kind AND c.snapshotId = @snapshotid ORDER BY c.page",
+		Params: []cosmosapi.QueryParam{
+			{Name: "@kind", Value: snapshotKindPage},
+			{Name: "@snapshotid", Value: snapshotid},
+		},
+	}
+	for err == nil {
+		pages := []SnapshotPageDoc{}
+		var res cosmosapi.QueryDocumentsResponse
+		res, err = currentClient.QueryDocuments(reqCtx, currentCfg.DbName, "snapshots", qry, &pages, qops)
+		if err != nil {
+			break
+		}
+		for _, page := range pages {
+			out.Reports = append(out.Reports, page.Reports...)
+		}
+		if res.Continuation == "" {
+			break
+		}
+		qops.Continuation = res.Continuation
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/kataras/iris/v12"
+)
+
+// notDeleted is the condition excluding soft-deleted reports from queries,
+// the deleted flag is only stored on deleted reports
+const notDeleted = "NOT IS_DEFINED(c.deleted)"
+
+// softDelete marks a report as deleted by the caller. It keeps the etag of
+// the model, so a report changed in the meantime is not deleted.
+func softDelete(ctx context.Context, model *VisitReportModel, by string) error {
+	now := time.Now().UTC()
+	model.Deleted = true
+	model.DeletedAt = &now
+	model.DeletedBy = by
+	_, err := currentRepo.Update(ctx, model)
+	return err
+}
+
+// restoreReport brings back a soft-deleted report, e.g. one deleted by
+// mistake. Restoring a report that is not deleted returns it unchanged.
+func restoreReport(ctx iris.Context) {
+	reqCtx := ctx.Request().Context()
+	reportid := ctx.Params().GetString("reportid")
+	model, err := currentRepo.GetDeleted(reqCtx, reportid)
+	if err == ErrReportNotFound {
+		model, err = currentRepo.Get(reqCtx, reportid)
+	} else if err == nil {
+		deletedAt := model.DeletedAt
+		model.Deleted = false
+		model.DeletedAt = nil
+		model.DeletedBy = ""
+		var etag string
+		if etag, err = currentRepo.Update(reqCtx, model); err == nil {
+			ctx.Header("ETag", etagHeader(etag))
+			indexReportInBg(*model)
+			fmt.Printf("Report %s deleted at %v restored by %s\n", reportid, deletedAt, ctx.Values().GetString(ctxKeyCaller))
+		}
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	out := VisitReportReadDoc{}
+	copier.Copy(&out, model)
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
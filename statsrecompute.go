@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	statsScopeContact  = "contact"
+	statsScopeOverall  = "overall"
+	statsScopeTimeline = "timeline"
+
+	statsSourceQuery     = "query"
+	statsSourceDashboard = "dashboard"
+
+	// statsTolerance - smaller differences of scores are rounding, not drift
+	statsTolerance = 1e-9
+)
+
+// StatsRecomputeQuery - query parameters of POST /stats/recompute of the
+// admin app. ContactID limits the contact scope to a contact, From and To
+// limit the timeline scope to an inclusive range of visit dates.
+type StatsRecomputeQuery struct {
+	Scope     string `url:"scope" validate:"required,oneof=contact overall timeline"`
+	ContactID string `url:"contactid"`
+	From      string `url:"from" validate:"omitempty,datetime=2006-01-02"`
+	To        string `url:"to" validate:"omitempty,datetime=2006-01-02"`
+	Repair    bool   `url:"repair"`
+}
+
+// StatsDiscrepancyDoc - a stats value that differs from the value recomputed
+// from the reports. Source is "query" for the aggregate the stats endpoints
+// run and "dashboard" for the cached dashboard. Key is the contact id or
+// the visit date.
+type StatsDiscrepancyDoc struct {
+	Source     string  `json:"source"`
+	Key        string  `json:"key,omitempty"`
+	Field      string  `json:"field"`
+	Projected  float64 `json:"projected"`
+	Recomputed float64 `json:"recomputed"`
+	Repaired   bool    `json:"repaired"`
+}
+
+// StatsRecomputeDoc - result of POST /stats/recompute. Reports is the number
+// of reports read.
+type StatsRecomputeDoc struct {
+	Scope         string                `json:"scope"`
+	Reports       int                   `json:"reports"`
+	Discrepancies []StatsDiscrepancyDoc `json:"discrepancies"`
+	Repaired      int                   `json:"repaired"`
+	RecomputedAt  time.Time             `json:"recomputedAt"`
+}
+
+// statsAcc aggregates the scores of reports the way the stats queries do
+type statsAcc struct {
+	count         int
+	sum, min, max float64
+}
+
+func (a *statsAcc) add(score float64) {
+	if a.count == 0 || score < a.min {
+		a.min = score
+	}
+	if a.count == 0 || score > a.max {
+		a.max = score
+	}
+	a.count++
+	a.sum += score
+}
+
+func (a *statsAcc) doc() StatsOverallDoc {
+	if a == nil || a.count == 0 {
+		return StatsOverallDoc{}
+	}
+	return StatsOverallDoc{
+		CountScore: float64(a.count),
+		MinScore:   a.min,
+		MaxScore:   a.max,
+		AvgScore:   a.sum / float64(a.count),
+	}
+}
+
+// compare records a discrepancy if the values differ.
+func (out *StatsRecomputeDoc) compare(source, key, field string, projected, recomputed float64) {
+	if math.Abs(projected-recomputed) <= statsTolerance {
+		return
+	}
+	out.Discrepancies = append(out.Discrepancies, StatsDiscrepancyDoc{
+		Source:     source,
+		Key:        key,
+		Field:      field,
+		Projected:  projected,
+		Recomputed: recomputed,
+	})
+}
+
+// compareStats compares all values of the stats of a key and returns the
+// index of the first discrepancy, -1 if there is none.
+func (out *StatsRecomputeDoc) compareStats(source, key string, projected, recomputed StatsOverallDoc) int {
+	first := len(out.Discrepancies)
+	out.compare(source, key, "countScore", projected.CountScore, recomputed.CountScore)
+	out.compare(source, key, "minScore", projected.MinScore, recomputed.MinScore)
+	out.compare(source, key, "maxScore", projected.MaxScore, recomputed.MaxScore)
+	out.compare(source, key, "avgScore", projected.AvgScore, recomputed.AvgScore)
+	if first == len(out.Discrepancies) {
+		return -1
+	}
+	return first
+}
+
+// markRepaired marks the discrepancies from index first on as repaired.
+func (out *StatsRecomputeDoc) markRepaired(first int) {
+	for i := first; i < len(out.Discrepancies); i++ {
+		out.Discrepancies[i].Repaired = true
+		out.Repaired++
+	}
+}
+
+func inVisitRange(visitDate, from, to string) bool {
+	return (from == "" || visitDate >= from) && (to == "" || visitDate < nextDay(to))
+}
+
+// recomputeStats recomputes the stats of a scope from the reports and
+// compares them with the aggregate queries of the stats endpoints and with
+// the cached dashboard. The aggregates are computed on read, a discrepancy
+// there points at reports the query reads differently, e.g. reports with a
+// result but without a sentiment score, and is only reported. With repair,
+// the cached dashboard is patched with the recomputed values.
+func recomputeStats(ctx iris.Context) {
+	params := StatsRecomputeQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	reqCtx := ctx.Request().Context()
+	out := StatsRecomputeDoc{Scope: params.Scope, Discrepancies: []StatsDiscrepancyDoc{}}
+	var err error
+	switch params.Scope {
+	case statsScopeOverall:
+		err = recomputeStatsOverall(reqCtx, params, &out)
+	case statsScopeContact:
+		err = recomputeStatsByContact(reqCtx, params, &out)
+	case statsScopeTimeline:
+		err = recomputeStatsTimeline(reqCtx, params, &out)
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	out.RecomputedAt = time.Now().UTC()
+	if len(out.Discrepancies) > 0 {
+		fmt.Printf("Recomputed %s stats from %d reports: %d discrepancies, %d repaired\n",
+			out.Scope, out.Reports, len(out.Discrepancies), out.Repaired)
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(out)
+}
+
+// aggregatesAvailable reports whether the aggregate queries can run, the
+// memory repository has none.
+func aggregatesAvailable() bool {
+	return currentCfg.Repository != "memory"
+}
+
+// requireAggregates answers 501 Not Implemented for the routes built on the
+// aggregate queries of Cosmos DB while the memory repository is used, they
+// would read other reports than the report endpoints.
+func requireAggregates(ctx iris.Context) {
+	if !aggregatesAvailable() {
+		ctx.StopWithProblem(iris.StatusNotImplemented, iris.NewProblem().
+			Title("Not available").
+			Detail("The endpoint needs Cosmos DB, it is not available with VR_REPOSITORY=memory"))
+		return
+	}
+	ctx.Next()
+}
+
+func recomputeStatsOverall(ctx context.Context, params StatsRecomputeQuery, out *StatsRecomputeDoc) error {
+	acc := &statsAcc{}
+	err := currentRepo.Query(ctx, ReportFilter{}, func(model *VisitReportModel) error {
+		out.Reports++
+		if model.Result != "" {
+			acc.add(model.VisitResultSentimentScore)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	recomputed := acc.doc()
+
+	if aggregatesAvailable() {
+		docs, err := queryStatsOverall(ctx)
+		if err != nil {
+			return err
+		}
+		projected := StatsOverallDoc{}
+		if len(docs) > 0 {
+			projected = docs[0]
+		}
+		out.compareStats(statsSourceQuery, "", projected, recomputed)
+	}
+
+	if cached := currentDashboardCache.get(); cached != nil {
+		projected := StatsOverallDoc{}
+		if len(cached.Overall) > 0 {
+			projected = cached.Overall[0]
+		}
+		first := out.compareStats(statsSourceDashboard, "", projected, recomputed)
+		if first >= 0 && params.Repair && currentDashboardCache.patch(func(doc *DashboardDoc) {
+			doc.Overall = []StatsOverallDoc{recomputed}
+		}) {
+			out.markRepaired(first)
+		}
+	}
+	return nil
+}
+
+func recomputeStatsByContact(ctx context.Context, params StatsRecomputeQuery, out *StatsRecomputeDoc) error {
+	accs := map[string]*statsAcc{}
+	err := currentRepo.Query(ctx, ReportFilter{ContactID: params.ContactID}, func(model *VisitReportModel) error {
+		out.Reports++
+		if model.Result == "" {
+			return nil
+		}
+		acc, ok := accs[model.Contact.Id]
+		if !ok {
+			acc = &statsAcc{}
+			accs[model.Contact.Id] = acc
+		}
+		acc.add(model.VisitResultSentimentScore)
+		return nil
+	})
+	if err != nil || !aggregatesAvailable() {
+		return err
+	}
+
+	docs, err := queryStatsByContact(ctx, params.ContactID)
+	if err != nil {
+		return err
+	}
+	projected := map[string]StatsOverallDoc{}
+	ids := make([]string, 0, len(docs)+len(accs))
+	for id := range accs {
+		ids = append(ids, id)
+	}
+	for _, doc := range docs {
+		ids = append(ids, doc.Id)
+		projected[doc.Id] = StatsOverallDoc{
+			CountScore: doc.CountScore,
+			MinScore:   doc.MinScore,
+			MaxScore:   doc.MaxScore,
+			AvgScore:   doc.AvgScore,
+		}
+	}
+	for _, id := range unionKeys(ids) {
+		out.compareStats(statsSourceQuery, id, projected[id], accs[id].doc())
+	}
+	return nil
+}
+
+func recomputeStatsTimeline(ctx context.Context, params StatsRecomputeQuery, out *StatsRecomputeDoc) error {
+	recomputed := map[string]int{}
+	err := currentRepo.Query(ctx, ReportFilter{From: params.From, To: params.To}, func(model *VisitReportModel) error {
+		out.Reports++
+		if model.Result != "" {
+			recomputed[model.VisitDate]++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if aggregatesAvailable() {
+		docs, err := queryStatsTimeline(ctx, params.From, params.To)
+		if err != nil {
+			return err
+		}
+		projected := map[string]int{}
+		for _, doc := range docs {
+			projected[doc.VisitDate] = int(doc.Visits)
+		}
+		for _, date := range unionKeys(countKeys(projected), countKeys(recomputed)) {
+			out.compare(statsSourceQuery, date, "visits", float64(projected[date]), float64(recomputed[date]))
+		}
+	}
+
+	cached := currentDashboardCache.get()
+	if cached == nil {
+		return nil
+	}
+	// the dashboard only has the last dashboardTimelineDays
+	dashboardFrom := cached.GeneratedAt.AddDate(0, 0, -dashboardTimelineDays).Format("2006-01-02")
+	inRange := func(date string) bool {
+		return date >= dashboardFrom && inVisitRange(date, params.From, params.To)
+	}
+	projected := map[string]int{}
+	for _, doc := range cached.Timeline {
+		if inRange(doc.VisitDate) {
+			projected[doc.VisitDate] = int(doc.Visits)
+		}
+	}
+	first := len(out.Discrepancies)
+	for _, date := range unionKeys(countKeys(projected), countKeys(recomputed)) {
+		if inRange(date) {
+			out.compare(statsSourceDashboard, date, "visits", float64(projected[date]), float64(recomputed[date]))
+		}
+	}
+	if first == len(out.Discrepancies) || !params.Repair {
+		return nil
+	}
+	if currentDashboardCache.patch(func(doc *DashboardDoc) {
+		timeline := make([]StatsTimelineDoc, 0, len(doc.Timeline))
+		for _, day := range doc.Timeline {
+			if !inRange(day.VisitDate) {
+				timeline = append(timeline, day)
+			}
+		}
+		for date, visits := range recomputed {
+			if inRange(date) {
+				timeline = append(timeline, StatsTimelineDoc{VisitDate: date, Visits: int16(visits)})
+			}
+		}
+		sort.Slice(timeline, func(i, j int) bool { return timeline[i].VisitDate < timeline[j].VisitDate })
+		doc.Timeline = timeline
+	}) {
+		out.markRepaired(first)
+	}
+	return nil
+}
+
+// unionKeys returns the keys of all slices once, sorted.
+func unionKeys(keys ...[]string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, ks := range keys {
+		for _, k := range ks {
+			if !seen[k] {
+				seen[k] = true
+				out = append(out, k)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func countKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+)
+
+// streamPageSize bounds the number of documents held in memory while streaming
+const streamPageSize = 100
+
+// headerContinuationToken carries the token of the next page of a paged list
+const headerContinuationToken = "X-Continuation-Token"
+
+// listPager returns a page of a list starting at the token and the token of
+// the next page
+type listPager func(ctx context.Context, token string) ([]VisitReportListDoc, string, error)
+
+// streamReports writes the matching reports, with includeDrafts followed by
+// the matching drafts, as a JSON array, encoding every page as soon as it is
+// returned instead of buffering all reports. The list is cancelled when the
+// client disconnects.
+func streamReports(ctx iris.Context, filter ReportFilter, includeDrafts bool) {
+	pagers := []listPager{func(c context.Context, token string) ([]VisitReportListDoc, string, error) {
+		return currentRepo.List(c, filter, streamPageSize, token)
+	}}
+	if includeDrafts {
+		pagers = append(pagers, func(c context.Context, token string) ([]VisitReportListDoc, string, error) {
+			return listDraftPage(c, filter, token)
+		})
+	}
+
+	reqCtx := ctx.Request().Context()
+	started := false
+	count := 0
+	for _, pager := range pagers {
+		token := ""
+		for {
+			page, next, err := pager(reqCtx, token)
+			if err != nil {
+				if reqCtx.Err() != nil {
+					// client went away, nothing to respond to
+					fmt.Printf("Stream cancelled after %d documents: %v\n", count, reqCtx.Err())
+					return
+				}
+				if !started {
+					stopWithError(ctx, err)
+					return
+				}
+				err = errors.WithStack(err)
+				fmt.Println(err)
+				// once streaming the status is sent, leave the array
+				// unterminated so clients notice the truncated response
+				return
+			}
+
+			if !started {
+				ctx.ContentType("application/json")
+				ctx.StatusCode(http.StatusOK)
+				ctx.WriteString("[")
+				started = true
+			}
+			for _, doc := range page {
+				if count > 0 {
+					ctx.WriteString(",")
+				}
+				b, err := json.Marshal(normalize(doc))
+				if err != nil {
+					err = errors.WithStack(err)
+					fmt.Println(err)
+					return
+				}
+				ctx.Write(b)
+				count++
+			}
+			ctx.ResponseWriter().Flush()
+
+			if next == "" {
+				break
+			}
+			token = next
+		}
+	}
+	ctx.WriteString("]")
+	fmt.Printf("Streamed %d documents\n", count)
+}
+
+// pageReports returns a single page of the matching reports, at most limit
+// (streamPageSize if zero) starting at the continuation token. The token of
+// the next page is sent in the X-Continuation-Token header and the body.
+func pageReports(ctx iris.Context, filter ReportFilter, limit int, token string) {
+	if limit <= 0 {
+		limit = streamPageSize
+	}
+	items, next, err := currentRepo.List(ctx.Request().Context(), filter, limit, token)
+	if err == ErrInvalidContinuation {
+		ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+			Title("Invalid continuation token").
+			Detail("continuationToken must be a token returned by a previous page of the same query"))
+		return
+	}
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	if next != "" {
+		ctx.Header(headerContinuationToken, next)
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(VisitReportPageDoc{Items: items, ContinuationToken: next}))
+}
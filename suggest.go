@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	suggestKindSubject = "subject"
+	suggestKindContact = "contact"
+
+	// suggestCandidates is the number of reports suggestions are taken from,
+	// the most recent visits first
+	suggestCandidates = 50
+	// suggestCacheMaxEntries bounds the cache, it is cleared when full
+	suggestCacheMaxEntries = 1000
+)
+
+// SuggestQuery - query parameters of GET /reports/suggest
+type SuggestQuery struct {
+	Q   string `url:"q" validate:"required,max=100"`
+	Top int    `url:"top" validate:"min=1,max=10"`
+}
+
+// SuggestionDoc - a subject or a contact for the autocomplete box. ReportId
+// is the most recent report with the subject.
+type SuggestionDoc struct {
+	Kind     string      `json:"kind"`
+	Text     string      `json:"text"`
+	ReportId string      `json:"reportId,omitempty"`
+	Contact  *ContactDoc `json:"contact,omitempty"`
+}
+
+type suggestionCacheEntry struct {
+	docs    []SuggestionDoc
+	expires time.Time
+}
+
+// suggestionCache keeps the suggestions of a prefix for VR_SUGGESTCACHETTL,
+// typing the same prefixes again doesn't query again
+type suggestionCache struct {
+	mu      sync.Mutex
+	entries map[string]suggestionCacheEntry
+}
+
+var currentSuggestionCache = &suggestionCache{entries: map[string]suggestionCacheEntry{}}
+
+func (c *suggestionCache) get(key string) ([]SuggestionDoc, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.docs, true
+}
+
+func (c *suggestionCache) set(key string, docs []SuggestionDoc, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= suggestCacheMaxEntries {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= suggestCacheMaxEntries {
+			c.entries = map[string]suggestionCacheEntry{}
+		}
+	}
+	c.entries[key] = suggestionCacheEntry{docs: docs, expires: time.Now().Add(ttl)}
+}
+
+func contactDisplayName(contact ContactDoc) string {
+	return strings.TrimSpace(contact.Firstname + " " + contact.Lastname)
+}
+
+// hasPrefix reports whether s starts with the lower case prefix at its
+// beginning or at one of its words, the way suggestFromList matches.
+func hasPrefix(s, prefix string) bool {
+	s = strings.ToLower(s)
+	return strings.HasPrefix(s, prefix) || strings.Contains(s, " "+prefix)
+}
+
+// suggestionsOf returns up to top distinct suggestions of the reports, in
+// their order. A report suggests its contact and its subject if they match
+// the prefix.
+func suggestionsOf(docs []VisitReportListDoc, prefix string, top int) []SuggestionDoc {
+	out := []SuggestionDoc{}
+	seen := map[string]bool{}
+	add := func(key string, doc SuggestionDoc) {
+		if len(out) < top && !seen[key] {
+			seen[key] = true
+			out = append(out, doc)
+		}
+	}
+	for i := range docs {
+		doc := &docs[i]
+		contact := doc.Contact
+		if hasPrefix(contactDisplayName(contact), prefix) || hasPrefix(contact.Company, prefix) {
+			add(suggestKindContact+"/"+contact.Id, SuggestionDoc{
+				Kind:    suggestKindContact,
+				Text:    contactDisplayName(contact),
+				Contact: &contact,
+			})
+		}
+		if hasPrefix(doc.Subject, prefix) {
+			add(suggestKindSubject+"/"+strings.ToLower(doc.Subject), SuggestionDoc{
+				Kind:     suggestKindSubject,
+				Text:     doc.Subject,
+				ReportId: doc.Id,
+			})
+		}
+	}
+	return out
+}
+
+// suggestFromIndex runs a prefix query for every word against the search
+// index.
+func suggestFromIndex(ctx context.Context, prefix string) ([]VisitReportListDoc, error) {
+	var terms []string
+	for _, word := range strings.Fields(prefix) {
+		terms = append(terms, "+"+escapeLucene(word)+"*")
+	}
+	body := map[string]interface{}{
+		"search":       strings.Join(terms, " "),
+		"queryType":    "full",
+		"searchMode":   "all",
+		"searchFields": "subject,contact/firstname,contact/lastname,contact/company",
+		"select":       "id,number,subject,visitDate,contact",
+		"orderby":      "visitDate desc",
+		"top":          suggestCandidates,
+	}
+	var res searchResponse
+	if err := searchRequest(ctx, http.MethodPost, "/"+currentCfg.SearchIndex+"/docs/search", body, &res); err != nil {
+		return nil, err
+	}
+	out := make([]VisitReportListDoc, 0, len(res.Value))
+	for _, hit := range res.Value {
+		out = append(out, hit.VisitReportListDoc)
+	}
+	return out, nil
+}
+
+// suggestFromList runs a prefix query against the list projection, or the
+// reports until the projection caught up.
+func suggestFromList(ctx context.Context, prefix string) ([]VisitReportListDoc, error) {
+	collection := currentCfg.ReportContainer
+	if reportListEnabled() && atomic.LoadInt32(&reportListReady) == 1 {
+		collection = currentCfg.ReportListContainer
+	}
+	var conditions []string
+	for _, path := range []string{"c.subject", "c.contact.firstname", "c.contact.lastname", "c.contact.company"} {
+		conditions = append(conditions, fmt.Sprintf("STARTSWITH(LOWER(%[1]s), @prefix) OR CONTAINS(LOWER(%[1]s), @wordprefix)", path))
+	}
+	qry := cosmosapi.Query{
+		Query: fmt.Sprintf("SELECT TOP %d c.id, c.type, c.number, c.subject, c.visitDate, c.contact FROM c WHERE c.type = 'visitreport' AND %s AND (%s) ORDER BY c.visitDate DESC",
+			suggestCandidates, notDeleted, strings.Join(conditions, " OR ")),
+		Params: []cosmosapi.QueryParam{
+			{Name: "@prefix", Value: prefix},
+			{Name: "@wordprefix", Value: " " + prefix},
+		},
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "visitreport"
+	docs := []VisitReportListDoc{}
+	_, err := currentClient.QueryDocuments(ctx, currentCfg.DbName, collection, qry, &docs, qops)
+	return docs, err
+}
+
+// suggestFromRepository scans the reports of the memory repository.
+func suggestFromRepository(ctx context.Context, prefix string) ([]VisitReportListDoc, error) {
+	var docs []VisitReportListDoc
+	err := currentRepo.Query(ctx, ReportFilter{}, func(model *VisitReportModel) error {
+		if hasPrefix(model.Subject, prefix) || hasPrefix(contactDisplayName(model.Contact), prefix) || hasPrefix(model.Contact.Company, prefix) {
+			docs = append(docs, VisitReportListDoc{
+				Id:        model.Id,
+				Type:      model.Type,
+				Number:    model.Number,
+				Subject:   model.Subject,
+				VisitDate: model.VisitDate,
+				Contact:   model.Contact,
+			})
+		}
+		return nil
+	})
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].VisitDate > docs[j].VisitDate })
+	return docs, err
+}
+
+// suggestReports returns subjects and contacts starting with the typed
+// prefix, from the search index if one is configured. Suggestions are
+// cached for VR_SUGGESTCACHETTL, by the server and by the browser, unless
+// the request prefers fresh data.
+func suggestReports(ctx iris.Context) {
+	params := SuggestQuery{Top: 10}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	prefix := strings.Join(strings.Fields(strings.ToLower(params.Q)), " ")
+	key := fmt.Sprintf("%d/%s", params.Top, prefix)
+	fresh := wantsFresh(ctx.Request().Context())
+	var out []SuggestionDoc
+	ok := false
+	if !fresh {
+		out, ok = currentSuggestionCache.get(key)
+	}
+	if !ok && prefix != "" {
+		var docs []VisitReportListDoc
+		var err error
+		reqCtx := ctx.Request().Context()
+		switch {
+		case searchEnabled():
+			docs, err = suggestFromIndex(reqCtx, prefix)
+		case currentCfg.Repository == "memory":
+			docs, err = suggestFromRepository(reqCtx, prefix)
+		default:
+			docs, err = suggestFromList(reqCtx, prefix)
+		}
+		if err != nil {
+			stopWithError(ctx, err)
+			return
+		}
+		out = suggestionsOf(docs, prefix, params.Top)
+		currentSuggestionCache.set(key, out, currentCfg.SuggestCacheTTL)
+	}
+	if out == nil {
+		out = []SuggestionDoc{}
+	}
+	if fresh {
+		ctx.Header("Cache-Control", "no-cache")
+	} else {
+		ctx.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(currentCfg.SuggestCacheTTL.Seconds())))
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(out))
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	summaryPeriodWeek  = "week"
+	summaryPeriodMonth = "month"
+
+	summaryScopeContact = "contact"
+	summaryScopeCompany = "company"
+
+	summaryTopKeyPhrases = 5
+)
+
+// SummaryDoc - materialized weekly or monthly summary of a contact or company.
+// Summaries are stored in the "summaries" container, partitioned by type.
+type SummaryDoc struct {
+	Id            string      `json:"id"`
+	Type          string      `json:"type"`
+	Period        string      `json:"period"`
+	PeriodType    string      `json:"periodType"`
+	Scope         string      `json:"scope"`
+	Contact       *ContactDoc `json:"contact,omitempty"`
+	Company       string      `json:"company"`
+	Visits        int         `json:"visits"`
+	AvgSentiment  float64     `json:"avgSentiment"`
+	TopKeyPhrases []string    `json:"topKeyPhrases"`
+	UpdatedAt     time.Time   `json:"updatedAt"`
+}
+
+// SummaryQuery - query parameters of GET /summaries
+type SummaryQuery struct {
+	Period string `url:"period" validate:"required"`
+	Scope  string `url:"scope" validate:"omitempty,oneof=contact company"`
+}
+
+type summaryReportDoc struct {
+	Contact                   ContactDoc `json:"contact"`
+	VisitResultSentimentScore float64    `json:"visitResultSentimentScore"`
+	VisitResultKeyPhrases     []string   `json:"visitResultKeyPhrases"`
+}
+
+type summaryAcc struct {
+	doc       SummaryDoc
+	sentiment float64
+	phrases   map[string]int
+}
+
+// parsePeriod accepts a month ("2024-05") or an ISO week ("2024-W21") and
+// returns the inclusive start and exclusive end visit dates.
+func parsePeriod(period string) (periodType, from, to string, err error) {
+	if i := strings.Index(period, "-W"); i > 0 {
+		year, errYear := strconv.Atoi(period[:i])
+		week, errWeek := strconv.Atoi(period[i+2:])
+		if errYear != nil || errWeek != nil || week < 1 || week > 53 {
+			return "", "", "", fmt.Errorf("invalid week %q, expected e.g. 2024-W21", period)
+		}
+		// January 4th is always in week 1
+		jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, time.UTC)
+		monday := jan4.AddDate(0, 0, -((int(jan4.Weekday())+6)%7)+(week-1)*7)
+		if y, w := monday.ISOWeek(); y != year || w != week {
+			return "", "", "", fmt.Errorf("week %q does not exist", period)
+		}
+		return summaryPeriodWeek, monday.Format("2006-01-02"), monday.AddDate(0, 0, 7).Format("2006-01-02"), nil
+	}
+	start, err := time.Parse("2006-01", period)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid period %q, expected a month (2024-05) or a week (2024-W21)", period)
+	}
+	return summaryPeriodMonth, start.Format("2006-01-02"), start.AddDate(0, 1, 0).Format("2006-01-02"), nil
+}
+
+func weekPeriod(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+func summaryId(period, scope, key string) string {
+	// contact ids are safe, company names may contain characters Cosmos
+	// DB does not allow in ids
+	h := sha256.Sum256([]byte(key))
+	return period + "-" + scope + "-" + hex.EncodeToString(h[:8])
+}
+
+// materializeSummaries recomputes all contact and company summaries of a period.
+func materializeSummaries(ctx context.Context, period string) (int, error) {
+	periodType, from, to, err := parsePeriod(period)
+	if err != nil {
+		return 0, err
+	}
+	qry := cosmosapi.Query{
+		Query: `SELECT c.contact, c.visitResultSentimentScore, c.visitResultKeyPhrases FROM c
+				WHERE c.type = 'visitreport' AND c.result != '' AND ` + notDeleted + ` AND c.visitDate >= @from AND c.visitDate < @to`,
+		Params: []cosmosapi.QueryParam{
+			{Name: "@from", Value: from},
+			{Name: "@to", Value: to},
+		},
+	}
+	var docs []summaryReportDoc
+	_, err = queryAllPartitions(ctx, currentCfg.ReportContainer, qry, 1000, func() interface{} {
+		return &[]summaryReportDoc{}
+	}, func(page interface{}) error {
+		docs = append(docs, *page.(*[]summaryReportDoc)...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	accs := map[string]*summaryAcc{}
+	add := func(scope, key string, doc summaryReportDoc) {
+		id := summaryId(period, scope, key)
+		acc, ok := accs[id]
+		if !ok {
+			acc = &summaryAcc{
+				doc: SummaryDoc{
+					Id:         id,
+					Type:       "summary",
+					Period:     period,
+					PeriodType: periodType,
+					Scope:      scope,
+					Company:    doc.Contact.Company,
+				},
+				phrases: map[string]int{},
+			}
+			if scope == summaryScopeContact {
+				contact := doc.Contact
+				acc.doc.Contact = &contact
+			}
+			accs[id] = acc
+		}
+		acc.doc.Visits++
+		acc.sentiment += doc.VisitResultSentimentScore
+		for _, p := range doc.VisitResultKeyPhrases {
+			acc.phrases[strings.ToLower(p)]++
+		}
+	}
+	for _, doc := range docs {
+		add(summaryScopeContact, doc.Contact.Id, doc)
+		if doc.Contact.Company != "" {
+			add(summaryScopeCompany, doc.Contact.Company, doc)
+		}
+	}
+
+	now := time.Now().UTC()
+	ops := cosmosapi.CreateDocumentOptions{
+		PartitionKeyValue: "summary",
+		IsUpsert:          true,
+	}
+	for _, acc := range accs {
+		acc.doc.AvgSentiment = acc.sentiment / float64(acc.doc.Visits)
+		acc.doc.TopKeyPhrases = topPhrases(acc.phrases, summaryTopKeyPhrases)
+		acc.doc.UpdatedAt = now
+		if _, _, err := currentClient.CreateDocument(ctx, currentCfg.DbName, "summaries", acc.doc, ops); err != nil {
+			return 0, err
+		}
+	}
+	return len(accs), nil
+}
+
+func topPhrases(counts map[string]int, n int) []string {
+	phrases := make([]string, 0, len(counts))
+	for p := range counts {
+		phrases = append(phrases, p)
+	}
+	sort.Slice(phrases, func(i, j int) bool {
+		if counts[phrases[i]] != counts[phrases[j]] {
+			return counts[phrases[i]] > counts[phrases[j]]
+		}
+		return phrases[i] < phrases[j]
+	})
+	if len(phrases) > n {
+		phrases = phrases[:n]
+	}
+	return phrases
+}
+
+// startSummaryWorker refreshes the summaries of the current and the previous
+// week and month and all cohorts every VR_SUMMARYINTERVAL, so late results
+// are picked up.
+func startSummaryWorker() {
+	go func() {
+		for {
+			now := time.Now().UTC()
+			periods := []string{
+				weekPeriod(now),
+				weekPeriod(now.AddDate(0, 0, -7)),
+				now.Format("2006-01"),
+				time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0).Format("2006-01"),
+			}
+			for _, period := range periods {
+				n, err := materializeSummaries(context.Background(), period)
+				if err != nil {
+					err = errors.WithStack(err)
+					fmt.Println(err)
+					continue
+				}
+				fmt.Printf("Materialized %d summaries for %s\n", n, period)
+			}
+			if n, err := materializeCohorts(context.Background()); err != nil {
+				err = errors.WithStack(err)
+				fmt.Println(err)
+			} else {
+				fmt.Printf("Materialized %d cohorts\n", n)
+			}
+			time.Sleep(currentCfg.SummaryInterval)
+		}
+	}()
+}
+
+// summarize materializes the summaries of the given periods, e.g. to backfill,
+// and the cohorts for "cohorts".
+func summarize(args []string) error {
+	fs := flag.NewFlagSet("summarize", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: summarize <period|cohorts>... (e.g. 2024-05 2024-W21 cohorts)")
+	}
+	for _, period := range fs.Args() {
+		if period == "cohorts" {
+			n, err := materializeCohorts(context.Background())
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Materialized %d cohorts\n", n)
+			continue
+		}
+		n, err := materializeSummaries(context.Background(), period)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Materialized %d summaries for %s\n", n, period)
+	}
+	return nil
+}
+
+func readSummaries(ctx iris.Context) {
+	params := SummaryQuery{}
+	if !bindQuery(ctx, &params) {
+		return
+	}
+	if _, _, _, err := parsePeriod(params.Period); err != nil {
+		ctx.StopWithProblem(iris.StatusBadRequest, iris.NewProblem().
+			Title("Invalid period").
+			Detail(err.Error()))
+		return
+	}
+
+	where := "c.type = 'summary' AND c.period = @period"
+	qparams := []cosmosapi.QueryParam{{Name: "@period", Value: params.Period}}
+	if params.Scope != "" {
+		where += " AND c.scope = @scope"
+		qparams = append(qparams, cosmosapi.QueryParam{Name: "@scope", Value: params.Scope})
+	}
+	qops := cosmosapi.DefaultQueryDocumentOptions()
+	qops.PartitionKeyValue = "summary"
+	qry := cosmosapi.Query{
+		Query:  "SELECT * FROM c WHERE " + where + " ORDER BY c.visits DESC",
+		Params: qparams,
+	}
+	docs := []SummaryDoc{}
+	_, err := currentClient.QueryDocuments(ctx.Request().Context(), currentCfg.DbName, "summaries", qry, &docs, qops)
+	if err != nil {
+		stopWithError(ctx, err)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(normalize(docs))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const (
+	// highlightPreTag and highlightPostTag enclose matched terms, the same
+	// tags Azure Cognitive Search uses by default
+	highlightPreTag  = "<em>"
+	highlightPostTag = "</em>"
+	// highlightContext is the number of characters kept around a match in
+	// a highlight
+	highlightContext = 60
+)
+
+// textFields are the fields of a report a text search looks into, with the
+// weight of a match for the ranking
+var textFields = []struct {
+	name   string
+	weight float64
+	value  func(model *VisitReportModel) string
+}{
+	{"subject", 3, func(m *VisitReportModel) string { return m.Subject }},
+	{"result", 2, func(m *VisitReportModel) string { return m.Result }},
+	{"description", 1, func(m *VisitReportModel) string { return m.Description }},
+}
+
+// searchTerms splits a search text into lower-case words, "*" matches all
+// reports.
+func searchTerms(text string) []string {
+	var terms []string
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if word != "*" {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// textCondition returns the condition matching reports that contain all
+// words of a text in the subject, description or result.
+func textCondition(text string) (string, []cosmosapi.QueryParam) {
+	conditions := []string{"true"}
+	var params []cosmosapi.QueryParam
+	for i, term := range searchTerms(text) {
+		p := fmt.Sprintf("@text%d", i)
+		conditions = append(conditions, fmt.Sprintf(
+			"(CONTAINS(LOWER(c.subject), %[1]s) OR CONTAINS(LOWER(c.description), %[1]s) OR CONTAINS(LOWER(c.result), %[1]s))", p))
+		params = append(params, cosmosapi.QueryParam{Name: p, Value: term})
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")", params
+}
+
+// matchesText applies a text filter the way textCondition does.
+func matchesText(text string, model *VisitReportModel) bool {
+	for _, term := range searchTerms(text) {
+		found := false
+		for _, field := range textFields {
+			found = found || strings.Contains(strings.ToLower(field.value(model)), term)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// textScore ranks a report by the weighted number of matches of the terms.
+func textScore(terms []string, model *VisitReportModel) float64 {
+	score := 0.0
+	for _, field := range textFields {
+		value := strings.ToLower(field.value(model))
+		for _, term := range terms {
+			score += field.weight * float64(strings.Count(value, term))
+		}
+	}
+	return score
+}
+
+// highlight returns the part of a value around its first match with all
+// matches in it enclosed in highlightPreTag and highlightPostTag, empty if
+// no term matches.
+func highlight(value string, terms []string) string {
+	// lower-casing may change the length of some characters, only
+	// highlight values where it doesn't
+	lower := strings.ToLower(value)
+	if len(lower) != len(value) {
+		return ""
+	}
+	type match struct{ start, end int }
+	var matches []match
+	for _, term := range terms {
+		for i := 0; ; {
+			j := strings.Index(lower[i:], term)
+			if j < 0 {
+				break
+			}
+			matches = append(matches, match{i + j, i + j + len(term)})
+			i += j + len(term)
+		}
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	from := runeStart(value, matches[0].start-highlightContext)
+	to := runeStart(value, matches[0].end+highlightContext)
+	var sb strings.Builder
+	if from > 0 {
+		sb.WriteString("…")
+	}
+	pos := from
+	for _, m := range matches {
+		if m.start < pos || m.end > to {
+			// overlapping or outside of the fragment
+			continue
+		}
+		sb.WriteString(value[pos:m.start])
+		sb.WriteString(highlightPreTag + value[m.start:m.end] + highlightPostTag)
+		pos = m.end
+	}
+	sb.WriteString(value[pos:to])
+	if to < len(value) {
+		sb.WriteString("…")
+	}
+	return sb.String()
+}
+
+// runeStart clamps a byte offset to a value and moves it back to the start
+// of a character.
+func runeStart(value string, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	if i >= len(value) {
+		return len(value)
+	}
+	for i > 0 && value[i]&0xC0 == 0x80 {
+		i--
+	}
+	return i
+}
+
+// searchReportsByText is the search without a search index: it finds the
+// reports containing all words of the query and ranks them by where and
+// how often the words appear, most recent visits first on ties.
+func searchReportsByText(ctx context.Context, params SearchQuery) (SearchResultDoc, error) {
+	terms := searchTerms(params.Q)
+	var hits []SearchHitDoc
+	err := currentRepo.Query(ctx, ReportFilter{Text: params.Q}, func(model *VisitReportModel) error {
+		hit := SearchHitDoc{
+			VisitReportListDoc: listDocOf(model),
+			Score:              textScore(terms, model),
+		}
+		for _, field := range textFields {
+			if h := highlight(field.value(model), terms); h != "" {
+				if hit.Highlights == nil {
+					hit.Highlights = map[string][]string{}
+				}
+				hit.Highlights[field.name] = []string{h}
+			}
+		}
+		hits = append(hits, hit)
+		return nil
+	})
+	if err != nil {
+		return SearchResultDoc{}, err
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].VisitDate != hits[j].VisitDate {
+			return hits[i].VisitDate > hits[j].VisitDate
+		}
+		return hits[i].Id < hits[j].Id
+	})
+	out := SearchResultDoc{
+		Count:  len(hits),
+		Hits:   []SearchHitDoc{},
+		Facets: map[string][]SearchFacetDoc{},
+	}
+	if params.Skip < len(hits) {
+		hits = hits[params.Skip:]
+		if len(hits) > params.Top {
+			hits = hits[:params.Top]
+		}
+		out.Hits = hits
+	}
+	return out, nil
+}
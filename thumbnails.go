@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// thumbnailMaxPixels limits the images thumbnails are generated for, a
+// decoded image takes 4 bytes per pixel
+const thumbnailMaxPixels = 40 * 1000 * 1000
+
+// thumbnailSlots limits the thumbnails generated at the same time
+var thumbnailSlots = make(chan struct{}, 2)
+
+// AttachmentThumbnailDoc - a scaled down copy of an image attachment, Size
+// is the size of VR_ATTACHMENTTHUMBNAILS it fits into
+type AttachmentThumbnailDoc struct {
+	Size        int    `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"contentType"`
+}
+
+// thumbnailBlobName is the blob of a thumbnail, next to the attachment
+func thumbnailBlobName(reportid, attachmentid string, size int) string {
+	return attachmentBlobName(reportid, attachmentid) + "/thumbnail-" + strconv.Itoa(size)
+}
+
+// thumbnailType returns the media type of the thumbnails of an attachment,
+// "" for content types without thumbnails.
+func thumbnailType(contentType string) string {
+	switch t := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0])); t {
+	case "image/jpeg", "image/png":
+		return t
+	}
+	return ""
+}
+
+// generateThumbnailsInBg scales an image attachment down to the sizes of
+// VR_ATTACHMENTTHUMBNAILS and adds the thumbnails to the attachment,
+// list views show them instead of the full-size image. Sizes the image
+// fits into already get no thumbnail.
+func generateThumbnailsInBg(reportid string, attachment AttachmentDoc) {
+	if thumbnailType(attachment.ContentType) == "" || len(currentCfg.AttachmentThumbnails) == 0 {
+		return
+	}
+	go func() {
+		thumbnailSlots <- struct{}{}
+		defer func() { <-thumbnailSlots }()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := generateThumbnails(ctx, reportid, attachment); err != nil {
+			err = errors.Wrapf(err, "thumbnails of attachment %s of report %s", attachment.Id, reportid)
+			fmt.Println(err)
+		}
+	}()
+}
+
+func generateThumbnails(ctx context.Context, reportid string, attachment AttachmentDoc) error {
+	res, err := blobRequest(ctx, http.MethodGet, attachmentBlobName(reportid, attachment.Id), "r", "", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("reading the image: %s", res.Status)
+	}
+	src, err := decodeImage(res.Body)
+	if err != nil {
+		return err
+	}
+
+	contentType := thumbnailType(attachment.ContentType)
+	var thumbnails []AttachmentThumbnailDoc
+	for _, size := range currentCfg.AttachmentThumbnails {
+		b := src.Bounds()
+		if size <= 0 || (b.Dx() <= size && b.Dy() <= size) {
+			continue
+		}
+		thumbnail := scaleImage(src, size)
+		data, err := encodeImage(thumbnail, contentType)
+		if err != nil {
+			return err
+		}
+		header := http.Header{"x-ms-blob-type": {"BlockBlob"}, "Content-Type": {contentType}}
+		res, err := blobRequest(ctx, http.MethodPut, thumbnailBlobName(reportid, attachment.Id, size), "cw", "", header, data)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusCreated {
+			return fmt.Errorf("storing the thumbnail of size %d: %s", size, res.Status)
+		}
+		thumbnails = append(thumbnails, AttachmentThumbnailDoc{
+			Size:        size,
+			Width:       thumbnail.Bounds().Dx(),
+			Height:      thumbnail.Bounds().Dy(),
+			ContentType: contentType,
+		})
+	}
+	if len(thumbnails) == 0 {
+		return nil
+	}
+	return addThumbnails(ctx, reportid, attachment.Id, thumbnails)
+}
+
+// addThumbnails records the thumbnails in the attachment metadata. Reports
+// placed under legal hold in the meantime are not changed.
+func addThumbnails(ctx context.Context, reportid, attachmentid string, thumbnails []AttachmentThumbnailDoc) error {
+	for i := 0; ; i++ {
+		model, err := currentRepo.Get(ctx, reportid)
+		if err == ErrReportNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if model.LegalHold != nil {
+			fmt.Printf("Thumbnails of attachment %s not recorded, report %s is under legal hold\n", attachmentid, reportid)
+			return nil
+		}
+		found := false
+		for j := range model.Attachments {
+			if model.Attachments[j].Id == attachmentid {
+				model.Attachments[j].Thumbnails = thumbnails
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+		_, err = currentRepo.Update(ctx, model)
+		if err != ErrReportChanged || i == attachmentMaxRetries {
+			return err
+		}
+	}
+}
+
+// decodeImage decodes a JPEG or PNG image, refusing images larger than
+// thumbnailMaxPixels before decoding them.
+func decodeImage(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > thumbnailMaxPixels {
+		return nil, fmt.Errorf("the image has more than %d pixels", thumbnailMaxPixels)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	return src, err
+}
+
+func encodeImage(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+	return buf.Bytes(), err
+}
+
+// scaleImage scales an image down to fit into size x size, keeping the
+// aspect ratio. Each pixel is the average of the pixels it covers.
+func scaleImage(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := b.Min.Y+ty*h/th, b.Min.Y+(ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := b.Min.X+tx*w/tw, b.Min.X+(tx+1)*w/tw
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, pa := src.At(x, y).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA64(tx, ty, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
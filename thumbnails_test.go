@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestScaleImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			// black and white columns average to grey
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+	thumbnail := scaleImage(src, 100)
+	if b := thumbnail.Bounds(); b.Dx() != 100 || b.Dy() != 50 {
+		t.Fatalf("got %dx%d, want 100x50", b.Dx(), b.Dy())
+	}
+	if r, _, _, a := thumbnail.At(10, 10).RGBA(); r < 0x7000 || r > 0x9000 || a != 0xffff {
+		t.Errorf("got red %#x alpha %#x, want grey", r, a)
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	img, err := decodeImage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 30 || b.Dy() != 20 {
+		t.Errorf("got %dx%d, want 30x20", b.Dx(), b.Dy())
+	}
+	if thumbnailType("image/PNG; q=1") != "image/png" || thumbnailType("application/pdf") != "" {
+		t.Error("thumbnailType does not normalize the content type")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// StatsTimelineDayDoc - visits of a calendar day with the requested moving
+// averages over the trailing 7 or 30 days and the value of the trend line.
+// Moving averages are only set once the window is complete.
+type StatsTimelineDayDoc struct {
+	Date            string   `json:"date"`
+	Visits          int      `json:"visits"`
+	MovingAverage7  *float64 `json:"movingAverage7,omitempty"`
+	MovingAverage30 *float64 `json:"movingAverage30,omitempty"`
+	Trend           *float64 `json:"trend,omitempty"`
+}
+
+// StatsTimelineSeriesDoc - struct for GET /stats/timeline with moving
+// averages or trend. Days run from the first to the last visit, or over the
+// from/to range, including days without visits. TrendSlope is the least
+// squares slope in visits per day.
+type StatsTimelineSeriesDoc struct {
+	Days       []StatsTimelineDayDoc `json:"days"`
+	TrendSlope *float64              `json:"trendSlope,omitempty"`
+}
+
+// timelineSeries turns the visits per visit date into a daily series. Visit
+// dates with a time part are counted on their date as written.
+func timelineSeries(docs []StatsTimelineDoc, params TimelineQuery) StatsTimelineSeriesDoc {
+	perDay := map[string]int{}
+	for _, doc := range docs {
+		if t, _, ok := parseVisitDate(doc.VisitDate); ok {
+			perDay[t.Format("2006-01-02")] += int(doc.Visits)
+		}
+	}
+	dates := make([]string, 0, len(perDay))
+	for date := range perDay {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	first, last := params.From, params.To
+	if first == "" && len(dates) > 0 {
+		first = dates[0]
+	}
+	if last == "" && len(dates) > 0 {
+		last = dates[len(dates)-1]
+	}
+	out := StatsTimelineSeriesDoc{Days: []StatsTimelineDayDoc{}}
+	start, errStart := time.Parse("2006-01-02", first)
+	end, errEnd := time.Parse("2006-01-02", last)
+	if errStart != nil || errEnd != nil {
+		return out
+	}
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		out.Days = append(out.Days, StatsTimelineDayDoc{Date: date, Visits: perDay[date]})
+	}
+
+	for _, window := range params.MovingAverage {
+		sum := 0
+		for i := range out.Days {
+			sum += out.Days[i].Visits
+			if i >= window {
+				sum -= out.Days[i-window].Visits
+			}
+			if i < window-1 {
+				continue
+			}
+			avg := float64(sum) / float64(window)
+			if window == 7 {
+				out.Days[i].MovingAverage7 = &avg
+			} else {
+				out.Days[i].MovingAverage30 = &avg
+			}
+		}
+	}
+
+	if params.Trend && len(out.Days) > 1 {
+		// least squares fit of visits over the day index
+		n := float64(len(out.Days))
+		var sumX, sumY, sumXY, sumXX float64
+		for i, day := range out.Days {
+			x, y := float64(i), float64(day.Visits)
+			sumX += x
+			sumY += y
+			sumXY += x * y
+			sumXX += x * x
+		}
+		slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+		intercept := (sumY - slope*sumX) / n
+		out.TrendSlope = &slope
+		for i := range out.Days {
+			value := intercept + slope*float64(i)
+			out.Days[i].Trend = &value
+		}
+	}
+	return out
+}
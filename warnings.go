@@ -0,0 +1,58 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+)
+
+const (
+	warningMissingDescription = "missing-description"
+	warningShortResult        = "short-result"
+	warningDraftNotDeleted    = "draft-not-deleted"
+)
+
+// validationWarnings counts the soft validation warnings per code, see /debug/vars
+var validationWarnings = expvar.NewMap("validationWarnings")
+
+// ValidationWarningDoc - a non-critical data quality issue of a report
+type ValidationWarningDoc struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// VisitReportWriteDoc - struct for the response of a write, including soft
+// validation warnings
+type VisitReportWriteDoc struct {
+	VisitReportReadDoc
+	Warnings []ValidationWarningDoc `json:"warnings,omitempty"`
+}
+
+// softValidate returns the data quality warnings of a report if
+// VR_SOFTVALIDATION is enabled. Unlike validation errors they never block
+// the write.
+func softValidate(model *VisitReportModel) []ValidationWarningDoc {
+	if !currentCfg.SoftValidation {
+		return nil
+	}
+	var warnings []ValidationWarningDoc
+	if strings.TrimSpace(model.Description) == "" {
+		warnings = append(warnings, ValidationWarningDoc{
+			Field:   "description",
+			Code:    warningMissingDescription,
+			Message: "The report has no description",
+		})
+	}
+	if result := strings.TrimSpace(model.Result); result != "" && len([]rune(result)) < currentCfg.MinResultLength {
+		warnings = append(warnings, ValidationWarningDoc{
+			Field:   "result",
+			Code:    warningShortResult,
+			Message: fmt.Sprintf("The result is shorter than %d characters", currentCfg.MinResultLength),
+		})
+	}
+	for _, w := range warnings {
+		validationWarnings.Add(w.Code, 1)
+	}
+	return warnings
+}